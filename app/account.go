@@ -0,0 +1,217 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/mail"
+	"github.com/Chaldron/clay-play/user"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	emailVerifyTTL   = 48 * time.Hour
+)
+
+type accountData struct {
+	Error     string
+	Message   string
+	Token     string
+	CSRFToken string
+}
+
+// Returns an absolute link to path on the configured base URL. The request's host is never used, it can't be trusted in emailed links.
+func (a *App) absoluteURL(path string) string {
+	return strings.TrimSuffix(a.conf.BaseURL, "/") + path
+}
+
+// Sends the verification link to the email the user asked for, or to their current one if they didn't ask for a new one.
+func (a *App) sendVerifyEmail(u user.User) error {
+	token, err := a.userService.IssueToken(u.Id, user.TokenEmailVerify, emailVerifyTTL)
+	if err != nil {
+		return err
+	}
+
+	to := u.Email
+	if u.PendingEmail.Valid {
+		to = u.PendingEmail.String
+	}
+
+	link := a.absoluteURL("/auth/verify?token=" + url.QueryEscape(token))
+	return a.mailer.Send(mail.Message{
+		To:      to,
+		Subject: "Verify your Clay Play email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in %d hours.\n",
+			u.FullName, link, int(emailVerifyTTL.Hours()),
+		),
+	})
+}
+
+func (a *App) renderForgotPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.renderPage(w, "auth/forgot.html", accountData{
+			CSRFToken: a.csrfToken(r),
+		})
+	}
+}
+
+func (a *App) handleForgotPassword() http.HandlerFunc {
+	type request struct {
+		Email string `schema:"email"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		// always show the same message so that this can't be used to find out who has an account
+		d := accountData{
+			Message:   "If an account exists for that email, we've sent a link to reset your password.",
+			CSRFToken: a.csrfToken(r),
+		}
+
+		u, err := a.userService.GetByEmail(strings.TrimSpace(req.Email))
+		if errors.Is(err, user.ErrNoUser) {
+			a.renderPage(w, "auth/forgot.html", d)
+			return
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		// failures are only logged, an error page would give away that the account exists
+		token, err := a.userService.IssueToken(u.Id, user.TokenPasswordReset, passwordResetTTL)
+		if err != nil {
+			a.log.Errorf(err.Error())
+			a.renderPage(w, "auth/forgot.html", d)
+			return
+		}
+
+		link := a.absoluteURL("/auth/reset?token=" + url.QueryEscape(token))
+		err = a.mailer.Send(mail.Message{
+			To:      u.Email,
+			Subject: "Reset your Clay Play password",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nSomeone asked to reset the password for your account. If it was you, open the link below:\n\n%s\n\nThe link expires in 1 hour. If you didn't ask for this, you can ignore this email.\n",
+				u.FullName, link,
+			),
+		})
+		if err != nil {
+			a.log.Errorf(err.Error())
+		}
+
+		a.renderPage(w, "auth/forgot.html", d)
+	}
+}
+
+func (a *App) renderResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.renderPage(w, "auth/reset.html", accountData{
+			Token:     r.URL.Query().Get("token"),
+			CSRFToken: a.csrfToken(r),
+		})
+	}
+}
+
+func (a *App) handleResetPassword() http.HandlerFunc {
+	type request struct {
+		Token    string `schema:"token"`
+		Password string `schema:"password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		d := accountData{
+			Token:     req.Token,
+			CSRFToken: a.csrfToken(r),
+		}
+
+		if len(req.Password) < minPasswordLength {
+			d.Error = "Password must be at least 8 characters."
+			w.WriteHeader(http.StatusBadRequest)
+			a.renderPage(w, "auth/reset.html", d)
+			return
+		}
+
+		u, err := a.userService.ResetPassword(req.Token, req.Password)
+		if errors.Is(err, user.ErrInvalidToken) {
+			d.Error = "This link is invalid or has expired. Please request a new one."
+			w.WriteHeader(http.StatusBadRequest)
+			a.renderPage(w, "auth/reset.html", d)
+			return
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.auditlogService.Create(u.Id, "Reset their password")
+		if err != nil {
+			a.log.Errorf(err.Error())
+		}
+
+		ld := a.newLoginData(r)
+		ld.Email = u.Email
+		ld.Message = "Your password was updated, please log in."
+		a.renderPage(w, "index.html", ld)
+	}
+}
+
+func (a *App) handleVerifyEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := accountData{}
+
+		u, err := a.userService.VerifyEmail(r.URL.Query().Get("token"))
+		if errors.Is(err, user.ErrInvalidToken) {
+			d.Error = "This link is invalid or has expired."
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.Is(err, user.ErrEmailTaken) {
+			d.Error = "Another account took this email in the meantime, please choose a different one."
+			w.WriteHeader(http.StatusConflict)
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		} else {
+			d.Message = "Thanks, your email address is verified."
+
+			err = a.auditlogService.Create(u.Id, "Verified their email "+html.EscapeString(u.Email))
+			if err != nil {
+				a.log.Errorf(err.Error())
+			}
+		}
+
+		a.renderPage(w, "auth/verify.html", d)
+	}
+}
+
+func (a *App) resendVerifyEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		u, err := a.userService.Get(su.Id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err := a.sendVerifyEmail(u); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("<small>We sent you a new verification link.</small>"))
+	}
+}
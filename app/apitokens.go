@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/Chaldron/clay-play/user"
+	"github.com/go-chi/chi/v5"
+)
+
+type contextKey string
+
+const apiTokenContextKey contextKey = "api_token"
+
+type tokenAuth struct {
+	Token user.APIToken
+	User  user.SessionUser
+}
+
+// Returns the token of an "Authorization: Bearer" header, if there is one.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+func isTokenRequest(r *http.Request) bool {
+	_, ok := r.Context().Value(apiTokenContextKey).(tokenAuth)
+	return ok
+}
+
+// Authenticates requests carrying an API token instead of a session cookie, for use in front of requireAuth.
+// Tokens only work on routes that opt in with this middleware, and need the read or write scope of the resource
+// depending on the request method.
+func (a *App) bearerAuth(resource string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := bearerToken(r)
+			if token == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			t, u, err := a.userService.AuthenticateAPIToken(token)
+			if errors.Is(err, user.ErrInvalidAPIToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				a.log.Errorf(err.Error())
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			scope := user.Scope(resource + ":write")
+			switch r.Method {
+			case http.MethodGet, http.MethodHead:
+				scope = user.Scope(resource + ":read")
+			}
+			if !t.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+string(scope)+`"`)
+				http.Error(w, "token is missing the "+string(scope)+" scope", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), apiTokenContextKey, tokenAuth{
+				Token: t,
+				User:  u.ToSessionUser(),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+type apiTokenData struct {
+	BaseData
+	Tokens   []user.APIToken
+	Scopes   []user.Scope
+	NewToken string
+}
+
+func (a *App) renderAPITokensPage(w http.ResponseWriter, u user.SessionUser, newToken string) {
+	tokens, err := a.userService.ListAPITokens(u.Id)
+	if err != nil {
+		a.renderErrorPage(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	a.renderPage(w, "me/tokens.html", apiTokenData{
+		BaseData: BaseData{
+			User: u,
+		},
+		Tokens:   tokens,
+		Scopes:   user.Scopes,
+		NewToken: newToken,
+	})
+}
+
+func (a *App) renderAPITokens() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		a.renderAPITokensPage(w, u, "")
+	}
+}
+
+func (a *App) createAPIToken() http.HandlerFunc {
+	type request struct {
+		Name   string       `schema:"name"`
+		Scopes []user.Scope `schema:"scopes"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if strings.TrimSpace(req.Name) == "" {
+			a.renderErrorNotif(w, errors.New("give the token a name"), http.StatusBadRequest)
+			return
+		}
+
+		t, token, err := a.userService.CreateAPIToken(user.CreateAPITokenParams{
+			UserId: u.Id,
+			Name:   req.Name,
+			Scopes: req.Scopes,
+		})
+		if errors.Is(err, user.ErrNoScopes) || errors.Is(err, user.ErrUnknownScope) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		scopes, _ := t.Scopes.Value()
+		a.audit(u, "Created API token "+html.EscapeString(t.Name)+" with scopes "+scopes.(string))
+
+		a.renderAPITokensPage(w, u, token)
+	}
+}
+
+func (a *App) revokeAPIToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		t, err := a.userService.RevokeAPIToken(u.Id, id)
+		if errors.Is(err, user.ErrNoAPIToken) {
+			a.renderErrorNotif(w, err, http.StatusNotFound)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(u, "Revoked API token "+html.EscapeString(t.Name))
+
+		http.Redirect(w, r, "/me/tokens", http.StatusSeeOther)
+	}
+}
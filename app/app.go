@@ -9,6 +9,10 @@ import (
 	"github.com/Chaldron/clay-play/event"
 	"github.com/Chaldron/clay-play/group"
 	"github.com/Chaldron/clay-play/logger"
+	"github.com/Chaldron/clay-play/mail"
+	"github.com/Chaldron/clay-play/notification"
+	"github.com/Chaldron/clay-play/openid"
+	"github.com/Chaldron/clay-play/review"
 	"github.com/Chaldron/clay-play/template"
 	"github.com/Chaldron/clay-play/user"
 
@@ -17,10 +21,15 @@ import (
 )
 
 type App struct {
-	eventService    event.Service
-	userService     user.Service
-	groupService    group.Service
-	auditlogService auditlog.Service
+	eventService        event.Service
+	userService         user.Service
+	groupService        group.Service
+	auditlogService     auditlog.Service
+	reviewService       review.Service
+	notificationService notification.Service
+
+	oidc   *openid.Provider
+	mailer mail.Sender
 
 	conf      *config.Config
 	session   *scs.SessionManager
@@ -33,6 +42,11 @@ func New(
 	userService user.Service,
 	groupService group.Service,
 	auditlogService auditlog.Service,
+	reviewService review.Service,
+	notificationService notification.Service,
+
+	oidc *openid.Provider,
+	mailer mail.Sender,
 
 	conf *config.Config,
 	session *scs.SessionManager,
@@ -40,10 +54,15 @@ func New(
 	log logger.Logger,
 ) *App {
 	return &App{
-		eventService:    eventService,
-		userService:     userService,
-		groupService:    groupService,
-		auditlogService: auditlogService,
+		eventService:        eventService,
+		userService:         userService,
+		groupService:        groupService,
+		auditlogService:     auditlogService,
+		reviewService:       reviewService,
+		notificationService: notificationService,
+
+		oidc:   oidc,
+		mailer: mailer,
 
 		conf:      conf,
 		session:   session,
@@ -114,19 +133,40 @@ func (a *App) renderErrorPage(
 	})
 }
 
-func (app *App) renewSessionUser(request *http.Request, user *user.SessionUser) error {
+// Records what the user did in the audit log. While an admin views the app as the user, the admin is recorded too,
+// so changes made on someone's behalf are never put down to that user alone.
+func (a *App) audit(su user.SessionUser, description string) {
+	var err error
+	if su.IsImpersonated() {
+		err = a.auditlogService.CreateImpersonated(su.Id, su.Impersonator.Id, description)
+	} else {
+		err = a.auditlogService.Create(su.Id, description)
+	}
+	if err != nil {
+		a.log.Errorf(err.Error())
+	}
+}
+
+func (app *App) renewSessionUser(request *http.Request, u *user.SessionUser, rememberMe bool) error {
+	prevToken := app.session.Token(request.Context())
+
 	err := app.session.RenewToken(request.Context())
 	if err != nil {
 		return err
 	}
 
-	rememberMe := request.URL.Query().Get("rememberme") == "on"
-
-	app.session.Put(request.Context(), "user", user)
+	app.session.Remove(request.Context(), csrfSessionKey) // new session, new token
+	app.session.Put(request.Context(), "user", u)
 	app.session.Cookie.Persist = false
 	app.session.RememberMe(request.Context(), rememberMe)
 
-	return nil
+	return app.userService.StartSession(user.StartSessionParams{
+		UserId:    u.Id,
+		Token:     app.session.Token(request.Context()),
+		PrevToken: prevToken,
+		UserAgent: request.UserAgent(),
+		IP:        clientIP(request),
+	})
 }
 
 func schemaDecode[T any](r *http.Request) (T, error) {
@@ -0,0 +1,256 @@
+package app
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/Chaldron/clay-play/event"
+	"github.com/go-chi/chi/v5"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Returns the PNG QR code of the text as a data URL that can be used as an image source.
+func qrCodeURL(text string) (template.URL, error) {
+	png, err := qrcode.Encode(text, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
+
+func (a *App) renderEventAttendance() http.HandlerFunc {
+	type data struct {
+		BaseData
+		Event       event.EventDetailed
+		Roster      []event.EventResponse
+		CheckInCode string
+		CheckInURL  string
+		QRCode      template.URL
+		Statuses    []event.AttendanceStatus
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		e, err := a.eventService.GetDetailed(id, u.Id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		code, err := a.eventService.GetCheckInCode(id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		checkInURL := a.absoluteURL("/event/" + id + "/checkin?code=" + url.QueryEscape(code))
+		qr, err := qrCodeURL(checkInURL)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		roster := []event.EventResponse{}
+		for _, resp := range e.Responses {
+			if !resp.OnWaitlist {
+				roster = append(roster, resp)
+			}
+		}
+
+		a.renderPage(w, "event/attendance.html", data{
+			BaseData: BaseData{
+				User: u,
+			},
+			Event:       e,
+			Roster:      roster,
+			CheckInCode: code,
+			CheckInURL:  checkInURL,
+			QRCode:      qr,
+			Statuses:    []event.AttendanceStatus{event.AttendancePresent, event.AttendanceLate, event.AttendanceAbsent},
+		})
+	}
+}
+
+func (a *App) recordAttendance() http.HandlerFunc {
+	type request struct {
+		UserId  int64                  `schema:"userId"`
+		GuestId int64                  `schema:"guestId"`
+		Status  event.AttendanceStatus `schema:"status"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		e, err := a.eventService.GetDetailed(id, u.Id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.eventService.RecordAttendance(event.RecordAttendanceParams{
+			EventId:    id,
+			UserId:     req.UserId,
+			GuestId:    req.GuestId,
+			Status:     req.Status,
+			RecordedBy: u.Id,
+		})
+		if errors.Is(err, event.ErrInvalidAttendance) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if errors.Is(err, event.ErrNotOnRoster) || errors.Is(err, event.ErrNoGuest) {
+			a.renderErrorNotif(w, err, http.StatusNotFound)
+			return
+		} else if errors.Is(err, event.ErrCheckInNotOpen) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		name := ""
+		for _, resp := range e.Responses {
+			if resp.UserId != req.UserId {
+				continue
+			}
+			name = resp.UserFullName
+			for _, g := range resp.Guests {
+				if g.Id == req.GuestId {
+					name = g.Name + ", guest of " + resp.UserFullName
+				}
+			}
+		}
+
+		a.audit(
+			u,
+			fmt.Sprintf("Marked %s %s at <a href=\"/event/%s\">%s</a>", html.EscapeString(name), req.Status, e.Id, html.EscapeString(e.Name)),
+		)
+
+		http.Redirect(w, r, "/event/"+id+"/attendance", http.StatusSeeOther)
+	}
+}
+
+func (a *App) renderCheckIn() http.HandlerFunc {
+	type data struct {
+		BaseData
+		Event event.EventDetailed
+		Code  string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		e, err := a.eventService.GetDetailed(id, u.Id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err = a.groupService.UserCanAccessError(e.GroupId, u.Id); err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "event/checkin.html", data{
+			BaseData: BaseData{
+				User: u,
+			},
+			Event: e,
+			Code:  r.URL.Query().Get("code"),
+		})
+	}
+}
+
+func (a *App) checkIn() http.HandlerFunc {
+	type request struct {
+		Code string `schema:"code"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		e, err := a.eventService.Get(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err = a.groupService.UserCanAccessError(e.GroupId, u.Id); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		status, err := a.eventService.CheckIn(id, u.Id, req.Code)
+		if errors.Is(err, event.ErrInvalidCheckInCode) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if errors.Is(err, event.ErrNotOnRoster) || errors.Is(err, event.ErrCheckInNotOpen) || errors.Is(err, event.ErrCheckInClosed) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(
+			u,
+			fmt.Sprintf("Checked in to <a href=\"/event/%s\">%s</a> (%s)", e.Id, html.EscapeString(e.Name), status),
+		)
+
+		http.Redirect(w, r, "/event/"+id, http.StatusSeeOther)
+	}
+}
+
+func (a *App) renderAttendanceStats() http.HandlerFunc {
+	type data struct {
+		BaseData
+		Stats        []event.AttendanceStats
+		Restrictions []event.Restriction
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		stats, err := a.eventService.ListAttendanceStats()
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		restrictions, err := a.eventService.ListRestrictions()
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "user/attendance.html", data{
+			BaseData: BaseData{
+				User: u,
+			},
+			Stats:        stats,
+			Restrictions: restrictions,
+		})
+	}
+}
@@ -1,60 +1,202 @@
 package app
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"html"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/Chaldron/clay-play/openid"
+	"github.com/Chaldron/clay-play/user"
 	gonanoid "github.com/matoous/go-nanoid/v2"
 )
 
-func (a *App) renderLogin() http.HandlerFunc {
+type loginData struct {
+	Error       string
+	Message     string
+	Email       string
+	CSRFToken   string
+	OIDCEnabled bool
+}
+
+func (a *App) newLoginData(r *http.Request) loginData {
+	return loginData{
+		CSRFToken:   a.csrfToken(r),
+		OIDCEnabled: a.oidc != nil,
+	}
+}
+
+func (a *App) handleLogin() http.HandlerFunc {
+	type request struct {
+		Email      string `schema:"email"`
+		Password   string `schema:"password"`
+		RememberMe bool   `schema:"rememberme"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		state, err := gonanoid.New()
+		req, err := schemaDecode[request](r)
 		if err != nil {
-			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			a.renderLoginError(w, r, "", err)
+			return
 		}
 
-		a.session.Put(r.Context(), "state", state)
+		email := strings.ToLower(strings.TrimSpace(req.Email))
 
-		http.Redirect(w, r, "/auth/callback", http.StatusSeeOther)
+		u, err := a.userService.HandleFromCreds(email, req.Password, clientIP(r))
+		var lockout *user.LockoutError
+		if errors.As(err, &lockout) && lockout.Started && lockout.UserId > -1 {
+			desc := "Login locked until " + lockout.Until.Format(time.RFC1123) + " after repeated failed attempts"
+			if lockout.ByIP {
+				desc += " from " + html.EscapeString(clientIP(r))
+			}
+			if err := a.auditlogService.Create(lockout.UserId, desc); err != nil {
+				a.log.Errorf(err.Error())
+			}
+		}
+		if err != nil {
+			a.renderLoginError(w, r, email, err)
+			return
+		}
+
+		a.loginUser(w, r, u, req.RememberMe)
 	}
 }
 
-func (app *App) handleLoginCallback() http.HandlerFunc {
-	return func(response http.ResponseWriter, request *http.Request) {
-		log.Printf("login callback: %s", request.URL.String())
+// Re-renders the login page with a message that is safe to show to the user.
+func (a *App) renderLoginError(w http.ResponseWriter, r *http.Request, email string, err error) {
+	msg := "Something went wrong, please try again."
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, user.ErrInvalidCredentials), errors.Is(err, user.ErrNoUser): // don't tell whether the account exists
+		msg = "Invalid email or password."
+		status = http.StatusUnauthorized
+	case errors.Is(err, user.ErrLockedOut):
+		msg = "Too many failed attempts, please try again later or reset your password."
+		status = http.StatusTooManyRequests
+	case errors.Is(err, user.ErrEmailNotVerified):
+		msg = "Verify your email, or reset your password, before signing in with single sign-on."
+		status = http.StatusUnauthorized
+	case errors.Is(err, openid.ErrEmailUnverified):
+		msg = "Your email has not been verified with your sign-in provider."
+		status = http.StatusUnauthorized
+	case errors.Is(err, errOIDCState), errors.Is(err, errLoginExpired):
+		msg = "Your sign-in attempt expired, please try again."
+		status = http.StatusBadRequest
+	default:
+		a.log.Errorf("login: %s", err.Error())
+	}
+
+	d := a.newLoginData(r)
+	d.Error = msg
+	d.Email = email
+
+	w.WriteHeader(status)
+	a.renderPage(w, "index.html", d)
+}
+
+// Returns the IP of the client, preferring the header set by the fly.io proxy.
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("Fly-Client-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Returns the page stored before the user was sent to log in, only allowing local paths.
+func (a *App) popRedirect(r *http.Request) string {
+	redirect := a.session.PopString(r.Context(), "redirect")
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		return "/home"
+	}
+	return redirect
+}
+
+var errOIDCState = errors.New("oidc state mismatch")
+
+func (a *App) handleOIDCLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.oidc == nil {
+			http.NotFound(w, r)
+			return
+		}
 
-		email := strings.ToLower(request.URL.Query().Get("email"))
-		password := request.URL.Query().Get("password")
+		state, err := gonanoid.New()
+		if err != nil {
+			a.renderLoginError(w, r, "", err)
+			return
+		}
 
-		user, err := app.userService.HandleFromCreds(email, password)
+		nonce, err := gonanoid.New()
 		if err != nil {
-			app.renderErrorPage(response, err, http.StatusInternalServerError)
+			a.renderLoginError(w, r, "", err)
 			return
 		}
 
-		sessionUser := user.ToSessionUser()
+		verifier := openid.GenerateVerifier()
 
-		log.Printf("sessionUser:%+v", sessionUser)
+		a.session.Put(r.Context(), "oidc_state", state)
+		a.session.Put(r.Context(), "oidc_nonce", nonce)
+		a.session.Put(r.Context(), "oidc_verifier", verifier)
+		a.session.Put(r.Context(), "oidc_rememberme", r.URL.Query().Get("rememberme") == "on")
 
-		if err := app.renewSessionUser(request, &sessionUser); err != nil {
-			app.renderErrorPage(response, err, http.StatusInternalServerError)
+		http.Redirect(w, r, a.oidc.AuthCodeURL(state, nonce, verifier), http.StatusSeeOther)
+	}
+}
+
+func (a *App) handleOIDCCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.oidc == nil {
+			http.NotFound(w, r)
 			return
 		}
 
-		redirect := app.session.PopString(request.Context(), "redirect")
-		if redirect != "" {
-			http.Redirect(response, request, redirect, http.StatusSeeOther)
-		} else {
-			http.Redirect(response, request, "/home", http.StatusSeeOther)
+		state := a.session.PopString(r.Context(), "oidc_state")
+		nonce := a.session.PopString(r.Context(), "oidc_nonce")
+		verifier := a.session.PopString(r.Context(), "oidc_verifier")
+		rememberMe := a.session.PopBool(r.Context(), "oidc_rememberme")
+
+		q := r.URL.Query()
+		if state == "" || q.Get("state") != state {
+			a.renderLoginError(w, r, "", errOIDCState)
+			return
 		}
+
+		if e := q.Get("error"); e != "" {
+			a.renderLoginError(w, r, "", fmt.Errorf("oidc provider: %s %s", e, q.Get("error_description")))
+			return
+		}
+
+		claims, err := a.oidc.Exchange(r.Context(), q.Get("code"), nonce, verifier)
+		if err != nil {
+			a.renderLoginError(w, r, "", err)
+			return
+		}
+
+		u, err := a.userService.HandleFromOIDC(claims.Email, claims.Picture)
+		if err != nil {
+			a.renderLoginError(w, r, claims.Email, err)
+			return
+		}
+
+		a.loginUser(w, r, u, rememberMe)
 	}
 }
 
 func (app *App) handleLogout() http.HandlerFunc {
 	return func(response http.ResponseWriter, request *http.Request) {
-		err := app.session.Destroy(request.Context())
+		err := app.userService.EndSession(app.session.Token(request.Context()))
+		if err != nil {
+			app.log.Errorf(err.Error())
+		}
+
+		err = app.session.Destroy(request.Context())
 		if err != nil {
 			app.renderErrorPage(response, err, http.StatusInternalServerError)
 			return
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/ical"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/go-chi/chi/v5"
+)
+
+// Events have no end time, calendar apps show them this long.
+const calendarEventDuration = 2 * time.Hour
+
+func (a *App) calendarEvent(e event.Event, status ical.Status) ical.Event {
+	description := ""
+	if e.Description.Valid {
+		description = e.Description.String
+	}
+
+	return ical.Event{
+		UID:          e.Id,
+		Summary:      e.Name,
+		Description:  description,
+		URL:          a.absoluteURL("/event/" + e.Id),
+		Start:        e.Start,
+		Duration:     calendarEventDuration,
+		Status:       status,
+		Sequence:     e.Sequence,
+		Created:      e.CreatedAt,
+		LastModified: e.LastModified(),
+	}
+}
+
+func writeCalendar(w http.ResponseWriter, filename string, c ical.Calendar) error {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	return c.Encode(w, db.Now())
+}
+
+func (a *App) downloadEventCalendar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		e, err := a.eventService.GetDetailed(id, u.Id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err = a.groupService.UserCanAccessError(e.GroupId, u.Id); err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		status := ical.StatusConfirmed
+		if e.UserResponse != nil && e.UserResponse.OnWaitlist {
+			status = ical.StatusTentative
+		}
+
+		err = writeCalendar(w, e.Id+".ics", ical.Calendar{
+			Events: []ical.Event{a.calendarEvent(e.Event, status)},
+		})
+		if err != nil {
+			a.log.Errorf(err.Error())
+		}
+	}
+}
+
+// Serves the calendar a user subscribed to. The token in the link authenticates the request, there is no session.
+func (a *App) serveCalendarFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := chi.URLParam(r, "token")
+
+		f, u, err := a.userService.AuthenticateCalendarFeed(token)
+		if errors.Is(err, user.ErrInvalidCalendarFeed) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			a.log.Errorf(err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		events, err := a.eventService.ListCalendar(event.CalendarFilter{
+			UserId:     u.Id,
+			IncludeAll: f.IncludeAll,
+		})
+		if err != nil {
+			a.log.Errorf(err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		c := ical.Calendar{Name: "Clay Play"}
+		for _, e := range events {
+			status := ical.StatusConfirmed
+			switch {
+			case e.IsDeleted:
+				status = ical.StatusCancelled
+			case !e.OnWaitlist.Valid:
+				status = "" // not going, just visible
+			case e.OnWaitlist.Bool:
+				status = ical.StatusTentative
+			}
+			c.Events = append(c.Events, a.calendarEvent(e.Event, status))
+		}
+
+		err = writeCalendar(w, "clay-play.ics", c)
+		if err != nil {
+			a.log.Errorf(err.Error())
+		}
+	}
+}
+
+type calendarFeedData struct {
+	BaseData
+	Feed      *user.CalendarFeed
+	FeedURL   string // only set right after the link was created
+	WebcalURL string
+}
+
+func (a *App) renderCalendarFeedPage(w http.ResponseWriter, r *http.Request, u user.SessionUser, token string) {
+	f, err := a.userService.GetCalendarFeed(u.Id)
+	if err != nil {
+		a.renderErrorPage(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	feedURL, webcal := "", ""
+	if token != "" {
+		feedURL = a.absoluteURL("/calendar/" + token + "/events.ics")
+		webcal = webcalURL(feedURL)
+	}
+
+	a.renderPage(w, "me/calendar.html", calendarFeedData{
+		BaseData: BaseData{
+			User: u,
+		},
+		Feed:      f,
+		FeedURL:   feedURL,
+		WebcalURL: webcal,
+	})
+}
+
+func (a *App) renderCalendarFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		a.renderCalendarFeedPage(w, r, u, "")
+	}
+}
+
+// Creates the calendar link, or replaces it so that the old one stops working.
+func (a *App) createCalendarFeed() http.HandlerFunc {
+	type request struct {
+		IncludeAll bool `schema:"includeAll"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		_, token, err := a.userService.CreateCalendarFeed(u.Id, req.IncludeAll)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(u, "Created a calendar link")
+
+		a.renderCalendarFeedPage(w, r, u, token)
+	}
+}
+
+func (a *App) updateCalendarFeed() http.HandlerFunc {
+	type request struct {
+		IncludeAll bool `schema:"includeAll"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		err = a.userService.SetCalendarFeedIncludeAll(u.Id, req.IncludeAll)
+		if errors.Is(err, user.ErrInvalidCalendarFeed) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/me/calendar", http.StatusSeeOther)
+	}
+}
+
+func (a *App) revokeCalendarFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		err := a.userService.RevokeCalendarFeed(u.Id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(u, "Revoked the calendar link")
+
+		http.Redirect(w, r, "/me/calendar", http.StatusSeeOther)
+	}
+}
+
+// Calendar apps subscribe to webcal links rather than downloading them once.
+func webcalURL(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		return "webcal" + url[i:]
+	}
+	return url
+}
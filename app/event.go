@@ -1,13 +1,18 @@
 package app
 
 import (
+	"errors"
 	"fmt"
+	"html"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Chaldron/clay-play/event"
 	"github.com/Chaldron/clay-play/group"
+	"github.com/Chaldron/clay-play/rrule"
 	"github.com/Chaldron/clay-play/template"
 	"github.com/Chaldron/clay-play/user"
 	"github.com/go-chi/chi/v5"
@@ -57,8 +62,9 @@ func (a *App) renderHome() http.HandlerFunc {
 func (a *App) renderNewEvent() http.HandlerFunc {
 	type data struct {
 		BaseData
-		Groups []group.Group
-		Users  []user.User
+		Groups               []group.Group
+		Users                []user.User
+		MaxSeriesOccurrences int
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -80,8 +86,9 @@ func (a *App) renderNewEvent() http.HandlerFunc {
 			BaseData: BaseData{
 				User: u,
 			},
-			Groups: g,
-			Users:  allU,
+			Groups:               g,
+			Users:                allU,
+			MaxSeriesOccurrences: event.MaxSeriesOccurrences,
 		})
 	}
 }
@@ -95,6 +102,11 @@ func (a *App) createEvent() http.HandlerFunc {
 		TimezoneOffset  int    `schema:"timezoneOffset"`
 		StudioMonitorId int64  `schema:"studioMonitorId"`
 		Description     string `schema:"description"`
+		OfferHours      int    `schema:"offerHours"`
+		MaxPartySize    int    `schema:"maxPartySize"`
+		Timezone        string `schema:"timezone"`
+		windowRequest
+		repeatRequest
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -112,6 +124,24 @@ func (a *App) createEvent() http.HandlerFunc {
 			return
 		}
 
+		rule, err := req.rule()
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		exDates, err := req.exDates()
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		opensAt, closesAt, cutoff, err := req.windows(req.TimezoneOffset)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
 		_, err = a.eventService.Create(event.CreateParams{
 			Name:            req.Name,
 			GroupId:         req.GroupId,
@@ -120,8 +150,22 @@ func (a *App) createEvent() http.HandlerFunc {
 			CreatorId:       u.Id,
 			StudioMonitorId: req.StudioMonitorId,
 			Description:     req.Description,
+			OfferHours:      req.OfferHours,
+			MaxPartySize:    req.MaxPartySize,
+			RRule:           rule,
+			ExDates:         exDates,
+			Timezone:        req.Timezone,
+
+			RegistrationOpensAt:  opensAt,
+			RegistrationClosesAt: closesAt,
+			CancellationCutoff:   cutoff,
 		})
-		if err != nil {
+		if errors.Is(err, rrule.ErrInvalid) || errors.Is(err, rrule.ErrUnsupported) || errors.Is(err, rrule.ErrNoEnd) ||
+			errors.Is(err, rrule.ErrTooMany) || errors.Is(err, event.ErrInvalidTimezone) || errors.Is(err, event.ErrNoOccurrences) ||
+			errors.Is(err, event.ErrInvalidOfferHours) || errors.Is(err, event.ErrInvalidWindow) || errors.Is(err, event.ErrInvalidPartySize) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
@@ -171,6 +215,10 @@ func (a *App) updateEvent() http.HandlerFunc {
 		TimezoneOffset  int    `schema:"timezoneOffset"`
 		StudioMonitorId int64  `schema:"studioMonitorId"`
 		Description     string `schema:"description"`
+		OfferHours      int    `schema:"offerHours"`
+		MaxPartySize    int    `schema:"maxPartySize"`
+		Scope           string `schema:"scope"`
+		windowRequest
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -195,6 +243,12 @@ func (a *App) updateEvent() http.HandlerFunc {
 			return
 		}
 
+		opensAt, closesAt, cutoff, err := req.windows(req.TimezoneOffset)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
 		if err := a.eventService.Update(event.UpdateParams{
 			Id:              id,
 			Name:            req.Name,
@@ -202,7 +256,23 @@ func (a *App) updateEvent() http.HandlerFunc {
 			Start:           start,
 			StudioMonitorId: req.StudioMonitorId,
 			Description:     req.Description,
-		}); err != nil {
+			OfferHours:      req.OfferHours,
+			MaxPartySize:    req.MaxPartySize,
+			Scope:           event.Scope(req.Scope),
+
+			RegistrationOpensAt:  opensAt,
+			RegistrationClosesAt: closesAt,
+			CancellationCutoff:   cutoff,
+
+			User: u,
+		}); errors.Is(err, event.ErrCannotManage) {
+			a.renderErrorNotif(w, err, http.StatusForbidden)
+			return
+		} else if errors.Is(err, event.ErrInvalidScope) || errors.Is(err, event.ErrInvalidOfferHours) || errors.Is(err, event.ErrInvalidWindow) ||
+			errors.Is(err, event.ErrInvalidPartySize) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
@@ -214,10 +284,18 @@ func (a *App) updateEvent() http.HandlerFunc {
 
 func (a *App) deleteEvent() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
 		id := chi.URLParam(r, "id")
+		scope := event.Scope(r.FormValue("scope"))
 
-		err := a.eventService.Delete(id)
-		if err != nil {
+		err := a.eventService.DeleteOccurrences(id, scope, u)
+		if errors.Is(err, event.ErrCannotManage) {
+			a.renderErrorNotif(w, err, http.StatusForbidden)
+			return
+		} else if errors.Is(err, event.ErrInvalidScope) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
@@ -229,8 +307,10 @@ func (a *App) deleteEvent() http.HandlerFunc {
 func (a *App) renderEventDetails() http.HandlerFunc {
 	type data struct {
 		BaseData
-		Event            event.EventDetailed
-		MaxAttendeeCount int
+		Event             event.EventDetailed
+		CanManage         bool
+		OnBehalf          bool
+		LateCancellations []event.LateCancellation
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -248,16 +328,170 @@ func (a *App) renderEventDetails() http.HandlerFunc {
 			return
 		}
 
+		canManage, err := a.eventService.UserCanManage(id, u)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		var late []event.LateCancellation
+		if canManage {
+			late, err = a.eventService.ListLateCancellations(id)
+			if err != nil {
+				a.renderErrorPage(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+
 		a.renderPage(w, "event/details.html", data{
 			BaseData: BaseData{
 				User: u,
 			},
-			Event:            e,
-			MaxAttendeeCount: event.MaxAttendeeCount,
+			Event:             e,
+			CanManage:         canManage,
+			OnBehalf:          actsOnBehalf(u),
+			LateCancellations: late,
 		})
 	}
 }
 
+func (a *App) acceptOffer() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		e, err := a.eventService.Get(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err = a.groupService.UserCanAccessError(e.GroupId, u.Id); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.eventService.AcceptOffer(id, u.Id)
+		if errors.Is(err, event.ErrNoOffer) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(
+			u,
+			fmt.Sprintf("Accepted a spot off the waitlist of <a href=\"/event/%s\">%s</a>", e.Id, html.EscapeString(e.Name)),
+		)
+
+		http.Redirect(w, r, "/event/"+id, http.StatusSeeOther)
+	}
+}
+
+func (a *App) addGuest() http.HandlerFunc {
+	type request struct {
+		Name  string `schema:"name"`
+		Email string `schema:"email"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		e, err := a.eventService.Get(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err = a.groupService.UserCanAccessError(e.GroupId, u.Id); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		g, err := a.eventService.AddGuest(event.AddGuestParams{
+			EventId:  id,
+			UserId:   u.Id,
+			Name:     req.Name,
+			Email:    req.Email,
+			Override: actsOnBehalf(u),
+		})
+		if errors.Is(err, event.ErrGuestNameRequired) || errors.Is(err, event.ErrInvalidGuestEmail) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if errors.Is(err, event.ErrNoResponse) || errors.Is(err, event.ErrPartyTooLarge) || errors.Is(err, event.ErrCannotRespondToPast) ||
+			errors.Is(err, event.ErrRegistrationNotOpen) || errors.Is(err, event.ErrRegistrationClosed) || errors.Is(err, event.ErrRestricted) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(
+			u,
+			fmt.Sprintf("Added guest %s to <a href=\"/event/%s\">%s</a>", html.EscapeString(g.Name), e.Id, html.EscapeString(e.Name)),
+		)
+
+		http.Redirect(w, r, "/event/"+id, http.StatusSeeOther)
+	}
+}
+
+func (a *App) removeGuest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		guestId, err := strconv.ParseInt(chi.URLParam(r, "guestId"), 10, 64)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		e, err := a.eventService.Get(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err = a.groupService.UserCanAccessError(e.GroupId, u.Id); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.eventService.RemoveGuest(event.RemoveGuestParams{
+			EventId:  id,
+			UserId:   u.Id,
+			GuestId:  guestId,
+			Override: actsOnBehalf(u),
+		})
+		if errors.Is(err, event.ErrNoGuest) {
+			a.renderErrorNotif(w, err, http.StatusNotFound)
+			return
+		} else if errors.Is(err, event.ErrCannotRespondToPast) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(
+			u,
+			fmt.Sprintf("Removed a guest from <a href=\"/event/%s\">%s</a>", e.Id, html.EscapeString(e.Name)),
+		)
+
+		http.Redirect(w, r, "/event/"+id, http.StatusSeeOther)
+	}
+}
+
 func (a *App) respondEvent() http.HandlerFunc {
 	type request struct {
 		Id            string `schema:"id"`
@@ -292,19 +526,21 @@ func (a *App) respondEvent() http.HandlerFunc {
 			UserId:        u.Id,
 			Id:            req.Id,
 			AttendeeCount: req.AttendeeCount,
+			Override:      actsOnBehalf(u),
 		})
-		if err != nil {
+		if errors.Is(err, event.ErrRegistrationNotOpen) || errors.Is(err, event.ErrRegistrationClosed) ||
+			errors.Is(err, event.ErrPartyTooLarge) || errors.Is(err, event.ErrRestricted) || errors.Is(err, event.ErrCannotRespondToPast) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		err = a.auditlogService.Create(
-			u.Id,
+		a.audit(
+			u,
 			fmt.Sprintf("Responded to <a href=\"/event/%s\">%s</a> with %d attendee(s)", e.Id, e.Name, req.AttendeeCount),
 		)
-		if err != nil {
-			a.log.Errorf(err.Error())
-		}
 
 		http.Redirect(w, r, "/event/"+req.Id, http.StatusSeeOther)
 	}
@@ -319,3 +555,182 @@ func timeFromForm(t string, offset int) (time.Time, error) {
 
 	return r, nil
 }
+
+// The registration section of the event forms, every time is optional.
+type windowRequest struct {
+	RegistrationOpensAt  string `schema:"registrationOpensAt"`
+	RegistrationClosesAt string `schema:"registrationClosesAt"`
+	CancellationCutoff   string `schema:"cancellationCutoff"`
+}
+
+// Returns when registration opens and closes and the cancellation cutoff, zero for those left empty.
+func (r windowRequest) windows(offset int) (time.Time, time.Time, time.Time, error) {
+	times := []time.Time{}
+	for _, s := range []string{r.RegistrationOpensAt, r.RegistrationClosesAt, r.CancellationCutoff} {
+		if s == "" {
+			times = append(times, time.Time{})
+			continue
+		}
+
+		t, err := timeFromForm(s, offset)
+		if err != nil {
+			return time.Time{}, time.Time{}, time.Time{}, err
+		}
+		times = append(times, t)
+	}
+
+	return times[0], times[1], times[2], nil
+}
+
+// The repeat section of the new event form.
+type repeatRequest struct {
+	Repeat   string   `schema:"repeat"` // DAILY, WEEKLY, MONTHLY, custom or empty for a single event
+	Interval int      `schema:"interval"`
+	ByDay    []string `schema:"byDay"`
+	Ends     string   `schema:"ends"` // count or until
+	Count    int      `schema:"count"`
+	Until    string   `schema:"until"`
+	ExDates  string   `schema:"exdates"` // comma separated dates
+	RRule    string   `schema:"rrule"`   // used as is when repeat is custom
+}
+
+// Builds the RRULE the form describes, or returns an empty string if the event doesn't repeat.
+func (r repeatRequest) rule() (string, error) {
+	switch r.Repeat {
+	case "":
+		return "", nil
+	case "custom":
+		return r.RRule, nil
+	case "DAILY", "WEEKLY", "MONTHLY":
+	default:
+		return "", fmt.Errorf("%w: unknown repeat %q", rrule.ErrInvalid, r.Repeat)
+	}
+
+	parts := []string{"FREQ=" + r.Repeat}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if r.Repeat == "WEEKLY" && len(r.ByDay) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(r.ByDay, ","))
+	}
+
+	switch r.Ends {
+	case "until":
+		until, err := time.Parse(time.DateOnly, r.Until)
+		if err != nil {
+			return "", fmt.Errorf("%w: choose the date the event stops repeating", rrule.ErrInvalid)
+		}
+		parts = append(parts, "UNTIL="+until.Format("20060102"))
+	default:
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+
+	return strings.Join(parts, ";"), nil
+}
+
+func (r repeatRequest) exDates() ([]time.Time, error) {
+	var dates []time.Time
+	for _, d := range strings.Split(r.ExDates, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded date %q, use YYYY-MM-DD", d)
+		}
+		dates = append(dates, t)
+	}
+	return dates, nil
+}
+
+func (a *App) enrollSeries() http.HandlerFunc {
+	type request struct {
+		AttendeeCount int `schema:"attendeeCount"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		e, err := a.eventService.Get(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !e.SeriesId.Valid {
+			a.renderErrorNotif(w, event.ErrNoSeries, http.StatusBadRequest)
+			return
+		}
+
+		if err = a.groupService.UserCanAccessError(e.GroupId, u.Id); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		enrollment, err := a.eventService.EnrollSeries(event.EnrollSeriesParams{
+			SeriesId:      e.SeriesId.String,
+			UserId:        u.Id,
+			AttendeeCount: req.AttendeeCount,
+			Override:      actsOnBehalf(u),
+		})
+		if errors.Is(err, event.ErrAlreadyEnrolled) || errors.Is(err, event.ErrNoUpcomingOccurrences) || errors.Is(err, event.ErrPartyTooLarge) ||
+			errors.Is(err, event.ErrRegistrationNotOpen) || errors.Is(err, event.ErrRegistrationClosed) || errors.Is(err, event.ErrRestricted) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		desc := "Enrolled in the series of"
+		if enrollment.OnWaitlist {
+			desc = "Joined the series waitlist of"
+		}
+		a.audit(
+			u,
+			fmt.Sprintf("%s <a href=\"/event/%s\">%s</a> with %d attendee(s)", desc, e.Id, html.EscapeString(e.Name), req.AttendeeCount),
+		)
+
+		http.Redirect(w, r, "/event/"+id, http.StatusSeeOther)
+	}
+}
+
+func (a *App) dropSeries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		e, err := a.eventService.Get(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !e.SeriesId.Valid {
+			a.renderErrorNotif(w, event.ErrNoSeries, http.StatusBadRequest)
+			return
+		}
+
+		err = a.eventService.DropSeries(e.SeriesId.String, u.Id)
+		if errors.Is(err, event.ErrNotEnrolled) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(
+			u,
+			fmt.Sprintf("Dropped out of the series of <a href=\"/event/%s\">%s</a>", e.Id, html.EscapeString(e.Name)),
+		)
+
+		http.Redirect(w, r, "/event/"+id, http.StatusSeeOther)
+	}
+}
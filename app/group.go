@@ -2,10 +2,12 @@ package app
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/Chaldron/clay-play/group"
+	"github.com/Chaldron/clay-play/user"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -49,14 +51,15 @@ func (a *App) createGroup() http.HandlerFunc {
 func (a *App) renderGroupDetails() http.HandlerFunc {
 	type data struct {
 		BaseData
-		Group group.GroupDetailed
+		Group     group.GroupDetailed
+		CanManage bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		u, _ := a.sessionUser(r)
 		id := chi.URLParam(r, "id")
 
-		if !u.IsAdmin {
+		if !u.Can(user.PermManageGroups) {
 			if err := a.groupService.UserCanAccessError(sql.NullString{
 				String: id,
 				Valid:  true,
@@ -72,11 +75,18 @@ func (a *App) renderGroupDetails() http.HandlerFunc {
 			return
 		}
 
+		canManage, err := a.groupService.UserCanManage(id, u)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
 		a.renderPage(w, "group/details.html", data{
 			BaseData: BaseData{
 				User: u,
 			},
-			Group: g,
+			Group:     g,
+			CanManage: canManage,
 		})
 	}
 }
@@ -90,7 +100,7 @@ func (a *App) renderGroupList() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		u, _ := a.sessionUser(r)
 
-		g, err := a.groupService.List()
+		g, err := a.groupService.ListManaged(u)
 		if err != nil {
 			a.renderErrorPage(w, err, http.StatusInternalServerError)
 			return
@@ -113,6 +123,10 @@ func (a *App) inviteGroup() http.HandlerFunc {
 			http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
 			return
 		}
+		if u.IsPending {
+			http.Redirect(w, r, "/review/request", http.StatusSeeOther)
+			return
+		}
 
 		id := chi.URLParam(r, "id")
 
@@ -157,6 +171,7 @@ func (a *App) updateGroup() http.HandlerFunc {
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
 		id := chi.URLParam(r, "id")
 
 		req, err := schemaDecode[request](r)
@@ -168,8 +183,12 @@ func (a *App) updateGroup() http.HandlerFunc {
 		err = a.groupService.Update(group.UpdateParams{
 			Id:   id,
 			Name: req.Name,
+			User: u,
 		})
-		if err != nil {
+		if errors.Is(err, group.ErrCannotManage) {
+			a.renderErrorNotif(w, err, http.StatusForbidden)
+			return
+		} else if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
@@ -180,10 +199,14 @@ func (a *App) updateGroup() http.HandlerFunc {
 
 func (a *App) deleteGroup() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
 		id := chi.URLParam(r, "id")
 
-		err := a.groupService.Delete(id)
-		if err != nil {
+		err := a.groupService.Delete(id, u)
+		if errors.Is(err, group.ErrCannotManage) {
+			a.renderErrorNotif(w, err, http.StatusForbidden)
+			return
+		} else if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
@@ -194,6 +217,7 @@ func (a *App) deleteGroup() http.HandlerFunc {
 
 func (a *App) removeGroupMember() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
 		id := chi.URLParam(r, "id")
 		userIdStr := chi.URLParam(r, "userId")
 		userId, err := strconv.ParseInt(userIdStr, 10, 64)
@@ -202,8 +226,11 @@ func (a *App) removeGroupMember() http.HandlerFunc {
 			return
 		}
 
-		err = a.groupService.RemoveMember(id, userId)
-		if err != nil {
+		err = a.groupService.RemoveMember(id, userId, u)
+		if errors.Is(err, group.ErrCannotManage) {
+			a.renderErrorNotif(w, err, http.StatusForbidden)
+			return
+		} else if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
@@ -214,10 +241,14 @@ func (a *App) removeGroupMember() http.HandlerFunc {
 
 func (a *App) refreshInviteLinkGroup() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
 		id := chi.URLParam(r, "id")
 
-		err := a.groupService.RefreshInviteId(id)
-		if err != nil {
+		err := a.groupService.RefreshInviteId(id, u)
+		if errors.Is(err, group.ErrCannotManage) {
+			a.renderErrorNotif(w, err, http.StatusForbidden)
+			return
+		} else if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
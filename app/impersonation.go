@@ -0,0 +1,180 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// The admin's own session user is kept under this key while they view the app as someone else.
+	impersonatorSessionKey = "impersonator"
+	// Impersonation ends by itself after this long, so that a forgotten tab does not stay signed in as someone else.
+	impersonationTTL = time.Hour
+)
+
+var (
+	ErrImpersonationReadOnly = errors.New("this action is blocked while viewing the app as another user")
+	ErrCannotImpersonate     = errors.New("this user can't be viewed as")
+)
+
+func (a *App) startImpersonation() http.HandlerFunc {
+	type request struct {
+		AllowWrites bool `schema:"allowwrites"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		u, err := a.userService.Get(id)
+		if errors.Is(err, user.ErrNoUser) {
+			a.renderErrorNotif(w, err, http.StatusNotFound)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		// other admins are off limits, viewing as them would hand out their permissions
+		if u.Id == su.Id || u.IsAdmin || u.IsPending || u.IsDeleted() {
+			a.renderErrorNotif(w, ErrCannotImpersonate, http.StatusForbidden)
+			return
+		}
+
+		target := u.ToSessionUser()
+		target.Impersonator = &user.Impersonator{
+			Id:          su.Id,
+			FullName:    su.FullName,
+			AllowWrites: req.AllowWrites,
+			StartedAt:   db.Now(),
+		}
+
+		a.session.Put(r.Context(), impersonatorSessionKey, su)
+		a.session.Put(r.Context(), "user", target)
+
+		mode := "read only"
+		if req.AllowWrites {
+			mode = "with changes allowed"
+		}
+		err = a.auditlogService.CreateImpersonated(u.Id, su.Id, fmt.Sprintf("Started viewing as %s, %s", html.EscapeString(u.FullName), mode))
+		if err != nil {
+			a.log.Errorf(err.Error())
+		}
+
+		http.Redirect(w, r, "/home", http.StatusSeeOther)
+	}
+}
+
+func (a *App) stopImpersonation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+		if !su.IsImpersonated() {
+			http.Redirect(w, r, "/home", http.StatusSeeOther)
+			return
+		}
+
+		err := a.endImpersonation(r, su, "Stopped viewing as "+html.EscapeString(su.FullName))
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/user/%d/edit", su.Id), http.StatusSeeOther)
+	}
+}
+
+// Gives the admin their own session back.
+func (a *App) endImpersonation(r *http.Request, su user.SessionUser, description string) error {
+	err := a.auditlogService.CreateImpersonated(su.Id, su.Impersonator.Id, description)
+	if err != nil {
+		a.log.Errorf(err.Error())
+	}
+
+	admin, ok := a.session.Get(r.Context(), impersonatorSessionKey).(user.SessionUser)
+	if !ok {
+		// nothing to go back to, so log out rather than stay signed in as the user
+		return a.session.Destroy(r.Context())
+	}
+
+	a.session.Remove(r.Context(), impersonatorSessionKey)
+	a.session.Put(r.Context(), "user", admin)
+	return nil
+}
+
+// Reports whether an admin is making changes for the user they are viewing the app as. Only then are registration windows,
+// sign-up restrictions and late cancellations waived, event managers signing themselves up follow the same rules as everyone.
+func actsOnBehalf(su user.SessionUser) bool {
+	return su.IsImpersonated() && su.Impersonator.AllowWrites
+}
+
+// Records every request made while an admin views the app as another user, with both identities,
+// and blocks requests that change data unless the admin allowed them when starting.
+func (a *App) impersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		su, ok := a.sessionUser(r)
+		if !ok || !su.IsImpersonated() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if time.Since(su.Impersonator.StartedAt) > impersonationTTL {
+			err := a.endImpersonation(r, su, "Stopped viewing as "+html.EscapeString(su.FullName)+" after it timed out")
+			if err != nil {
+				a.renderErrorPage(w, err, http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/home", http.StatusSeeOther)
+			return
+		}
+
+		// stopping is recorded by its handler, and the unread badge is loaded along with every page
+		if r.URL.Path == "/impersonate/stop" || r.URL.Path == "/notifications/badge" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed := true
+		description := r.Method + " " + r.URL.Path
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			description = "Viewed " + r.URL.Path
+		default:
+			// logging out ends the impersonation along with the session
+			if !su.Impersonator.AllowWrites && r.URL.Path != "/auth/logout" {
+				allowed = false
+				description = "Blocked " + description
+			}
+		}
+
+		err := a.auditlogService.CreateImpersonated(su.Id, su.Impersonator.Id, html.EscapeString(description))
+		if err != nil {
+			a.log.Errorf(err.Error())
+		}
+
+		if !allowed {
+			a.renderErrorNotif(w, ErrImpersonationReadOnly, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,320 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Chaldron/clay-play/group"
+	"github.com/Chaldron/clay-play/mail"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/go-chi/chi/v5"
+)
+
+func (a *App) sendInvitation(inv user.Invitation, token string) error {
+	link := a.absoluteURL("/auth/invite?token=" + url.QueryEscape(token))
+
+	greeting := "Hi,"
+	if inv.FullName != "" {
+		greeting = "Hi " + inv.FullName + ","
+	}
+
+	return a.mailer.Send(mail.Message{
+		To:      inv.Email,
+		Subject: "You're invited to Clay Play",
+		Body: fmt.Sprintf(
+			"%s\n\nYou've been invited to join Clay Play. Open the link below to choose a password and set up your account:\n\n%s\n\nThe link expires in %d days and can only be used once.\n",
+			greeting, link, int(user.InvitationTTL.Hours()/24),
+		),
+	})
+}
+
+func (a *App) renderNewUser() http.HandlerFunc {
+	type data struct {
+		BaseData
+		Roles  []user.Role
+		Groups []group.Group
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		groups, err := a.groupService.List()
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "user/new.html", data{
+			BaseData: BaseData{
+				User: u,
+			},
+			Roles:  user.AssignableRoles,
+			Groups: groups,
+		})
+	}
+}
+
+func (a *App) inviteUser() http.HandlerFunc {
+	type request struct {
+		Name    string      `schema:"name"`
+		Email   string      `schema:"email"`
+		IsAdmin bool        `schema:"isadmin"`
+		Roles   []user.Role `schema:"roles"`
+		Groups  []string    `schema:"groups"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		inv, token, err := a.userService.CreateInvitation(user.CreateInvitationParams{
+			Email:     req.Email,
+			FullName:  req.Name,
+			IsAdmin:   req.IsAdmin,
+			Roles:     req.Roles,
+			GroupIds:  req.Groups,
+			InvitedBy: su.Id,
+		})
+		if errors.Is(err, user.ErrEmailTaken) || errors.Is(err, user.ErrInvitationPending) || errors.Is(err, user.ErrInvalidEmail) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Invited "+html.EscapeString(inv.Email))
+
+		if err := a.sendInvitation(inv, token); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/user/invitations", http.StatusSeeOther)
+	}
+}
+
+func (a *App) renderInvitations() http.HandlerFunc {
+	type invitation struct {
+		user.Invitation
+		InvitedByName string
+		GroupNames    []string
+	}
+	type data struct {
+		BaseData
+		Invitations []invitation
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		invitations, err := a.userService.ListInvitations()
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		users, err := a.userService.GetAll()
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+		names := map[int64]string{}
+		for _, u := range users {
+			names[u.Id] = u.FullName
+		}
+
+		groups, err := a.groupService.List()
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+		groupNames := map[string]string{}
+		for _, g := range groups {
+			groupNames[g.Id] = g.Name
+		}
+
+		d := data{
+			BaseData: BaseData{
+				User: su,
+			},
+			Invitations: []invitation{},
+		}
+		for _, inv := range invitations {
+			i := invitation{Invitation: inv, InvitedByName: names[inv.InvitedBy]}
+			for _, g := range inv.GroupIds {
+				if name, ok := groupNames[g]; ok {
+					i.GroupNames = append(i.GroupNames, name)
+				}
+			}
+			d.Invitations = append(d.Invitations, i)
+		}
+
+		a.renderPage(w, "user/invitations.html", d)
+	}
+}
+
+func (a *App) resendInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		inv, token, err := a.userService.ResendInvitation(chi.URLParam(r, "id"))
+		if errors.Is(err, user.ErrNoInvitation) {
+			a.renderErrorNotif(w, err, http.StatusNotFound)
+			return
+		} else if errors.Is(err, user.ErrInvitationClosed) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Resent the invitation to "+html.EscapeString(inv.Email))
+
+		if err := a.sendInvitation(inv, token); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/user/invitations", http.StatusSeeOther)
+	}
+}
+
+func (a *App) revokeInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		inv, err := a.userService.RevokeInvitation(chi.URLParam(r, "id"))
+		if errors.Is(err, user.ErrNoInvitation) {
+			a.renderErrorNotif(w, err, http.StatusNotFound)
+			return
+		} else if errors.Is(err, user.ErrInvitationClosed) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Revoked the invitation to "+html.EscapeString(inv.Email))
+
+		http.Redirect(w, r, "/user/invitations", http.StatusSeeOther)
+	}
+}
+
+type invitationData struct {
+	Error     string
+	Token     string
+	Name      string
+	Email     string
+	CSRFToken string
+}
+
+func (a *App) renderAcceptInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := invitationData{
+			Token:     r.URL.Query().Get("token"),
+			CSRFToken: a.csrfToken(r),
+		}
+
+		inv, err := a.userService.GetInvitationByToken(d.Token)
+		if errors.Is(err, user.ErrInvalidToken) {
+			d.Error = "This invitation is invalid or has expired. Ask an admin to send you a new one."
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+		d.Name = inv.FullName
+		d.Email = inv.Email
+
+		a.renderPage(w, "auth/invite.html", d)
+	}
+}
+
+func (a *App) handleAcceptInvitation() http.HandlerFunc {
+	type request struct {
+		Token    string `schema:"token"`
+		Name     string `schema:"name"`
+		Password string `schema:"password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		d := invitationData{
+			Token:     req.Token,
+			Name:      req.Name,
+			CSRFToken: a.csrfToken(r),
+		}
+
+		inv, err := a.userService.GetInvitationByToken(req.Token)
+		if errors.Is(err, user.ErrInvalidToken) {
+			d.Error = "This invitation is invalid or has expired. Ask an admin to send you a new one."
+			w.WriteHeader(http.StatusBadRequest)
+			a.renderPage(w, "auth/invite.html", d)
+			return
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+		d.Email = inv.Email
+
+		if strings.TrimSpace(req.Name) == "" {
+			d.Error = "Please enter your name."
+			w.WriteHeader(http.StatusBadRequest)
+			a.renderPage(w, "auth/invite.html", d)
+			return
+		}
+		if len(req.Password) < minPasswordLength {
+			d.Error = "Password must be at least 8 characters."
+			w.WriteHeader(http.StatusBadRequest)
+			a.renderPage(w, "auth/invite.html", d)
+			return
+		}
+
+		inv, u, err := a.userService.AcceptInvitation(user.AcceptInvitationParams{
+			Token:    req.Token,
+			FullName: req.Name,
+			Password: req.Password,
+		})
+		if errors.Is(err, user.ErrInvalidToken) {
+			d.Error = "This invitation is invalid or has expired. Ask an admin to send you a new one."
+			w.WriteHeader(http.StatusBadRequest)
+			a.renderPage(w, "auth/invite.html", d)
+			return
+		} else if errors.Is(err, user.ErrEmailTaken) {
+			d.Error = "An account with this email already exists, please log in instead."
+			w.WriteHeader(http.StatusConflict)
+			a.renderPage(w, "auth/invite.html", d)
+			return
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.auditlogService.Create(u.Id, "Accepted their invitation")
+		if err != nil {
+			a.log.Errorf(err.Error())
+		}
+
+		ld := a.newLoginData(r)
+		ld.Email = u.Email
+		ld.Message = "Your account is ready, please log in."
+		a.renderPage(w, "index.html", ld)
+	}
+}
@@ -1,14 +1,24 @@
 package app
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/group"
 	user "github.com/Chaldron/clay-play/user"
+	"github.com/go-chi/chi/v5"
 )
 
 func (a *App) sessionUser(r *http.Request) (user.SessionUser, bool) {
+	if t, ok := r.Context().Value(apiTokenContextKey).(tokenAuth); ok {
+		return t.User, true
+	}
+
 	u, ok := a.session.Get(r.Context(), "user").(user.SessionUser)
 	o := ok && u.IsAuthenticated()
 	return u, o
@@ -16,6 +26,31 @@ func (a *App) sessionUser(r *http.Request) (user.SessionUser, bool) {
 
 // TODO: clear sessions if auth fails
 func (a *App) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := a.sessionUser(r)
+		if ok && a.mustEnroll2FA(u.IsAdmin) && !u.TwoFactor && !isTokenRequest(r) {
+			// admin sessions from before two-factor authentication was required have to log in again
+			if err := a.session.Destroy(r.Context()); err != nil {
+				a.renderErrorPage(w, err, http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		} else if ok && u.IsPending {
+			http.Redirect(w, r, "/review/request", http.StatusSeeOther)
+			return
+		} else if ok {
+			next.ServeHTTP(w, r)
+			return
+		} else {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	})
+}
+
+// Like requireAuth, but also lets through users whose registration is still pending review.
+func (a *App) requireAuthOrPending(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, ok := a.sessionUser(r)
 		if ok {
@@ -40,6 +75,83 @@ func (a *App) isAdmin(next http.Handler) http.Handler {
 	})
 }
 
+// Only lets through users granted the permission by one of their roles.
+func (a *App) requirePermission(p user.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u, _ := a.sessionUser(r); u.Can(p) {
+				next.ServeHTTP(w, r)
+			} else {
+				status := http.StatusForbidden
+				a.renderErrorPage(w, errors.New(http.StatusText(status)), status)
+				return
+			}
+		})
+	}
+}
+
+// Only lets through users who can manage the event in the {id} URL parameter.
+func (a *App) canManageEvent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		err := a.eventService.UserCanManageError(id, u)
+		if errors.Is(err, event.ErrCannotManage) {
+			a.renderErrorPage(w, err, http.StatusForbidden)
+			return
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Only lets through users who can manage the group in the {id} URL parameter.
+func (a *App) canManageGroup(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		err := a.groupService.UserCanManageError(id, u)
+		if errors.Is(err, group.ErrCannotManage) {
+			a.renderErrorPage(w, err, http.StatusForbidden)
+			return
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Keeps the last seen time of the session up to date, and logs the user out if the session was revoked.
+func (a *App) trackSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if su, ok := a.sessionUser(r); ok {
+			err := a.userService.TouchSession(user.TouchSessionParams{
+				UserId:    su.Id,
+				Token:     a.session.Token(r.Context()),
+				UserAgent: r.UserAgent(),
+				IP:        clientIP(r),
+			})
+			if errors.Is(err, user.ErrNoSession) {
+				if err := a.session.Destroy(r.Context()); err != nil {
+					a.renderErrorPage(w, err, http.StatusInternalServerError)
+					return
+				}
+			} else if err != nil {
+				a.log.Errorf(err.Error())
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (a *App) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -54,3 +166,68 @@ func (a *App) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+const (
+	csrfSessionKey = "csrf_token"
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+var ErrInvalidCSRF = errors.New("invalid or missing CSRF token, please reload the page")
+
+// Returns the CSRF token of the current session, for forms that are not submitted through htmx.
+func (a *App) csrfToken(r *http.Request) string {
+	return a.session.GetString(r.Context(), csrfSessionKey)
+}
+
+// Protects state-changing requests with a token stored in the session.
+// The token is also exposed in a cookie so that htmx can send it back in a header, see ui/public/index.js.
+func (a *App) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// browsers never attach an Authorization header on their own, so requests bearerAuth authenticated can't be forged.
+		// Anything else, even with such a header, is authenticated by the session cookie and needs the token.
+		if isTokenRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := a.session.GetString(r.Context(), csrfSessionKey)
+		if token == "" {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				a.renderErrorPage(w, err, http.StatusInternalServerError)
+				return
+			}
+			token = base64.RawURLEncoding.EncodeToString(b)
+			a.session.Put(r.Context(), csrfSessionKey, token)
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookieName,
+			Value:    token,
+			Path:     "/",
+			Secure:   a.session.Cookie.Secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sent := r.Header.Get(csrfHeaderName)
+		if sent == "" {
+			sent = r.PostFormValue(csrfFormField)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			status := http.StatusForbidden
+			a.renderErrorPage(w, ErrInvalidCSRF, status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
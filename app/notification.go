@@ -0,0 +1,60 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/Chaldron/clay-play/notification"
+)
+
+const notificationListLimit = 50
+
+func (a *App) renderNotifications() http.HandlerFunc {
+	type data struct {
+		BaseData
+		Notifications []notification.Notification
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		notifications, err := a.notificationService.List(u.Id, notificationListLimit)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "notifications.html", data{
+			BaseData: BaseData{
+				User: u,
+			},
+			Notifications: notifications,
+		})
+
+		// they were listed unread this once, so that new ones stand out. An admin viewing the app as the user
+		// leaves them unread for the user to see.
+		if u.IsImpersonated() {
+			return
+		}
+		err = a.notificationService.MarkAllRead(u.Id)
+		if err != nil {
+			a.log.Errorf(err.Error())
+		}
+	}
+}
+
+// The unread count next to the inbox link, loaded after the page so that every page doesn't need to query it.
+func (a *App) renderNotificationBadge() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		n, err := a.notificationService.CountUnread(u.Id)
+		if err != nil {
+			a.log.Errorf(err.Error())
+			n = 0
+		}
+
+		a.renderTemplate(w, "notifications.html", "badge", map[string]any{
+			"Unread": n,
+		})
+	}
+}
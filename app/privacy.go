@@ -0,0 +1,452 @@
+package app
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/review"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/go-chi/chi/v5"
+)
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+type exportProfile struct {
+	Id                 int64      `json:"id"`
+	FullName           string     `json:"full_name"`
+	Email              string     `json:"email"`
+	EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+	PendingEmail       string     `json:"pending_email,omitempty"`
+	Picture            string     `json:"picture,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	IsAdmin            bool       `json:"is_admin"`
+	IsPending          bool       `json:"is_pending"`
+	Roles              []string   `json:"roles"`
+	TwoFactorEnabled   bool       `json:"two_factor_enabled"`
+	DeletedAt          *time.Time `json:"deleted_at"`
+	ErasureRequestedAt *time.Time `json:"erasure_requested_at"`
+	RegistrationNote   string     `json:"registration_note,omitempty"`
+}
+
+type exportResponse struct {
+	EventId       string        `json:"event_id"`
+	EventName     string        `json:"event_name"`
+	EventStart    time.Time     `json:"event_start"`
+	AttendeeCount int           `json:"attendee_count"`
+	Guests        []exportGuest `json:"guests"`
+	OnWaitlist    bool          `json:"on_waitlist"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+type exportGuest struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+type exportAttendance struct {
+	EventId    string    `json:"event_id"`
+	EventName  string    `json:"event_name"`
+	EventStart time.Time `json:"event_start"`
+	Status     string    `json:"status"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+type exportMembership struct {
+	GroupId   string    `json:"group_id"`
+	GroupName string    `json:"group_name"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+type exportAuditEntry struct {
+	RecordedAt   time.Time `json:"recorded_at"`
+	Description  string    `json:"description"`
+	Impersonator string    `json:"viewed_by_admin,omitempty"`
+}
+
+type exportSession struct {
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+type exportAPIToken struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// Writes a zip archive of everything stored about the user, one JSON file per kind of data plus their avatar.
+func (a *App) writeDataExport(w http.ResponseWriter, userId int64) error {
+	u, err := a.userService.Get(userId)
+	if err != nil {
+		return err
+	}
+
+	totp, err := a.userService.GetTOTPStatus(userId)
+	if err != nil {
+		return err
+	}
+
+	profile := exportProfile{
+		Id:                 u.Id,
+		FullName:           u.FullName,
+		Email:              u.Email,
+		EmailVerifiedAt:    nullTime(u.EmailVerifiedAt),
+		PendingEmail:       u.PendingEmail.String,
+		Picture:            u.Picture.String,
+		CreatedAt:          u.CreatedAt,
+		IsAdmin:            u.IsAdmin,
+		IsPending:          u.IsPending,
+		Roles:              []string{},
+		TwoFactorEnabled:   totp.Enabled,
+		DeletedAt:          nullTime(u.DeletedAt),
+		ErasureRequestedAt: nullTime(u.ErasureRequestedAt),
+	}
+	for _, r := range u.Roles {
+		profile.Roles = append(profile.Roles, string(r))
+	}
+
+	rv, err := a.reviewService.Get(userId)
+	if err != nil && !errors.Is(err, review.ErrNoReview) {
+		return err
+	}
+	profile.RegistrationNote = rv.Comment.String
+
+	ur, err := a.eventService.ListUserResponses(userId)
+	if err != nil {
+		return err
+	}
+	responses := []exportResponse{}
+	for _, r := range ur {
+		guests := []exportGuest{}
+		for _, g := range r.Guests {
+			guests = append(guests, exportGuest{Name: g.Name, Email: g.Email.String})
+		}
+
+		responses = append(responses, exportResponse{
+			EventId:       r.EventId,
+			EventName:     r.EventName,
+			EventStart:    r.EventStart,
+			AttendeeCount: r.AttendeeCount,
+			Guests:        guests,
+			OnWaitlist:    r.OnWaitlist,
+			CreatedAt:     r.CreatedAt,
+			UpdatedAt:     r.UpdatedAt,
+		})
+	}
+
+	ua, err := a.eventService.ListUserAttendance(userId)
+	if err != nil {
+		return err
+	}
+	attendance := []exportAttendance{}
+	for _, r := range ua {
+		attendance = append(attendance, exportAttendance{
+			EventId:    r.EventId,
+			EventName:  r.EventName,
+			EventStart: r.EventStart,
+			Status:     string(r.Status),
+			RecordedAt: r.RecordedAt,
+		})
+	}
+
+	gm, err := a.groupService.ListUserMemberships(userId)
+	if err != nil {
+		return err
+	}
+	memberships := []exportMembership{}
+	for _, m := range gm {
+		memberships = append(memberships, exportMembership{
+			GroupId:   m.GroupId,
+			GroupName: m.GroupName,
+			JoinedAt:  m.CreatedAt,
+		})
+	}
+
+	al, err := a.auditlogService.ListByUser(userId)
+	if err != nil {
+		return err
+	}
+	audit := []exportAuditEntry{}
+	for _, e := range al {
+		audit = append(audit, exportAuditEntry{
+			RecordedAt:   e.RecordedAt,
+			Description:  e.Description,
+			Impersonator: e.ImpersonatorFullName.String,
+		})
+	}
+
+	us, err := a.userService.ListSessions(userId)
+	if err != nil {
+		return err
+	}
+	sessions := []exportSession{}
+	for _, s := range us {
+		sessions = append(sessions, exportSession{
+			Device:     s.Device(),
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+		})
+	}
+
+	ut, err := a.userService.ListAPITokens(userId)
+	if err != nil {
+		return err
+	}
+	tokens := []exportAPIToken{}
+	for _, t := range ut {
+		e := exportAPIToken{
+			Name:       t.Name,
+			Scopes:     []string{},
+			CreatedAt:  t.CreatedAt,
+			LastUsedAt: nullTime(t.LastUsedAt),
+		}
+		for _, s := range t.Scopes {
+			e.Scopes = append(e.Scopes, string(s))
+		}
+		tokens = append(tokens, e)
+	}
+
+	avatar, err := a.userService.GetAvatar(userId)
+	if err != nil && !errors.Is(err, user.ErrNoAvatar) {
+		return err
+	}
+
+	name := fmt.Sprintf("clay-play-%d-%s.zip", userId, db.Now().Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", profile},
+		{"event_responses.json", responses},
+		{"attendance.json", attendance},
+		{"group_memberships.json", memberships},
+		{"audit_log.json", audit},
+		{"sessions.json", sessions},
+		{"api_tokens.json", tokens},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(f.v); err != nil {
+			return err
+		}
+	}
+
+	if avatar.Data != nil {
+		ext := strings.TrimPrefix(avatar.ContentType, "image/")
+		fw, err := zw.Create("avatar." + ext)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(avatar.Data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (a *App) renderPrivacySettings() http.HandlerFunc {
+	type data struct {
+		BaseData
+		Profile user.User
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		u, err := a.userService.Get(su.Id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "me/privacy.html", data{
+			BaseData: BaseData{
+				User: su,
+			},
+			Profile: u,
+		})
+	}
+}
+
+func (a *App) exportOwnData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		// recorded first, so that the export includes it
+		a.audit(su, "Exported their personal data")
+
+		if err := a.writeDataExport(w, su.Id); err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (a *App) exportUserData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		u, err := a.userService.Get(id)
+		if errors.Is(err, user.ErrNoUser) {
+			a.renderErrorPage(w, err, http.StatusNotFound)
+			return
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Exported the personal data of "+html.EscapeString(u.FullName))
+
+		if err := a.writeDataExport(w, id); err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (a *App) requestErasure() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		err := a.userService.RequestErasure(su.Id)
+		if errors.Is(err, user.ErrErasureRequested) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Requested erasure of their personal data")
+
+		http.Redirect(w, r, "/me/privacy", http.StatusSeeOther)
+	}
+}
+
+func (a *App) cancelErasure() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		err := a.userService.CancelErasure(su.Id)
+		if errors.Is(err, user.ErrNoErasureRequest) {
+			a.renderErrorNotif(w, err, http.StatusNotFound)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Withdrew their erasure request")
+
+		http.Redirect(w, r, "/me/privacy", http.StatusSeeOther)
+	}
+}
+
+func (a *App) renderErasureList() http.HandlerFunc {
+	type data struct {
+		BaseData
+		Users []user.User
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		users, err := a.userService.ListErasureRequests()
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "user/erasure.html", data{
+			BaseData: BaseData{
+				User: su,
+			},
+			Users: users,
+		})
+	}
+}
+
+// Carries out an erasure: the user is deactivated, removed from groups and upcoming events and anonymized, all at once.
+// Admins can also erase users that asked for it some other way.
+func (a *App) eraseUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if id == 0 {
+			a.renderErrorNotif(w, errors.New("default admin user cannot be erased"), http.StatusForbidden)
+			return
+		}
+
+		u, err := a.userService.Get(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.eventService.RemoveUser(event.RemoveUserParams{UserId: id, Anonymize: true})
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.notificationService.DeleteUserNotifications(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		desc := "Erased the personal data of user " + strconv.FormatInt(id, 10)
+		if u.ErasureRequestedAt.Valid {
+			desc += ", as they requested on " + u.ErasureRequestedAt.Time.Format("Jan 02, 2006")
+		}
+		a.audit(su, desc)
+
+		http.Redirect(w, r, "/user/erasure", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Chaldron/clay-play/mail"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/go-chi/chi/v5"
+)
+
+type profileData struct {
+	BaseData
+	Profile user.User
+	Message string
+}
+
+func (a *App) renderProfilePage(w http.ResponseWriter, su user.SessionUser, message string) {
+	u, err := a.userService.Get(su.Id)
+	if err != nil {
+		a.renderErrorPage(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	a.renderPage(w, "me/profile.html", profileData{
+		BaseData: BaseData{
+			User: su,
+		},
+		Profile: u,
+		Message: message,
+	})
+}
+
+func (a *App) renderProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+		a.renderProfilePage(w, su, "")
+	}
+}
+
+func (a *App) updateProfile() http.HandlerFunc {
+	type request struct {
+		Name  string `schema:"name"`
+		Email string `schema:"email"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		old, err := a.userService.Get(su.Id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		u, err := a.userService.UpdateProfile(user.UpdateProfileParams{
+			Id:       su.Id,
+			FullName: req.Name,
+			Email:    req.Email,
+		})
+		if errors.Is(err, user.ErrProfileRequired) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if errors.Is(err, user.ErrEmailTaken) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		su.FullName = u.FullName
+		a.session.Put(r.Context(), "user", su)
+
+		message := "Your profile was updated."
+		if u.PendingEmail.Valid && u.PendingEmail != old.PendingEmail {
+			a.audit(su, "Asked to change their email from "+html.EscapeString(u.Email)+" to "+html.EscapeString(u.PendingEmail.String))
+
+			// let the current address know, in case someone else took over the account
+			err = a.mailer.Send(mail.Message{
+				To:      u.Email,
+				Subject: "Your Clay Play email is being changed",
+				Body: fmt.Sprintf(
+					"Hi %s,\n\nSomeone asked to change the email address of your account to %s. It changes once the new address is verified. If this wasn't you, please contact an admin.\n",
+					u.FullName, u.PendingEmail.String,
+				),
+			})
+			if err != nil {
+				a.log.Errorf(err.Error())
+			}
+
+			if err := a.sendVerifyEmail(u); err != nil {
+				a.renderErrorNotif(w, err, http.StatusInternalServerError)
+				return
+			}
+			message = "Your profile was updated. We sent a link to " + u.PendingEmail.String + ", your email changes once you open it."
+		} else if old.PendingEmail.Valid && !u.PendingEmail.Valid {
+			message = "Your profile was updated and the email change was cancelled."
+		}
+		if u.FullName != old.FullName {
+			a.audit(su, "Changed their name from "+html.EscapeString(old.FullName)+" to "+html.EscapeString(u.FullName))
+		}
+
+		a.renderProfilePage(w, su, message)
+	}
+}
+
+func (a *App) changePassword() http.HandlerFunc {
+	type request struct {
+		Current  string `schema:"current"`
+		Password string `schema:"password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if len(req.Password) < minPasswordLength {
+			a.renderErrorNotif(w, errors.New("password must be at least 8 characters"), http.StatusBadRequest)
+			return
+		}
+
+		err = a.userService.ChangePassword(user.ChangePasswordParams{
+			Id:        su.Id,
+			Current:   req.Current,
+			New:       req.Password,
+			KeepToken: a.session.Token(r.Context()),
+		})
+		if errors.Is(err, user.ErrWrongPassword) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Changed their password")
+
+		a.renderProfilePage(w, su, "Your password was changed and your other sessions were logged out.")
+	}
+}
+
+func (a *App) uploadAvatar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		// leave some room for the rest of the multipart body
+		r.Body = http.MaxBytesReader(w, r.Body, user.MaxAvatarSize+64<<10)
+		if err := r.ParseMultipartForm(user.MaxAvatarSize); err != nil {
+			a.renderErrorNotif(w, user.ErrAvatarTooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		f, _, err := r.FormFile("avatar")
+		if err != nil {
+			a.renderErrorNotif(w, errors.New("choose an image to upload"), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(io.LimitReader(f, user.MaxAvatarSize+1))
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		_, err = a.userService.SetAvatar(su.Id, data)
+		if errors.Is(err, user.ErrAvatarTooLarge) {
+			a.renderErrorNotif(w, err, http.StatusRequestEntityTooLarge)
+			return
+		} else if errors.Is(err, user.ErrInvalidAvatar) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Updated their avatar")
+
+		http.Redirect(w, r, "/me", http.StatusSeeOther)
+	}
+}
+
+func (a *App) deleteAvatar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		err := a.userService.DeleteAvatar(su.Id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Removed their avatar")
+
+		http.Redirect(w, r, "/me", http.StatusSeeOther)
+	}
+}
+
+func (a *App) serveAvatar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		avatar, err := a.userService.GetAvatar(id)
+		if errors.Is(err, user.ErrNoAvatar) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			a.log.Errorf(err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", avatar.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// the url changes whenever the avatar does
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		w.Write(avatar.Data)
+	}
+}
@@ -0,0 +1,256 @@
+package app
+
+import (
+	"errors"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/Chaldron/clay-play/review"
+	"github.com/Chaldron/clay-play/user"
+)
+
+const minPasswordLength = 8
+
+type registerData struct {
+	Error     string
+	Name      string
+	Email     string
+	Comment   string
+	CSRFToken string
+}
+
+func (a *App) renderRegister() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ok := a.sessionUser(r)
+		if ok {
+			http.Redirect(w, r, "/home", http.StatusSeeOther)
+			return
+		}
+
+		a.renderPage(w, "auth/register.html", registerData{
+			CSRFToken: a.csrfToken(r),
+		})
+	}
+}
+
+func (a *App) handleRegister() http.HandlerFunc {
+	type request struct {
+		Name     string `schema:"name"`
+		Email    string `schema:"email"`
+		Password string `schema:"password"`
+		Comment  string `schema:"comment"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		d := registerData{
+			Name:      strings.TrimSpace(req.Name),
+			Email:     strings.ToLower(strings.TrimSpace(req.Email)),
+			Comment:   req.Comment,
+			CSRFToken: a.csrfToken(r),
+		}
+
+		renderError := func(msg string, status int) {
+			d.Error = msg
+			w.WriteHeader(status)
+			a.renderPage(w, "auth/register.html", d)
+		}
+
+		if d.Name == "" || d.Email == "" {
+			renderError("Name and email are required.", http.StatusBadRequest)
+			return
+		}
+		if len(req.Password) < minPasswordLength {
+			renderError("Password must be at least 8 characters.", http.StatusBadRequest)
+			return
+		}
+
+		u, err := a.userService.Create(user.CreateParams{
+			FullName:  d.Name,
+			Email:     d.Email,
+			Password:  req.Password,
+			IsPending: true,
+		})
+		if errors.Is(err, user.ErrEmailTaken) {
+			renderError("An account with this email already exists.", http.StatusConflict)
+			return
+		} else if err != nil {
+			a.log.Errorf("register: %s", err.Error())
+			renderError("Something went wrong, please try again.", http.StatusInternalServerError)
+			return
+		}
+
+		err = a.reviewService.Request(review.RequestParams{
+			UserId:  u.Id,
+			Comment: req.Comment,
+		})
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err := a.sendVerifyEmail(u); err != nil {
+			a.log.Errorf("register: sending verification email: %s", err.Error())
+		}
+
+		sessionUser := u.ToSessionUser()
+		if err := a.renewSessionUser(r, &sessionUser, false); err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/review/request", http.StatusSeeOther)
+	}
+}
+
+func (a *App) renderReviewRequest() http.HandlerFunc {
+	type data struct {
+		BaseData
+		UserReview    review.UserReview
+		EmailVerified bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		u, err := a.userService.Get(su.Id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if !u.IsPending { // approved since they logged in
+			sessionUser := u.ToSessionUser()
+			a.session.Put(r.Context(), "user", sessionUser)
+			http.Redirect(w, r, "/home", http.StatusSeeOther)
+			return
+		}
+
+		ur, err := a.reviewService.Get(su.Id)
+		if err != nil && !errors.Is(err, review.ErrNoReview) {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "review/request.html", data{
+			BaseData: BaseData{
+				User: su,
+			},
+			UserReview:    ur,
+			EmailVerified: u.EmailVerifiedAt.Valid,
+		})
+	}
+}
+
+func (a *App) handleReviewRequest() http.HandlerFunc {
+	type request struct {
+		Comment string `schema:"comment"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+		if !su.IsPending {
+			http.Redirect(w, r, "/home", http.StatusSeeOther)
+			return
+		}
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.reviewService.Request(review.RequestParams{
+			UserId:  su.Id,
+			Comment: req.Comment,
+		})
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("<small>Thanks, your comment was saved.</small>"))
+	}
+}
+
+func (a *App) renderReviewList() http.HandlerFunc {
+	type data struct {
+		BaseData
+		Reviews []review.UserReview
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		rl, err := a.reviewService.List()
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "review/list.html", data{
+			BaseData: BaseData{
+				User: u,
+			},
+			Reviews: rl,
+		})
+	}
+}
+
+func (a *App) approveReview() http.HandlerFunc {
+	type request struct {
+		UserId int64 `schema:"user_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		ur, err := a.reviewService.Approve(req.UserId)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Approved registration of "+html.EscapeString(ur.UserFullName))
+
+		http.Redirect(w, r, "/review/list", http.StatusSeeOther)
+	}
+}
+
+func (a *App) rejectReview() http.HandlerFunc {
+	type request struct {
+		UserId int64 `schema:"user_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		ur, err := a.reviewService.Reject(req.UserId)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Rejected registration of "+html.EscapeString(ur.UserFullName)+" ("+html.EscapeString(ur.UserEmail)+")")
+
+		http.Redirect(w, r, "/review/list", http.StatusSeeOther)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/Chaldron/clay-play/auditlog"
+	"github.com/Chaldron/clay-play/user"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httprate"
@@ -19,57 +20,157 @@ func (a *App) Routes() http.Handler {
 	r.Handle("/public/*", http.StripPrefix("/public/", publicFileServer))
 
 	r.Get("/privacy", a.renderPrivacy())
+	r.With(httprate.LimitByIP(60, 1*time.Minute), middleware.Logger, a.recoverPanic).
+		Get("/calendar/{token}/events.ics", a.serveCalendarFeed())
 
 	r.Group(func(r chi.Router) {
 		r.Use(httprate.LimitAll(100, 1*time.Minute))
 		r.Use(middleware.Logger)
 		r.Use(a.recoverPanic)
 		r.Use(a.session.LoadAndSave)
+		r.Use(a.trackSession)
 
-		r.Get("/", a.renderIndex())
+		// csrf and impersonation run after bearerAuth, so that they know which requests a token authenticated
+		r.Group(func(r chi.Router) {
+			r.Use(a.csrf)
+			r.Use(a.impersonation)
+
+			r.Get("/", a.renderIndex())
+
+			r.Route("/auth", func(r chi.Router) {
+				r.Get("/login", a.renderIndex())
+				r.Post("/login", a.handleLogin())
+				r.Get("/oidc/login", a.handleOIDCLogin())
+				r.Get("/oidc/callback", a.handleOIDCCallback())
+				r.Get("/register", a.renderRegister())
+				r.Post("/register", a.handleRegister())
+				r.Get("/2fa", a.renderTwoFactor())
+				r.Post("/2fa", a.handleTwoFactor())
+				r.Get("/2fa/setup", a.renderTwoFactorEnroll())
+				r.Post("/2fa/setup", a.handleTwoFactorEnroll())
+				r.Get("/forgot", a.renderForgotPassword())
+				r.Post("/forgot", a.handleForgotPassword())
+				r.Get("/reset", a.renderResetPassword())
+				r.Post("/reset", a.handleResetPassword())
+				r.Get("/invite", a.renderAcceptInvitation())
+				r.Post("/invite", a.handleAcceptInvitation())
+				r.Get("/verify", a.handleVerifyEmail())
+				r.With(a.requireAuthOrPending).Post("/verify/resend", a.resendVerifyEmail())
+
+				r.With(a.requireAuthOrPending).Post("/logout", a.handleLogout())
+			})
+
+			r.Route("/review", func(r chi.Router) {
+				r.Group(func(r chi.Router) {
+					r.Use(a.requireAuthOrPending)
+
+					r.Get("/request", a.renderReviewRequest())
+					r.Post("/request", a.handleReviewRequest())
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(a.requireAuth)
+					r.Use(a.requirePermission(user.PermManageUsers))
+
+					r.Get("/list", a.renderReviewList())
+					r.Post("/approve", a.approveReview())
+					r.Post("/reject", a.rejectReview())
+				})
+			})
 
-		r.Route("/auth", func(r chi.Router) {
-			r.Get("/login", a.renderLogin())
-			r.Get("/callback", a.handleLoginCallback())
+			r.Group(func(r chi.Router) {
+				r.Use(a.requireAuth)
 
-			r.With(a.requireAuth).Get("/logout", a.handleLogout())
+				r.Get("/me", a.renderProfile())
+				r.Post("/me", a.updateProfile())
+				r.Post("/me/password", a.changePassword())
+				r.Post("/me/avatar", a.uploadAvatar())
+				r.Delete("/me/avatar", a.deleteAvatar())
+				r.Get("/me/sessions", a.renderSessions())
+				r.Delete("/me/sessions/{id}", a.revokeSession())
+				r.Get("/me/2fa", a.renderTwoFactorSettings())
+				r.Get("/me/2fa/setup", a.renderTwoFactorSetup())
+				r.Post("/me/2fa/setup", a.enableTwoFactor())
+				r.Post("/me/2fa/disable", a.disableTwoFactor())
+				r.Post("/me/2fa/recovery", a.regenerateRecoveryCodes())
+				r.Get("/me/privacy", a.renderPrivacySettings())
+				r.Get("/me/export", a.exportOwnData())
+				r.Post("/me/erasure", a.requestErasure())
+				r.Delete("/me/erasure", a.cancelErasure())
+				r.Get("/me/tokens", a.renderAPITokens())
+				r.Post("/me/tokens", a.createAPIToken())
+				r.Delete("/me/tokens/{id}", a.revokeAPIToken())
+				r.Get("/me/calendar", a.renderCalendarFeed())
+				r.Post("/me/calendar", a.createCalendarFeed())
+				r.Post("/me/calendar/options", a.updateCalendarFeed())
+				r.Delete("/me/calendar", a.revokeCalendarFeed())
+				r.Get("/notifications", a.renderNotifications())
+				r.Get("/notifications/badge", a.renderNotificationBadge())
+				r.With(a.isAdmin).Get("/admin", a.renderAdmin())
+				r.Post("/impersonate/stop", a.stopImpersonation())
+				r.With(a.requirePermission(user.PermViewAuditlog)).Get("/auditlog", a.renderAuditlog())
+			})
 		})
 
 		r.Group(func(r chi.Router) {
+			r.Use(a.bearerAuth("events"))
+			r.Use(a.csrf)
+			r.Use(a.impersonation)
 			r.Use(a.requireAuth)
 
 			r.Get("/home", a.renderHome())
-			r.With(a.isAdmin).Get("/admin", a.renderAdmin())
-			r.With(a.isAdmin).Get("/auditlog", a.renderAuditlog())
 
 			r.Route("/event", func(r chi.Router) {
 				r.Group(func(r chi.Router) {
-					r.Use(a.isAdmin)
+					r.Use(a.requirePermission(user.PermCreateEvents))
 
 					r.Get("/new", a.renderNewEvent())
 					r.Post("/new", a.createEvent())
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(a.canManageEvent)
+
 					r.Get("/{id}/edit", a.renderEditEvent())
 					r.Post("/{id}/edit", a.updateEvent())
 					r.Delete("/{id}/edit", a.deleteEvent())
+					r.Get("/{id}/attendance", a.renderEventAttendance())
+					r.Post("/{id}/attendance", a.recordAttendance())
 				})
 
 				r.Get("/{id}", a.renderEventDetails())
+				r.Get("/{id}/calendar.ics", a.downloadEventCalendar())
 				r.Post("/respond", a.respondEvent())
+				r.Post("/{id}/offer/accept", a.acceptOffer())
+				r.Post("/{id}/guest", a.addGuest())
+				r.Delete("/{id}/guest/{guestId}", a.removeGuest())
+				r.Get("/{id}/checkin", a.renderCheckIn())
+				r.Post("/{id}/checkin", a.checkIn())
+				r.Post("/{id}/series/enroll", a.enrollSeries())
+				r.Post("/{id}/series/drop", a.dropSeries())
 			})
 		})
 
 		r.Route("/group", func(r chi.Router) {
-			r.Get("/{id}/invite", a.inviteGroup())
+			r.With(a.csrf, a.impersonation).Get("/{id}/invite", a.inviteGroup())
 
 			r.Group(func(r chi.Router) {
+				r.Use(a.bearerAuth("groups"))
+				r.Use(a.csrf)
+				r.Use(a.impersonation)
 				r.Use(a.requireAuth)
 
 				r.Group(func(r chi.Router) {
-					r.Use(a.isAdmin)
+					r.Use(a.requirePermission(user.PermCreateGroups))
 
 					r.Get("/list", a.renderGroupList())
 					r.Get("/new", a.renderNewGroup())
 					r.Post("/new", a.createGroup())
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(a.canManageGroup)
+
 					r.Get("/{id}/edit", a.renderEditGroup())
 					r.Post("/{id}/edit", a.updateGroup())
 					r.Delete("/{id}/edit", a.deleteGroup())
@@ -83,19 +184,40 @@ func (a *App) Routes() http.Handler {
 
 		r.Route("/user", func(r chi.Router) {
 			r.Group(func(r chi.Router) {
+				r.Use(a.bearerAuth("users"))
+				r.Use(a.csrf)
+				r.Use(a.impersonation)
 				r.Use(a.requireAuth)
 
 				r.Group(func(r chi.Router) {
-					r.Use(a.isAdmin)
+					r.Use(a.requirePermission(user.PermManageUsers))
 
 					r.Get("/list", a.renderUserList())
+					r.Get("/erasure", a.renderErasureList())
+					r.Get("/attendance", a.renderAttendanceStats())
+					r.Get("/import", a.renderUserImport())
+					r.Post("/import", a.importUsers())
+					r.Get("/export.csv", a.exportUsers())
 					r.Get("/new", a.renderNewUser())
-					r.Post("/new", a.createUser())
+					r.Post("/new", a.inviteUser())
+					r.Get("/invitations", a.renderInvitations())
+					r.Post("/invitations/{id}/resend", a.resendInvitation())
+					r.Delete("/invitations/{id}", a.revokeInvitation())
 					r.Get("/{id}/edit", a.renderEditUser())
 					r.Post("/{id}/edit", a.updateUser())
 					r.Delete("/{id}/edit", a.deleteUser())
+					r.Post("/{id}/unlock", a.unlockUser())
+					r.Post("/{id}/logout", a.forceLogoutUser())
+					r.Post("/{id}/strikes/{strikeId}/forgive", a.forgiveStrike())
+					r.Post("/{id}/2fa/reset", a.resetUserTwoFactor())
+					r.Post("/{id}/reactivate", a.reactivateUser())
+					r.Post("/{id}/anonymize", a.anonymizeUser())
+					r.Get("/{id}/export", a.exportUserData())
+					r.Post("/{id}/erase", a.eraseUser())
+					r.With(a.isAdmin).Post("/{id}/impersonate", a.startImpersonation())
 				})
 
+				r.Get("/{id}/avatar", a.serveAvatar())
 			})
 		})
 
@@ -118,7 +240,7 @@ func (a *App) renderIndex() http.HandlerFunc {
 			return
 		}
 
-		a.renderPage(w, "index.html", nil)
+		a.renderPage(w, "index.html", a.newLoginData(r))
 	}
 }
 
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Chaldron/clay-play/user"
+	"github.com/go-chi/chi/v5"
+)
+
+func (a *App) renderSessions() http.HandlerFunc {
+	type data struct {
+		BaseData
+		Sessions  []user.Session
+		CurrentId string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		token := a.session.Token(r.Context())
+
+		sessions, err := a.userService.ListSessions(u.Id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		currentId := ""
+		for _, s := range sessions {
+			if s.Token == token {
+				currentId = s.Id
+			}
+		}
+
+		a.renderPage(w, "me/sessions.html", data{
+			BaseData: BaseData{
+				User: u,
+			},
+			Sessions:  sessions,
+			CurrentId: currentId,
+		})
+	}
+}
+
+func (a *App) revokeSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+		id := chi.URLParam(r, "id")
+
+		sessions, err := a.userService.ListSessions(u.Id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		isCurrent := false
+		for _, s := range sessions {
+			if s.Id == id && s.Token == a.session.Token(r.Context()) {
+				isCurrent = true
+			}
+		}
+
+		err = a.userService.RevokeSession(u.Id, id)
+		if errors.Is(err, user.ErrNoSession) {
+			a.renderErrorNotif(w, err, http.StatusNotFound)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if isCurrent {
+			if err := a.session.Destroy(r.Context()); err != nil {
+				a.renderErrorNotif(w, err, http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		http.Redirect(w, r, "/me/sessions", http.StatusSeeOther)
+	}
+}
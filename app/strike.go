@@ -0,0 +1,95 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+
+	"github.com/Chaldron/clay-play/event"
+	"github.com/go-chi/chi/v5"
+)
+
+// Records every strike and sign-up restriction change in the audit log, meant to be subscribed to the event service.
+// Changes nobody made for the user, i.e. the user's own late cancellations, restrictions starting and ending and
+// changes by the system, are recorded as happening to the user rather than as the user acting on themselves.
+func (a *App) AuditStrikeChanged(c event.StrikeChanged) {
+	self := c.ActorId < 0 || c.ActorId == c.UserId
+	actorId := c.ActorId
+	if self {
+		actorId = c.UserId
+	}
+
+	eventLink := fmt.Sprintf("<a href=\"/event/%s\">%s</a>", c.Strike.EventId, html.EscapeString(c.Strike.EventName))
+	var desc string
+	switch c.Kind {
+	case event.StrikeAdded:
+		if self {
+			desc = fmt.Sprintf("Got a strike for a %s at %s", c.Strike.Kind, eventLink)
+		} else {
+			desc = fmt.Sprintf("Gave %s a strike for a %s at %s", html.EscapeString(c.Strike.UserFullName), c.Strike.Kind, eventLink)
+		}
+	case event.StrikeRemoved:
+		if self {
+			desc = fmt.Sprintf("No-show strike at %s was taken back as the attendance was corrected", eventLink)
+		} else {
+			desc = fmt.Sprintf("Took back the no-show strike of %s at %s as their attendance was corrected", html.EscapeString(c.Strike.UserFullName), eventLink)
+		}
+	case event.StrikeForgiven:
+		if self {
+			desc = fmt.Sprintf("The %s strike at %s was forgiven", c.Strike.Kind, eventLink)
+		} else {
+			desc = fmt.Sprintf("Forgave the %s strike of %s at %s", c.Strike.Kind, html.EscapeString(c.Strike.UserFullName), eventLink)
+		}
+	case event.RestrictionStarted:
+		// restrictions follow from the strikes by themselves, whoever gave the last one
+		actorId = c.UserId
+		until := c.Restriction.EndsAt.Format("Jan 2, 2006")
+		if c.Restriction.Mode == event.RestrictionBlock {
+			desc = fmt.Sprintf("Blocked from signing up until %s after repeated strikes", until)
+		} else {
+			desc = fmt.Sprintf("Restricted to waitlist sign-ups until %s after repeated strikes", until)
+		}
+	case event.RestrictionLifted:
+		if self {
+			desc = "Sign-up restriction was lifted"
+		} else {
+			desc = fmt.Sprintf("Lifted the sign-up restriction of %s", html.EscapeString(c.Restriction.UserFullName))
+		}
+	case event.RestrictionEnded:
+		desc = "Sign-up restriction ended"
+	default:
+		return
+	}
+
+	err := a.auditlogService.Create(actorId, desc)
+	if err != nil {
+		a.log.Errorf(err.Error())
+	}
+}
+
+func (a *App) forgiveStrike() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		idStr := chi.URLParam(r, "id")
+		strikeId, err := strconv.ParseInt(chi.URLParam(r, "strikeId"), 10, 64)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		// audited through AuditStrikeChanged
+		err = a.eventService.ForgiveStrike(strikeId, su.Id)
+		if errors.Is(err, event.ErrNoStrike) {
+			a.renderErrorNotif(w, err, http.StatusNotFound)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/user/"+idStr+"/edit", http.StatusSeeOther)
+	}
+}
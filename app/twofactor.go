@@ -0,0 +1,468 @@
+package app
+
+import (
+	"errors"
+	"html"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Chaldron/clay-play/totp"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	twoFactorUserKey     = "2fa_user_id"
+	twoFactorRememberKey = "2fa_rememberme"
+	twoFactorStartedKey  = "2fa_started_at"
+
+	// How long the second login step can take after the password was accepted.
+	twoFactorTTL = 10 * time.Minute
+
+	totpIssuer = "Clay Play"
+)
+
+var (
+	ErrTwoFactorRequired = errors.New("admins are required to use two-factor authentication")
+	errLoginExpired      = errors.New("login attempt expired")
+)
+
+type twoFactorData struct {
+	BaseData
+	Error         string
+	CSRFToken     string
+	Action        string // where the setup form posts to
+	Secret        string
+	QRCode        template.URL
+	RecoveryCodes []string
+	Continue      string
+	Status        user.TOTPStatus
+	Required      bool
+}
+
+// Whether the user has to set up two-factor authentication before they can log in.
+func (a *App) mustEnroll2FA(isAdmin bool) bool {
+	return isAdmin && a.conf.RequireAdmin2FA
+}
+
+// Finishes the password or SSO step of a login. Users with two-factor authentication,
+// or admins who are required to set it up, are sent to the second step before the SessionUser is stored.
+func (a *App) loginUser(w http.ResponseWriter, r *http.Request, u user.User, rememberMe bool) {
+	status, err := a.userService.GetTOTPStatus(u.Id)
+	if err != nil {
+		a.renderLoginError(w, r, u.Email, err)
+		return
+	}
+
+	if status.Enabled || a.mustEnroll2FA(u.IsAdmin) {
+		a.session.Put(r.Context(), twoFactorUserKey, u.Id)
+		a.session.Put(r.Context(), twoFactorRememberKey, rememberMe)
+		a.session.Put(r.Context(), twoFactorStartedKey, time.Now().Unix())
+
+		if status.Enabled {
+			http.Redirect(w, r, "/auth/2fa", http.StatusSeeOther)
+		} else {
+			http.Redirect(w, r, "/auth/2fa/setup", http.StatusSeeOther)
+		}
+		return
+	}
+
+	sessionUser := u.ToSessionUser()
+
+	if err := a.renewSessionUser(r, &sessionUser, rememberMe); err != nil {
+		a.renderLoginError(w, r, u.Email, err)
+		return
+	}
+
+	http.Redirect(w, r, a.popRedirect(r), http.StatusSeeOther)
+}
+
+// Returns the user that passed the first login step, if they did so recently enough.
+func (a *App) pendingTwoFactorUser(r *http.Request) (int64, bool) {
+	id, ok := a.session.Get(r.Context(), twoFactorUserKey).(int64)
+	started := a.session.GetInt64(r.Context(), twoFactorStartedKey)
+	if !ok || time.Since(time.Unix(started, 0)) > twoFactorTTL {
+		return 0, false
+	}
+	return id, true
+}
+
+// Stores the SessionUser once the second login step is done.
+func (a *App) finishTwoFactorLogin(r *http.Request, u user.User) error {
+	rememberMe := a.session.GetBool(r.Context(), twoFactorRememberKey)
+	a.session.Remove(r.Context(), twoFactorUserKey)
+	a.session.Remove(r.Context(), twoFactorRememberKey)
+	a.session.Remove(r.Context(), twoFactorStartedKey)
+
+	sessionUser := u.ToSessionUser()
+	sessionUser.TwoFactor = true
+
+	return a.renewSessionUser(r, &sessionUser, rememberMe)
+}
+
+func (a *App) renderTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := a.pendingTwoFactorUser(r); !ok {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		a.renderPage(w, "auth/2fa.html", twoFactorData{
+			CSRFToken: a.csrfToken(r),
+		})
+	}
+}
+
+func (a *App) handleTwoFactor() http.HandlerFunc {
+	type request struct {
+		Code string `schema:"code"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := a.pendingTwoFactorUser(r)
+		if !ok {
+			a.renderLoginError(w, r, "", errLoginExpired)
+			return
+		}
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		err = a.userService.VerifyTOTP(id, req.Code, clientIP(r))
+		var lockout *user.LockoutError
+		if errors.As(err, &lockout) && lockout.Started {
+			desc := "Login locked until " + lockout.Until.Format(time.RFC1123) + " after repeated failed two-factor attempts"
+			if err := a.auditlogService.Create(id, desc); err != nil {
+				a.log.Errorf(err.Error())
+			}
+		}
+		if err != nil {
+			d := twoFactorData{CSRFToken: a.csrfToken(r)}
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, user.ErrInvalidTOTP):
+				d.Error = "Invalid code, please try again."
+				status = http.StatusUnauthorized
+			case errors.Is(err, user.ErrLockedOut):
+				d.Error = "Too many failed attempts, please try again later."
+				status = http.StatusTooManyRequests
+			default:
+				a.log.Errorf("two-factor: %s", err.Error())
+				d.Error = "Something went wrong, please try again."
+			}
+
+			w.WriteHeader(status)
+			a.renderPage(w, "auth/2fa.html", d)
+			return
+		}
+
+		u, err := a.userService.Get(id)
+		if err != nil {
+			a.renderLoginError(w, r, "", err)
+			return
+		}
+
+		if err := a.finishTwoFactorLogin(r, u); err != nil {
+			a.renderLoginError(w, r, u.Email, err)
+			return
+		}
+
+		http.Redirect(w, r, a.popRedirect(r), http.StatusSeeOther)
+	}
+}
+
+// Renders the secret for the user to scan, the confirmation code is posted to d.Action.
+func (a *App) renderTwoFactorSetupPage(w http.ResponseWriter, r *http.Request, d twoFactorData, u user.User) {
+	secret, err := a.userService.BeginTOTP(u.Id)
+	if err != nil {
+		a.renderErrorPage(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	qr, err := totp.QRCode(totp.URI(totpIssuer, u.Email, secret))
+	if err != nil {
+		a.renderErrorPage(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	d.CSRFToken = a.csrfToken(r)
+	d.Secret = secret
+	d.QRCode = template.URL(qr)
+	a.renderPage(w, "auth/2fa-setup.html", d)
+}
+
+// Setup during login, for admins who are required to use two-factor authentication but have not set it up yet.
+func (a *App) renderTwoFactorEnroll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := a.pendingTwoFactorUser(r)
+		if !ok {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		u, err := a.userService.Get(id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderTwoFactorSetupPage(w, r, twoFactorData{
+			Action:   "/auth/2fa/setup",
+			Required: true,
+		}, u)
+	}
+}
+
+func (a *App) handleTwoFactorEnroll() http.HandlerFunc {
+	type request struct {
+		Code string `schema:"code"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := a.pendingTwoFactorUser(r)
+		if !ok {
+			a.renderLoginError(w, r, "", errLoginExpired)
+			return
+		}
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		u, err := a.userService.Get(id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		codes, err := a.userService.EnableTOTP(id, req.Code)
+		if errors.Is(err, user.ErrInvalidTOTP) {
+			w.WriteHeader(http.StatusUnauthorized)
+			a.renderTwoFactorSetupPage(w, r, twoFactorData{
+				Action:   "/auth/2fa/setup",
+				Required: true,
+				Error:    "Invalid code, please try again.",
+			}, u)
+			return
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err := a.auditlogService.Create(id, "Enabled two-factor authentication"); err != nil {
+			a.log.Errorf(err.Error())
+		}
+
+		if err := a.finishTwoFactorLogin(r, u); err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "auth/2fa-recovery.html", twoFactorData{
+			RecoveryCodes: codes,
+			Continue:      a.popRedirect(r),
+		})
+	}
+}
+
+func (a *App) renderTwoFactorSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := a.sessionUser(r)
+
+		status, err := a.userService.GetTOTPStatus(u.Id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderPage(w, "me/2fa.html", twoFactorData{
+			BaseData: BaseData{
+				User: u,
+			},
+			CSRFToken: a.csrfToken(r),
+			Status:    status,
+			Required:  a.mustEnroll2FA(u.IsAdmin),
+		})
+	}
+}
+
+func (a *App) renderTwoFactorSetup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		u, err := a.userService.Get(su.Id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.renderTwoFactorSetupPage(w, r, twoFactorData{
+			BaseData: BaseData{
+				User: su,
+			},
+			Action: "/me/2fa/setup",
+		}, u)
+	}
+}
+
+func (a *App) enableTwoFactor() http.HandlerFunc {
+	type request struct {
+		Code string `schema:"code"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		codes, err := a.userService.EnableTOTP(su.Id, req.Code)
+		if errors.Is(err, user.ErrInvalidTOTP) {
+			u, err := a.userService.Get(su.Id)
+			if err != nil {
+				a.renderErrorPage(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusUnauthorized)
+			a.renderTwoFactorSetupPage(w, r, twoFactorData{
+				BaseData: BaseData{
+					User: su,
+				},
+				Action: "/me/2fa/setup",
+				Error:  "Invalid code, please try again.",
+			}, u)
+			return
+		} else if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		su.TwoFactor = true
+		a.session.Put(r.Context(), "user", su)
+
+		a.audit(su, "Enabled two-factor authentication")
+
+		a.renderPage(w, "auth/2fa-recovery.html", twoFactorData{
+			BaseData: BaseData{
+				User: su,
+			},
+			RecoveryCodes: codes,
+			Continue:      "/me/2fa",
+		})
+	}
+}
+
+func (a *App) disableTwoFactor() http.HandlerFunc {
+	type request struct {
+		Code string `schema:"code"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		if a.mustEnroll2FA(su.IsAdmin) {
+			a.renderErrorNotif(w, ErrTwoFactorRequired, http.StatusForbidden)
+			return
+		}
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := a.userService.VerifyTOTP(su.Id, req.Code, clientIP(r)); err != nil {
+			a.renderErrorNotif(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		if err := a.userService.DisableTOTP(su.Id); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		su.TwoFactor = false
+		a.session.Put(r.Context(), "user", su)
+
+		a.audit(su, "Disabled two-factor authentication")
+
+		http.Redirect(w, r, "/me/2fa", http.StatusSeeOther)
+	}
+}
+
+func (a *App) regenerateRecoveryCodes() http.HandlerFunc {
+	type request struct {
+		Code string `schema:"code"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		req, err := schemaDecode[request](r)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := a.userService.VerifyTOTP(su.Id, req.Code, clientIP(r)); err != nil {
+			a.renderErrorNotif(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		codes, err := a.userService.RegenerateRecoveryCodes(su.Id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Generated new two-factor recovery codes")
+
+		a.renderPage(w, "auth/2fa-recovery.html", twoFactorData{
+			BaseData: BaseData{
+				User: su,
+			},
+			RecoveryCodes: codes,
+			Continue:      "/me/2fa",
+		})
+	}
+}
+
+// Lets an admin turn off two-factor authentication for a user who lost their device and recovery codes.
+func (a *App) resetUserTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		idStr := chi.URLParam(r, "id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		u, err := a.userService.Get(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err := a.userService.DisableTOTP(id); err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Reset two-factor authentication for "+html.EscapeString(u.FullName))
+
+		http.Redirect(w, r, "/user/"+idStr+"/edit", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,288 @@
+package app
+
+import (
+	"encoding/csv"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/group"
+	"github.com/Chaldron/clay-play/user"
+)
+
+const maxImportSize = 1 << 20
+
+type importRow struct {
+	user.ImportRow
+	GroupIds []string
+	Result   string
+}
+
+type importData struct {
+	BaseData
+	CSV     string
+	Rows    []importRow
+	Valid   int
+	Applied bool
+	MaxRows int
+}
+
+// Checks the parsed rows against existing accounts and groups. Groups can be given by id or by name.
+func (a *App) planImport(rows []user.ImportRow) ([]importRow, error) {
+	emails := []string{}
+	for _, r := range rows {
+		emails = append(emails, r.Email)
+	}
+	inUse, err := a.userService.EmailsInUse(emails)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := a.userService.ListInvitations()
+	if err != nil {
+		return nil, err
+	}
+	invited := map[string]bool{}
+	for _, inv := range invitations {
+		invited[inv.Email] = true
+	}
+
+	groups, err := a.groupService.List()
+	if err != nil {
+		return nil, err
+	}
+	byId := map[string]group.Group{}
+	byName := map[string][]group.Group{}
+	for _, g := range groups {
+		byId[g.Id] = g
+		name := strings.ToLower(g.Name)
+		byName[name] = append(byName[name], g)
+	}
+
+	plan := []importRow{}
+	for _, r := range rows {
+		p := importRow{ImportRow: r}
+		if inUse[r.Email] {
+			p.Errors = append(p.Errors, "already has an account")
+		} else if invited[strings.ToLower(r.Email)] {
+			p.Errors = append(p.Errors, "already invited")
+		}
+
+		for _, g := range r.Groups {
+			if found, ok := byId[g]; ok {
+				p.GroupIds = append(p.GroupIds, found.Id)
+				continue
+			}
+			switch matches := byName[strings.ToLower(g)]; len(matches) {
+			case 0:
+				p.Errors = append(p.Errors, "no group named "+g)
+			case 1:
+				p.GroupIds = append(p.GroupIds, matches[0].Id)
+			default:
+				p.Errors = append(p.Errors, "more than one group is named "+g+", use its id instead")
+			}
+		}
+
+		plan = append(plan, p)
+	}
+
+	return plan, nil
+}
+
+func (a *App) renderUserImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		a.renderPage(w, "user/import.html", importData{
+			BaseData: BaseData{
+				User: su,
+			},
+			MaxRows: user.MaxImportRows,
+		})
+	}
+}
+
+// Previews an import, or carries it out when apply is set. Rows with problems are always skipped,
+// and the file is checked again on apply since accounts may have been created since the preview.
+func (a *App) importUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize+64<<10)
+		if err := r.ParseMultipartForm(maxImportSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		text := r.PostFormValue("csv")
+		if f, _, err := r.FormFile("file"); err == nil {
+			b, err := io.ReadAll(io.LimitReader(f, maxImportSize))
+			f.Close()
+			if err != nil {
+				a.renderErrorNotif(w, err, http.StatusBadRequest)
+				return
+			}
+			text = string(b)
+		}
+		if strings.TrimSpace(text) == "" {
+			a.renderErrorNotif(w, errors.New("choose a CSV file to import"), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := user.ParseImportCSV(strings.NewReader(text))
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusBadRequest)
+			return
+		}
+
+		plan, err := a.planImport(rows)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		d := importData{
+			BaseData: BaseData{
+				User: su,
+			},
+			CSV:     text,
+			Applied: r.PostFormValue("apply") == "true",
+			MaxRows: user.MaxImportRows,
+		}
+		for _, p := range plan {
+			if p.Valid() {
+				d.Valid++
+			}
+		}
+
+		if d.Applied {
+			for i := range plan {
+				if !plan[i].Valid() {
+					plan[i].Result = "skipped"
+					continue
+				}
+				plan[i].Result = a.importUser(su, plan[i])
+			}
+		}
+		d.Rows = plan
+
+		a.renderPage(w, "user/import.html", d)
+	}
+}
+
+// Invites a single imported user and reports what happened, so that one failure does not stop the rest.
+// The invitation carries the groups, so the account and its memberships are only created once it is accepted.
+func (a *App) importUser(su user.SessionUser, p importRow) string {
+	inv, token, err := a.userService.CreateInvitation(user.CreateInvitationParams{
+		Email:     p.Email,
+		FullName:  p.FullName,
+		IsAdmin:   p.IsAdmin,
+		GroupIds:  p.GroupIds,
+		InvitedBy: su.Id,
+	})
+	if err != nil {
+		a.log.Errorf(err.Error())
+		return "failed: " + err.Error()
+	}
+
+	if err := a.sendInvitation(inv, token); err != nil {
+		a.log.Errorf(err.Error())
+		// nobody got the link, so leave the row free to be imported again
+		if _, err := a.userService.RevokeInvitation(inv.Id); err != nil {
+			a.log.Errorf(err.Error())
+		}
+		return "failed: the invitation could not be sent"
+	}
+
+	a.audit(su, "Invited "+html.EscapeString(inv.Email)+" through an import")
+
+	return "invited"
+}
+
+func (a *App) exportUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		users, err := a.userService.GetAll()
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Exported the user list")
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="users-`+db.Now().Format("20060102")+`.csv"`)
+		w.Header().Set("Cache-Control", "no-store")
+
+		cw := csv.NewWriter(w)
+		header := append([]string{}, user.ImportColumns...)
+		header = append(header, "roles", "created_at", "email_verified", "status")
+		if err := cw.Write(header); err != nil {
+			a.log.Errorf(err.Error())
+			return
+		}
+
+		for _, u := range users {
+			memberships, err := a.groupService.ListUserMemberships(u.Id)
+			if err != nil {
+				a.log.Errorf(err.Error())
+				return
+			}
+			groups := []string{}
+			for _, m := range memberships {
+				groups = append(groups, m.GroupName)
+			}
+
+			roles := []string{}
+			for _, role := range u.Roles {
+				roles = append(roles, string(role))
+			}
+
+			status := "active"
+			if u.IsDeleted() {
+				status = "deactivated"
+			} else if u.IsPending {
+				status = "pending"
+			}
+
+			admin := "no"
+			if u.IsAdmin {
+				admin = "yes"
+			}
+
+			err = cw.Write([]string{
+				csvSafe(u.FullName),
+				csvSafe(u.Email),
+				admin,
+				csvSafe(strings.Join(groups, ";")),
+				strings.Join(roles, ";"),
+				u.CreatedAt.Format(time.RFC3339),
+				strconv.FormatBool(u.EmailVerifiedAt.Valid),
+				status,
+			})
+			if err != nil {
+				a.log.Errorf(err.Error())
+				return
+			}
+		}
+
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			a.log.Errorf(err.Error())
+		}
+	}
+}
+
+// Keeps spreadsheet apps from running user provided values as formulas.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -2,9 +2,11 @@ package app
 
 import (
 	"errors"
+	"html"
 	"net/http"
 	"strconv"
 
+	"github.com/Chaldron/clay-play/event"
 	"github.com/Chaldron/clay-play/user"
 	"github.com/go-chi/chi/v5"
 )
@@ -33,79 +35,83 @@ func (a *App) renderUserList() http.HandlerFunc {
 	}
 }
 
-func (a *App) renderNewUser() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		u, _ := a.sessionUser(r)
-
-		a.renderPage(w, "user/new.html", BaseData{
-			User: u,
-		})
-	}
-}
-
-func (a *App) createUser() http.HandlerFunc {
-	type request struct {
-		Name     string `schema:"name"`
-		Email    string `schema:"email"`
-		Password string `schema:"password"`
-		IsAdmin  bool   `schema:"isadmin"`
+func (a *App) renderEditUser() http.HandlerFunc {
+	type data struct {
+		BaseData
+		UserData user.User
+		Lockout  user.Lockout
+		Roles    []user.Role
+		Sessions []user.Session
+		TOTP     user.TOTPStatus
+
+		Attendance        event.AttendanceStats
+		AttendanceHistory []event.AttendanceRecord
+		Strikes           []event.Strike
+		Restriction       *event.Restriction
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		su, _ := a.sessionUser(r)
 
-		req, err := schemaDecode[request](r)
+		idStr := chi.URLParam(r, "id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			a.renderErrorPage(w, err, http.StatusBadRequest)
 			return
 		}
 
-		new_u, err := a.userService.Create(user.CreateParams{
-			FullName: req.Name,
-			Email:    req.Email,
-			Password: req.Password,
-			IsAdmin:  req.IsAdmin,
-		})
+		u, err := a.userService.Get(id)
+		// Convert the id from string to int64
+
 		if err != nil {
-			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		err = a.auditlogService.Create(su.Id, "Created "+new_u.FullName)
-		if err != nil {
+		if u.Id == 0 {
+			a.renderErrorNotif(w, errors.New("default admin user is not editable"), http.StatusForbidden)
 			return
 		}
 
-		http.Redirect(w, r, "/user/list", http.StatusSeeOther)
-	}
-}
+		l, err := a.userService.GetLockout(id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
 
-func (a *App) renderEditUser() http.HandlerFunc {
-	type data struct {
-		BaseData
-		UserData user.User
-	}
+		sessions, err := a.userService.ListSessions(id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		su, _ := a.sessionUser(r)
+		totp, err := a.userService.GetTOTPStatus(id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
 
-		idStr := chi.URLParam(r, "id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
+		attendance, err := a.eventService.GetAttendanceStats(id)
 		if err != nil {
-			a.renderErrorPage(w, err, http.StatusBadRequest)
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		u, err := a.userService.Get(id)
-		// Convert the id from string to int64
+		history, err := a.eventService.ListUserAttendance(id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
+			return
+		}
 
+		strikes, err := a.eventService.ListStrikes(id)
 		if err != nil {
 			a.renderErrorPage(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		if u.Id == 0 {
-			a.renderErrorNotif(w, errors.New("default admin user is not editable"), http.StatusForbidden)
+		restriction, err := a.eventService.GetRestriction(id)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusInternalServerError)
 			return
 		}
 
@@ -114,16 +120,26 @@ func (a *App) renderEditUser() http.HandlerFunc {
 				User: su,
 			},
 			UserData: u,
+			Lockout:  l,
+			Roles:    user.AssignableRoles,
+			Sessions: sessions,
+			TOTP:     totp,
+
+			Attendance:        attendance,
+			AttendanceHistory: history,
+			Strikes:           strikes,
+			Restriction:       restriction,
 		})
 	}
 }
 
 func (a *App) updateUser() http.HandlerFunc {
 	type request struct {
-		Name     string `schema:"name"`
-		Email    string `schema:"email"`
-		Password string `schema:"password"`
-		IsAdmin  bool   `schema:"isadmin"`
+		Name     string      `schema:"name"`
+		Email    string      `schema:"email"`
+		Password string      `schema:"password"`
+		IsAdmin  bool        `schema:"isadmin"`
+		Roles    []user.Role `schema:"roles"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -148,22 +164,57 @@ func (a *App) updateUser() http.HandlerFunc {
 			Email:    req.Email,
 			Password: req.Password,
 			IsAdmin:  req.IsAdmin,
+			Roles:    req.Roles,
 		})
+		if errors.Is(err, user.ErrEmailTaken) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Edited "+html.EscapeString(new_u.FullName))
+
+		http.Redirect(w, r, "/user/list", http.StatusSeeOther)
+	}
+}
+
+func (a *App) deleteUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		idStr := chi.URLParam(r, "id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if id == 0 {
+			a.renderErrorNotif(w, errors.New("default admin user cannot be deactivated"), http.StatusForbidden)
+			return
+		}
+
+		u, err := a.userService.Get(id)
 		if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		err = a.auditlogService.Create(su.Id, "Edited "+new_u.FullName)
+		err = a.eventService.RemoveUser(event.RemoveUserParams{UserId: id})
 		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
 
+		a.audit(su, "Deactivated user "+html.EscapeString(u.FullName))
+
 		http.Redirect(w, r, "/user/list", http.StatusSeeOther)
 	}
 }
 
-func (a *App) deleteUser() http.HandlerFunc {
+func (a *App) reactivateUser() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		su, _ := a.sessionUser(r)
 
@@ -174,17 +225,108 @@ func (a *App) deleteUser() http.HandlerFunc {
 			return
 		}
 
-		err = a.userService.Delete(id)
+		u, err := a.userService.Reactivate(id)
+		if errors.Is(err, user.ErrAnonymized) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Reactivated user "+html.EscapeString(u.FullName))
+
+		http.Redirect(w, r, "/user/"+idStr+"/edit", http.StatusSeeOther)
+	}
+}
+
+func (a *App) anonymizeUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		idStr := chi.URLParam(r, "id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		u, err := a.userService.Get(id)
 		if err != nil {
 			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		err = a.auditlogService.Create(su.Id, "Deleted user "+strconv.FormatInt(id, 10))
+		_, err = a.userService.Anonymize(id)
+		if errors.Is(err, user.ErrUserNotDeleted) {
+			a.renderErrorNotif(w, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.notificationService.DeleteUserNotifications(id)
 		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
 			return
 		}
 
+		// the name is gone after this, so only the id is recorded
+		a.audit(su, "Anonymized user "+strconv.FormatInt(u.Id, 10))
+
 		http.Redirect(w, r, "/user/list", http.StatusSeeOther)
 	}
 }
+
+func (a *App) unlockUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		idStr := chi.URLParam(r, "id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		u, err := a.userService.Unlock(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Unlocked login for "+html.EscapeString(u.FullName))
+
+		http.Redirect(w, r, "/user/"+idStr+"/edit", http.StatusSeeOther)
+	}
+}
+
+func (a *App) forceLogoutUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, _ := a.sessionUser(r)
+
+		idStr := chi.URLParam(r, "id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			a.renderErrorPage(w, err, http.StatusBadRequest)
+			return
+		}
+
+		u, err := a.userService.Get(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = a.userService.RevokeSessions(id)
+		if err != nil {
+			a.renderErrorNotif(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		a.audit(su, "Logged out "+html.EscapeString(u.FullName)+" everywhere")
+
+		http.Redirect(w, r, "/user/"+idStr+"/edit", http.StatusSeeOther)
+	}
+}
@@ -1,10 +1,15 @@
 package auditlog
 
-import "time"
+import (
+	"database/sql"
+	"time"
+)
 
 type Service interface {
 	Create(int64, string) error
+	CreateImpersonated(userId int64, impersonatorId int64, description string) error
 	List(ListFilter) ([]AuditLog, int, error)
+	ListByUser(int64) ([]AuditLog, error)
 }
 
 type AuditLog struct {
@@ -13,4 +18,7 @@ type AuditLog struct {
 	RecordedAt   time.Time `db:"recorded_at"`
 	Description  string    `db:"description"`
 	Count        int       `db:"count"`
+
+	// Set when an admin was viewing the app as the user, see Service.CreateImpersonated.
+	ImpersonatorFullName sql.NullString `db:"impersonator_full_name"`
 }
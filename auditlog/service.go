@@ -2,6 +2,7 @@ package auditlog
 
 import (
 	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -30,6 +31,33 @@ func (s *service) Create(userId int64, description string) error {
 	return tx.Commit()
 }
 
+// Records something that happened while an admin was viewing the app as the user, keeping both identities.
+func (s *service) CreateImpersonated(userId int64, impersonatorId int64, description string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        INSERT INTO audit_log (user_id, impersonator_id, recorded_at, description)
+        VALUES (?, ?, ?, ?)
+    `
+	args := []any{
+		userId,
+		impersonatorId,
+		db.Now(),
+		description,
+	}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 type ListFilter struct {
 	Limit  int
 	Offset int
@@ -46,8 +74,33 @@ func (s *service) List(f ListFilter) ([]AuditLog, int, error) {
 	return al, count, err
 }
 
+// Lists the entries recorded for actions the user took, newest first.
+func (s *service) ListByUser(userId int64) ([]AuditLog, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT al.user_id, al.recorded_at, al.description
+            ,` + impersonatorNameSQL + ` AS impersonator_full_name
+        FROM audit_log al
+        LEFT JOIN users iu ON al.impersonator_id = iu.id
+        WHERE al.user_id = ?
+        ORDER BY al.recorded_at DESC
+    `
+	args := []any{userId}
+
+	al := []AuditLog{}
+	err = tx.Select(&al, stmt, args...)
+	return al, err
+}
+
 var al = []AuditLog{}
 
+var impersonatorNameSQL = `CASE WHEN al.impersonator_id IS NULL THEN NULL ELSE ` + user.DisplayNameSQL("iu") + ` END`
+
 func create(tx *sqlx.Tx, userId int64, description string) error {
 	stmt := `
         INSERT INTO audit_log (user_id, recorded_at, description)
@@ -67,12 +120,14 @@ func list(tx *sqlx.Tx, f ListFilter) ([]AuditLog, int, error) {
 	stmt := `
         SELECT 
             user_id
-            ,u.full_name AS user_full_name
+            ,` + user.DisplayNameSQL("u") + ` AS user_full_name
             ,recorded_at
             ,description 
+            ,` + impersonatorNameSQL + ` AS impersonator_full_name
             ,COUNT(*) OVER () AS count
         FROM audit_log al
-        INNER JOIN users u ON al.user_id = u.id
+        LEFT JOIN users u ON al.user_id = u.id
+        LEFT JOIN users iu ON al.impersonator_id = iu.id
         ORDER BY recorded_at DESC
         ` + db.FormatLimitOffset(f.Limit, f.Offset)
 
@@ -75,3 +75,86 @@ func TestList(t *testing.T) {
 	assert.Equal(t, u2.Id, al[0].UserId)
 	assert.Equal(t, u1.Id, al[1].UserId)
 }
+
+func TestListByUser(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	auditlogService := auditlog.NewService(db)
+	userService := user.NewService(db)
+
+	u1, err := userService.Create(user.CreateParams{FullName: "one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2, err := userService.Create(user.CreateParams{FullName: "two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []struct {
+		userId      int64
+		description string
+	}{{u1.Id, "test1"}, {u2.Id, "test2"}, {u1.Id, "test3"}} {
+		if err := auditlogService.Create(e.userId, e.description); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := auditlogService.ListByUser(u1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(al))
+	assert.Equal(t, "test3", al[0].Description)
+	assert.Equal(t, "test1", al[1].Description)
+}
+
+func TestListFormerMember(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	auditlogService := auditlog.NewService(db)
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{FullName: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auditlogService.Create(u.Id, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := userService.Delete(u.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries of deactivated users are kept, without their name
+	al, _, err := auditlogService.List(auditlog.ListFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(al))
+	assert.Equal(t, user.FormerMemberName, al[0].UserFullName)
+}
+
+func TestCreateImpersonated(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	auditlogService := auditlog.NewService(db)
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{FullName: "member"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, auditlogService.Create(u.Id, "own action"))
+	assert.NoError(t, auditlogService.CreateImpersonated(u.Id, 0, "Viewed /home"))
+
+	al, _, err := auditlogService.List(auditlog.ListFilter{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(al))
+	assert.Equal(t, "Viewed /home", al[0].Description)
+	assert.Equal(t, "member", al[0].UserFullName)
+	assert.Equal(t, "Admin", al[0].ImpersonatorFullName.String)
+	assert.False(t, al[1].ImpersonatorFullName.Valid)
+
+	al, err = auditlogService.ListByUser(u.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(al))
+	assert.Equal(t, "Admin", al[0].ImpersonatorFullName.String)
+}
@@ -0,0 +1,31 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	assert.Equal(t, start, c.Now())
+
+	soon := c.After(time.Minute)
+	later := c.After(time.Hour)
+
+	c.Advance(30 * time.Second)
+	assert.Len(t, soon, 0)
+
+	c.Advance(30 * time.Second)
+	if assert.Len(t, soon, 1) {
+		assert.Equal(t, start.Add(time.Minute), <-soon)
+	}
+	assert.Len(t, later, 0)
+
+	c.Advance(2 * time.Hour)
+	assert.Len(t, later, 1, "fires once it is overdue")
+	assert.Equal(t, start.Add(2*time.Hour+time.Minute), c.Now())
+}
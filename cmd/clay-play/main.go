@@ -1,20 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"time"
+	_ "time/tzdata" // series are scheduled in the timezone of the browser, which the runtime image may not ship
 
 	appPkg "github.com/Chaldron/clay-play/app"
 	"github.com/Chaldron/clay-play/auditlog"
+	"github.com/Chaldron/clay-play/clock"
 	"github.com/Chaldron/clay-play/config"
 	"github.com/Chaldron/clay-play/db"
 	"github.com/Chaldron/clay-play/event"
 	"github.com/Chaldron/clay-play/group"
 	"github.com/Chaldron/clay-play/logger"
+	"github.com/Chaldron/clay-play/mail"
+	"github.com/Chaldron/clay-play/notification"
+	"github.com/Chaldron/clay-play/openid"
+	"github.com/Chaldron/clay-play/review"
+	"github.com/Chaldron/clay-play/scheduler"
 	"github.com/Chaldron/clay-play/template"
 	"github.com/Chaldron/clay-play/user"
 	"github.com/alexedwards/scs/sqlite3store"
@@ -38,8 +47,11 @@ func run() error {
 	if err != nil {
 		return err
 	}
+	if conf.BaseURL == "" { // the request's host can't be trusted for links in emails
+		return errors.New("base_url has to be set")
+	}
 
-	db, err := db.Connect(conf.DbConn, conf.DefaultAdminPassword, log)
+	db, err := db.Connect(conf.DbConn, conf.DefaultAdminPassword, conf.PasswordCost, log)
 	if err != nil {
 		return err
 	}
@@ -59,23 +71,83 @@ func run() error {
 
 	eventService := event.NewService(db)
 	eventService.SetLogger(log)
+	strikes := event.DefaultStrikePolicy
+	if conf.StrikeThreshold != 0 {
+		strikes.Threshold = max(conf.StrikeThreshold, 0)
+	}
+	if conf.StrikeWindow > 0 {
+		strikes.Window = conf.StrikeWindow
+	}
+	if conf.StrikeRestriction != "" {
+		strikes.Mode = event.RestrictionMode(conf.StrikeRestriction)
+		if !strikes.Mode.Valid() {
+			return fmt.Errorf("strike_restriction has to be waitlist or block, got %q", conf.StrikeRestriction)
+		}
+	}
+	if conf.StrikeRestrictionPeriod > 0 {
+		strikes.Duration = conf.StrikeRestrictionPeriod
+	}
+	eventService.SetStrikePolicy(strikes)
 
 	userService := user.NewService(db)
-	eventService.SetLogger(log)
+	userService.SetLogger(log)
+	userService.SetPasswordCost(conf.PasswordCost)
+	throttle := user.DefaultThrottlePolicy
+	if conf.LoginThrottleThreshold > 0 {
+		throttle.Threshold = conf.LoginThrottleThreshold
+	}
+	if conf.LoginThrottleBase > 0 {
+		throttle.Base = conf.LoginThrottleBase
+	}
+	if conf.LoginLockoutMax > 0 {
+		throttle.Max = conf.LoginLockoutMax
+	}
+	userService.SetThrottlePolicy(throttle)
 
 	auditlogService := auditlog.NewService(db)
 
+	reviewService := review.NewService(db)
+	reviewService.SetLogger(log)
+
+	mailer := mail.NewSender(conf)
+
+	notificationService := notification.NewService(db)
+	notificationService.SetLogger(log)
+
+	notifier := notification.NewNotifier(notificationService, userService, mailer, conf.BaseURL)
+	notifier.SetLogger(log)
+	eventService.Subscribe(notifier.WaitlistChanged)
+
+	oidc, err := openid.New(context.Background(), conf)
+	if err != nil {
+		return err
+	}
+
 	app := appPkg.New(
 		eventService,
 		userService,
 		groupService,
 		auditlogService,
+		reviewService,
+		notificationService,
+
+		oidc,
+		mailer,
 
 		conf,
 		session,
 		templates,
 		log,
 	)
+	eventService.SubscribeStrikes(app.AuditStrikeChanged)
+
+	// started once every subscriber is registered, the event service doesn't guard its subscriber lists
+	jobs := scheduler.New(clock.Real())
+	jobs.SetLogger(log)
+	jobs.Every("expire waitlist offers", time.Minute, eventService.ExpireOffers)
+	jobs.Every("end sign-up restrictions", time.Minute, eventService.ExpireRestrictions)
+	go jobs.Run(context.Background())
+	go notifier.Run(context.Background())
 
 	log.Printf("listening on port %d", conf.Port)
 	http.ListenAndServe(fmt.Sprintf(":%d", conf.Port), app.Routes())
@@ -30,7 +30,7 @@ func run() error {
 		return err
 	}
 
-	db, err := db.Connect(conf.DbConn, conf.DefaultAdminPassword, log)
+	db, err := db.Connect(conf.DbConn, conf.DefaultAdminPassword, conf.PasswordCost, log)
 	if err != nil {
 		return err
 	}
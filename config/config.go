@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/caarlos0/env"
 	"gopkg.in/yaml.v3"
@@ -11,6 +12,31 @@ type Config struct {
 	DbConn               string `yaml:"db_conn" env:"DB_CONN,required"`
 	Port                 int    `yaml:"port" env:"PORT,required"`
 	DefaultAdminPassword string `yaml:"default_admin_password" env:"DEFAULT_ADMIN_PASSWORD,required"`
+	PasswordCost         int    `yaml:"password_cost" env:"PASSWORD_COST"`
+
+	LoginThrottleThreshold int           `yaml:"login_throttle_threshold" env:"LOGIN_THROTTLE_THRESHOLD"`
+	LoginThrottleBase      time.Duration `yaml:"login_throttle_base" env:"LOGIN_THROTTLE_BASE"`
+	LoginLockoutMax        time.Duration `yaml:"login_lockout_max" env:"LOGIN_LOCKOUT_MAX"`
+	RequireAdmin2FA        bool          `yaml:"require_admin_2fa" env:"REQUIRE_ADMIN_2FA"`
+
+	StrikeThreshold         int           `yaml:"strike_threshold" env:"STRIKE_THRESHOLD"` // negative turns sign-up restrictions off
+	StrikeWindow            time.Duration `yaml:"strike_window" env:"STRIKE_WINDOW"`
+	StrikeRestriction       string        `yaml:"strike_restriction" env:"STRIKE_RESTRICTION"` // waitlist or block
+	StrikeRestrictionPeriod time.Duration `yaml:"strike_restriction_period" env:"STRIKE_RESTRICTION_PERIOD"`
+
+	OIDCIssuer       string   `yaml:"oidc_issuer" env:"OIDC_ISSUER"`
+	OIDCClientId     string   `yaml:"oidc_client_id" env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret string   `yaml:"oidc_client_secret" env:"OIDC_CLIENT_SECRET"`
+	OIDCRedirectURL  string   `yaml:"oidc_redirect_url" env:"OIDC_REDIRECT_URL"`
+	OIDCScopes       []string `yaml:"oidc_scopes" env:"OIDC_SCOPES" envSeparator:","`
+
+	BaseURL       string `yaml:"base_url" env:"BASE_URL,required"`
+	MailFrom      string `yaml:"mail_from" env:"MAIL_FROM"`
+	MailOutboxDir string `yaml:"mail_outbox_dir" env:"MAIL_OUTBOX_DIR"`
+	SMTPHost      string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort      int    `yaml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername  string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword  string `yaml:"smtp_password" env:"SMTP_PASSWORD"`
 }
 
 func ReadFile(src string) (*Config, error) {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/Chaldron/clay-play/db/migrations"
 	"github.com/Chaldron/clay-play/logger"
+	"github.com/Chaldron/clay-play/password"
 	"github.com/jmoiron/sqlx"
 	"github.com/pressly/goose/v3"
 )
@@ -21,8 +22,9 @@ type DB struct {
 //go:embed migrations/*.sql
 var migrationsFs embed.FS
 
-func Connect(dsn string, defaultAdminPassword string, log logger.Logger) (*DB, error) {
+func Connect(dsn string, defaultAdminPassword string, passwordCost int, log logger.Logger) (*DB, error) {
 	migrations.DefaultAdminPassword = defaultAdminPassword
+	migrations.PasswordCost = passwordCost
 
 	db, err := sqlx.Connect("sqlite3", dsn)
 	if err != nil {
@@ -50,7 +52,7 @@ func Connect(dsn string, defaultAdminPassword string, log logger.Logger) (*DB, e
 
 func TestingConnect(t testing.TB) *DB {
 	t.Helper()
-	db, err := Connect(":memory:", "admin", logger.NewNoopLogger())
+	db, err := Connect(":memory:", "admin", password.MinCost, logger.NewNoopLogger())
 	if err != nil {
 		panic(err)
 	}
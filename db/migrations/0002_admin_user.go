@@ -3,6 +3,7 @@ package migrations
 import (
 	"database/sql"
 
+	"github.com/Chaldron/clay-play/password"
 	"github.com/pressly/goose/v3"
 )
 
@@ -13,11 +14,16 @@ func init() {
 }
 
 func Up(tx *sql.Tx) error {
-	_, err := tx.Exec(
+	hash, err := password.Hash(DefaultAdminPassword, PasswordCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
 		`INSERT INTO users (id, full_name, email, password, isadmin, created_at)
-		VALUES (0, "Admin", "admin@example.com", "` +
-			DefaultAdminPassword +
-			`", true, CURRENT_TIMESTAMP)`)
+		VALUES (0, "Admin", "admin@example.com", ?, true, CURRENT_TIMESTAMP)`,
+		hash,
+	)
 
 	if err != nil {
 		return err
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/Chaldron/clay-play/password"
+	"github.com/pressly/goose/v3"
+)
+
+var PasswordCost int
+
+func init() {
+	goose.AddMigration(upHashPasswords, downHashPasswords)
+}
+
+// Hashes any passwords still stored as plaintext.
+// Users that log in before this runs are rehashed by the user service instead.
+func upHashPasswords(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, password FROM users WHERE password <> ''`)
+	if err != nil {
+		return err
+	}
+
+	plaintext := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var p string
+		if err := rows.Scan(&id, &p); err != nil {
+			rows.Close()
+			return err
+		}
+		if !password.IsHashed(p) {
+			plaintext[id] = p
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, p := range plaintext {
+		hash, err := password.Hash(p, PasswordCost)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE users SET password = ? WHERE id = ?`, hash, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Hashes cannot be reversed, so there is nothing to undo.
+func downHashPasswords(tx *sql.Tx) error {
+	return nil
+}
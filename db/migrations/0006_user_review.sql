@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN is_pending BOOL NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS user_review (
+    user_id INTEGER PRIMARY KEY,
+    comment TEXT,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_review;
+ALTER TABLE users DROP COLUMN is_pending;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+
+CREATE TABLE IF NOT EXISTS user_token (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    purpose TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS user_token_user_idx ON user_token(user_id, purpose);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS user_token_user_idx;
+DROP TABLE IF EXISTS user_token;
+ALTER TABLE users DROP COLUMN email_verified_at;
+-- +goose StatementEnd
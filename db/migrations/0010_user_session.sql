@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_session (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    token TEXT NOT NULL UNIQUE,
+    user_agent TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_seen_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_session_user_id_idx ON user_session(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS user_session_user_id_idx;
+DROP TABLE IF EXISTS user_session;
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_invitation (
+    id TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    full_name TEXT NOT NULL,
+    isadmin INTEGER NOT NULL DEFAULT 0,
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by INTEGER NOT NULL,
+    created_at DATETIME NOT NULL,
+    sent_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    accepted_at DATETIME,
+    user_id INTEGER,
+    revoked_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS user_invitation_email_idx ON user_invitation(email);
+
+CREATE TABLE IF NOT EXISTS user_invitation_role (
+    invitation_id TEXT NOT NULL,
+    role TEXT NOT NULL,
+    PRIMARY KEY (invitation_id, role)
+);
+
+CREATE TABLE IF NOT EXISTS user_invitation_group (
+    invitation_id TEXT NOT NULL,
+    group_id TEXT NOT NULL,
+    PRIMARY KEY (invitation_id, group_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_invitation_group;
+DROP TABLE IF EXISTS user_invitation_role;
+DROP INDEX IF EXISTS user_invitation_email_idx;
+DROP TABLE IF EXISTS user_invitation;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS event_series (
+    id TEXT PRIMARY KEY,
+    rrule TEXT NOT NULL,
+    timezone TEXT NOT NULL,
+    exdates TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    creator_id INTEGER NOT NULL
+);
+
+ALTER TABLE event ADD COLUMN series_id TEXT;
+ALTER TABLE event ADD COLUMN original_start DATETIME;
+
+CREATE INDEX IF NOT EXISTS event_series_idx ON event(series_id, original_start);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS event_series_idx;
+ALTER TABLE event DROP COLUMN original_start;
+ALTER TABLE event DROP COLUMN series_id;
+DROP TABLE IF EXISTS event_series;
+-- +goose StatementEnd
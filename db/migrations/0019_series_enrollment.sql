@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS series_enrollment (
+    series_id TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    attendee_count INTEGER NOT NULL,
+    on_waitlist BOOLEAN NOT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (series_id, user_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS series_enrollment;
+-- +goose StatementEnd
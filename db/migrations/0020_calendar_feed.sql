@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE event ADD COLUMN sequence INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE event ADD COLUMN updated_at DATETIME;
+
+CREATE TABLE IF NOT EXISTS calendar_feed (
+    user_id INTEGER PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    include_all BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS calendar_feed;
+ALTER TABLE event DROP COLUMN updated_at;
+ALTER TABLE event DROP COLUMN sequence;
+-- +goose StatementEnd
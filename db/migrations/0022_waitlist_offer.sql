@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE event ADD COLUMN offer_hours INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE event_response ADD COLUMN offer_expires_at DATETIME;
+CREATE INDEX IF NOT EXISTS event_response_offer_idx ON event_response(offer_expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS event_response_offer_idx;
+ALTER TABLE event_response DROP COLUMN offer_expires_at;
+ALTER TABLE event DROP COLUMN offer_hours;
+-- +goose StatementEnd
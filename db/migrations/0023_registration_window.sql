@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE event ADD COLUMN registration_opens_at DATETIME;
+ALTER TABLE event ADD COLUMN registration_closes_at DATETIME;
+ALTER TABLE event ADD COLUMN cancellation_cutoff DATETIME;
+
+CREATE TABLE IF NOT EXISTS late_cancellation (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    attendee_count INTEGER NOT NULL,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS late_cancellation_event_idx ON late_cancellation(event_id);
+CREATE INDEX IF NOT EXISTS late_cancellation_user_idx ON late_cancellation(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS late_cancellation;
+ALTER TABLE event DROP COLUMN cancellation_cutoff;
+ALTER TABLE event DROP COLUMN registration_closes_at;
+ALTER TABLE event DROP COLUMN registration_opens_at;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE event ADD COLUMN max_party_size INTEGER NOT NULL DEFAULT 2;
+
+CREATE TABLE IF NOT EXISTS event_guest (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    email TEXT,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS event_guest_response_idx ON event_guest(event_id, user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS event_guest;
+ALTER TABLE event DROP COLUMN max_party_size;
+-- +goose StatementEnd
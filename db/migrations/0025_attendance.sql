@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE event ADD COLUMN check_in_code TEXT;
+
+CREATE TABLE IF NOT EXISTS attendance (
+    event_id TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    guest_id INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL,
+    recorded_by INTEGER NOT NULL,
+    recorded_at DATETIME NOT NULL,
+    PRIMARY KEY (event_id, user_id, guest_id)
+);
+CREATE INDEX IF NOT EXISTS attendance_user_idx ON attendance(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS attendance;
+ALTER TABLE event DROP COLUMN check_in_code;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS strike (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    event_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    forgiven_at DATETIME,
+    forgiven_by INTEGER,
+    UNIQUE (user_id, event_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS restriction (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    mode TEXT NOT NULL,
+    starts_at DATETIME NOT NULL,
+    ends_at DATETIME NOT NULL,
+    lifted_at DATETIME,
+    lifted_by INTEGER
+);
+CREATE INDEX IF NOT EXISTS restriction_user_idx ON restriction(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS restriction;
+DROP TABLE IF EXISTS strike;
+-- +goose StatementEnd
@@ -0,0 +1,465 @@
+package event
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/user"
+	"github.com/jmoiron/sqlx"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+type AttendanceStatus string
+
+const (
+	AttendancePresent AttendanceStatus = "present"
+	AttendanceLate    AttendanceStatus = "late"
+	AttendanceAbsent  AttendanceStatus = "absent" // a no-show
+)
+
+func (s AttendanceStatus) Valid() bool {
+	return s == AttendancePresent || s == AttendanceLate || s == AttendanceAbsent
+}
+
+var (
+	// Attendance can be taken from this long before the start, self check-in closes this long after it.
+	CheckInOpensBefore = 30 * time.Minute
+	CheckInClosesAfter = 2 * time.Hour
+	// Users checking in themselves this long after the start are marked late.
+	LateAfter = 10 * time.Minute
+)
+
+// Check-in codes avoid characters that are easy to mix up when read off a screen.
+const (
+	checkInCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	checkInCodeLength   = 6
+)
+
+var (
+	ErrInvalidAttendance  = errors.New("attendance has to be present, late or absent")
+	ErrNotOnRoster        = errors.New("not on the roster of this event")
+	ErrCheckInNotOpen     = errors.New("check-in for this event hasn't opened yet")
+	ErrCheckInClosed      = errors.New("check-in for this event has closed")
+	ErrInvalidCheckInCode = errors.New("check-in code is not valid")
+)
+
+// Whether a user, or one of their guests, showed up to an event. GuestId is 0 for the user themselves.
+type Attendance struct {
+	EventId    string           `db:"event_id"`
+	UserId     int64            `db:"user_id"`
+	GuestId    int64            `db:"guest_id"`
+	Status     AttendanceStatus `db:"status"`
+	RecordedBy int64            `db:"recorded_by"` // the user themselves for self check-ins
+	RecordedAt time.Time        `db:"recorded_at"`
+}
+
+// Attendance of a user along with the event it is for.
+type AttendanceRecord struct {
+	Attendance
+	EventName  string    `db:"event_name"`
+	EventStart time.Time `db:"event_start"`
+}
+
+// Attendance of a user over every event it was taken for, guests not included.
+type AttendanceStats struct {
+	UserId       int64  `db:"user_id"`
+	UserFullName string `db:"user_full_name"`
+	Present      int    `db:"present"`
+	Late         int    `db:"late"`
+	Absent       int    `db:"absent"`
+}
+
+func (s AttendanceStats) Total() int {
+	return s.Present + s.Late + s.Absent
+}
+
+// Share of the recorded events the user didn't show up to, 0 if none were recorded.
+func (s AttendanceStats) NoShowRate() float64 {
+	if s.Total() == 0 {
+		return 0
+	}
+	return float64(s.Absent) / float64(s.Total())
+}
+
+// The no-show rate rounded to a whole percentage, for display.
+func (s AttendanceStats) NoShowPercent() int {
+	return int(math.Round(s.NoShowRate() * 100))
+}
+
+// Returns why users can't check in to the event at the given time, nil if they can.
+func (e Event) CheckCheckIn(now time.Time) error {
+	if now.Before(e.Start.Add(-CheckInOpensBefore)) {
+		return ErrCheckInNotOpen
+	}
+	if now.After(e.Start.Add(CheckInClosesAfter)) {
+		return ErrCheckInClosed
+	}
+	return nil
+}
+
+type RecordAttendanceParams struct {
+	EventId    string
+	UserId     int64
+	GuestId    int64 // 0 for the user themselves
+	Status     AttendanceStatus
+	RecordedBy int64
+}
+
+// Records whether someone on the roster of the event showed up, replacing what was recorded before.
+// Only users who held a spot are on the roster, along with their guests.
+func (s *service) RecordAttendance(p RecordAttendanceParams) error {
+	s.log.Printf("event RecordAttendance params %+v", p)
+	if !p.Status.Valid() {
+		return ErrInvalidAttendance
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now()
+	e, err := get(tx, p.EventId, now)
+	if err != nil {
+		return err
+	}
+
+	if now.Before(e.Start.Add(-CheckInOpensBefore)) {
+		return ErrCheckInNotOpen
+	}
+
+	r, err := getUserResponse(tx, p.EventId, p.UserId)
+	if err != nil {
+		return err
+	}
+	if r == nil || r.OnWaitlist {
+		return ErrNotOnRoster
+	}
+
+	if p.GuestId != 0 {
+		guests, err := listResponseGuests(tx, p.EventId, p.UserId)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, g := range guests {
+			found = found || g.Id == p.GuestId
+		}
+		if !found {
+			return ErrNoGuest
+		}
+	}
+
+	a := Attendance{
+		EventId:    p.EventId,
+		UserId:     p.UserId,
+		GuestId:    p.GuestId,
+		Status:     p.Status,
+		RecordedBy: p.RecordedBy,
+		RecordedAt: now,
+	}
+	err = recordAttendance(tx, a)
+	if err != nil {
+		return err
+	}
+
+	strikeChanges, changes, err := s.syncStrikes(tx, a)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publishStrikes(strikeChanges)
+	s.publish(changes)
+	return nil
+}
+
+// Checks the user and their guests in with the code shown at the event, marking them late if they are.
+func (s *service) CheckIn(eventId string, userId int64, code string) (AttendanceStatus, error) {
+	s.log.Printf("event CheckIn eventId:%s userId:%d", eventId, userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now()
+	e, err := get(tx, eventId, now)
+	if err != nil {
+		return "", err
+	}
+
+	var want sql.NullString
+	err = tx.Get(&want, `SELECT check_in_code FROM event WHERE id = ?`, eventId)
+	if err != nil {
+		return "", err
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !want.Valid || subtle.ConstantTimeCompare([]byte(code), []byte(want.String)) != 1 {
+		return "", ErrInvalidCheckInCode
+	}
+
+	if err := e.CheckCheckIn(now); err != nil {
+		return "", err
+	}
+
+	r, err := getUserResponse(tx, eventId, userId)
+	if err != nil {
+		return "", err
+	}
+	if r == nil || r.OnWaitlist {
+		return "", ErrNotOnRoster
+	}
+
+	status := AttendancePresent
+	if now.After(e.Start.Add(LateAfter)) {
+		status = AttendanceLate
+	}
+
+	guests, err := listResponseGuests(tx, eventId, userId)
+	if err != nil {
+		return "", err
+	}
+	ids := []int64{0}
+	for _, g := range guests {
+		ids = append(ids, g.Id)
+	}
+	for _, id := range ids {
+		err = recordAttendance(tx, Attendance{
+			EventId:    eventId,
+			UserId:     userId,
+			GuestId:    id,
+			Status:     status,
+			RecordedBy: userId,
+			RecordedAt: now,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// checking in takes back a no-show the monitor recorded too early
+	strikeChanges, changes, err := s.syncStrikes(tx, Attendance{
+		EventId:    eventId,
+		UserId:     userId,
+		Status:     status,
+		RecordedBy: userId,
+		RecordedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	s.publishStrikes(strikeChanges)
+	s.publish(changes)
+	return status, nil
+}
+
+// Returns the code users check in to the event with, creating it the first time it is asked for.
+func (s *service) GetCheckInCode(eventId string) (string, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var code sql.NullString
+	err = tx.Get(&code, `SELECT check_in_code FROM event WHERE id = ? AND is_deleted = FALSE`, eventId)
+	if err != nil {
+		return "", err
+	}
+	if code.Valid {
+		return code.String, nil
+	}
+
+	code.String, err = gonanoid.Generate(checkInCodeAlphabet, checkInCodeLength)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(`UPDATE event SET check_in_code = ? WHERE id = ?`, code.String, eventId)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return code.String, nil
+}
+
+// Lists the attendance recorded for the user, newest event first. Their guests are left out.
+func (s *service) ListUserAttendance(userId int64) ([]AttendanceRecord, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT
+            a.event_id, a.user_id, a.guest_id, a.status, a.recorded_by, a.recorded_at
+            , e.name AS event_name, e.start AS event_start
+        FROM attendance AS a
+        INNER JOIN event AS e ON a.event_id = e.id
+        WHERE a.user_id = ? AND a.guest_id = 0
+        ORDER BY e.start DESC
+    `
+	args := []any{userId}
+
+	records := []AttendanceRecord{}
+	err = tx.Select(&records, stmt, args...)
+	return records, err
+}
+
+// Rolls up the attendance of every user it was recorded for, the highest no-show rates first.
+func (s *service) ListAttendanceStats() ([]AttendanceStats, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stats, err := listAttendanceStats(tx, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	// sorted here as the rate is computed in Go
+	slices.SortStableFunc(stats, func(a, b AttendanceStats) int {
+		return cmp.Compare(b.NoShowRate(), a.NoShowRate())
+	})
+
+	return stats, nil
+}
+
+func (s *service) GetAttendanceStats(userId int64) (AttendanceStats, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return AttendanceStats{}, err
+	}
+	defer tx.Rollback()
+
+	stats, err := listAttendanceStats(tx, userId)
+	if err != nil {
+		return AttendanceStats{}, err
+	}
+	if len(stats) == 0 {
+		return AttendanceStats{UserId: userId}, nil
+	}
+
+	return stats[0], nil
+}
+
+// Lists the stats of the user, or of every user if userId is -1.
+func listAttendanceStats(tx *sqlx.Tx, userId int64) ([]AttendanceStats, error) {
+	where, wargs := "a.guest_id = 0", []any{}
+	if userId > -1 {
+		where += " AND a.user_id = ?"
+		wargs = append(wargs, userId)
+	}
+
+	stmt := `
+        SELECT
+            a.user_id
+            , ` + user.DisplayNameSQL("u") + ` AS user_full_name
+            , SUM(CASE WHEN a.status = 'present' THEN 1 ELSE 0 END) AS present
+            , SUM(CASE WHEN a.status = 'late' THEN 1 ELSE 0 END) AS late
+            , SUM(CASE WHEN a.status = 'absent' THEN 1 ELSE 0 END) AS absent
+        FROM attendance AS a
+        LEFT JOIN users AS u ON a.user_id = u.id
+        WHERE ` + where + `
+        GROUP BY a.user_id
+        ORDER BY user_full_name
+    `
+
+	stats := []AttendanceStats{}
+	err := tx.Select(&stats, stmt, wargs...)
+	return stats, err
+}
+
+func recordAttendance(tx *sqlx.Tx, a Attendance) error {
+	stmt := `
+        INSERT INTO attendance (event_id, user_id, guest_id, status, recorded_by, recorded_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (event_id, user_id, guest_id) DO UPDATE SET
+            status = excluded.status,
+            recorded_by = excluded.recorded_by,
+            recorded_at = excluded.recorded_at
+    `
+	args := []any{a.EventId, a.UserId, a.GuestId, a.Status, a.RecordedBy, a.RecordedAt}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
+
+// Gives or takes back the no-show strike the attendance calls for, restricting or lifting the restriction of the user to match.
+func (s *service) syncStrikes(tx *sqlx.Tx, a Attendance) ([]StrikeChanged, []WaitlistChanged, error) {
+	added, removed, err := syncNoShowStrike(tx, a)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if added != nil {
+		strikeChanges, err := s.applyStrikes(tx, []Strike{*added}, a.RecordedBy, a.RecordedAt)
+		return strikeChanges, nil, err
+	}
+	if removed != nil {
+		strikeChanges := []StrikeChanged{{
+			Kind:    StrikeRemoved,
+			UserId:  removed.UserId,
+			ActorId: a.RecordedBy,
+			Strike:  *removed,
+		}}
+		more, changes, err := s.liftIfCleared(tx, removed.UserId, a.RecordedBy, a.RecordedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		return append(strikeChanges, more...), changes, nil
+	}
+
+	return nil, nil, nil
+}
+
+// Lists the attendance taken for the event, by user and then guest.
+func listEventAttendance(tx *sqlx.Tx, eventId string) (map[int64]map[int64]AttendanceStatus, error) {
+	stmt := `
+        SELECT event_id, user_id, guest_id, status, recorded_by, recorded_at
+        FROM attendance
+        WHERE event_id = ?
+    `
+	args := []any{eventId}
+
+	var attendance []Attendance
+	err := tx.Select(&attendance, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := map[int64]map[int64]AttendanceStatus{}
+	for _, a := range attendance {
+		if byUser[a.UserId] == nil {
+			byUser[a.UserId] = map[int64]AttendanceStatus{}
+		}
+		byUser[a.UserId][a.GuestId] = a.Status
+	}
+	return byUser, nil
+}
@@ -0,0 +1,105 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/clock"
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttendance(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	c := clock.NewFake(now)
+	eventService := event.NewService(db)
+	eventService.SetClock(c)
+
+	var users []user.User
+	for i := 0; i < 3; i++ {
+		u, err := user.NewService(db).Create(user.CreateParams{FullName: "Potter"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	monitor := users[2]
+
+	start := now.Add(time.Hour)
+	id := MustCreate(t, db, event.CreateParams{Name: "Class", Capacity: 2, Start: start, StudioMonitorId: -1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[1].Id, Id: id, AttendeeCount: 1})
+	guest, err := eventService.AddGuest(event.AddGuestParams{EventId: id, UserId: users[0].Id, Name: "Ann"})
+	assert.NoError(t, err)
+
+	code, err := eventService.GetCheckInCode(id)
+	assert.NoError(t, err)
+	again, err := eventService.GetCheckInCode(id)
+	assert.NoError(t, err)
+	assert.Equal(t, code, again, "the code stays the same")
+
+	_, err = eventService.CheckIn(id, users[0].Id, code)
+	assert.ErrorIs(t, err, event.ErrCheckInNotOpen)
+	err = eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id, UserId: users[0].Id, Status: event.AttendancePresent, RecordedBy: monitor.Id})
+	assert.ErrorIs(t, err, event.ErrCheckInNotOpen)
+
+	c.Advance(time.Hour + event.LateAfter + time.Minute)
+	_, err = eventService.CheckIn(id, users[0].Id, "WRONG")
+	assert.ErrorIs(t, err, event.ErrInvalidCheckInCode)
+	_, err = eventService.CheckIn(id, users[1].Id, code)
+	assert.ErrorIs(t, err, event.ErrNotOnRoster, "users on the waitlist have no spot to check in to")
+
+	status, err := eventService.CheckIn(id, users[0].Id, code)
+	assert.NoError(t, err)
+	assert.Equal(t, event.AttendanceLate, status)
+
+	err = eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id, UserId: users[0].Id, GuestId: guest.Id, Status: event.AttendanceAbsent, RecordedBy: monitor.Id})
+	assert.NoError(t, err)
+	err = eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id, UserId: users[0].Id, GuestId: 999, Status: event.AttendanceAbsent, RecordedBy: monitor.Id})
+	assert.ErrorIs(t, err, event.ErrNoGuest)
+	err = eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id, UserId: users[0].Id, Status: "asleep", RecordedBy: monitor.Id})
+	assert.ErrorIs(t, err, event.ErrInvalidAttendance)
+
+	e, err := eventService.GetDetailed(id, users[0].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, event.AttendanceLate, e.UserResponse.Attendance)
+	assert.Equal(t, event.AttendanceAbsent, e.UserResponse.Guests[0].Attendance)
+
+	c.Advance(event.CheckInClosesAfter)
+	_, err = eventService.CheckIn(id, users[0].Id, code)
+	assert.ErrorIs(t, err, event.ErrCheckInClosed)
+
+	// a second event the user doesn't show up to
+	id2 := MustCreate(t, db, event.CreateParams{Name: "Other class", Capacity: 2, Start: c.Now().Add(time.Hour), StudioMonitorId: -1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[0].Id, Id: id2, AttendeeCount: 1})
+	c.Advance(time.Hour)
+	err = eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id2, UserId: users[0].Id, Status: event.AttendanceAbsent, RecordedBy: monitor.Id})
+	assert.NoError(t, err)
+
+	history, err := eventService.ListUserAttendance(users[0].Id)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2, "guests aren't part of the user's history") {
+		assert.Equal(t, "Other class", history[0].EventName)
+		assert.Equal(t, event.AttendanceAbsent, history[0].Status)
+	}
+
+	stats, err := eventService.GetAttendanceStats(users[0].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Late)
+	assert.Equal(t, 1, stats.Absent)
+	assert.Equal(t, 0.5, stats.NoShowRate())
+	assert.Equal(t, 50, stats.NoShowPercent())
+
+	stats, err = eventService.GetAttendanceStats(users[1].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.Total())
+	assert.Equal(t, 0.0, stats.NoShowRate())
+
+	all, err := eventService.ListAttendanceStats()
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+}
@@ -0,0 +1,50 @@
+package event
+
+import (
+	"database/sql"
+)
+
+// An event as it appears in a user's calendar feed.
+type CalendarEvent struct {
+	Event
+	IsDeleted  bool         `db:"is_deleted"`
+	OnWaitlist sql.NullBool `db:"on_waitlist"` // the user's response, invalid if they didn't respond
+}
+
+type CalendarFilter struct {
+	UserId     int64
+	IncludeAll bool // also list events the user didn't respond to but can see through their groups
+}
+
+// Lists the upcoming events of the user's calendar feed, soonest first.
+// Deleted events the user responded to are still listed so that calendar apps can cancel them.
+func (s *service) ListCalendar(f CalendarFilter) ([]CalendarEvent, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT
+            e.id, e.name, e.capacity, e.start, e.created_at, e.creator_id, e.description, e.group_id
+            , e.series_id, e.original_start, e.sequence, e.updated_at
+            , e.is_deleted, er.on_waitlist
+        FROM event AS e
+        LEFT JOIN event_response AS er ON er.event_id = e.id AND er.user_id = ?
+        WHERE datetime(?) <= datetime(e.start)
+            AND (
+                er.user_id IS NOT NULL
+                OR (
+                    ? AND e.is_deleted = FALSE
+                    AND (e.group_id IS NULL OR e.group_id IN (SELECT group_id FROM user_group_member WHERE user_id = ?))
+                )
+            )
+        ORDER BY e.start
+    `
+	args := []any{f.UserId, s.clock.Now(), f.IncludeAll, f.UserId}
+
+	events := []CalendarEvent{}
+	err = tx.Select(&events, stmt, args...)
+	return events, err
+}
@@ -0,0 +1,95 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/group"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListCalendar(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	eventService := event.NewService(db)
+	userService := user.NewService(db)
+
+	u1, err := userService.Create(user.CreateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2, err := userService.Create(user.CreateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := group.NewService(db).CreateAndAddMember(group.CreateParams{CreatorId: u2.Id, Name: "Members"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	going := MustCreate(t, db, event.CreateParams{Name: "Going", Capacity: 1, Start: now.Add(day), StudioMonitorId: -1})
+	waitlisted := MustCreate(t, db, event.CreateParams{Name: "Waitlisted", Capacity: 1, Start: now.Add(2 * day), StudioMonitorId: -1})
+	deleted := MustCreate(t, db, event.CreateParams{Name: "Deleted", Capacity: 1, Start: now.Add(3 * day), StudioMonitorId: -1})
+	other := MustCreate(t, db, event.CreateParams{Name: "Other", Capacity: 1, Start: now.Add(4 * day), StudioMonitorId: -1})
+	private := MustCreate(t, db, event.CreateParams{Name: "Private", GroupId: g, Start: now.Add(5 * day), StudioMonitorId: -1})
+	MustCreate(t, db, event.CreateParams{Name: "Past", Capacity: 1, Start: now.Add(-day), StudioMonitorId: -1})
+
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: u1.Id, Id: going, AttendeeCount: 1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: u2.Id, Id: waitlisted, AttendeeCount: 1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: u1.Id, Id: waitlisted, AttendeeCount: 1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: u1.Id, Id: deleted, AttendeeCount: 1})
+	assert.NoError(t, eventService.Delete(deleted, admin))
+
+	events, err := eventService.ListCalendar(event.CalendarFilter{UserId: u1.Id})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, going, events[0].Id)
+		assert.False(t, events[0].OnWaitlist.Bool)
+		assert.Equal(t, waitlisted, events[1].Id)
+		assert.True(t, events[1].OnWaitlist.Bool)
+		assert.Equal(t, deleted, events[2].Id)
+		assert.True(t, events[2].IsDeleted)
+		assert.Equal(t, 1, events[2].Sequence, "deleting bumps the sequence")
+	}
+
+	events, err = eventService.ListCalendar(event.CalendarFilter{UserId: u1.Id, IncludeAll: true})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 4, "includes events without a response, but not those of other groups") {
+		assert.Equal(t, other, events[3].Id)
+		assert.False(t, events[3].OnWaitlist.Valid)
+	}
+
+	err = group.NewService(db).AddMember(g, u1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err = eventService.ListCalendar(event.CalendarFilter{UserId: u1.Id, IncludeAll: true})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 5) {
+		assert.Equal(t, private, events[4].Id)
+	}
+}
+
+func TestUpdateBumpsSequence(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	eventService := event.NewService(db)
+
+	id := MustCreate(t, db, event.CreateParams{Name: "Class", Start: time.Now().Add(day), StudioMonitorId: -1})
+	e, err := eventService.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, e.Sequence)
+	assert.Equal(t, e.CreatedAt, e.LastModified())
+
+	err = eventService.Update(event.UpdateParams{User: admin, Id: id, Name: "Renamed", Start: e.Start, StudioMonitorId: -1})
+	assert.NoError(t, err)
+
+	e, err = eventService.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, e.Sequence)
+	assert.True(t, e.UpdatedAt.Valid)
+}
@@ -0,0 +1,440 @@
+package event
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Chaldron/clay-play/user"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrAlreadyEnrolled       = errors.New("you are already enrolled in this series")
+	ErrNotEnrolled           = errors.New("you are not enrolled in this series")
+	ErrNoUpcomingOccurrences = errors.New("the series has no upcoming events")
+)
+
+// A user signed up for every upcoming occurrence of a series at once.
+// Enrolled users have a response to each occurrence, users on the series waitlist have none
+// until a spot is free in every occurrence.
+type SeriesEnrollment struct {
+	SeriesId      string    `db:"series_id"`
+	UserId        int64     `db:"user_id"`
+	AttendeeCount int       `db:"attendee_count"`
+	OnWaitlist    bool      `db:"on_waitlist"`
+	CreatedAt     time.Time `db:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at"`
+	UserFullName  string    `db:"user_full_name"`
+}
+
+type EnrollSeriesParams struct {
+	SeriesId      string
+	UserId        int64
+	AttendeeCount int
+	Override      bool // for admins acting on the user's behalf, skips the registration windows
+}
+
+// Reserves a spot in every upcoming occurrence of the series, or none at all.
+// If any occurrence is full the user goes onto the series waitlist instead, see the returned enrollment.
+// Registration has to be open for the next occurrence.
+func (s *service) EnrollSeries(p EnrollSeriesParams) (SeriesEnrollment, error) {
+	s.log.Printf("event EnrollSeries params %+v", p)
+	if err := checkAttendeeCount(p.AttendeeCount); err != nil {
+		return SeriesEnrollment{}, err
+	}
+	if p.AttendeeCount == 0 {
+		return SeriesEnrollment{}, errors.New("cannot enroll without attendees")
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return SeriesEnrollment{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = getSeries(tx, p.SeriesId)
+	if err != nil {
+		return SeriesEnrollment{}, err
+	}
+
+	now := s.clock.Now()
+
+	existing, err := getSeriesEnrollment(tx, p.SeriesId, p.UserId)
+	if err != nil {
+		return SeriesEnrollment{}, err
+	}
+	if existing != nil {
+		return SeriesEnrollment{}, ErrAlreadyEnrolled
+	}
+
+	occurrences, err := listUpcomingOccurrences(tx, p.SeriesId, now)
+	if err != nil {
+		return SeriesEnrollment{}, err
+	}
+	if len(occurrences) == 0 {
+		return SeriesEnrollment{}, ErrNoUpcomingOccurrences
+	}
+	for i, id := range occurrences {
+		e, err := get(tx, id, now)
+		if err != nil {
+			return SeriesEnrollment{}, err
+		}
+		if err := e.checkPartySize(p.AttendeeCount); err != nil {
+			return SeriesEnrollment{}, err
+		}
+		// every occurrence's window is shifted along with its start, so only the next one's has to be open
+		if i == 0 && !p.Override {
+			if err := e.CheckRegistration(now); err != nil {
+				return SeriesEnrollment{}, err
+			}
+		}
+	}
+	if !p.Override {
+		if err := checkNotBlocked(tx, p.UserId, now); err != nil {
+			return SeriesEnrollment{}, err
+		}
+	}
+
+	reserved, flipped, err := reserveSeries(tx, p.SeriesId, p.UserId, p.AttendeeCount, now)
+	if err != nil {
+		return SeriesEnrollment{}, err
+	}
+
+	changes, err := waitlistChanges(tx, flipped, p.UserId, now)
+	if err != nil {
+		return SeriesEnrollment{}, err
+	}
+
+	enrollment := SeriesEnrollment{
+		SeriesId:      p.SeriesId,
+		UserId:        p.UserId,
+		AttendeeCount: p.AttendeeCount,
+		OnWaitlist:    !reserved,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	stmt := `
+        INSERT INTO series_enrollment (series_id, user_id, attendee_count, on_waitlist, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `
+	args := []any{enrollment.SeriesId, enrollment.UserId, enrollment.AttendeeCount, enrollment.OnWaitlist, enrollment.CreatedAt, enrollment.UpdatedAt}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return SeriesEnrollment{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return SeriesEnrollment{}, err
+	}
+
+	s.publish(changes)
+	return enrollment, nil
+}
+
+// Ends the enrollment of the user, releasing their spots in every upcoming occurrence of the series.
+// Responses to past occurrences are kept as history.
+func (s *service) DropSeries(seriesId string, userId int64) error {
+	s.log.Printf("event DropSeries seriesId:%s userId:%d", seriesId, userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now()
+	enrollment, err := getSeriesEnrollment(tx, seriesId, userId)
+	if err != nil {
+		return err
+	}
+	if enrollment == nil {
+		return ErrNotEnrolled
+	}
+
+	_, err = tx.Exec(`DELETE FROM series_enrollment WHERE series_id = ? AND user_id = ?`, seriesId, userId)
+	if err != nil {
+		return err
+	}
+
+	changes := []WaitlistChanged{}
+	strikes := []Strike{}
+	if !enrollment.OnWaitlist {
+		var flipped []EventResponse
+		flipped, strikes, err = removeSeriesResponses(tx, seriesId, userId, now)
+		if err != nil {
+			return err
+		}
+
+		changes, err = waitlistChanges(tx, flipped, userId, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	more, err := manageSeriesWaitlist(tx, seriesId, now)
+	if err != nil {
+		return err
+	}
+	changes = append(changes, more...)
+
+	strikeChanges, err := s.applyStrikes(tx, strikes, userId, now)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publish(changes)
+	s.publishStrikes(strikeChanges)
+	return nil
+}
+
+// Returns nil if the user never enrolled in the series.
+func (s *service) GetSeriesEnrollment(seriesId string, userId int64) (*SeriesEnrollment, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	enrollment, err := getSeriesEnrollment(tx, seriesId, userId)
+	return enrollment, err
+}
+
+// Lists everyone enrolled in the series, in the order they enrolled, followed by the series waitlist.
+func (s *service) ListSeriesEnrollments(seriesId string) ([]SeriesEnrollment, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT
+            se.series_id, se.user_id, se.attendee_count, se.on_waitlist, se.created_at, se.updated_at
+            , ` + user.DisplayNameSQL("u") + ` AS user_full_name
+        FROM series_enrollment AS se
+        LEFT JOIN users AS u ON se.user_id = u.id
+        WHERE se.series_id = ?
+        ORDER BY se.on_waitlist, se.created_at
+    `
+	args := []any{seriesId}
+
+	enrollments := []SeriesEnrollment{}
+	err = tx.Select(&enrollments, stmt, args...)
+	return enrollments, err
+}
+
+func checkAttendeeCount(n int) error {
+	if n < 0 {
+		return errors.New("cannot have less than 0 attendees")
+	}
+	return nil
+}
+
+func getSeriesEnrollment(tx *sqlx.Tx, seriesId string, userId int64) (*SeriesEnrollment, error) {
+	stmt := `
+        SELECT series_id, user_id, attendee_count, on_waitlist, created_at, updated_at
+        FROM series_enrollment
+        WHERE series_id = ? AND user_id = ?
+    `
+	args := []any{seriesId, userId}
+
+	var enrollment SeriesEnrollment
+	err := tx.Get(&enrollment, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &enrollment, nil
+}
+
+func listUpcomingOccurrences(tx *sqlx.Tx, seriesId string, now time.Time) ([]string, error) {
+	stmt := `
+        SELECT id FROM event
+        WHERE series_id = ? AND is_deleted = FALSE AND datetime(?) <= datetime(start)
+        ORDER BY start
+    `
+	args := []any{seriesId, now}
+
+	var ids []string
+	err := tx.Select(&ids, stmt, args...)
+	return ids, err
+}
+
+// Signs the user up for every upcoming occurrence of the series.
+// If that would put them on the waitlist of any occurrence, or they are restricted to waitlists, nothing is changed and false is returned.
+// Otherwise also returns the responses of others whose waitlist status changed, which happens if the user already had a smaller response to an occurrence.
+func reserveSeries(tx *sqlx.Tx, seriesId string, userId int64, attendeeCount int, now time.Time) (bool, []EventResponse, error) {
+	occurrences, err := listUpcomingOccurrences(tx, seriesId, now)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(occurrences) == 0 {
+		return false, nil, nil
+	}
+
+	// users restricted to the waitlist can't hold spots, so they stay on the series waitlist
+	waitlistOnly, err := isWaitlistOnly(tx, userId, now)
+	if err != nil || waitlistOnly {
+		return false, nil, err
+	}
+
+	_, err = tx.Exec(`SAVEPOINT reserve_series`)
+	if err != nil {
+		return false, nil, err
+	}
+
+	changed := []EventResponse{}
+	for _, id := range occurrences {
+		err = updateResponse(tx, updateResponseParams{
+			EventId:       id,
+			UserId:        userId,
+			AttendeeCount: attendeeCount,
+			Now:           now,
+		})
+		if err != nil {
+			return false, nil, err
+		}
+
+		flipped, err := manageWaitlist(tx, id, now)
+		if err != nil {
+			return false, nil, err
+		}
+
+		r, err := getUserResponse(tx, id, userId)
+		if err != nil {
+			return false, nil, err
+		}
+		if r.OnWaitlist {
+			_, err = tx.Exec(`ROLLBACK TO reserve_series`)
+			if err != nil {
+				return false, nil, err
+			}
+			_, err = tx.Exec(`RELEASE reserve_series`)
+			return false, nil, err
+		}
+
+		for _, f := range flipped {
+			if f.UserId != userId {
+				changed = append(changed, f)
+			}
+		}
+	}
+
+	_, err = tx.Exec(`RELEASE reserve_series`)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, changed, nil
+}
+
+// Removes the responses of the user to the upcoming occurrences of the series, moving people up from their waitlists.
+// Occurrences past their cancellation cutoff record a late cancellation, along with a strike.
+//
+// Returns the responses that had their waitlist status updated, and the strikes given.
+func removeSeriesResponses(tx *sqlx.Tx, seriesId string, userId int64, now time.Time) ([]EventResponse, []Strike, error) {
+	occurrences, err := listUpcomingOccurrences(tx, seriesId, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := []EventResponse{}
+	strikes := []Strike{}
+	for _, id := range occurrences {
+		e, err := get(tx, id, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		r, err := getUserResponse(tx, id, userId)
+		if err != nil {
+			return nil, nil, err
+		}
+		if r != nil {
+			strike, err := recordLateCancellation(tx, e, r, r.AttendeeCount, now)
+			if err != nil {
+				return nil, nil, err
+			}
+			if strike != nil {
+				strikes = append(strikes, *strike)
+			}
+		}
+
+		err = deleteResponse(tx, id, userId)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		flipped, err := manageWaitlist(tx, id, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		changed = append(changed, flipped...)
+	}
+
+	return changed, strikes, nil
+}
+
+// Enrolls users from the series waitlist, in the order they joined it, for as long as every occurrence has room for them.
+//
+// Returns the waitlist changes this caused, both the enrollments that moved off the series waitlist
+// and any responses to single occurrences that moved because of them.
+func manageSeriesWaitlist(tx *sqlx.Tx, seriesId string, now time.Time) ([]WaitlistChanged, error) {
+	stmt := `
+        SELECT series_id, user_id, attendee_count, on_waitlist, created_at, updated_at
+        FROM series_enrollment
+        WHERE series_id = ? AND on_waitlist = TRUE
+        ORDER BY created_at
+    `
+	args := []any{seriesId}
+
+	var waitlist []SeriesEnrollment
+	err := tx.Select(&waitlist, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	promoted := []SeriesEnrollment{}
+	flipped := []EventResponse{}
+	for _, e := range waitlist {
+		ok, f, err := reserveSeries(tx, seriesId, e.UserId, e.AttendeeCount, now)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		flipped = append(flipped, f...)
+
+		e.OnWaitlist = false
+		e.UpdatedAt = now
+		_, err = tx.Exec(
+			`UPDATE series_enrollment SET on_waitlist = FALSE, updated_at = ? WHERE series_id = ? AND user_id = ?`,
+			e.UpdatedAt, e.SeriesId, e.UserId,
+		)
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, e)
+	}
+
+	changes, err := seriesWaitlistChanges(tx, seriesId, promoted, now)
+	if err != nil {
+		return nil, err
+	}
+
+	more, err := waitlistChanges(tx, flipped, -1, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(changes, more...), nil
+}
@@ -0,0 +1,182 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrollSeries(t *testing.T) {
+	start := time.Now().UTC().Truncate(time.Hour).Add(day)
+	setup := func(t *testing.T) (*db.DB, []event.Event, []user.User) {
+		db := db.TestingConnect(t)
+		id := MustCreate(t, db, event.CreateParams{
+			Name:            "Course",
+			Capacity:        2,
+			Start:           start,
+			StudioMonitorId: -1,
+			RRule:           "FREQ=WEEKLY;COUNT=3",
+		})
+
+		var users []user.User
+		for i := 0; i < 3; i++ {
+			u, err := user.NewService(db).Create(user.CreateParams{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			users = append(users, u)
+		}
+
+		return db, seriesEvents(t, event.NewService(db), id), users
+	}
+	onWaitlist := func(t *testing.T, s event.Service, eventId string, userId int64) *bool {
+		t.Helper()
+		r, err := s.ListResponses(eventId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, er := range r {
+			if er.UserId == userId {
+				return &er.OnWaitlist
+			}
+		}
+		return nil
+	}
+
+	t.Run("ReservesEveryOccurrence", func(t *testing.T) {
+		db, events, users := setup(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+
+		enrollment, err := eventService.EnrollSeries(event.EnrollSeriesParams{
+			SeriesId:      events[0].SeriesId.String,
+			UserId:        users[0].Id,
+			AttendeeCount: 1,
+		})
+		assert.NoError(t, err)
+		assert.False(t, enrollment.OnWaitlist)
+
+		for _, e := range events {
+			if w := onWaitlist(t, eventService, e.Id, users[0].Id); assert.NotNil(t, w) {
+				assert.False(t, *w)
+			}
+		}
+
+		_, err = eventService.EnrollSeries(event.EnrollSeriesParams{
+			SeriesId:      events[0].SeriesId.String,
+			UserId:        users[0].Id,
+			AttendeeCount: 1,
+		})
+		assert.ErrorIs(t, err, event.ErrAlreadyEnrolled)
+	})
+
+	t.Run("WaitlistsWhenAnyOccurrenceIsFull", func(t *testing.T) {
+		db, events, users := setup(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+
+		// the last week is full
+		MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[0].Id, Id: events[2].Id, AttendeeCount: 2})
+
+		enrollment, err := eventService.EnrollSeries(event.EnrollSeriesParams{
+			SeriesId:      events[0].SeriesId.String,
+			UserId:        users[1].Id,
+			AttendeeCount: 1,
+		})
+		assert.NoError(t, err)
+		assert.True(t, enrollment.OnWaitlist)
+
+		for _, e := range events {
+			assert.Nil(t, onWaitlist(t, eventService, e.Id, users[1].Id), "no spot is taken in any occurrence")
+		}
+
+		// a spot opens up in the last week
+		MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[0].Id, Id: events[2].Id, AttendeeCount: 1})
+
+		got, err := eventService.GetSeriesEnrollment(events[0].SeriesId.String, users[1].Id)
+		assert.NoError(t, err)
+		if assert.NotNil(t, got) {
+			assert.False(t, got.OnWaitlist)
+		}
+		for _, e := range events {
+			if w := onWaitlist(t, eventService, e.Id, users[1].Id); assert.NotNil(t, w) {
+				assert.False(t, *w)
+			}
+		}
+	})
+
+	t.Run("ChecksRegistrationOfNextOccurrence", func(t *testing.T) {
+		db, _, users := setup(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		create := func(opensAt time.Time) string {
+			id := MustCreate(t, db, event.CreateParams{
+				Name:                 "Windowed course",
+				Capacity:             2,
+				Start:                start,
+				StudioMonitorId:      -1,
+				RRule:                "FREQ=WEEKLY;COUNT=3",
+				RegistrationOpensAt:  opensAt,
+				RegistrationClosesAt: start.Add(-time.Hour),
+			})
+			return seriesEvents(t, eventService, id)[0].SeriesId.String
+		}
+
+		// open for the first week, the later weeks open in the weeks after
+		seriesId := create(start.Add(-2 * day))
+		enrollment, err := eventService.EnrollSeries(event.EnrollSeriesParams{SeriesId: seriesId, UserId: users[0].Id, AttendeeCount: 1})
+		assert.NoError(t, err)
+		assert.False(t, enrollment.OnWaitlist)
+
+		// not open for the first week yet
+		seriesId = create(start.Add(-2 * time.Hour))
+		_, err = eventService.EnrollSeries(event.EnrollSeriesParams{SeriesId: seriesId, UserId: users[0].Id, AttendeeCount: 1})
+		assert.ErrorIs(t, err, event.ErrRegistrationNotOpen)
+
+		_, err = eventService.EnrollSeries(event.EnrollSeriesParams{SeriesId: seriesId, UserId: users[0].Id, AttendeeCount: 1, Override: true})
+		assert.NoError(t, err, "admins can enroll the user anyway")
+	})
+
+	t.Run("DropReleasesSpots", func(t *testing.T) {
+		db, events, users := setup(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		seriesId := events[0].SeriesId.String
+
+		_, err := eventService.EnrollSeries(event.EnrollSeriesParams{SeriesId: seriesId, UserId: users[0].Id, AttendeeCount: 2})
+		assert.NoError(t, err)
+
+		// waitlisted on a single occurrence and on the series
+		MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[1].Id, Id: events[1].Id, AttendeeCount: 1})
+		enrollment, err := eventService.EnrollSeries(event.EnrollSeriesParams{SeriesId: seriesId, UserId: users[2].Id, AttendeeCount: 1})
+		assert.NoError(t, err)
+		assert.True(t, enrollment.OnWaitlist)
+
+		err = eventService.DropSeries(seriesId, users[0].Id)
+		assert.NoError(t, err)
+
+		for _, e := range events {
+			assert.Nil(t, onWaitlist(t, eventService, e.Id, users[0].Id))
+		}
+		if w := onWaitlist(t, eventService, events[1].Id, users[1].Id); assert.NotNil(t, w) {
+			assert.False(t, *w, "moves up from the occurrence waitlist")
+		}
+
+		got, err := eventService.GetSeriesEnrollment(seriesId, users[2].Id)
+		assert.NoError(t, err)
+		if assert.NotNil(t, got) {
+			assert.False(t, got.OnWaitlist, "moves up from the series waitlist")
+		}
+
+		enrollments, err := eventService.ListSeriesEnrollments(seriesId)
+		assert.NoError(t, err)
+		assert.Len(t, enrollments, 1)
+
+		err = eventService.DropSeries(seriesId, users[0].Id)
+		assert.ErrorIs(t, err, event.ErrNotEnrolled)
+	})
+}
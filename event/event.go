@@ -2,7 +2,10 @@ package event
 
 import (
 	"database/sql"
+	"errors"
 	"time"
+
+	"github.com/Chaldron/clay-play/user"
 )
 
 type Service interface {
@@ -12,8 +15,37 @@ type Service interface {
 	List(ListFilter) (EventList, error)
 	Create(CreateParams) (string, error)
 	Update(UpdateParams) error
-	Delete(string) error
+	Delete(string, user.SessionUser) error
+	DeleteOccurrences(string, Scope, user.SessionUser) error
+	GetSeries(string) (Series, error)
+	ListCalendar(CalendarFilter) ([]CalendarEvent, error)
+	EnrollSeries(EnrollSeriesParams) (SeriesEnrollment, error)
+	DropSeries(seriesId string, userId int64) error
+	GetSeriesEnrollment(seriesId string, userId int64) (*SeriesEnrollment, error)
+	ListSeriesEnrollments(seriesId string) ([]SeriesEnrollment, error)
 	HandleResponse(HandleResponseParams) error
+	AcceptOffer(eventId string, userId int64) error
+	ListLateCancellations(eventId string) ([]LateCancellation, error)
+	ExpireOffers() error
+	RecordAttendance(RecordAttendanceParams) error
+	CheckIn(eventId string, userId int64, code string) (AttendanceStatus, error)
+	GetCheckInCode(eventId string) (string, error)
+	ListUserAttendance(userId int64) ([]AttendanceRecord, error)
+	ListAttendanceStats() ([]AttendanceStats, error)
+	GetAttendanceStats(userId int64) (AttendanceStats, error)
+	AddGuest(AddGuestParams) (Guest, error)
+	RemoveGuest(RemoveGuestParams) error
+	Subscribe(func(WaitlistChanged))
+	ListStrikes(userId int64) ([]Strike, error)
+	GetRestriction(userId int64) (*Restriction, error)
+	ListRestrictions() ([]Restriction, error)
+	ForgiveStrike(strikeId int64, actorId int64) error
+	ExpireRestrictions() error
+	SubscribeStrikes(func(StrikeChanged))
+	RemoveUser(RemoveUserParams) error
+	ListUserResponses(userId int64) ([]UserResponse, error)
+	UserCanManage(string, user.SessionUser) (bool, error)
+	UserCanManageError(string, user.SessionUser) error
 }
 
 type Event struct {
@@ -27,34 +59,83 @@ type Event struct {
 	CreatorId             string         `db:"creator_id"`
 	CreatorFullName       string         `db:"creator_full_name"`
 	TotalAttendeeCount    int            `db:"total_attendee_count"`
+	OfferedAttendeeCount  int            `db:"offered_attendee_count"` // held for waitlisted users until they accept or their offer expires
 	IsPast                bool           `db:"is_past"`
 	StudioMonitorId       sql.NullInt64  `db:"studio_monitor_id"`
 	StudioMonitorFullName sql.NullString `db:"studio_monitor_full_name"`
 	Description           sql.NullString `db:"description"`
+	SeriesId              sql.NullString `db:"series_id"`
+	OriginalStart         sql.NullTime   `db:"original_start"` // start the series gave the occurrence, before any edits
+	Sequence              int            `db:"sequence"`       // revision of the event, bumped on every update for calendar apps
+	UpdatedAt             sql.NullTime   `db:"updated_at"`
+	OfferHours            int            `db:"offer_hours"`    // how long a waitlisted user has to accept a free spot, 0 moves them up right away
+	MaxPartySize          int            `db:"max_party_size"` // most attendees a single response can bring, the user included
+
+	// Users can only sign up or add attendees between these, if set. Cancelling is always possible until the start,
+	// but cancellations after the cutoff are recorded as late.
+	RegistrationOpensAt  sql.NullTime `db:"registration_opens_at"`
+	RegistrationClosesAt sql.NullTime `db:"registration_closes_at"`
+	CancellationCutoff   sql.NullTime `db:"cancellation_cutoff"`
 }
 
 func (e Event) SpotsLeft() int {
-	return e.Capacity - e.TotalAttendeeCount
+	return e.Capacity - e.TotalAttendeeCount - e.OfferedAttendeeCount
+}
+
+// When the event was last changed, events that were never updated report when they were created.
+func (e Event) LastModified() time.Time {
+	if e.UpdatedAt.Valid {
+		return e.UpdatedAt.Time
+	}
+	return e.CreatedAt
 }
 
 type EventResponse struct {
-	EventId       string    `db:"event_id"`
-	UserId        int64     `db:"user_id"`
-	CreatedAt     time.Time `db:"created_at"`
-	UpdatedAt     time.Time `db:"updated_at"`
-	AttendeeCount int       `db:"attendee_count"`
-	OnWaitlist    bool      `db:"on_waitlist"`
-	UserFullName  string    `db:"user_full_name"`
+	EventId       string         `db:"event_id"`
+	UserId        int64          `db:"user_id"`
+	CreatedAt     time.Time      `db:"created_at"`
+	UpdatedAt     time.Time      `db:"updated_at"`
+	AttendeeCount int            `db:"attendee_count"`
+	OnWaitlist    bool           `db:"on_waitlist"`
+	UserFullName  string         `db:"user_full_name"`
+	UserPicture   sql.NullString `db:"user_picture"`
+
+	// Set while the user is offered a spot off the waitlist, see Service.AcceptOffer.
+	OfferExpiresAt sql.NullTime `db:"offer_expires_at"`
+
+	// The guests the user named, responses made before guests had names can have fewer than PlusOnes.
+	Guests []Guest `db:"-"`
+
+	Attendance AttendanceStatus `db:"-"` // empty until attendance is taken
 }
 
 func (e EventResponse) PlusOnes() int {
 	return e.AttendeeCount - 1
 }
 
+// Plus ones the user hasn't given a name for.
+func (e EventResponse) UnnamedGuests() int {
+	return e.PlusOnes() - len(e.Guests)
+}
+
+// A response of a user along with the event it is for.
+type UserResponse struct {
+	EventResponse
+	EventName  string    `db:"event_name"`
+	EventStart time.Time `db:"event_start"`
+}
+
 type EventDetailed struct {
 	Event
 	UserResponse *EventResponse
 	Responses    []EventResponse
+	Series       *Series
+	Enrollment   *SeriesEnrollment // the user's enrollment in the series, if any
+
+	RegistrationErr    error        // why the user can't sign up right now, nil if they can
+	CancellationIsLate bool         // cancelling now would be recorded as late
+	CheckInErr         error        // why the user can't check in right now, nil if they can
+	Restriction        *Restriction // the sign-up restriction the user is under, if any
 }
 
 // containing this in a struct in case need to include more fields for pagination
@@ -62,4 +143,9 @@ type EventList struct {
 	Events []Event
 }
 
-var MaxAttendeeCount = 2
+// Party size of events created without one, the user and one plus one.
+const DefaultMaxPartySize = 2
+
+var (
+	ErrCannotManage = errors.New("you cannot manage this event")
+)
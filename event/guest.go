@@ -0,0 +1,356 @@
+package event
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrPartyTooLarge       = errors.New("party is too large for this event")
+	ErrInvalidPartySize    = errors.New("party size has to be at least 1")
+	ErrNoResponse          = errors.New("you have to be going to bring guests")
+	ErrNoGuest             = errors.New("guest not found")
+	ErrGuestNameRequired   = errors.New("guests need a name")
+	ErrInvalidGuestEmail   = errors.New("guest email is not valid")
+	ErrCannotRespondToPast = errors.New("cannot respond to past events")
+)
+
+// Someone a user brings along to an event. Every guest takes one of the attendees of the user's response.
+type Guest struct {
+	Id        int64          `db:"id"`
+	EventId   string         `db:"event_id"`
+	UserId    int64          `db:"user_id"`
+	Name      string         `db:"name"`
+	Email     sql.NullString `db:"email"`
+	CreatedAt time.Time      `db:"created_at"`
+
+	Attendance AttendanceStatus `db:"-"` // empty until attendance is taken
+}
+
+type AddGuestParams struct {
+	EventId  string
+	UserId   int64
+	Name     string
+	Email    string // optional
+	Override bool   // for admins acting on the user's behalf, skips the registration window and restrictions
+}
+
+// Adds a named guest to the user's response. Plus ones the user hasn't named yet are named first,
+// only once every plus one has a name does the party grow, which can move it onto the waitlist.
+func (s *service) AddGuest(p AddGuestParams) (Guest, error) {
+	s.log.Printf("event AddGuest params %+v", p)
+	p.Name = strings.TrimSpace(p.Name)
+	p.Email = strings.TrimSpace(p.Email)
+	if p.Name == "" {
+		return Guest{}, ErrGuestNameRequired
+	}
+	if p.Email != "" {
+		if a, err := mail.ParseAddress(p.Email); err != nil || a.Address != p.Email {
+			return Guest{}, ErrInvalidGuestEmail
+		}
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Guest{}, err
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now()
+	e, err := get(tx, p.EventId, now)
+	if err != nil {
+		return Guest{}, err
+	}
+	if e.IsPast {
+		return Guest{}, ErrCannotRespondToPast
+	}
+
+	r, err := getUserResponse(tx, p.EventId, p.UserId)
+	if err != nil {
+		return Guest{}, err
+	}
+	if r == nil {
+		return Guest{}, ErrNoResponse
+	}
+
+	guests, err := listResponseGuests(tx, p.EventId, p.UserId)
+	if err != nil {
+		return Guest{}, err
+	}
+
+	grows := len(guests) >= r.PlusOnes()
+	if grows {
+		if err := e.checkPartySize(r.AttendeeCount + 1); err != nil {
+			return Guest{}, err
+		}
+		if !p.Override {
+			if err := e.CheckRegistration(now); err != nil {
+				return Guest{}, err
+			}
+			if err := checkNotBlocked(tx, p.UserId, now); err != nil {
+				return Guest{}, err
+			}
+		}
+	}
+
+	g := Guest{
+		EventId: p.EventId,
+		UserId:  p.UserId,
+		Name:    p.Name,
+		Email: sql.NullString{
+			String: p.Email,
+			Valid:  p.Email != "",
+		},
+		CreatedAt: now,
+	}
+	g.Id, err = createGuest(tx, g)
+	if err != nil {
+		return Guest{}, err
+	}
+
+	changes := []WaitlistChanged{}
+	if grows {
+		changes, err = s.resizeParty(tx, e, p.UserId, r.AttendeeCount+1)
+		if err != nil {
+			return Guest{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Guest{}, err
+	}
+
+	s.publish(changes)
+	return g, nil
+}
+
+type RemoveGuestParams struct {
+	EventId  string
+	UserId   int64
+	GuestId  int64
+	Override bool // for admins acting on the user's behalf, doesn't record a late cancellation
+}
+
+// Removes a guest from the user's response, shrinking the party by one.
+// Removing a guest after the cancellation cutoff is recorded as a late cancellation of one attendee.
+func (s *service) RemoveGuest(p RemoveGuestParams) error {
+	s.log.Printf("event RemoveGuest params %+v", p)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now()
+	e, err := get(tx, p.EventId, now)
+	if err != nil {
+		return err
+	}
+	if e.IsPast {
+		return ErrCannotRespondToPast
+	}
+
+	r, err := getUserResponse(tx, p.EventId, p.UserId)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return ErrNoGuest
+	}
+
+	stmt := `
+        DELETE FROM event_guest
+        WHERE id = ? AND event_id = ? AND user_id = ?
+    `
+	args := []any{p.GuestId, p.EventId, p.UserId}
+
+	res, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoGuest
+	}
+
+	strikes := []Strike{}
+	if !p.Override {
+		strike, err := recordLateCancellation(tx, e, r, 1, now)
+		if err != nil {
+			return err
+		}
+		if strike != nil {
+			strikes = append(strikes, *strike)
+		}
+	}
+
+	changes, err := s.resizeParty(tx, e, p.UserId, r.AttendeeCount-1)
+	if err != nil {
+		return err
+	}
+
+	strikeChanges, err := s.applyStrikes(tx, strikes, p.UserId, now)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publish(changes)
+	s.publishStrikes(strikeChanges)
+	return nil
+}
+
+// Sets the attendee count of the user's response and moves people on or off the waitlists to match.
+func (s *service) resizeParty(tx *sqlx.Tx, e Event, userId int64, attendeeCount int) ([]WaitlistChanged, error) {
+	now := s.clock.Now()
+	err := updateResponse(tx, updateResponseParams{
+		EventId:       e.Id,
+		UserId:        userId,
+		AttendeeCount: attendeeCount,
+		Now:           now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	flipped, err := manageWaitlist(tx, e.Id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := waitlistChanges(tx, flipped, userId, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.SeriesId.Valid {
+		more, err := manageSeriesWaitlist(tx, e.SeriesId.String, now)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, more...)
+	}
+
+	return changes, nil
+}
+
+// Checks a response of attendeeCount attendees fits the event. Lowering the party size of an event
+// leaves larger parties as they are, they just can't grow.
+func (e Event) checkPartySize(attendeeCount int) error {
+	if attendeeCount > e.MaxPartySize {
+		return fmt.Errorf("%w, maximum of %d plus one(s) allowed", ErrPartyTooLarge, e.MaxPartySize-1)
+	}
+	return nil
+}
+
+func checkMaxPartySize(n int) error {
+	if n < 1 {
+		return ErrInvalidPartySize
+	}
+	return nil
+}
+
+func createGuest(tx *sqlx.Tx, g Guest) (int64, error) {
+	stmt := `
+        INSERT INTO event_guest (event_id, user_id, name, email, created_at)
+        VALUES (?, ?, ?, ?, ?)
+    `
+	args := []any{g.EventId, g.UserId, g.Name, g.Email, g.CreatedAt}
+
+	res, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func listResponseGuests(tx *sqlx.Tx, eventId string, userId int64) ([]Guest, error) {
+	stmt := `
+        SELECT id, event_id, user_id, name, email, created_at
+        FROM event_guest
+        WHERE event_id = ? AND user_id = ?
+        ORDER BY id
+    `
+	args := []any{eventId, userId}
+
+	guests := []Guest{}
+	err := tx.Select(&guests, stmt, args...)
+	return guests, err
+}
+
+// Lists the guests of every response to the event, by the user who brought them.
+func listEventGuests(tx *sqlx.Tx, eventId string) (map[int64][]Guest, error) {
+	stmt := `
+        SELECT id, event_id, user_id, name, email, created_at
+        FROM event_guest
+        WHERE event_id = ?
+        ORDER BY id
+    `
+	args := []any{eventId}
+
+	var guests []Guest
+	err := tx.Select(&guests, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := map[int64][]Guest{}
+	for _, g := range guests {
+		byUser[g.UserId] = append(byUser[g.UserId], g)
+	}
+	return byUser, nil
+}
+
+// Lists the guests the user brought to any event, by event.
+func listUserGuests(tx *sqlx.Tx, userId int64) (map[string][]Guest, error) {
+	stmt := `
+        SELECT id, event_id, user_id, name, email, created_at
+        FROM event_guest
+        WHERE user_id = ?
+        ORDER BY id
+    `
+	args := []any{userId}
+
+	var guests []Guest
+	err := tx.Select(&guests, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	byEvent := map[string][]Guest{}
+	for _, g := range guests {
+		byEvent[g.EventId] = append(byEvent[g.EventId], g)
+	}
+	return byEvent, nil
+}
+
+// Deletes the guests of the user's response beyond the first keep, the most recently added go first.
+func trimGuests(tx *sqlx.Tx, eventId string, userId int64, keep int) error {
+	stmt := `
+        DELETE FROM event_guest
+        WHERE id IN (
+            SELECT id FROM event_guest
+            WHERE event_id = ? AND user_id = ?
+            ORDER BY id
+            LIMIT -1 OFFSET ?
+        )
+    `
+	args := []any{eventId, userId, keep}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
@@ -0,0 +1,113 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartySize(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	eventService := event.NewService(db)
+
+	u, err := user.NewService(db).Create(user.CreateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := MustCreate(t, db, event.CreateParams{Capacity: 10, Start: time.Now().Add(day), StudioMonitorId: -1})
+	e, err := eventService.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, event.DefaultMaxPartySize, e.MaxPartySize)
+
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 3})
+	assert.ErrorIs(t, err, event.ErrPartyTooLarge)
+
+	err = eventService.Update(event.UpdateParams{User: admin, Id: id, Capacity: 10, Start: e.Start, StudioMonitorId: -1, MaxPartySize: 4})
+	assert.NoError(t, err)
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 4})
+
+	err = eventService.Update(event.UpdateParams{User: admin, Id: id, Capacity: 10, Start: e.Start, StudioMonitorId: -1, MaxPartySize: 1})
+	assert.NoError(t, err)
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 3})
+	assert.NoError(t, err, "larger parties can still shrink")
+
+	_, err = eventService.Create(event.CreateParams{Start: time.Now().Add(day), StudioMonitorId: -1, MaxPartySize: -1})
+	assert.ErrorIs(t, err, event.ErrInvalidPartySize)
+}
+
+func TestGuests(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	eventService := event.NewService(db)
+
+	var users []user.User
+	for i := 0; i < 2; i++ {
+		u, err := user.NewService(db).Create(user.CreateParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+
+	id := MustCreate(t, db, event.CreateParams{Capacity: 3, Start: time.Now().Add(day), StudioMonitorId: -1, MaxPartySize: 3})
+
+	_, err := eventService.AddGuest(event.AddGuestParams{EventId: id, UserId: users[0].Id, Name: "Ann"})
+	assert.ErrorIs(t, err, event.ErrNoResponse)
+
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 2})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[1].Id, Id: id, AttendeeCount: 1})
+
+	ann, err := eventService.AddGuest(event.AddGuestParams{EventId: id, UserId: users[0].Id, Name: " Ann ", Email: "ann@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Ann", ann.Name)
+
+	e, err := eventService.GetDetailed(id, users[0].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, e.UserResponse.AttendeeCount, "the unnamed plus one got a name")
+	assert.Len(t, e.UserResponse.Guests, 1)
+	assert.False(t, e.Responses[1].OnWaitlist)
+
+	_, err = eventService.AddGuest(event.AddGuestParams{EventId: id, UserId: users[0].Id, Name: "Ben"})
+	assert.NoError(t, err)
+
+	e, err = eventService.GetDetailed(id, users[0].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, e.UserResponse.AttendeeCount)
+	assert.Equal(t, "Ann", e.Responses[0].Guests[0].Name)
+	assert.Equal(t, "Ben", e.Responses[0].Guests[1].Name)
+	assert.True(t, e.Responses[1].OnWaitlist, "the party grew past capacity")
+
+	_, err = eventService.AddGuest(event.AddGuestParams{EventId: id, UserId: users[0].Id, Name: "Cal"})
+	assert.ErrorIs(t, err, event.ErrPartyTooLarge)
+	_, err = eventService.AddGuest(event.AddGuestParams{EventId: id, UserId: users[0].Id, Name: " "})
+	assert.ErrorIs(t, err, event.ErrGuestNameRequired)
+	_, err = eventService.AddGuest(event.AddGuestParams{EventId: id, UserId: users[0].Id, Name: "Cal", Email: "cal"})
+	assert.ErrorIs(t, err, event.ErrInvalidGuestEmail)
+
+	err = eventService.RemoveGuest(event.RemoveGuestParams{EventId: id, UserId: users[1].Id, GuestId: ann.Id})
+	assert.ErrorIs(t, err, event.ErrNoGuest, "only the user who brought a guest can remove them")
+
+	err = eventService.RemoveGuest(event.RemoveGuestParams{EventId: id, UserId: users[0].Id, GuestId: ann.Id})
+	assert.NoError(t, err)
+
+	e, err = eventService.GetDetailed(id, users[0].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, e.UserResponse.AttendeeCount)
+	assert.Equal(t, "Ben", e.UserResponse.Guests[0].Name)
+	assert.False(t, e.Responses[1].OnWaitlist, "removing a guest freed a spot")
+
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 1})
+	e, err = eventService.GetDetailed(id, users[0].Id)
+	assert.NoError(t, err)
+	assert.Empty(t, e.UserResponse.Guests, "a smaller party drops its guests")
+
+	responses, err := eventService.ListUserResponses(users[0].Id)
+	assert.NoError(t, err)
+	assert.Empty(t, responses[0].Guests)
+}
@@ -0,0 +1,133 @@
+package event
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrNoOffer           = errors.New("there is no spot on offer for you, it may have expired")
+	ErrInvalidOfferHours = errors.New("offer hours cannot be negative")
+)
+
+// Takes the spot the user was offered off the waitlist.
+func (s *service) AcceptOffer(eventId string, userId int64) error {
+	s.log.Printf("event AcceptOffer eventId:%s userId:%d", eventId, userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r, err := getUserResponse(tx, eventId, userId)
+	if err != nil {
+		return err
+	}
+	if r == nil || !r.OfferExpiresAt.Valid || !r.OfferExpiresAt.Time.After(s.clock.Now()) {
+		return ErrNoOffer
+	}
+
+	r.OnWaitlist = false
+	r.OfferExpiresAt = sql.NullTime{}
+	err = setWaitlist(tx, *r)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Removes the responses of users who didn't accept their offer in time, passing the spot on to the next person on the waitlist.
+// Meant to be run periodically.
+func (s *service) ExpireOffers() error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now()
+	expired, err := listExpiredOffers(tx, now)
+	if err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	s.log.Printf("event ExpireOffers expiring %d offer(s)", len(expired))
+
+	changes := []WaitlistChanged{}
+	managed := map[string]bool{}
+	for _, r := range expired {
+		err = deleteResponse(tx, r.EventId, r.UserId)
+		if err != nil {
+			return err
+		}
+
+		c, err := waitlistChanges(tx, []EventResponse{r}, -1, now)
+		if err != nil {
+			return err
+		}
+		for i := range c {
+			c[i].OfferExpired = true
+		}
+		changes = append(changes, c...)
+	}
+
+	for _, r := range expired {
+		if managed[r.EventId] {
+			continue
+		}
+		managed[r.EventId] = true
+
+		flipped, err := manageWaitlist(tx, r.EventId, now)
+		if err != nil {
+			return err
+		}
+
+		c, err := waitlistChanges(tx, flipped, -1, now)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, c...)
+
+		e, err := get(tx, r.EventId, now)
+		if err != nil {
+			return err
+		}
+		if e.SeriesId.Valid {
+			c, err = manageSeriesWaitlist(tx, e.SeriesId.String, now)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, c...)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publish(changes)
+	return nil
+}
+
+func listExpiredOffers(tx *sqlx.Tx, now time.Time) ([]EventResponse, error) {
+	stmt := `
+        SELECT er.event_id, er.user_id, er.attendee_count, er.on_waitlist, er.offer_expires_at
+        FROM event_response AS er
+        INNER JOIN event AS e ON er.event_id = e.id
+        WHERE er.offer_expires_at IS NOT NULL AND datetime(er.offer_expires_at) <= datetime(?)
+            AND e.is_deleted = FALSE
+        ORDER BY er.offer_expires_at
+    `
+	args := []any{now}
+
+	var responses []EventResponse
+	err := tx.Select(&responses, stmt, args...)
+	return responses, err
+}
@@ -0,0 +1,224 @@
+package event_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/clock"
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/scheduler"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitlistOffers(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	start := now.Add(2 * day)
+	setup := func(t *testing.T, offerHours int) (*db.DB, *clock.Fake, event.Service, string, []user.User, *[]event.WaitlistChanged) {
+		db := db.TestingConnect(t)
+		c := clock.NewFake(now)
+		s := event.NewService(db)
+		s.SetClock(c)
+
+		changes := &[]event.WaitlistChanged{}
+		s.Subscribe(func(wc event.WaitlistChanged) {
+			*changes = append(*changes, wc)
+		})
+
+		id := MustCreate(t, db, event.CreateParams{Name: "Class", Capacity: 1, Start: start, StudioMonitorId: -1, OfferHours: offerHours})
+
+		var users []user.User
+		for i := 0; i < 3; i++ {
+			u, err := user.NewService(db).Create(user.CreateParams{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			users = append(users, u)
+
+			err = s.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return db, c, s, id, users, changes
+	}
+	response := func(t *testing.T, s event.Service, id string, userId int64) *event.EventResponse {
+		t.Helper()
+		e, err := s.GetDetailed(id, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.UserResponse
+	}
+
+	t.Run("OffersTheFreeSpot", func(t *testing.T) {
+		db, _, s, id, users, changes := setup(t, 2)
+		defer db.Close()
+
+		err := s.HandleResponse(event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 0})
+		assert.NoError(t, err)
+
+		r := response(t, s, id, users[1].Id)
+		assert.True(t, r.OnWaitlist, "stays on the waitlist until they accept")
+		assert.Equal(t, now.Add(2*time.Hour), r.OfferExpiresAt.Time)
+		assert.True(t, response(t, s, id, users[2].Id).OnWaitlist)
+		assert.False(t, response(t, s, id, users[2].Id).OfferExpiresAt.Valid, "only one spot is offered")
+
+		e, err := s.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, e.SpotsLeft(), "the offered spot is held")
+
+		if assert.Len(t, *changes, 1) {
+			assert.Equal(t, users[1].Id, (*changes)[0].UserId)
+			assert.True(t, (*changes)[0].IsOffer())
+			assert.Equal(t, now.Add(2*time.Hour), (*changes)[0].OfferExpiresAt)
+		}
+	})
+
+	t.Run("Accept", func(t *testing.T) {
+		db, c, s, id, users, _ := setup(t, 2)
+		defer db.Close()
+
+		err := s.AcceptOffer(id, users[1].Id)
+		assert.ErrorIs(t, err, event.ErrNoOffer, "nothing is on offer yet")
+
+		err = s.HandleResponse(event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 0})
+		assert.NoError(t, err)
+
+		c.Advance(time.Hour)
+		err = s.ExpireOffers()
+		assert.NoError(t, err)
+		err = s.AcceptOffer(id, users[1].Id)
+		assert.NoError(t, err)
+
+		r := response(t, s, id, users[1].Id)
+		assert.False(t, r.OnWaitlist)
+		assert.False(t, r.OfferExpiresAt.Valid)
+
+		err = s.AcceptOffer(id, users[1].Id)
+		assert.ErrorIs(t, err, event.ErrNoOffer)
+	})
+
+	t.Run("ExpiredOfferPassesOn", func(t *testing.T) {
+		db, c, s, id, users, changes := setup(t, 2)
+		defer db.Close()
+
+		err := s.HandleResponse(event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 0})
+		assert.NoError(t, err)
+		*changes = nil
+
+		c.Advance(2 * time.Hour)
+		err = s.AcceptOffer(id, users[1].Id)
+		assert.ErrorIs(t, err, event.ErrNoOffer, "can't accept once it expired, even before it is cleaned up")
+
+		err = s.ExpireOffers()
+		assert.NoError(t, err)
+
+		assert.Nil(t, response(t, s, id, users[1].Id), "lost their place")
+		r := response(t, s, id, users[2].Id)
+		assert.True(t, r.OnWaitlist)
+		assert.Equal(t, c.Now().Add(2*time.Hour), r.OfferExpiresAt.Time)
+
+		if assert.Len(t, *changes, 2) {
+			assert.Equal(t, users[1].Id, (*changes)[0].UserId)
+			assert.True(t, (*changes)[0].OfferExpired)
+			assert.False(t, (*changes)[0].IsOffer())
+			assert.Equal(t, users[2].Id, (*changes)[1].UserId)
+			assert.True(t, (*changes)[1].IsOffer())
+		}
+
+		*changes = nil
+		err = s.ExpireOffers()
+		assert.NoError(t, err)
+		assert.Empty(t, *changes, "nothing else is due")
+	})
+
+	t.Run("OffersEndAtTheStart", func(t *testing.T) {
+		db, c, s, id, users, _ := setup(t, 72)
+		defer db.Close()
+
+		err := s.HandleResponse(event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 0})
+		assert.NoError(t, err)
+		assert.Equal(t, start, response(t, s, id, users[1].Id).OfferExpiresAt.Time)
+
+		c.Advance(2 * day)
+		err = s.ExpireOffers()
+		assert.NoError(t, err)
+		r := response(t, s, id, users[2].Id)
+		assert.True(t, r.OnWaitlist)
+		assert.False(t, r.OfferExpiresAt.Valid, "too late to offer it to anyone else")
+	})
+
+	t.Run("LowerCapacityWithdrawsOffer", func(t *testing.T) {
+		db, _, s, id, users, _ := setup(t, 2)
+		defer db.Close()
+
+		e, err := s.Get(id)
+		assert.NoError(t, err)
+		p := event.UpdateParams{User: admin, Id: id, Name: e.Name, Capacity: 2, Start: e.Start, StudioMonitorId: -1, OfferHours: 2}
+		err = s.Update(p)
+		assert.NoError(t, err)
+		assert.True(t, response(t, s, id, users[1].Id).OfferExpiresAt.Valid)
+
+		p.Capacity = 1
+		err = s.Update(p)
+		assert.NoError(t, err)
+		r := response(t, s, id, users[1].Id)
+		assert.True(t, r.OnWaitlist)
+		assert.False(t, r.OfferExpiresAt.Valid)
+		assert.False(t, response(t, s, id, users[0].Id).OnWaitlist)
+	})
+
+	t.Run("TurningOffersOffPromotes", func(t *testing.T) {
+		db, _, s, id, users, _ := setup(t, 2)
+		defer db.Close()
+
+		err := s.HandleResponse(event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 0})
+		assert.NoError(t, err)
+
+		err = s.Update(event.UpdateParams{User: admin, Id: id, Name: "Class", Capacity: 1, Start: start, StudioMonitorId: -1, OfferHours: 0})
+		assert.NoError(t, err)
+		r := response(t, s, id, users[1].Id)
+		assert.False(t, r.OnWaitlist)
+		assert.False(t, r.OfferExpiresAt.Valid)
+	})
+
+	t.Run("Scheduler", func(t *testing.T) {
+		db, c, s, id, users, _ := setup(t, 1)
+		defer db.Close()
+
+		err := s.HandleResponse(event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 0})
+		assert.NoError(t, err)
+
+		ran := make(chan error, 100)
+		jobs := scheduler.New(c)
+		jobs.Every("expire waitlist offers", time.Minute, func() error {
+			err := s.ExpireOffers()
+			ran <- err
+			return err
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go jobs.Run(ctx)
+
+		for i := 0; i < 60; i++ {
+			c.BlockUntil(1)
+			c.Advance(time.Minute)
+			assert.NoError(t, <-ran)
+		}
+
+		assert.Nil(t, response(t, s, id, users[1].Id))
+		assert.True(t, response(t, s, id, users[2].Id).OfferExpiresAt.Valid)
+	})
+
+	t.Run("NegativeHours", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+
+		_, err := event.NewService(db).Create(event.CreateParams{Name: "Class", Start: start, StudioMonitorId: -1, OfferHours: -1})
+		assert.ErrorIs(t, err, event.ErrInvalidOfferHours)
+	})
+}
@@ -0,0 +1,110 @@
+package event
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Chaldron/clay-play/user"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrRegistrationNotOpen = errors.New("registration for this event hasn't opened yet")
+	ErrRegistrationClosed  = errors.New("registration for this event has closed")
+	ErrInvalidWindow       = errors.New("registration has to open before it closes")
+)
+
+// A cancellation made after the cutoff of the event. Cancelling late isn't blocked, it is recorded for staff to follow up on.
+type LateCancellation struct {
+	Id            int64     `db:"id"`
+	EventId       string    `db:"event_id"`
+	UserId        int64     `db:"user_id"`
+	AttendeeCount int       `db:"attendee_count"` // how many attendees were cancelled
+	CreatedAt     time.Time `db:"created_at"`
+	UserFullName  string    `db:"user_full_name"`
+}
+
+// Returns why users can't sign up for the event or add attendees at the given time, nil if they can.
+func (e Event) CheckRegistration(now time.Time) error {
+	if e.RegistrationOpensAt.Valid && now.Before(e.RegistrationOpensAt.Time) {
+		return ErrRegistrationNotOpen
+	}
+	if e.RegistrationClosesAt.Valid && !now.Before(e.RegistrationClosesAt.Time) {
+		return ErrRegistrationClosed
+	}
+	return nil
+}
+
+// Reports whether cancelling at the given time is past the cutoff.
+func (e Event) IsLateCancellation(now time.Time) bool {
+	return e.CancellationCutoff.Valid && !now.Before(e.CancellationCutoff.Time)
+}
+
+// Lists the late cancellations of the event, oldest first.
+func (s *service) ListLateCancellations(eventId string) ([]LateCancellation, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT
+            lc.id, lc.event_id, lc.user_id, lc.attendee_count, lc.created_at
+            , ` + user.DisplayNameSQL("u") + ` AS user_full_name
+        FROM late_cancellation AS lc
+        LEFT JOIN users AS u ON lc.user_id = u.id
+        WHERE lc.event_id = ?
+        ORDER BY lc.created_at
+    `
+	args := []any{eventId}
+
+	cancellations := []LateCancellation{}
+	err = tx.Select(&cancellations, stmt, args...)
+	return cancellations, err
+}
+
+func checkWindow(opensAt, closesAt time.Time) error {
+	if !opensAt.IsZero() && !closesAt.IsZero() && !opensAt.Before(closesAt) {
+		return ErrInvalidWindow
+	}
+	return nil
+}
+
+// Records that the user cancelled attendeeCount attendees of their response after the cutoff,
+// unless the response was on the waitlist and held nobody's spot, and gives them a strike for it.
+// Returns the strike, nil if none was given.
+func recordLateCancellation(tx *sqlx.Tx, e Event, r *EventResponse, attendeeCount int, now time.Time) (*Strike, error) {
+	if r == nil || r.OnWaitlist || attendeeCount <= 0 || !e.IsLateCancellation(now) {
+		return nil, nil
+	}
+
+	stmt := `
+        INSERT INTO late_cancellation (event_id, user_id, attendee_count, created_at)
+        VALUES (?, ?, ?, ?)
+    `
+	args := []any{e.Id, r.UserId, attendeeCount, now}
+
+	_, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return addStrike(tx, r.UserId, e.Id, StrikeLateCancellation, now)
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{
+		Time:  t,
+		Valid: !t.IsZero(),
+	}
+}
+
+// Moves a window time along with the start of an occurrence, keeping it unset if it is.
+func shiftWindow(t time.Time, d time.Duration) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(d)
+}
@@ -0,0 +1,152 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/clock"
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationWindow(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	c := clock.NewFake(now)
+	eventService := event.NewService(db)
+	eventService.SetClock(c)
+
+	u, err := user.NewService(db).Create(user.CreateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := now.Add(3 * day)
+	id, err := eventService.Create(event.CreateParams{
+		Name:                 "Class",
+		Capacity:             4,
+		Start:                start,
+		StudioMonitorId:      -1,
+		RegistrationOpensAt:  now.Add(day),
+		RegistrationClosesAt: now.Add(2 * day),
+	})
+	assert.NoError(t, err)
+
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 1})
+	assert.ErrorIs(t, err, event.ErrRegistrationNotOpen)
+	e, err := eventService.GetDetailed(id, u.Id)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, e.RegistrationErr, event.ErrRegistrationNotOpen)
+
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 1, Override: true})
+	assert.NoError(t, err, "admins can sign the user up anyway")
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 0})
+	assert.NoError(t, err, "cancelling is always possible")
+
+	c.Advance(day)
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 1})
+	assert.NoError(t, err)
+
+	c.Advance(day)
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 2})
+	assert.ErrorIs(t, err, event.ErrRegistrationClosed, "can't add a plus one once closed")
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 0})
+	assert.NoError(t, err)
+
+	_, err = eventService.Create(event.CreateParams{
+		Name:                 "Backwards",
+		Start:                start,
+		StudioMonitorId:      -1,
+		RegistrationOpensAt:  now.Add(2 * day),
+		RegistrationClosesAt: now.Add(day),
+	})
+	assert.ErrorIs(t, err, event.ErrInvalidWindow)
+}
+
+func TestLateCancellation(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	c := clock.NewFake(now)
+	eventService := event.NewService(db)
+	eventService.SetClock(c)
+
+	var users []user.User
+	for i := 0; i < 3; i++ {
+		u, err := user.NewService(db).Create(user.CreateParams{FullName: "Potter"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+
+	id, err := eventService.Create(event.CreateParams{
+		Name:               "Class",
+		Capacity:           2,
+		Start:              now.Add(2 * day),
+		StudioMonitorId:    -1,
+		CancellationCutoff: now.Add(day),
+	})
+	assert.NoError(t, err)
+
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 2})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[1].Id, Id: id, AttendeeCount: 1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: users[2].Id, Id: id, AttendeeCount: 1})
+
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: users[2].Id, Id: id, AttendeeCount: 0})
+	assert.NoError(t, err)
+	late, err := eventService.ListLateCancellations(id)
+	assert.NoError(t, err)
+	assert.Empty(t, late, "before the cutoff")
+
+	c.Advance(day)
+	e, err := eventService.GetDetailed(id, users[0].Id)
+	assert.NoError(t, err)
+	assert.True(t, e.CancellationIsLate)
+
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: users[1].Id, Id: id, AttendeeCount: 0})
+	assert.NoError(t, err, "was on the waitlist, so it isn't late")
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 1})
+	assert.NoError(t, err, "late cancellations aren't blocked")
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 0, Override: true})
+	assert.NoError(t, err)
+
+	late, err = eventService.ListLateCancellations(id)
+	assert.NoError(t, err)
+	if assert.Len(t, late, 1) {
+		assert.Equal(t, users[0].Id, late[0].UserId)
+		assert.Equal(t, 1, late[0].AttendeeCount, "only the plus one was cancelled")
+		assert.Equal(t, "Potter", late[0].UserFullName)
+		assert.Equal(t, c.Now(), late[0].CreatedAt)
+	}
+}
+
+func TestSeriesWindows(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	eventService := event.NewService(db)
+
+	start := time.Now().UTC().Truncate(time.Hour).Add(day)
+	id, err := eventService.Create(event.CreateParams{
+		Name:                 "Course",
+		Capacity:             2,
+		Start:                start,
+		StudioMonitorId:      -1,
+		RRule:                "FREQ=WEEKLY;COUNT=2",
+		RegistrationClosesAt: start.Add(-time.Hour),
+		CancellationCutoff:   start.Add(-12 * time.Hour),
+	})
+	assert.NoError(t, err)
+
+	events := seriesEvents(t, eventService, id)
+	if assert.Len(t, events, 2) {
+		second, err := eventService.Get(events[1].Id)
+		assert.NoError(t, err)
+		assert.Equal(t, second.Start.Add(-time.Hour), second.RegistrationClosesAt.Time)
+		assert.Equal(t, second.Start.Add(-12*time.Hour), second.CancellationCutoff.Time)
+		assert.False(t, second.RegistrationOpensAt.Valid)
+	}
+}
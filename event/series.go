@@ -0,0 +1,294 @@
+package event
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/rrule"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/jmoiron/sqlx"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// Upper bound on the events a single series creates, so that a typo in the rule can't fill the calendar.
+const MaxSeriesOccurrences = 200
+
+var (
+	ErrNoSeries        = errors.New("series not found")
+	ErrInvalidTimezone = errors.New("unknown timezone")
+	ErrNoOccurrences   = errors.New("the series has no occurrences, every date is excluded")
+	ErrInvalidScope    = errors.New("choose which occurrences to change")
+)
+
+// Which occurrences of a series an edit applies to.
+type Scope string
+
+const (
+	ScopeThis      Scope = "this"
+	ScopeFollowing Scope = "following" // this occurrence and every later one
+	ScopeAll       Scope = "all"       // every occurrence that hasn't started yet
+)
+
+// A repeating event. Each occurrence is a regular event row pointing back to its series,
+// so occurrences keep their own capacity and responses.
+type Series struct {
+	Id        string    `db:"id"`
+	RRule     string    `db:"rrule"`
+	Timezone  string    `db:"timezone"`
+	ExDates   string    `db:"exdates"` // comma separated dates that were skipped, in the timezone of the series
+	CreatedAt time.Time `db:"created_at"`
+	CreatorId int64     `db:"creator_id"`
+}
+
+// A human readable description of the rule, e.g. "Every week on Monday, 10 times".
+func (s Series) Describe() string {
+	r, err := rrule.Parse(s.RRule)
+	if err != nil {
+		return s.RRule
+	}
+	return r.Describe()
+}
+
+func (s Series) ExDateList() []string {
+	if s.ExDates == "" {
+		return nil
+	}
+	return strings.Split(s.ExDates, ",")
+}
+
+func (s Series) Location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (s *service) GetSeries(id string) (Series, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Series{}, err
+	}
+	defer tx.Rollback()
+
+	series, err := getSeries(tx, id)
+	return series, err
+}
+
+// Deletes the event, or with a scope other than ScopeThis, the occurrences of its series it covers.
+func (s *service) DeleteOccurrences(id string, scope Scope, u user.SessionUser) error {
+	s.log.Printf("event DeleteOccurrences id:%s scope:%s", id, scope)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now()
+	e, err := get(tx, id, now)
+	if err != nil {
+		return err
+	}
+
+	targets, loc, err := scopeTargets(tx, e, scope, now)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if !canManage(t, u) {
+			return ErrCannotManage
+		}
+	}
+
+	for _, t := range targets {
+		_, err = tx.Exec(`UPDATE event SET is_deleted = TRUE, sequence = sequence + 1, updated_at = ? WHERE id = ?`, now, t.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	changes := []WaitlistChanged{}
+	if e.SeriesId.Valid {
+		// a single deleted occurrence is remembered as an exception of the series
+		if len(targets) == 1 && e.OriginalStart.Valid {
+			err = addExDate(tx, e.SeriesId.String, e.OriginalStart.Time.In(loc))
+			if err != nil {
+				return err
+			}
+		}
+
+		// one less occurrence to find room in
+		changes, err = manageSeriesWaitlist(tx, e.SeriesId.String, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publish(changes)
+	return nil
+}
+
+// Creates the series and an event for each of its occurrences, returning the id of the first one.
+func createSeries(tx *sqlx.Tx, p CreateParams, now time.Time) (string, error) {
+	rule, err := rrule.Parse(p.RRule)
+	if err != nil {
+		return "", err
+	}
+
+	loc := time.UTC
+	if p.Timezone != "" {
+		loc, err = time.LoadLocation(p.Timezone)
+		if err != nil {
+			return "", ErrInvalidTimezone
+		}
+	}
+
+	starts, err := rule.All(p.Start.In(loc), MaxSeriesOccurrences)
+	if err != nil {
+		return "", err
+	}
+
+	exDates := make([]string, len(p.ExDates))
+	for i, d := range p.ExDates {
+		exDates[i] = d.Format(time.DateOnly)
+	}
+	starts = slices.DeleteFunc(starts, func(t time.Time) bool {
+		return slices.Contains(exDates, t.Format(time.DateOnly))
+	})
+	if len(starts) == 0 {
+		return "", ErrNoOccurrences
+	}
+
+	seriesId, err := gonanoid.New()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `
+        INSERT INTO event_series (id, rrule, timezone, exdates, created_at, creator_id)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `
+	args := []any{seriesId, rule.String(), loc.String(), strings.Join(exDates, ","), now, p.CreatorId}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return "", err
+	}
+
+	firstId := ""
+	for _, start := range starts {
+		op := p
+		op.Start = start.UTC()
+
+		// every occurrence gets the same windows relative to its start
+		d := op.Start.Sub(p.Start)
+		op.RegistrationOpensAt = shiftWindow(p.RegistrationOpensAt, d)
+		op.RegistrationClosesAt = shiftWindow(p.RegistrationClosesAt, d)
+		op.CancellationCutoff = shiftWindow(p.CancellationCutoff, d)
+		id, err := create(tx, op, seriesId, now)
+		if err != nil {
+			return "", err
+		}
+		if firstId == "" {
+			firstId = id
+		}
+	}
+
+	return firstId, nil
+}
+
+func getSeries(tx *sqlx.Tx, id string) (Series, error) {
+	stmt := `
+        SELECT id, rrule, timezone, exdates, created_at, creator_id FROM event_series
+        WHERE id = ?
+    `
+	args := []any{id}
+
+	var series Series
+	err := tx.Get(&series, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Series{}, ErrNoSeries
+	}
+	return series, err
+}
+
+// Returns the events an edit of e with the given scope applies to, along with the location the series is scheduled in.
+// Past occurrences are only included if they are e itself.
+func scopeTargets(tx *sqlx.Tx, e Event, scope Scope, now time.Time) ([]Event, *time.Location, error) {
+	switch scope {
+	case "", ScopeThis, ScopeFollowing, ScopeAll:
+	default:
+		return nil, nil, ErrInvalidScope
+	}
+
+	if !e.SeriesId.Valid {
+		return []Event{e}, time.UTC, nil
+	}
+
+	series, err := getSeries(tx, e.SeriesId.String)
+	if err != nil {
+		return nil, nil, err
+	}
+	loc := series.Location()
+
+	if scope == "" || scope == ScopeThis {
+		return []Event{e}, loc, nil
+	}
+
+	where, args := []string{"series_id = ?", "is_deleted = FALSE"}, []any{e.SeriesId.String}
+	if scope == ScopeFollowing {
+		where = append(where, "datetime(original_start) >= datetime(?)")
+		args = append(args, e.OriginalStart.Time)
+	}
+
+	stmt := `
+        SELECT id, start, original_start FROM event
+        WHERE ` + strings.Join(where, " AND ") + `
+            AND (id = ? OR datetime(?) <= datetime(start))
+        ORDER BY original_start
+    `
+	args = append(args, e.Id, now)
+
+	var targets []Event
+	err = tx.Select(&targets, stmt, args...)
+	return targets, loc, err
+}
+
+// Moves an occurrence starting at t the same way the edited occurrence moved from oldStart to newStart:
+// by the same number of days, to the new time of day in the timezone of the series.
+func shiftStart(t, oldStart, newStart time.Time, loc *time.Location) time.Time {
+	if t.Equal(oldStart) {
+		return newStart
+	}
+
+	o, n, tl := oldStart.In(loc), newStart.In(loc), t.In(loc)
+	days := int(time.Date(n.Year(), n.Month(), n.Day(), 0, 0, 0, 0, time.UTC).Sub(time.Date(o.Year(), o.Month(), o.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24)
+
+	return time.Date(tl.Year(), tl.Month(), tl.Day()+days, n.Hour(), n.Minute(), n.Second(), 0, loc).UTC()
+}
+
+func addExDate(tx *sqlx.Tx, seriesId string, originalStart time.Time) error {
+	series, err := getSeries(tx, seriesId)
+	if err != nil {
+		return err
+	}
+
+	exDates := series.ExDateList()
+	date := originalStart.Format(time.DateOnly)
+	if slices.Contains(exDates, date) {
+		return nil
+	}
+	exDates = append(exDates, date)
+	slices.Sort(exDates)
+
+	_, err = tx.Exec(`UPDATE event_series SET exdates = ? WHERE id = ?`, strings.Join(exDates, ","), seriesId)
+	return err
+}
@@ -0,0 +1,322 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/rrule"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+// Lists the occurrences of the series the event belongs to, in order.
+func seriesEvents(t *testing.T, s event.Service, id string) []event.Event {
+	t.Helper()
+	e, err := s.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := s.List(event.ListFilter{UserId: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []event.Event
+	for _, o := range l.Events {
+		if o.SeriesId == e.SeriesId {
+			o, err := s.Get(o.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, o)
+		}
+	}
+	return events
+}
+
+func TestCreateSeries(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the clocks go back on the last Sunday of October
+	start := time.Date(2030, 10, 14, 19, 0, 0, 0, amsterdam)
+
+	t.Run("Ok", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+
+		id := MustCreate(t, db, event.CreateParams{
+			Name:            "Open studio",
+			Capacity:        8,
+			Start:           start.UTC(),
+			StudioMonitorId: -1,
+			RRule:           "FREQ=WEEKLY;COUNT=4",
+			ExDates:         []time.Time{time.Date(2030, 10, 21, 0, 0, 0, 0, time.UTC)},
+			Timezone:        "Europe/Amsterdam",
+		})
+
+		events := seriesEvents(t, eventService, id)
+		if assert.Len(t, events, 3) {
+			assert.Equal(t, id, events[0].Id)
+			for i, day := range []int{14, 28, 4} {
+				local := events[i].Start.In(amsterdam)
+				assert.Equal(t, day, local.Day())
+				assert.Equal(t, 19, local.Hour(), "keeps the wall clock time across daylight saving time")
+				assert.Equal(t, 8, events[i].Capacity)
+				assert.True(t, events[i].OriginalStart.Valid)
+				assert.True(t, events[i].OriginalStart.Time.Equal(events[i].Start))
+			}
+		}
+
+		series, err := eventService.GetSeries(events[0].SeriesId.String)
+		assert.NoError(t, err)
+		assert.Equal(t, "FREQ=WEEKLY;COUNT=4", series.RRule)
+		assert.Equal(t, "Europe/Amsterdam", series.Timezone)
+		assert.Equal(t, []string{"2030-10-21"}, series.ExDateList())
+
+		ed, err := eventService.GetDetailed(id, -1)
+		assert.NoError(t, err)
+		if assert.NotNil(t, ed.Series) {
+			assert.Equal(t, "Every week, 4 times", ed.Series.Describe())
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+
+		_, err := eventService.Create(event.CreateParams{Start: start, RRule: "FREQ=WEEKLY"})
+		assert.ErrorIs(t, err, rrule.ErrNoEnd)
+
+		_, err = eventService.Create(event.CreateParams{Start: start, RRule: "FREQ=HOURLY;COUNT=2"})
+		assert.ErrorIs(t, err, rrule.ErrUnsupported)
+
+		_, err = eventService.Create(event.CreateParams{Start: start, RRule: "FREQ=DAILY;COUNT=1000"})
+		assert.ErrorIs(t, err, rrule.ErrTooMany)
+
+		_, err = eventService.Create(event.CreateParams{Start: start, RRule: "FREQ=DAILY;COUNT=2", Timezone: "Mars/Olympus"})
+		assert.ErrorIs(t, err, event.ErrInvalidTimezone)
+
+		_, err = eventService.Create(event.CreateParams{
+			Start:    start,
+			RRule:    "FREQ=DAILY;COUNT=1",
+			ExDates:  []time.Time{start},
+			Timezone: "Europe/Amsterdam",
+		})
+		assert.ErrorIs(t, err, event.ErrNoOccurrences)
+
+		l, err := eventService.List(event.ListFilter{UserId: -1})
+		assert.NoError(t, err)
+		assert.Empty(t, l.Events, "nothing is created when the series is rejected")
+	})
+}
+
+func TestUpdateSeries(t *testing.T) {
+	start := time.Now().UTC().Truncate(time.Hour).Add(day)
+	create := func(t *testing.T, db *db.DB) []event.Event {
+		id := MustCreate(t, db, event.CreateParams{
+			Name:            "Class",
+			Capacity:        4,
+			Start:           start,
+			StudioMonitorId: -1,
+			RRule:           "FREQ=DAILY;COUNT=4",
+		})
+		return seriesEvents(t, event.NewService(db), id)
+	}
+
+	t.Run("This", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		events := create(t, db)
+
+		err := eventService.Update(event.UpdateParams{
+			User:            admin,
+			Id:              events[1].Id,
+			Name:            "Moved class",
+			Capacity:        6,
+			Start:           events[1].Start.Add(2 * time.Hour),
+			StudioMonitorId: -1,
+			Scope:           event.ScopeThis,
+		})
+		assert.NoError(t, err)
+
+		updated := seriesEvents(t, eventService, events[0].Id)
+		assert.Equal(t, "Class", updated[0].Name)
+		assert.Equal(t, "Moved class", updated[1].Name)
+		assert.Equal(t, 6, updated[1].Capacity)
+		assert.True(t, updated[1].Start.Equal(events[1].Start.Add(2*time.Hour)))
+		assert.True(t, updated[1].OriginalStart.Time.Equal(events[1].Start), "keeps the start the series gave it")
+		assert.Equal(t, "Class", updated[2].Name)
+	})
+
+	t.Run("Following", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		events := create(t, db)
+
+		err := eventService.Update(event.UpdateParams{
+			User:            admin,
+			Id:              events[2].Id,
+			Name:            "Evening class",
+			Capacity:        2,
+			Start:           events[2].Start.Add(day + 3*time.Hour),
+			StudioMonitorId: -1,
+			Scope:           event.ScopeFollowing,
+		})
+		assert.NoError(t, err)
+
+		updated := seriesEvents(t, eventService, events[0].Id)
+		for i, e := range updated {
+			if i < 2 {
+				assert.Equal(t, "Class", e.Name)
+				assert.True(t, e.Start.Equal(events[i].Start))
+				continue
+			}
+			assert.Equal(t, "Evening class", e.Name)
+			assert.Equal(t, 2, e.Capacity)
+			assert.True(t, e.Start.Equal(events[i].Start.Add(day+3*time.Hour)), "moves by the same days and to the same time")
+		}
+	})
+
+	t.Run("AllManagesWaitlists", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		userService := user.NewService(db)
+		events := create(t, db)
+
+		u1, err := userService.Create(user.CreateParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		u2, err := userService.Create(user.CreateParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
+			MustHandleResponse(t, db, event.HandleResponseParams{UserId: u1.Id, Id: e.Id, AttendeeCount: 1})
+		}
+		MustHandleResponse(t, db, event.HandleResponseParams{UserId: u2.Id, Id: events[3].Id, AttendeeCount: 1})
+
+		err = eventService.Update(event.UpdateParams{
+			User:            admin,
+			Id:              events[1].Id,
+			Name:            "Class",
+			Capacity:        1,
+			Start:           events[1].Start,
+			StudioMonitorId: -1,
+			Scope:           event.ScopeAll,
+		})
+		assert.NoError(t, err)
+
+		for _, e := range seriesEvents(t, eventService, events[0].Id) {
+			assert.Equal(t, 1, e.Capacity)
+		}
+
+		r, err := eventService.ListResponses(events[3].Id)
+		assert.NoError(t, err)
+		if assert.Len(t, r, 2) {
+			assert.False(t, r[0].OnWaitlist)
+			assert.True(t, r[1].OnWaitlist)
+		}
+	})
+
+	t.Run("InvalidScope", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		events := create(t, db)
+
+		err := event.NewService(db).Update(event.UpdateParams{User: admin, Id: events[0].Id, Scope: "some"})
+		assert.ErrorIs(t, err, event.ErrInvalidScope)
+	})
+
+	t.Run("CannotManage", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		events := create(t, db)
+
+		err := event.NewService(db).Update(event.UpdateParams{User: user.SessionUser{Id: 1}, Id: events[0].Id, Name: "Mine now", Scope: event.ScopeAll})
+		assert.ErrorIs(t, err, event.ErrCannotManage)
+	})
+}
+
+func TestDeleteOccurrences(t *testing.T) {
+	start := time.Now().UTC().Truncate(time.Hour).Add(day)
+	create := func(t *testing.T, db *db.DB) []event.Event {
+		id := MustCreate(t, db, event.CreateParams{
+			Name:            "Class",
+			Start:           start,
+			StudioMonitorId: -1,
+			RRule:           "FREQ=DAILY;COUNT=4",
+		})
+		return seriesEvents(t, event.NewService(db), id)
+	}
+
+	t.Run("This", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		events := create(t, db)
+
+		err := eventService.DeleteOccurrences(events[1].Id, event.ScopeThis, admin)
+		assert.NoError(t, err)
+
+		left := seriesEvents(t, eventService, events[0].Id)
+		assert.Len(t, left, 3)
+
+		series, err := eventService.GetSeries(events[0].SeriesId.String)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{events[1].Start.Format(time.DateOnly)}, series.ExDateList())
+	})
+
+	t.Run("Following", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		events := create(t, db)
+
+		err := eventService.DeleteOccurrences(events[1].Id, event.ScopeFollowing, admin)
+		assert.NoError(t, err)
+
+		left := seriesEvents(t, eventService, events[0].Id)
+		if assert.Len(t, left, 1) {
+			assert.Equal(t, events[0].Id, left[0].Id)
+		}
+	})
+
+	t.Run("SingleEvent", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		id := MustCreate(t, db, event.CreateParams{Start: start, StudioMonitorId: -1})
+
+		err := eventService.DeleteOccurrences(id, event.ScopeAll, admin)
+		assert.NoError(t, err)
+
+		_, err = eventService.Get(id)
+		assert.Error(t, err)
+	})
+
+	t.Run("CannotManage", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		id := MustCreate(t, db, event.CreateParams{Start: start, StudioMonitorId: -1})
+
+		err := eventService.DeleteOccurrences(id, event.ScopeAll, user.SessionUser{Id: 1})
+		assert.ErrorIs(t, err, event.ErrCannotManage)
+		err = eventService.Delete(id, user.SessionUser{Id: 1})
+		assert.ErrorIs(t, err, event.ErrCannotManage)
+
+		_, err = eventService.Get(id)
+		assert.NoError(t, err)
+	})
+}
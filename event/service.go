@@ -3,25 +3,33 @@ package event
 import (
 	"database/sql"
 	"errors"
-	"fmt"
 	"strings"
 	"time"
 
+	"github.com/Chaldron/clay-play/clock"
 	"github.com/Chaldron/clay-play/db"
 	"github.com/Chaldron/clay-play/logger"
+	"github.com/Chaldron/clay-play/user"
 	"github.com/jmoiron/sqlx"
 	gonanoid "github.com/matoous/go-nanoid/v2"
 )
 
 type service struct {
-	db  *db.DB
-	log logger.Logger
+	db          *db.DB
+	log         logger.Logger
+	clock       clock.Clock
+	subscribers []func(WaitlistChanged)
+
+	strikes           StrikePolicy
+	strikeSubscribers []func(StrikeChanged)
 }
 
 func NewService(db *db.DB) *service {
 	return &service{
-		db:  db,
-		log: logger.NewNoopLogger(),
+		db:      db,
+		log:     logger.NewNoopLogger(),
+		clock:   clock.Real(),
+		strikes: DefaultStrikePolicy,
 	}
 }
 
@@ -29,6 +37,11 @@ func (s *service) SetLogger(l logger.Logger) {
 	s.log = l
 }
 
+// The clock decides when waitlist offers expire.
+func (s *service) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
 func (s *service) Get(id string) (Event, error) {
 	tx, err := s.db.Beginx()
 	if err != nil {
@@ -36,7 +49,7 @@ func (s *service) Get(id string) (Event, error) {
 	}
 	defer tx.Rollback()
 
-	e, err := get(tx, id)
+	e, err := get(tx, id, s.clock.Now())
 	return e, err
 }
 
@@ -47,7 +60,8 @@ func (s *service) GetDetailed(id string, userId int64) (EventDetailed, error) {
 	}
 	defer tx.Rollback()
 
-	e, err := get(tx, id)
+	now := s.clock.Now()
+	e, err := get(tx, id, now)
 	if err != nil {
 		return EventDetailed{}, err
 	}
@@ -61,11 +75,38 @@ func (s *service) GetDetailed(id string, userId int64) (EventDetailed, error) {
 	if err != nil {
 		return EventDetailed{}, err
 	}
+	for _, resp := range r {
+		if ur != nil && resp.UserId == ur.UserId {
+			ur.Guests = resp.Guests
+			ur.Attendance = resp.Attendance
+		}
+	}
 
 	ed := EventDetailed{
-		Event:        e,
-		Responses:    r,
-		UserResponse: ur,
+		Event:              e,
+		Responses:          r,
+		UserResponse:       ur,
+		RegistrationErr:    e.CheckRegistration(now),
+		CancellationIsLate: e.IsLateCancellation(now),
+		CheckInErr:         e.CheckCheckIn(now),
+	}
+
+	ed.Restriction, err = getActiveRestriction(tx, userId, now)
+	if err != nil {
+		return EventDetailed{}, err
+	}
+
+	if e.SeriesId.Valid {
+		series, err := getSeries(tx, e.SeriesId.String)
+		if err != nil {
+			return EventDetailed{}, err
+		}
+		ed.Series = &series
+
+		ed.Enrollment, err = getSeriesEnrollment(tx, series.Id, userId)
+		if err != nil {
+			return EventDetailed{}, err
+		}
 	}
 
 	return ed, nil
@@ -98,7 +139,7 @@ func (s *service) List(f ListFilter) (EventList, error) {
 	}
 	defer tx.Rollback()
 
-	el, err := list(tx, f)
+	el, err := list(tx, f, s.clock.Now())
 	return el, err
 }
 
@@ -110,17 +151,47 @@ type CreateParams struct {
 	CreatorId       int64
 	StudioMonitorId int64
 	Description     string
+	OfferHours      int // 0 moves waitlisted users up right away, otherwise they get this long to accept a free spot
+	MaxPartySize    int // most attendees a response can bring, the user included, DefaultMaxPartySize if 0
+
+	// Zero times leave the window open on that side, or cancellations never late.
+	RegistrationOpensAt  time.Time
+	RegistrationClosesAt time.Time
+	CancellationCutoff   time.Time
+
+	// When set, the event repeats by this RFC 5545 rule and every occurrence is created as its own event.
+	RRule    string
+	ExDates  []time.Time // dates skipped by the rule, only the date part matters
+	Timezone string      // IANA name the rule is evaluated in, UTC if empty
 }
 
 func (s *service) Create(p CreateParams) (string, error) {
 	s.log.Printf("group Create params %+v", p)
+	if p.OfferHours < 0 {
+		return "", ErrInvalidOfferHours
+	}
+	if p.MaxPartySize == 0 {
+		p.MaxPartySize = DefaultMaxPartySize
+	}
+	if err := checkMaxPartySize(p.MaxPartySize); err != nil {
+		return "", err
+	}
+	if err := checkWindow(p.RegistrationOpensAt, p.RegistrationClosesAt); err != nil {
+		return "", err
+	}
+
 	tx, err := s.db.Beginx()
 	if err != nil {
 		return "", err
 	}
 	defer tx.Rollback()
 
-	id, err := create(tx, p)
+	var id string
+	if p.RRule != "" {
+		id, err = createSeries(tx, p, s.clock.Now())
+	} else {
+		id, err = create(tx, p, "", s.clock.Now())
+	}
 	if err != nil {
 		return "", err
 	}
@@ -141,61 +212,196 @@ type UpdateParams struct {
 	Start           time.Time
 	StudioMonitorId int64
 	Description     string
+	OfferHours      int
+	MaxPartySize    int   // DefaultMaxPartySize if 0
+	Scope           Scope // which occurrences of a series to change, only this one if empty
+
+	RegistrationOpensAt  time.Time
+	RegistrationClosesAt time.Time
+	CancellationCutoff   time.Time
+
+	User user.SessionUser // who makes the change, has to be able to manage every occurrence it touches
 }
 
 func (s *service) Update(p UpdateParams) error {
 	s.log.Printf("group Update params %+v", p)
+	if p.OfferHours < 0 {
+		return ErrInvalidOfferHours
+	}
+	if p.MaxPartySize == 0 {
+		p.MaxPartySize = DefaultMaxPartySize
+	}
+	if err := checkMaxPartySize(p.MaxPartySize); err != nil {
+		return err
+	}
+	if err := checkWindow(p.RegistrationOpensAt, p.RegistrationClosesAt); err != nil {
+		return err
+	}
+
 	tx, err := s.db.Beginx()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	err = update(tx, p)
+	now := s.clock.Now()
+	e, err := get(tx, p.Id, now)
 	if err != nil {
 		return err
 	}
 
-	_, err = manageWaitlist(tx, p.Id)
+	targets, loc, err := scopeTargets(tx, e, p.Scope, now)
 	if err != nil {
 		return err
 	}
+	for _, t := range targets {
+		if !canManage(t, p.User) {
+			return ErrCannotManage
+		}
+	}
 
-	return tx.Commit()
+	flipped := []EventResponse{}
+	for _, t := range targets {
+		tp := p
+		tp.Id = t.Id
+		tp.Start = shiftStart(t.Start, e.Start, p.Start, loc)
+
+		// studio monitors can edit their events but not hand them to someone else
+		if !p.User.Can(user.PermManageEvents) {
+			tp.StudioMonitorId = -1
+			if t.StudioMonitorId.Valid {
+				tp.StudioMonitorId = t.StudioMonitorId.Int64
+			}
+		}
+
+		// every occurrence keeps the same windows relative to its start
+		d := tp.Start.Sub(p.Start)
+		tp.RegistrationOpensAt = shiftWindow(p.RegistrationOpensAt, d)
+		tp.RegistrationClosesAt = shiftWindow(p.RegistrationClosesAt, d)
+		tp.CancellationCutoff = shiftWindow(p.CancellationCutoff, d)
+
+		err = update(tx, tp, now)
+		if err != nil {
+			return err
+		}
+
+		f, err := manageWaitlist(tx, t.Id, now)
+		if err != nil {
+			return err
+		}
+		flipped = append(flipped, f...)
+	}
+
+	// a capacity change moves people on or off the waitlist without them doing anything, so everyone is told
+	changes, err := waitlistChanges(tx, flipped, -1, now)
+	if err != nil {
+		return err
+	}
+
+	if e.SeriesId.Valid {
+		more, err := manageSeriesWaitlist(tx, e.SeriesId.String, now)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, more...)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publish(changes)
+	return nil
 }
 
-func (s *service) Delete(id string) error {
+// Deletes the event, if u can manage it.
+func (s *service) Delete(id string, u user.SessionUser) error {
 	s.log.Printf("group Delete id %s", id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now()
+	e, err := get(tx, id, now)
+	if err != nil {
+		return err
+	}
+	if !canManage(e, u) {
+		return ErrCannotManage
+	}
+
 	stmt := `
         UPDATE event
-        SET is_deleted = TRUE
+        SET is_deleted = TRUE, sequence = sequence + 1, updated_at = ?
         WHERE id = ?
     `
-	args := []any{id}
+	args := []any{now, id}
 
-	_, err := s.db.Exec(stmt, args...)
+	_, err = tx.Exec(stmt, args...)
 	if err != nil {
 		return err
 	}
 
+	return tx.Commit()
+}
+
+// Event managers can manage every event, studio monitors only the events they are assigned to.
+func (s *service) UserCanManage(id string, u user.SessionUser) (bool, error) {
+	if u.Can(user.PermManageEvents) {
+		return true, nil
+	}
+	if !u.Can(user.PermManageMonitoredEvents) {
+		return false, nil
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	e, err := get(tx, id, s.clock.Now())
+	if err != nil {
+		return false, err
+	}
+
+	return canManage(e, u), nil
+}
+
+func (s *service) UserCanManageError(id string, u user.SessionUser) error {
+	ok, err := s.UserCanManage(id, u)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCannotManage
+	}
+
 	return nil
 }
 
+func canManage(e Event, u user.SessionUser) bool {
+	if u.Can(user.PermManageEvents) {
+		return true
+	}
+	return u.Can(user.PermManageMonitoredEvents) && e.StudioMonitorId.Valid && e.StudioMonitorId.Int64 == u.Id
+}
+
 type HandleResponseParams struct {
 	UserId        int64
 	Id            string
 	AttendeeCount int
+	Override      bool // for admins acting on the user's behalf, skips the registration window and restrictions and doesn't record late cancellations
 }
 
 func (s *service) HandleResponse(p HandleResponseParams) error {
 	s.log.Printf("group HandleResponse params %+v", p)
 
-	if p.AttendeeCount < 0 {
-		return errors.New("cannot have less than 0 attendees")
-	}
-
-	if p.AttendeeCount > MaxAttendeeCount {
-		return fmt.Errorf("maximum of %d plus one(s) allowed", MaxAttendeeCount-1)
+	if err := checkAttendeeCount(p.AttendeeCount); err != nil {
+		return err
 	}
 
 	tx, err := s.db.Beginx()
@@ -204,13 +410,14 @@ func (s *service) HandleResponse(p HandleResponseParams) error {
 	}
 	defer tx.Rollback()
 
-	e, err := get(tx, p.Id)
+	now := s.clock.Now()
+	e, err := get(tx, p.Id, now)
 	if err != nil {
 		return err
 	}
 
 	if e.IsPast {
-		return errors.New("cannot respond to past events")
+		return ErrCannotRespondToPast
 	}
 
 	existingResponse, err := getUserResponse(tx, p.Id, p.UserId)
@@ -223,6 +430,37 @@ func (s *service) HandleResponse(p HandleResponseParams) error {
 		attendeeCountDelta -= existingResponse.AttendeeCount
 	}
 
+	if attendeeCountDelta > 0 {
+		if err := e.checkPartySize(p.AttendeeCount); err != nil {
+			return err
+		}
+	}
+
+	waitlistOnly := false
+	strikes := []Strike{}
+	if !p.Override {
+		if attendeeCountDelta > 0 {
+			if err := e.CheckRegistration(now); err != nil {
+				return err
+			}
+			if err := checkNotBlocked(tx, p.UserId, now); err != nil {
+				return err
+			}
+			waitlistOnly, err = isWaitlistOnly(tx, p.UserId, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		strike, err := recordLateCancellation(tx, e, existingResponse, -attendeeCountDelta, now)
+		if err != nil {
+			return err
+		}
+		if strike != nil {
+			strikes = append(strikes, *strike)
+		}
+	}
+
 	if p.AttendeeCount == 0 { // just delete the response, I don't think it really matters to keep it in DB
 		err := deleteResponse(tx, p.Id, p.UserId)
 		if err != nil {
@@ -234,13 +472,39 @@ func (s *service) HandleResponse(p HandleResponseParams) error {
 			EventId:       p.Id,
 			UserId:        p.UserId,
 			AttendeeCount: p.AttendeeCount,
+			OnWaitlist:    waitlistOnly, // held there by manageWaitlist until the restriction ends
+			Now:           now,
 		})
 		if err != nil {
 			return err
 		}
+
+		// a smaller party can't keep all its named guests
+		err = trimGuests(tx, p.Id, p.UserId, p.AttendeeCount-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	flipped, err := manageWaitlist(tx, p.Id, now)
+	if err != nil {
+		return err
 	}
 
-	_, err = manageWaitlist(tx, p.Id)
+	changes, err := waitlistChanges(tx, flipped, p.UserId, now)
+	if err != nil {
+		return err
+	}
+
+	if e.SeriesId.Valid {
+		more, err := manageSeriesWaitlist(tx, e.SeriesId.String, now)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, more...)
+	}
+
+	strikeChanges, err := s.applyStrikes(tx, strikes, p.UserId, now)
 	if err != nil {
 		return err
 	}
@@ -250,31 +514,177 @@ func (s *service) HandleResponse(p HandleResponseParams) error {
 		return err
 	}
 
+	s.publish(changes)
+	s.publishStrikes(strikeChanges)
 	return nil
 }
 
-func get(tx *sqlx.Tx, id string) (Event, error) {
+type RemoveUserParams struct {
+	UserId    int64
+	Anonymize bool // also removes their personal data, see user.Service.Anonymize
+}
+
+// Deactivates the user, takes them out of their groups and removes their responses to events that haven't started yet,
+// moving people up from the waitlists, all in one transaction. Responses to past events are kept as history.
+func (s *service) RemoveUser(p RemoveUserParams) error {
+	s.log.Printf("event RemoveUser %+v", p)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = user.DeleteTx(tx, p.UserId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_group_member WHERE user_id = ?`, p.UserId)
+	if err != nil {
+		return err
+	}
+
+	changes, err := removeUserResponses(tx, p.UserId, s.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	if p.Anonymize {
+		_, err = user.AnonymizeTx(tx, p.UserId)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publish(changes)
+	return nil
+}
+
+func removeUserResponses(tx *sqlx.Tx, userId int64, now time.Time) ([]WaitlistChanged, error) {
+	stmt := `
+        DELETE FROM event_response
+        WHERE user_id = ?
+            AND event_id IN (SELECT id FROM event WHERE datetime(?) <= datetime(start))
+        RETURNING event_id
+    `
+	args := []any{userId, now}
+
+	var eventIds []string
+	err := tx.Select(&eventIds, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []WaitlistChanged{}
+	for _, id := range eventIds {
+		err = trimGuests(tx, id, userId, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		flipped, err := manageWaitlist(tx, id, now)
+		if errors.Is(err, sql.ErrNoRows) { // deleted events have no waitlist to manage
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		c, err := waitlistChanges(tx, flipped, userId, now)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c...)
+	}
+
+	var seriesIds []string
+	err = tx.Select(&seriesIds, `DELETE FROM series_enrollment WHERE user_id = ? RETURNING series_id`, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range seriesIds {
+		c, err := manageSeriesWaitlist(tx, id, now)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c...)
+	}
+
+	return changes, nil
+}
+
+// Lists every response of the user, including those to past and deleted events, newest event first.
+func (s *service) ListUserResponses(userId int64) ([]UserResponse, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT
+            er.event_id, er.user_id, er.created_at, er.updated_at, er.attendee_count, er.on_waitlist
+            , e.name AS event_name, e.start AS event_start
+        FROM event_response AS er
+        INNER JOIN event AS e ON er.event_id = e.id
+        WHERE er.user_id = ?
+        ORDER BY e.start DESC
+    `
+	args := []any{userId}
+
+	responses := []UserResponse{}
+	err = tx.Select(&responses, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	guests, err := listUserGuests(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+	for i := range responses {
+		responses[i].Guests = guests[responses[i].EventId]
+	}
+
+	return responses, nil
+}
+
+func get(tx *sqlx.Tx, id string, now time.Time) (Event, error) {
 	stmt := `
         SELECT
             e.id, e.name, e.capacity, e.start, e.created_at, e.creator_id, e.studio_monitor_id, e.description
-            , u.full_name AS creator_full_name
-            , sm.full_name AS studio_monitor_full_name
+            , e.series_id, e.original_start, e.sequence, e.updated_at, e.offer_hours, e.max_party_size
+            , e.registration_opens_at, e.registration_closes_at, e.cancellation_cutoff
+            , ` + user.DisplayNameSQL("u") + ` AS creator_full_name
+            , CASE
+                WHEN e.studio_monitor_id IS NULL THEN NULL
+                ELSE ` + user.DisplayNameSQL("sm") + `
+            END AS studio_monitor_full_name
             , COALESCE((
                 SELECT SUM(attendee_count) FROM event_response
                 WHERE event_id = ? AND on_waitlist = FALSE
             ), 0) AS total_attendee_count
+            , COALESCE((
+                SELECT SUM(attendee_count) FROM event_response
+                WHERE event_id = ? AND offer_expires_at IS NOT NULL
+            ), 0) AS offered_attendee_count
             , e.group_id, ug.name AS group_name
             , CASE
-                WHEN datetime() > datetime(start) THEN TRUE
+                WHEN datetime(?) > datetime(start) THEN TRUE
                 ELSE FALSE
             END AS is_past
         FROM event AS e
         LEFT JOIN user_group AS ug ON e.group_id = ug.id
-        INNER JOIN users AS u ON e.creator_id = u.id
+        LEFT JOIN users AS u ON e.creator_id = u.id
         LEFT JOIN users AS sm ON e.studio_monitor_id = sm.id
         WHERE e.id = ? AND e.is_deleted = FALSE 
     `
-	args := []any{id, id}
+	args := []any{id, id, now, id}
 
 	var event Event
 	err := tx.Get(&event, stmt, args...)
@@ -287,11 +697,15 @@ func get(tx *sqlx.Tx, id string) (Event, error) {
 
 func listResponses(tx *sqlx.Tx, eventId string) ([]EventResponse, error) {
 	stmt := `
-        SELECT er.event_id, er.user_id, er.attendee_count, u.full_name AS user_full_name, er.created_at, er.on_waitlist
+        SELECT
+            er.event_id, er.user_id, er.attendee_count
+            , ` + user.DisplayNameSQL("u") + ` AS user_full_name
+            , ` + user.PictureSQL("u") + ` AS user_picture
+            , er.created_at, er.on_waitlist, er.offer_expires_at
         FROM event_response AS er
-        INNER JOIN users AS u ON er.user_id = u.id
+        LEFT JOIN users AS u ON er.user_id = u.id
         WHERE er.event_id = ?
-        ORDER BY er.created_at
+        ORDER BY er.created_at, er.rowid
     `
 	args := []any{eventId}
 
@@ -303,7 +717,7 @@ func listResponses(tx *sqlx.Tx, eventId string) ([]EventResponse, error) {
 	var responses []EventResponse
 	for rows.Next() {
 		var i EventResponse
-		if err := rows.Scan(&i.EventId, &i.UserId, &i.AttendeeCount, &i.UserFullName, &i.CreatedAt, &i.OnWaitlist); err != nil {
+		if err := rows.Scan(&i.EventId, &i.UserId, &i.AttendeeCount, &i.UserFullName, &i.UserPicture, &i.CreatedAt, &i.OnWaitlist, &i.OfferExpiresAt); err != nil {
 			return []EventResponse{}, err
 		}
 		responses = append(responses, i)
@@ -312,12 +726,29 @@ func listResponses(tx *sqlx.Tx, eventId string) ([]EventResponse, error) {
 		return []EventResponse{}, err
 	}
 
+	guests, err := listEventGuests(tx, eventId)
+	if err != nil {
+		return []EventResponse{}, err
+	}
+	attendance, err := listEventAttendance(tx, eventId)
+	if err != nil {
+		return []EventResponse{}, err
+	}
+	for i := range responses {
+		r := &responses[i]
+		r.Guests = guests[r.UserId]
+		r.Attendance = attendance[r.UserId][0]
+		for j := range r.Guests {
+			r.Guests[j].Attendance = attendance[r.UserId][r.Guests[j].Id]
+		}
+	}
+
 	return responses, nil
 }
 
 func getUserResponse(tx *sqlx.Tx, eventId string, userId int64) (*EventResponse, error) {
 	stmt := `
-        SELECT event_id, attendee_count, on_waitlist
+        SELECT event_id, user_id, attendee_count, on_waitlist, offer_expires_at
         FROM event_response
         WHERE event_id = ? AND user_id = ?
     `
@@ -334,15 +765,17 @@ func getUserResponse(tx *sqlx.Tx, eventId string, userId int64) (*EventResponse,
 	return &response, nil
 }
 
-func list(tx *sqlx.Tx, f ListFilter) (EventList, error) {
+func list(tx *sqlx.Tx, f ListFilter, now time.Time) (EventList, error) {
 	where, wargs := []string{}, []any{}
 
 	where = append(where, "is_deleted = FALSE")
 	if f.Upcoming {
-		where = append(where, "datetime() <= datetime(start)")
+		where = append(where, "datetime(?) <= datetime(start)")
+		wargs = append(wargs, now)
 	}
 	if f.Past {
-		where = append(where, "datetime() > datetime(start)")
+		where = append(where, "datetime(?) > datetime(start)")
+		wargs = append(wargs, now)
 	}
 
 	// move the logic for determining if user can access event based off group from group service over to here
@@ -360,11 +793,15 @@ func list(tx *sqlx.Tx, f ListFilter) (EventList, error) {
         SELECT 
             e.id, e.name, e.capacity, e.start	, e.created_at, e.creator_id
 		    , COALESCE (ec.total_attendee_count, 0) AS total_attendee_count
-            , e.group_id
+		    , COALESCE (ec.offered_attendee_count, 0) AS offered_attendee_count
+            , e.group_id, e.series_id
         FROM event AS e
         LEFT JOIN (
-            SELECT event_id, SUM(attendee_count) AS total_attendee_count FROM event_response
-            WHERE on_waitlist = FALSE
+            SELECT
+                event_id
+                , SUM(CASE WHEN on_waitlist = FALSE THEN attendee_count ELSE 0 END) AS total_attendee_count
+                , SUM(CASE WHEN offer_expires_at IS NOT NULL THEN attendee_count ELSE 0 END) AS offered_attendee_count
+            FROM event_response
             GROUP BY event_id
         ) AS ec ON e.id = ec.event_id
         WHERE ` + strings.Join(where, " AND ") + `
@@ -386,15 +823,19 @@ func list(tx *sqlx.Tx, f ListFilter) (EventList, error) {
 	}, nil
 }
 
-func create(tx *sqlx.Tx, p CreateParams) (string, error) {
+// Creates a single event, which is an occurrence of the series if seriesId is set.
+func create(tx *sqlx.Tx, p CreateParams, seriesId string, now time.Time) (string, error) {
 	newId, err := gonanoid.New()
 	if err != nil {
 		return "", err
 	}
 
 	stmt := `
-        INSERT INTO event (id, name, group_id, capacity, start, created_at, creator_id, studio_monitor_id, description)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        INSERT INTO event (
+            id, name, group_id, capacity, start, created_at, creator_id, studio_monitor_id, description, series_id, original_start, offer_hours
+            , registration_opens_at, registration_closes_at, cancellation_cutoff, max_party_size
+        )
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `
 	args := []any{
 		newId,
@@ -405,7 +846,7 @@ func create(tx *sqlx.Tx, p CreateParams) (string, error) {
 		},
 		p.Capacity,
 		p.Start,
-		time.Now().UTC(),
+		now,
 		p.CreatorId,
 		sql.NullInt64{
 			Int64: p.StudioMonitorId,
@@ -415,6 +856,19 @@ func create(tx *sqlx.Tx, p CreateParams) (string, error) {
 			String: p.Description,
 			Valid:  p.Description != "",
 		},
+		sql.NullString{
+			String: seriesId,
+			Valid:  seriesId != "",
+		},
+		sql.NullTime{
+			Time:  p.Start,
+			Valid: seriesId != "",
+		},
+		p.OfferHours,
+		nullTime(p.RegistrationOpensAt),
+		nullTime(p.RegistrationClosesAt),
+		nullTime(p.CancellationCutoff),
+		p.MaxPartySize,
 	}
 
 	_, err = tx.Exec(stmt, args...)
@@ -425,10 +879,12 @@ func create(tx *sqlx.Tx, p CreateParams) (string, error) {
 	return newId, nil
 }
 
-func update(tx *sqlx.Tx, p UpdateParams) error {
+func update(tx *sqlx.Tx, p UpdateParams, now time.Time) error {
 	stmt := `
 		        UPDATE event
-		        SET name = ?, capacity = ?, start = ?, studio_monitor_id = ?, description = ?
+		        SET name = ?, capacity = ?, start = ?, studio_monitor_id = ?, description = ?, offer_hours = ?
+		            , registration_opens_at = ?, registration_closes_at = ?, cancellation_cutoff = ?, max_party_size = ?
+		            , sequence = sequence + 1, updated_at = ?
 		        WHERE id = ?
 		    `
 	args := []any{
@@ -443,6 +899,12 @@ func update(tx *sqlx.Tx, p UpdateParams) error {
 			String: p.Description,
 			Valid:  p.Description != "",
 		},
+		p.OfferHours,
+		nullTime(p.RegistrationOpensAt),
+		nullTime(p.RegistrationClosesAt),
+		nullTime(p.CancellationCutoff),
+		p.MaxPartySize,
+		now,
 		p.Id,
 	}
 
@@ -462,7 +924,7 @@ func deleteResponse(tx *sqlx.Tx, eventId string, userId int64) error {
 		return err
 	}
 
-	return nil
+	return trimGuests(tx, eventId, userId, 0)
 }
 
 type updateResponseParams struct {
@@ -470,6 +932,7 @@ type updateResponseParams struct {
 	UserId        int64
 	AttendeeCount int
 	OnWaitlist    bool
+	Now           time.Time
 }
 
 func updateResponse(tx *sqlx.Tx, p updateResponseParams) error {
@@ -481,12 +944,11 @@ func updateResponse(tx *sqlx.Tx, p updateResponseParams) error {
             attendee_count = excluded.attendee_count
     `
 
-	now := time.Now().UTC()
 	args := []any{
 		p.EventId,
 		p.UserId,
-		now,
-		now,
+		p.Now,
+		p.Now,
 		p.AttendeeCount,
 		p.OnWaitlist,
 	}
@@ -500,38 +962,93 @@ func updateResponse(tx *sqlx.Tx, p updateResponseParams) error {
 
 // Manages the waitlist status of all attendees in an event.
 // Based on the event's capacity, will convert all regular attendees to waitlist and all waitlist attendees to regular as necessary.
+// If the event has OfferHours set, waitlist attendees are offered the spot until now plus OfferHours instead, and keep it
+// held for them until they accept or the offer expires, see ExpireOffers.
+//
+// Responses made at the same time keep the order they were made in.
 //
-// Returns list of responses that had their waitlist status updated.
-func manageWaitlist(tx *sqlx.Tx, eventId string) ([]EventResponse, error) {
-	e, err := get(tx, eventId)
+// Returns list of responses that had their waitlist status updated, along with those that were offered a spot.
+func manageWaitlist(tx *sqlx.Tx, eventId string, now time.Time) ([]EventResponse, error) {
+	e, err := get(tx, eventId, now)
 	if err != nil {
 		return []EventResponse{}, err
 	}
 
 	stmt := `
-			UPDATE event_response AS er
-			SET on_waitlist = r.on_waitlist
-			FROM (
-				SELECT
-					event_id
-					,user_id
-					,CASE
-						WHEN SUM(attendee_count) OVER (ORDER BY created_at) <= ? THEN FALSE
-						ELSE TRUE
-					END AS on_waitlist
-				FROM event_response
-				WHERE event_id = ?
-			) AS r
-			WHERE er.event_id = r.event_id
-				AND er.user_id = r.user_id
-				AND er.on_waitlist <> r.on_waitlist
-			RETURNING event_id, user_id, on_waitlist
-		`
-	var er []EventResponse
-	err = tx.Select(&er, stmt, e.Capacity, e.Id)
+        SELECT
+            event_id, user_id, on_waitlist, offer_expires_at
+            , held OR SUM(CASE WHEN held THEN 0 ELSE attendee_count END) OVER (ORDER BY created_at, seq) > ? AS should_waitlist
+        FROM (
+            SELECT
+                er.*
+                , er.rowid AS seq
+                , er.on_waitlist AND EXISTS (
+                    SELECT 1 FROM restriction AS r
+                    WHERE r.user_id = er.user_id AND r.mode = 'waitlist' AND ` + activeRestrictionSQL("r") + `
+                ) AS held
+            FROM event_response AS er
+            WHERE er.event_id = ?
+        )
+    `
+	args := []any{e.Capacity, now, now, e.Id}
+
+	// users restricted to the waitlist are held on it, taking up none of the capacity
+	var responses []struct {
+		EventResponse
+		ShouldWaitlist bool `db:"should_waitlist"`
+	}
+	err = tx.Select(&responses, stmt, args...)
 	if err != nil {
 		return []EventResponse{}, err
 	}
 
-	return er, nil
+	// offers can't outlast the event
+	expires := now.Add(time.Duration(e.OfferHours) * time.Hour)
+	if expires.After(e.Start) {
+		expires = e.Start
+	}
+
+	changed := []EventResponse{}
+	for _, r := range responses {
+		report := true
+		switch {
+		case r.ShouldWaitlist && !r.OnWaitlist:
+			r.OnWaitlist = true
+		case r.ShouldWaitlist && r.OfferExpiresAt.Valid: // someone ahead of them needs the spot after all
+			r.OfferExpiresAt = sql.NullTime{}
+			report = false
+		case !r.ShouldWaitlist && r.OnWaitlist:
+			if e.OfferHours == 0 {
+				r.OnWaitlist = false
+				r.OfferExpiresAt = sql.NullTime{}
+			} else if !r.OfferExpiresAt.Valid && expires.After(now) {
+				r.OfferExpiresAt = sql.NullTime{Time: expires, Valid: true}
+			} else {
+				continue // already offered, or too late to offer
+			}
+		default:
+			continue
+		}
+
+		err = setWaitlist(tx, r.EventResponse)
+		if err != nil {
+			return []EventResponse{}, err
+		}
+		if report {
+			changed = append(changed, r.EventResponse)
+		}
+	}
+
+	return changed, nil
+}
+
+func setWaitlist(tx *sqlx.Tx, r EventResponse) error {
+	stmt := `
+        UPDATE event_response SET on_waitlist = ?, offer_expires_at = ?
+        WHERE event_id = ? AND user_id = ?
+    `
+	args := []any{r.OnWaitlist, r.OfferExpiresAt, r.EventId, r.UserId}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Chaldron/clay-play/clock"
 	"github.com/Chaldron/clay-play/db"
 	"github.com/Chaldron/clay-play/event"
 	"github.com/Chaldron/clay-play/group"
@@ -15,6 +16,8 @@ import (
 
 var day = 24 * time.Hour
 
+var admin = user.SessionUser{IsAdmin: true}
+
 func TestHandleResponse(t *testing.T) {
 	t.Run("NegativeAttendeesError", func(t *testing.T) {
 		err := event.NewService(nil).HandleResponse(event.HandleResponseParams{
@@ -244,6 +247,25 @@ func TestGet(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, false, event.IsPast)
 	})
+
+	t.Run("IsPastFollowsTheClock", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		eventService := event.NewService(db)
+		c := clock.NewFake(time.Now().UTC())
+		eventService.SetClock(c)
+
+		id := MustCreate(t, db, event.CreateParams{Start: time.Now().UTC().Add(day), StudioMonitorId: -1})
+
+		c.Advance(2 * day)
+		e, err := eventService.Get(id)
+		assert.NoError(t, err)
+		assert.True(t, e.IsPast)
+
+		upcoming, err := eventService.List(event.ListFilter{UserId: -1, Upcoming: true})
+		assert.NoError(t, err)
+		assert.Empty(t, upcoming.Events)
+	})
 }
 
 func TestList(t *testing.T) {
@@ -369,6 +391,7 @@ func TestUpdate(t *testing.T) {
 			assert.Equal(t, false, responses[1].OnWaitlist)
 
 			err = eventService.Update(event.UpdateParams{
+				User:     admin,
 				Id:       eventId,
 				Capacity: 1,
 			})
@@ -427,6 +450,7 @@ func TestUpdate(t *testing.T) {
 			assert.Equal(t, false, responses[1].OnWaitlist)
 
 			err = eventService.Update(event.UpdateParams{
+				User:     admin,
 				Id:       eventId,
 				Capacity: 2,
 			})
@@ -485,6 +509,7 @@ func TestUpdate(t *testing.T) {
 			assert.Equal(t, true, responses[1].OnWaitlist)
 
 			err = eventService.Update(event.UpdateParams{
+				User:     admin,
 				Id:       eventId,
 				Capacity: 2,
 			})
@@ -504,6 +529,47 @@ func TestUpdate(t *testing.T) {
 	})
 }
 
+func TestUserCanManage(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	eventService := event.NewService(db)
+	userService := user.NewService(db)
+
+	monitor, err := userService.Create(user.CreateParams{Email: "monitor@example.com", Roles: []user.Role{user.RoleStudioMonitor}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := userService.Create(user.CreateParams{Email: "other@example.com", Roles: []user.Role{user.RoleStudioMonitor}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := userService.Create(user.CreateParams{Email: "manager@example.com", Roles: []user.Role{user.RoleEventManager}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := userService.Create(user.CreateParams{Email: "member@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := MustCreate(t, db, event.CreateParams{CreatorId: manager.Id, StudioMonitorId: monitor.Id, Start: time.Now().Add(day)})
+
+	ok, err := eventService.UserCanManage(id, monitor.ToSessionUser())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = eventService.UserCanManage(id, manager.ToSessionUser())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// studio monitors can only manage their own events
+	err = eventService.UserCanManageError(id, other.ToSessionUser())
+	assert.ErrorIs(t, err, event.ErrCannotManage)
+
+	err = eventService.UserCanManageError(id, member.ToSessionUser())
+	assert.ErrorIs(t, err, event.ErrCannotManage)
+}
+
 func MustCreate(t testing.TB, db *db.DB, p event.CreateParams) string {
 	t.Helper()
 	id, err := event.NewService(db).Create(p)
@@ -520,3 +586,67 @@ func MustHandleResponse(t testing.TB, db *db.DB, p event.HandleResponseParams) {
 		t.Fatal(err)
 	}
 }
+
+func TestRemoveUser(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	eventService := event.NewService(db)
+	userService := user.NewService(db)
+
+	u1, err := userService.Create(user.CreateParams{FullName: "One"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2, err := userService.Create(user.CreateParams{FullName: "Two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upcoming := MustCreate(t, db, event.CreateParams{CreatorId: u1.Id, Start: time.Now().Add(day), Capacity: 1})
+	past := MustCreate(t, db, event.CreateParams{CreatorId: u1.Id, Start: time.Now().Add(day), Capacity: 1})
+	for _, id := range []string{upcoming, past} {
+		MustHandleResponse(t, db, event.HandleResponseParams{UserId: u1.Id, Id: id, AttendeeCount: 1})
+		MustHandleResponse(t, db, event.HandleResponseParams{UserId: u2.Id, Id: id, AttendeeCount: 1})
+	}
+	if _, err := db.Exec(`UPDATE event SET start = ? WHERE id = ?`, time.Now().Add(-day).UTC(), past); err != nil {
+		t.Fatal(err)
+	}
+
+	groupService := group.NewService(db)
+	if _, err := groupService.CreateAndAddMember(group.CreateParams{CreatorId: u1.Id}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = eventService.RemoveUser(event.RemoveUserParams{UserId: u1.Id})
+	assert.NoError(t, err)
+
+	u1, err = userService.Get(u1.Id)
+	assert.NoError(t, err)
+	assert.True(t, u1.IsDeleted())
+	memberships, err := groupService.ListUserMemberships(u1.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, memberships)
+
+	// the waitlisted user moves up
+	responses, err := eventService.ListResponses(upcoming)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(responses))
+	assert.Equal(t, u2.Id, responses[0].UserId)
+	assert.False(t, responses[0].OnWaitlist)
+
+	// past events keep their history, without the name
+	responses, err = eventService.ListResponses(past)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, user.FormerMemberName, responses[0].UserFullName)
+	assert.Equal(t, "Two", responses[1].UserFullName)
+
+	e, err := eventService.Get(past)
+	assert.NoError(t, err)
+	assert.Equal(t, user.FormerMemberName, e.CreatorFullName)
+
+	err = eventService.RemoveUser(event.RemoveUserParams{UserId: u1.Id, Anonymize: true})
+	assert.NoError(t, err, "already deactivated users can still be erased")
+	u1, err = userService.Get(u1.Id)
+	assert.NoError(t, err)
+	assert.True(t, u1.AnonymizedAt.Valid)
+}
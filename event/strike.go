@@ -0,0 +1,630 @@
+package event
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Chaldron/clay-play/user"
+	"github.com/jmoiron/sqlx"
+)
+
+type StrikeKind string
+
+const (
+	StrikeNoShow           StrikeKind = "no_show"
+	StrikeLateCancellation StrikeKind = "late_cancellation"
+)
+
+func (k StrikeKind) String() string {
+	if k == StrikeNoShow {
+		return "no-show"
+	}
+	return "late cancellation"
+}
+
+type RestrictionMode string
+
+const (
+	RestrictionWaitlist RestrictionMode = "waitlist" // new sign-ups only go onto the waitlist, and stay there
+	RestrictionBlock    RestrictionMode = "block"    // no new sign-ups at all
+)
+
+func (m RestrictionMode) Valid() bool {
+	return m == RestrictionWaitlist || m == RestrictionBlock
+}
+
+// How many strikes within how long put a user under which restriction, and for how long.
+// A Threshold of 0 turns restrictions off, strikes are still recorded.
+type StrikePolicy struct {
+	Threshold int
+	Window    time.Duration
+	Mode      RestrictionMode
+	Duration  time.Duration
+}
+
+var DefaultStrikePolicy = StrikePolicy{
+	Threshold: 3,
+	Window:    60 * 24 * time.Hour,
+	Mode:      RestrictionWaitlist,
+	Duration:  30 * 24 * time.Hour,
+}
+
+var ErrRestricted = errors.New("your sign-ups are restricted")
+
+type RestrictedError struct {
+	Until time.Time
+}
+
+func (e *RestrictedError) Error() string {
+	return fmt.Sprintf("%s until %s after repeated no-shows or late cancellations", ErrRestricted, e.Until.Format(time.RFC3339))
+}
+
+func (e *RestrictedError) Is(target error) bool {
+	return target == ErrRestricted
+}
+
+var ErrNoStrike = errors.New("strike not found")
+
+// A no-show or late cancellation counted against a user. A user gets at most one strike of each kind per event.
+type Strike struct {
+	Id         int64         `db:"id"`
+	UserId     int64         `db:"user_id"`
+	EventId    string        `db:"event_id"`
+	Kind       StrikeKind    `db:"kind"`
+	CreatedAt  time.Time     `db:"created_at"`
+	ForgivenAt sql.NullTime  `db:"forgiven_at"`
+	ForgivenBy sql.NullInt64 `db:"forgiven_by"`
+
+	UserFullName string `db:"user_full_name"`
+	EventName    string `db:"event_name"`
+}
+
+// Whether the strike still counts towards a restriction under the policy.
+func (s Strike) IsActive(p StrikePolicy, now time.Time) bool {
+	return !s.ForgivenAt.Valid && now.Before(s.CreatedAt.Add(p.Window))
+}
+
+// Limits the sign-ups of a user between StartsAt and EndsAt, unless an admin lifted it early.
+// Restrictions that ran out have LiftedAt set to EndsAt and no LiftedBy.
+type Restriction struct {
+	Id       int64           `db:"id"`
+	UserId   int64           `db:"user_id"`
+	Mode     RestrictionMode `db:"mode"`
+	StartsAt time.Time       `db:"starts_at"`
+	EndsAt   time.Time       `db:"ends_at"`
+	LiftedAt sql.NullTime    `db:"lifted_at"`
+	LiftedBy sql.NullInt64   `db:"lifted_by"`
+
+	UserFullName string `db:"user_full_name"`
+}
+
+type StrikeChangeKind string
+
+const (
+	StrikeAdded        StrikeChangeKind = "strike added"
+	StrikeRemoved      StrikeChangeKind = "strike removed" // the attendance it was for was corrected
+	StrikeForgiven     StrikeChangeKind = "strike forgiven"
+	RestrictionStarted StrikeChangeKind = "restriction started"
+	RestrictionLifted  StrikeChangeKind = "restriction lifted" // strikes were forgiven or removed before it ran out
+	RestrictionEnded   StrikeChangeKind = "restriction ended"
+)
+
+// Published after a strike or restriction of a user changed. Strike is set for the strike changes,
+// Restriction for the restriction ones. ActorId is who caused the change, -1 for restrictions running out.
+type StrikeChanged struct {
+	Kind        StrikeChangeKind
+	UserId      int64
+	ActorId     int64
+	Strike      Strike
+	Restriction Restriction
+}
+
+func (s *service) SetStrikePolicy(p StrikePolicy) {
+	s.strikes = p
+}
+
+// Registers a function that is called with every StrikeChanged once the change is committed.
+// Subscribers run synchronously, so they should be quick. Register them all before the service is used concurrently.
+func (s *service) SubscribeStrikes(fn func(StrikeChanged)) {
+	s.strikeSubscribers = append(s.strikeSubscribers, fn)
+}
+
+func (s *service) publishStrikes(changes []StrikeChanged) {
+	for _, c := range changes {
+		s.log.Printf("event strike changed %+v", c)
+		for _, fn := range s.strikeSubscribers {
+			fn(c)
+		}
+	}
+}
+
+// Lists every strike the user got, newest first, forgiven and expired ones included.
+func (s *service) ListStrikes(userId int64) ([]Strike, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := strikeSelect + `
+        WHERE s.user_id = ?
+        ORDER BY s.created_at DESC, s.id DESC
+    `
+	args := []any{userId}
+
+	strikes := []Strike{}
+	err = tx.Select(&strikes, stmt, args...)
+	return strikes, err
+}
+
+// Returns the restriction the user is under right now, nil if there is none.
+func (s *service) GetRestriction(userId int64) (*Restriction, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	r, err := getActiveRestriction(tx, userId, s.clock.Now())
+	return r, err
+}
+
+// Lists the restrictions in effect right now, the ones ending soonest first.
+func (s *service) ListRestrictions() ([]Restriction, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := restrictionSelect + `
+        WHERE ` + activeRestrictionSQL("r") + `
+        ORDER BY r.ends_at
+    `
+	args := []any{s.clock.Now(), s.clock.Now()}
+
+	restrictions := []Restriction{}
+	err = tx.Select(&restrictions, stmt, args...)
+	return restrictions, err
+}
+
+// Forgives a strike so it no longer counts. If that leaves the user under the threshold of the policy,
+// the restriction they are under is lifted and they can move up from the waitlists again.
+func (s *service) ForgiveStrike(strikeId int64, actorId int64) error {
+	s.log.Printf("event ForgiveStrike strikeId:%d actorId:%d", strikeId, actorId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	strike, err := getStrike(tx, strikeId)
+	if err != nil {
+		return err
+	}
+	if strike.ForgivenAt.Valid {
+		return nil
+	}
+
+	now := s.clock.Now()
+	strike.ForgivenAt = sql.NullTime{Time: now, Valid: true}
+	strike.ForgivenBy = sql.NullInt64{Int64: actorId, Valid: true}
+
+	stmt := `
+        UPDATE strike SET forgiven_at = ?, forgiven_by = ?
+        WHERE id = ?
+    `
+	args := []any{strike.ForgivenAt, strike.ForgivenBy, strike.Id}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	strikeChanges := []StrikeChanged{{
+		Kind:    StrikeForgiven,
+		UserId:  strike.UserId,
+		ActorId: actorId,
+		Strike:  strike,
+	}}
+	more, changes, err := s.liftIfCleared(tx, strike.UserId, actorId, now)
+	if err != nil {
+		return err
+	}
+	strikeChanges = append(strikeChanges, more...)
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publishStrikes(strikeChanges)
+	s.publish(changes)
+	return nil
+}
+
+// Ends the restrictions that ran out, letting their users move up from the waitlists they were held on.
+// Meant to run periodically.
+func (s *service) ExpireRestrictions() error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now()
+	stmt := restrictionSelect + `
+        WHERE r.lifted_at IS NULL AND datetime(r.ends_at) <= datetime(?)
+    `
+	args := []any{now}
+
+	var ended []Restriction
+	err = tx.Select(&ended, stmt, args...)
+	if err != nil {
+		return err
+	}
+	if len(ended) == 0 {
+		return nil
+	}
+
+	strikeChanges := []StrikeChanged{}
+	changes := []WaitlistChanged{}
+	for _, r := range ended {
+		_, err = tx.Exec(`UPDATE restriction SET lifted_at = ends_at WHERE id = ?`, r.Id)
+		if err != nil {
+			return err
+		}
+		r.LiftedAt = sql.NullTime{Time: r.EndsAt, Valid: true}
+
+		strikeChanges = append(strikeChanges, StrikeChanged{
+			Kind:        RestrictionEnded,
+			UserId:      r.UserId,
+			ActorId:     -1,
+			Restriction: r,
+		})
+
+		more, err := refreshUserWaitlists(tx, r.UserId, now)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, more...)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publishStrikes(strikeChanges)
+	s.publish(changes)
+	return nil
+}
+
+// Turns newly given strikes into domain events, and restricts every user they pushed over the threshold of the policy.
+// Strikes given before the last restriction of a user started don't count towards a new one.
+func (s *service) applyStrikes(tx *sqlx.Tx, strikes []Strike, actorId int64, now time.Time) ([]StrikeChanged, error) {
+	changes := []StrikeChanged{}
+	users := []int64{}
+	for _, strike := range strikes {
+		strike, err := getStrike(tx, strike.Id)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, StrikeChanged{
+			Kind:    StrikeAdded,
+			UserId:  strike.UserId,
+			ActorId: actorId,
+			Strike:  strike,
+		})
+		users = append(users, strike.UserId)
+	}
+	if s.strikes.Threshold <= 0 {
+		return changes, nil
+	}
+
+	restricted := map[int64]bool{}
+	for _, userId := range users {
+		if restricted[userId] {
+			continue
+		}
+		restricted[userId] = true
+
+		active, err := getActiveRestriction(tx, userId, now)
+		if err != nil {
+			return nil, err
+		}
+		if active != nil {
+			continue
+		}
+
+		stmt := `
+            SELECT COUNT(*) FROM strike
+            WHERE user_id = ? AND forgiven_at IS NULL
+                AND datetime(created_at) > datetime(?)
+                AND datetime(created_at) > datetime(COALESCE((SELECT MAX(starts_at) FROM restriction WHERE user_id = ?), '1970-01-01'))
+        `
+		args := []any{userId, now.Add(-s.strikes.Window), userId}
+
+		var count int
+		err = tx.Get(&count, stmt, args...)
+		if err != nil {
+			return nil, err
+		}
+		if count < s.strikes.Threshold {
+			continue
+		}
+
+		stmt = `
+            INSERT INTO restriction (user_id, mode, starts_at, ends_at)
+            VALUES (?, ?, ?, ?)
+        `
+		args = []any{userId, s.strikes.Mode, now, now.Add(s.strikes.Duration)}
+
+		res, err := tx.Exec(stmt, args...)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := getRestriction(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, StrikeChanged{
+			Kind:        RestrictionStarted,
+			UserId:      userId,
+			ActorId:     actorId,
+			Restriction: r,
+		})
+	}
+
+	return changes, nil
+}
+
+// Lifts the restriction of the user if their active strikes dropped under the threshold of the policy,
+// refreshing the waitlists they were held on.
+func (s *service) liftIfCleared(tx *sqlx.Tx, userId int64, actorId int64, now time.Time) ([]StrikeChanged, []WaitlistChanged, error) {
+	r, err := getActiveRestriction(tx, userId, now)
+	if err != nil || r == nil {
+		return nil, nil, err
+	}
+
+	stmt := `
+        SELECT COUNT(*) FROM strike
+        WHERE user_id = ? AND forgiven_at IS NULL AND datetime(created_at) > datetime(?)
+    `
+	args := []any{userId, now.Add(-s.strikes.Window)}
+
+	var count int
+	err = tx.Get(&count, stmt, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.strikes.Threshold > 0 && count >= s.strikes.Threshold {
+		return nil, nil, nil
+	}
+
+	r.LiftedAt = sql.NullTime{Time: now, Valid: true}
+	r.LiftedBy = sql.NullInt64{Int64: actorId, Valid: true}
+
+	stmt = `
+        UPDATE restriction SET lifted_at = ?, lifted_by = ?
+        WHERE id = ?
+    `
+	args = []any{r.LiftedAt, r.LiftedBy, r.Id}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := refreshUserWaitlists(tx, userId, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []StrikeChanged{{
+		Kind:        RestrictionLifted,
+		UserId:      userId,
+		ActorId:     actorId,
+		Restriction: *r,
+	}}, changes, nil
+}
+
+// Gives the user a strike for the event, unless they already have one of that kind for it.
+// Returns the strike, nil if none was given.
+func addStrike(tx *sqlx.Tx, userId int64, eventId string, kind StrikeKind, now time.Time) (*Strike, error) {
+	stmt := `
+        INSERT INTO strike (user_id, event_id, kind, created_at)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (user_id, event_id, kind) DO NOTHING
+        RETURNING id
+    `
+	args := []any{userId, eventId, kind, now}
+
+	var id int64
+	err := tx.Get(&id, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &Strike{Id: id, UserId: userId, EventId: eventId, Kind: kind, CreatedAt: now}, nil
+}
+
+// Keeps the no-show strike of the user for the event in line with their attendance, guests don't count.
+// Returns the strike given or taken away, nil if neither happened.
+func syncNoShowStrike(tx *sqlx.Tx, a Attendance) (added *Strike, removed *Strike, err error) {
+	if a.GuestId != 0 {
+		return nil, nil, nil
+	}
+	if a.Status == AttendanceAbsent {
+		added, err = addStrike(tx, a.UserId, a.EventId, StrikeNoShow, a.RecordedAt)
+		return added, nil, err
+	}
+
+	stmt := strikeSelect + `
+        WHERE s.user_id = ? AND s.event_id = ? AND s.kind = ?
+    `
+	args := []any{a.UserId, a.EventId, StrikeNoShow}
+
+	var strike Strike
+	err = tx.Get(&strike, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM strike WHERE id = ?`, strike.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, &strike, nil
+}
+
+// Whether the user is only allowed onto waitlists right now.
+func isWaitlistOnly(tx *sqlx.Tx, userId int64, now time.Time) (bool, error) {
+	r, err := getActiveRestriction(tx, userId, now)
+	if err != nil {
+		return false, err
+	}
+	return r != nil && r.Mode == RestrictionWaitlist, nil
+}
+
+// Returns a RestrictedError if the user is blocked from signing up right now.
+func checkNotBlocked(tx *sqlx.Tx, userId int64, now time.Time) error {
+	r, err := getActiveRestriction(tx, userId, now)
+	if err != nil {
+		return err
+	}
+	if r != nil && r.Mode == RestrictionBlock {
+		return &RestrictedError{Until: r.EndsAt}
+	}
+	return nil
+}
+
+// Reruns the waitlists the user is on, for after their restriction ended. The user is told about spots they get as well.
+func refreshUserWaitlists(tx *sqlx.Tx, userId int64, now time.Time) ([]WaitlistChanged, error) {
+	stmt := `
+        SELECT DISTINCT e.id, COALESCE(e.series_id, '') AS series_id
+        FROM event_response AS er
+        INNER JOIN event AS e ON er.event_id = e.id
+        WHERE er.user_id = ? AND er.on_waitlist = TRUE AND e.is_deleted = FALSE AND datetime(?) <= datetime(e.start)
+        UNION
+        SELECT '', series_id
+        FROM series_enrollment
+        WHERE user_id = ? AND on_waitlist = TRUE
+    `
+	args := []any{userId, now, userId}
+
+	var rows []struct {
+		Id       string `db:"id"`
+		SeriesId string `db:"series_id"`
+	}
+	err := tx.Select(&rows, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []WaitlistChanged{}
+	series := map[string]bool{}
+	for _, row := range rows {
+		if row.Id != "" {
+			flipped, err := manageWaitlist(tx, row.Id, now)
+			if err != nil {
+				return nil, err
+			}
+			more, err := waitlistChanges(tx, flipped, -1, now)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, more...)
+		}
+		if row.SeriesId != "" {
+			series[row.SeriesId] = true
+		}
+	}
+	for seriesId := range series {
+		more, err := manageSeriesWaitlist(tx, seriesId, now)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, more...)
+	}
+
+	return changes, nil
+}
+
+var strikeSelect = `
+        SELECT
+            s.id, s.user_id, s.event_id, s.kind, s.created_at, s.forgiven_at, s.forgiven_by
+            , ` + user.DisplayNameSQL("u") + ` AS user_full_name
+            , COALESCE(e.name, '') AS event_name
+        FROM strike AS s
+        LEFT JOIN users AS u ON s.user_id = u.id
+        LEFT JOIN event AS e ON s.event_id = e.id
+    `
+
+var restrictionSelect = `
+        SELECT
+            r.id, r.user_id, r.mode, r.starts_at, r.ends_at, r.lifted_at, r.lifted_by
+            , ` + user.DisplayNameSQL("u") + ` AS user_full_name
+        FROM restriction AS r
+        LEFT JOIN users AS u ON r.user_id = u.id
+    `
+
+// The condition for restrictions of table alias t in effect at a time, which is passed twice.
+func activeRestrictionSQL(t string) string {
+	return t + `.lifted_at IS NULL AND datetime(` + t + `.starts_at) <= datetime(?) AND datetime(?) < datetime(` + t + `.ends_at)`
+}
+
+func getStrike(tx *sqlx.Tx, id int64) (Strike, error) {
+	stmt := strikeSelect + `
+        WHERE s.id = ?
+    `
+	args := []any{id}
+
+	var strike Strike
+	err := tx.Get(&strike, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Strike{}, ErrNoStrike
+	}
+	return strike, err
+}
+
+func getRestriction(tx *sqlx.Tx, id int64) (Restriction, error) {
+	stmt := restrictionSelect + `
+        WHERE r.id = ?
+    `
+	args := []any{id}
+
+	var r Restriction
+	err := tx.Get(&r, stmt, args...)
+	return r, err
+}
+
+func getActiveRestriction(tx *sqlx.Tx, userId int64, now time.Time) (*Restriction, error) {
+	stmt := restrictionSelect + `
+        WHERE r.user_id = ? AND ` + activeRestrictionSQL("r") + `
+        ORDER BY r.ends_at DESC
+        LIMIT 1
+    `
+	args := []any{userId, now, now}
+
+	var r Restriction
+	err := tx.Get(&r, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,188 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/clock"
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrikes(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	c := clock.NewFake(now)
+	eventService := event.NewService(db)
+	eventService.SetClock(c)
+	eventService.SetStrikePolicy(event.StrikePolicy{Threshold: 2, Window: 10 * day, Mode: event.RestrictionWaitlist, Duration: 5 * day})
+
+	var changes []event.StrikeChanged
+	eventService.SubscribeStrikes(func(sc event.StrikeChanged) {
+		changes = append(changes, sc)
+	})
+
+	var users []user.User
+	for i := 0; i < 3; i++ {
+		u, err := user.NewService(db).Create(user.CreateParams{FullName: "Potter"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	u, other, monitor := users[0], users[1], users[2]
+
+	// a no-show
+	id := MustCreate(t, db, event.CreateParams{Name: "Class", Capacity: 2, Start: now.Add(time.Hour), StudioMonitorId: -1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 1})
+	c.Advance(time.Hour)
+	err := eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id, UserId: u.Id, Status: event.AttendanceAbsent, RecordedBy: monitor.Id})
+	assert.NoError(t, err)
+	err = eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id, UserId: u.Id, Status: event.AttendanceAbsent, RecordedBy: monitor.Id})
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1, "one strike per event") {
+		assert.Equal(t, event.StrikeAdded, changes[0].Kind)
+		assert.Equal(t, event.StrikeNoShow, changes[0].Strike.Kind)
+		assert.Equal(t, monitor.Id, changes[0].ActorId)
+	}
+
+	// a late cancellation pushes the user over the threshold
+	id2 := MustCreate(t, db, event.CreateParams{Name: "Other class", Capacity: 1, Start: c.Now().Add(day), StudioMonitorId: -1, CancellationCutoff: c.Now()})
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id2, AttendeeCount: 1})
+	assert.NoError(t, err)
+	c.Advance(time.Hour)
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id2, AttendeeCount: 0})
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 3) {
+		assert.Equal(t, event.StrikeLateCancellation, changes[1].Strike.Kind)
+		assert.Equal(t, event.RestrictionStarted, changes[2].Kind)
+		assert.Equal(t, u.Id, changes[2].ActorId)
+	}
+
+	restriction, err := eventService.GetRestriction(u.Id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, restriction) {
+		assert.Equal(t, event.RestrictionWaitlist, restriction.Mode)
+		assert.Equal(t, c.Now().Add(5*day), restriction.EndsAt.UTC())
+	}
+
+	// restricted users only get onto the waitlist, and stay there while others move up
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id2, AttendeeCount: 1})
+	assert.NoError(t, err)
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: other.Id, Id: id2, AttendeeCount: 1})
+	assert.NoError(t, err)
+	e, err := eventService.GetDetailed(id2, u.Id)
+	assert.NoError(t, err)
+	assert.True(t, e.UserResponse.OnWaitlist)
+	assert.NotNil(t, e.Restriction)
+	assert.False(t, e.Responses[1].OnWaitlist, "the restricted user doesn't take up the spot")
+
+	strikes, err := eventService.ListStrikes(u.Id)
+	assert.NoError(t, err)
+	if assert.Len(t, strikes, 2) {
+		assert.Equal(t, "Other class", strikes[0].EventName)
+	}
+
+	// forgiving a strike lifts the restriction
+	err = eventService.ForgiveStrike(strikes[0].Id, monitor.Id)
+	assert.NoError(t, err)
+	restriction, err = eventService.GetRestriction(u.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, restriction)
+	if assert.Len(t, changes, 5) {
+		assert.Equal(t, event.StrikeForgiven, changes[3].Kind)
+		assert.Equal(t, event.RestrictionLifted, changes[4].Kind)
+	}
+
+	// correcting the no-show takes its strike back
+	err = eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id, UserId: u.Id, Status: event.AttendancePresent, RecordedBy: monitor.Id})
+	assert.NoError(t, err)
+	strikes, err = eventService.ListStrikes(u.Id)
+	assert.NoError(t, err)
+	assert.Len(t, strikes, 1)
+	assert.Equal(t, event.StrikeRemoved, changes[len(changes)-1].Kind)
+}
+
+func TestRestrictionBlock(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	c := clock.NewFake(now)
+	eventService := event.NewService(db)
+	eventService.SetClock(c)
+	eventService.SetStrikePolicy(event.StrikePolicy{Threshold: 1, Window: 10 * day, Mode: event.RestrictionBlock, Duration: day})
+
+	u, err := user.NewService(db).Create(user.CreateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := MustCreate(t, db, event.CreateParams{Capacity: 2, Start: now.Add(time.Hour), StudioMonitorId: -1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 1})
+	c.Advance(time.Hour)
+	err = eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id, UserId: u.Id, Status: event.AttendanceAbsent, RecordedBy: -1})
+	assert.NoError(t, err)
+
+	id2 := MustCreate(t, db, event.CreateParams{Capacity: 2, Start: c.Now().Add(3 * day), StudioMonitorId: -1})
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id2, AttendeeCount: 1})
+	assert.ErrorIs(t, err, event.ErrRestricted)
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id2, AttendeeCount: 1, Override: true})
+	assert.NoError(t, err, "admins can still sign the user up on their behalf")
+
+	c.Advance(day)
+	err = eventService.ExpireRestrictions()
+	assert.NoError(t, err)
+	restriction, err := eventService.GetRestriction(u.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, restriction)
+
+	id3 := MustCreate(t, db, event.CreateParams{Capacity: 2, Start: c.Now().Add(day), StudioMonitorId: -1})
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id3, AttendeeCount: 1})
+	assert.NoError(t, err)
+}
+
+func TestRestrictionEnds(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	c := clock.NewFake(now)
+	eventService := event.NewService(db)
+	eventService.SetClock(c)
+	eventService.SetStrikePolicy(event.StrikePolicy{Threshold: 1, Window: 10 * day, Mode: event.RestrictionWaitlist, Duration: day})
+
+	var waitlistChanges []event.WaitlistChanged
+	eventService.Subscribe(func(wc event.WaitlistChanged) {
+		waitlistChanges = append(waitlistChanges, wc)
+	})
+
+	u, err := user.NewService(db).Create(user.CreateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := MustCreate(t, db, event.CreateParams{Capacity: 2, Start: now.Add(time.Hour), StudioMonitorId: -1})
+	MustHandleResponse(t, db, event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 1})
+	c.Advance(time.Hour)
+	err = eventService.RecordAttendance(event.RecordAttendanceParams{EventId: id, UserId: u.Id, Status: event.AttendanceAbsent, RecordedBy: -1})
+	assert.NoError(t, err)
+
+	id2 := MustCreate(t, db, event.CreateParams{Capacity: 2, Start: c.Now().Add(3 * day), StudioMonitorId: -1})
+	err = eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id2, AttendeeCount: 1})
+	assert.NoError(t, err)
+	e, err := eventService.GetDetailed(id2, u.Id)
+	assert.NoError(t, err)
+	assert.True(t, e.UserResponse.OnWaitlist, "held on the waitlist even though there is room")
+
+	c.Advance(day)
+	err = eventService.ExpireRestrictions()
+	assert.NoError(t, err)
+	e, err = eventService.GetDetailed(id2, u.Id)
+	assert.NoError(t, err)
+	assert.False(t, e.UserResponse.OnWaitlist, "moved up once the restriction ended")
+	if assert.Len(t, waitlistChanges, 1) {
+		assert.Equal(t, u.Id, waitlistChanges[0].UserId)
+	}
+}
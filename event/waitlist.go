@@ -0,0 +1,107 @@
+package event
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Published after a change moved someone on or off the waitlist of an event,
+// or off the waitlist of a whole series, in which case SeriesId is set and the event is its next occurrence.
+// Nothing is published for the user whose own action caused the change.
+//
+// For events with offers, users stay on the waitlist when a spot opens up and OfferExpiresAt says
+// until when they can accept it. If they don't, OfferExpired is published and their response is gone.
+type WaitlistChanged struct {
+	EventId        string
+	EventName      string
+	EventStart     time.Time
+	SeriesId       string
+	UserId         int64
+	OnWaitlist     bool // false if they got a spot
+	OfferExpiresAt time.Time
+	OfferExpired   bool
+}
+
+func (c WaitlistChanged) IsOffer() bool {
+	return !c.OfferExpiresAt.IsZero() && !c.OfferExpired
+}
+
+// Registers a function that is called with every WaitlistChanged once the change is committed.
+// Subscribers run synchronously, so they should be quick. Register them all before the service is used concurrently.
+func (s *service) Subscribe(fn func(WaitlistChanged)) {
+	s.subscribers = append(s.subscribers, fn)
+}
+
+func (s *service) publish(changes []WaitlistChanged) {
+	for _, c := range changes {
+		s.log.Printf("event waitlist changed %+v", c)
+		for _, fn := range s.subscribers {
+			fn(c)
+		}
+	}
+}
+
+// Turns the responses manageWaitlist flipped into domain events, leaving out those of actorId.
+func waitlistChanges(tx *sqlx.Tx, responses []EventResponse, actorId int64, now time.Time) ([]WaitlistChanged, error) {
+	changes := []WaitlistChanged{}
+	events := map[string]Event{}
+	for _, r := range responses {
+		if r.UserId == actorId {
+			continue
+		}
+
+		e, ok := events[r.EventId]
+		if !ok {
+			var err error
+			e, err = get(tx, r.EventId, now)
+			if err != nil {
+				return nil, err
+			}
+			events[r.EventId] = e
+		}
+
+		changes = append(changes, WaitlistChanged{
+			EventId:        e.Id,
+			EventName:      e.Name,
+			EventStart:     e.Start,
+			UserId:         r.UserId,
+			OnWaitlist:     r.OnWaitlist,
+			OfferExpiresAt: r.OfferExpiresAt.Time,
+		})
+	}
+	return changes, nil
+}
+
+// Turns enrollments that moved off the series waitlist into domain events.
+func seriesWaitlistChanges(tx *sqlx.Tx, seriesId string, promoted []SeriesEnrollment, now time.Time) ([]WaitlistChanged, error) {
+	if len(promoted) == 0 {
+		return nil, nil
+	}
+
+	occurrences, err := listUpcomingOccurrences(tx, seriesId, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(occurrences) == 0 {
+		return nil, nil
+	}
+
+	next, err := get(tx, occurrences[0], now)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []WaitlistChanged{}
+	for _, p := range promoted {
+		changes = append(changes, WaitlistChanged{
+			EventId:    next.Id,
+			EventName:  next.Name,
+			EventStart: next.Start,
+			SeriesId:   seriesId,
+			UserId:     p.UserId,
+			OnWaitlist: false,
+		})
+	}
+	return changes, nil
+}
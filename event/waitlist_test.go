@@ -0,0 +1,106 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	eventService := event.NewService(db)
+	userService := user.NewService(db)
+
+	var changes []event.WaitlistChanged
+	eventService.Subscribe(func(c event.WaitlistChanged) {
+		changes = append(changes, c)
+	})
+
+	var users []user.User
+	for i := 0; i < 3; i++ {
+		u, err := userService.Create(user.CreateParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+
+	start := time.Now().UTC().Add(day)
+	id := MustCreate(t, db, event.CreateParams{Name: "Class", Capacity: 2, Start: start, StudioMonitorId: -1})
+	for _, u := range users {
+		err := eventService.HandleResponse(event.HandleResponseParams{UserId: u.Id, Id: id, AttendeeCount: 1})
+		assert.NoError(t, err)
+	}
+	assert.Empty(t, changes, "nobody is told about their own response")
+
+	// the third user gets the spot the first gave up
+	err := eventService.HandleResponse(event.HandleResponseParams{UserId: users[0].Id, Id: id, AttendeeCount: 0})
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, users[2].Id, changes[0].UserId)
+		assert.False(t, changes[0].OnWaitlist)
+		assert.Equal(t, id, changes[0].EventId)
+		assert.Equal(t, "Class", changes[0].EventName)
+		assert.Empty(t, changes[0].SeriesId)
+	}
+
+	// lowering the capacity demotes the last to sign up
+	changes = nil
+	err = eventService.Update(event.UpdateParams{User: admin, Id: id, Name: "Class", Capacity: 1, Start: start, StudioMonitorId: -1})
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, users[2].Id, changes[0].UserId)
+		assert.True(t, changes[0].OnWaitlist)
+	}
+}
+
+func TestSubscribeSeries(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	eventService := event.NewService(db)
+	userService := user.NewService(db)
+
+	var changes []event.WaitlistChanged
+	eventService.Subscribe(func(c event.WaitlistChanged) {
+		changes = append(changes, c)
+	})
+
+	u1, err := userService.Create(user.CreateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2, err := userService.Create(user.CreateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := MustCreate(t, db, event.CreateParams{
+		Name:            "Course",
+		Capacity:        1,
+		Start:           time.Now().UTC().Add(day),
+		StudioMonitorId: -1,
+		RRule:           "FREQ=WEEKLY;COUNT=2",
+	})
+	events := seriesEvents(t, eventService, id)
+	seriesId := events[0].SeriesId.String
+
+	for _, u := range []user.User{u1, u2} {
+		_, err = eventService.EnrollSeries(event.EnrollSeriesParams{SeriesId: seriesId, UserId: u.Id, AttendeeCount: 1})
+		assert.NoError(t, err)
+	}
+	assert.Empty(t, changes)
+
+	err = eventService.DropSeries(seriesId, u1.Id)
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, u2.Id, changes[0].UserId)
+		assert.Equal(t, seriesId, changes[0].SeriesId)
+		assert.Equal(t, events[0].Id, changes[0].EventId, "points to the next occurrence")
+		assert.False(t, changes[0].OnWaitlist)
+	}
+}
@@ -6,21 +6,27 @@ import (
 	"time"
 
 	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/user"
 )
 
 type Service interface {
 	Get(string) (Group, error)
 	GetDetailed(string) (GroupDetailed, error)
 	List() ([]Group, error)
+	ListManaged(user.SessionUser) ([]Group, error)
 	CreateAndAddMember(CreateParams) (string, error)
 	Update(UpdateParams) error
-	Delete(string) error
+	Delete(string, user.SessionUser) error
 	AddMemberFromInvite(string, int64) (Group, error)
-	RemoveMember(string, int64) error
+	AddMember(string, int64) error
+	RemoveMember(string, int64, user.SessionUser) error
+	ListUserMemberships(int64) ([]Membership, error)
 	UserCanAccess(sql.NullString, int64) (bool, error)
 	UserCanAccessError(sql.NullString, int64) error
+	UserCanManage(string, user.SessionUser) (bool, error)
+	UserCanManageError(string, user.SessionUser) error
 	FilterEventsUserCanAccess([]event.Event, int64) ([]event.Event, error)
-	RefreshInviteId(string) error
+	RefreshInviteId(string, user.SessionUser) error
 }
 
 type Group struct {
@@ -35,10 +41,18 @@ type Group struct {
 }
 
 type GroupMember struct {
-	GroupId      string    `db:"group_id"`
-	UserId       int64     `db:"user_id"`
-	UserFullName string    `db:"user_full_name"`
-	CreatedAt    time.Time `db:"created_at"`
+	GroupId      string         `db:"group_id"`
+	UserId       int64          `db:"user_id"`
+	UserFullName string         `db:"user_full_name"`
+	UserPicture  sql.NullString `db:"user_picture"`
+	CreatedAt    time.Time      `db:"created_at"`
+}
+
+// A group the user is a member of.
+type Membership struct {
+	GroupId   string    `db:"group_id"`
+	GroupName string    `db:"group_name"`
+	CreatedAt time.Time `db:"created_at"`
 }
 
 type GroupDetailed struct {
@@ -47,5 +61,6 @@ type GroupDetailed struct {
 }
 
 var (
-	ErrNoAccess = errors.New("you do not have access")
+	ErrNoAccess     = errors.New("you do not have access")
+	ErrCannotManage = errors.New("you cannot manage this group")
 )
@@ -8,6 +8,7 @@ import (
 	"github.com/Chaldron/clay-play/db"
 	"github.com/Chaldron/clay-play/event"
 	"github.com/Chaldron/clay-play/logger"
+	"github.com/Chaldron/clay-play/user"
 	"github.com/jmoiron/sqlx"
 
 	gonanoid "github.com/matoous/go-nanoid/v2"
@@ -74,6 +75,28 @@ func (s *service) List() ([]Group, error) {
 	return g, err
 }
 
+// Lists the groups u can manage, every group for group managers and the groups they created for group owners.
+func (s *service) ListManaged(u user.SessionUser) ([]Group, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return []Group{}, err
+	}
+	defer tx.Rollback()
+
+	g, err := list(tx)
+	if err != nil {
+		return []Group{}, err
+	}
+
+	managed := []Group{}
+	for _, gr := range g {
+		if canManage(gr, u) {
+			managed = append(managed, gr)
+		}
+	}
+	return managed, nil
+}
+
 type CreateParams struct {
 	CreatorId int64
 	Name      string
@@ -107,6 +130,7 @@ func (s *service) CreateAndAddMember(p CreateParams) (string, error) {
 type UpdateParams struct {
 	Id   string
 	Name string
+	User user.SessionUser // who makes the change, has to be able to manage the group
 }
 
 func (s *service) Update(p UpdateParams) error {
@@ -117,6 +141,11 @@ func (s *service) Update(p UpdateParams) error {
 	}
 	defer tx.Rollback()
 
+	err = checkCanManage(tx, p.Id, p.User)
+	if err != nil {
+		return err
+	}
+
 	err = update(tx, p)
 	if err != nil {
 		return err
@@ -125,7 +154,8 @@ func (s *service) Update(p UpdateParams) error {
 	return tx.Commit()
 }
 
-func (s *service) Delete(id string) error {
+// Deletes the group, if u can manage it.
+func (s *service) Delete(id string, u user.SessionUser) error {
 	s.log.Printf("group Delete id %s", id)
 	tx, err := s.db.Beginx()
 	if err != nil {
@@ -133,6 +163,11 @@ func (s *service) Delete(id string) error {
 	}
 	defer tx.Rollback()
 
+	err = checkCanManage(tx, id, u)
+	if err != nil {
+		return err
+	}
+
 	err = delete(tx, id)
 	if err != nil {
 		return err
@@ -168,7 +203,38 @@ func (s *service) AddMemberFromInvite(inviteId string, userId int64) (Group, err
 	return g, nil
 }
 
-func (s *service) RemoveMember(groupId string, userId int64) error {
+// Adds the user to the group, doing nothing if they are a member already.
+func (s *service) AddMember(groupId string, userId int64) error {
+	s.log.Printf("group AddMember groupId:%s userId:%d", groupId, userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = get(tx, groupId)
+	if err != nil {
+		return err
+	}
+
+	isMember, err := hasMember(tx, groupId, userId)
+	if err != nil {
+		return err
+	}
+	if isMember {
+		return nil
+	}
+
+	err = addMember(tx, groupId, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Removes the user from the group, if u can manage it.
+func (s *service) RemoveMember(groupId string, userId int64, u user.SessionUser) error {
 	s.log.Printf("group RemoveMember groupId:%s userId:%s", groupId, userId)
 	tx, err := s.db.Beginx()
 	if err != nil {
@@ -176,6 +242,11 @@ func (s *service) RemoveMember(groupId string, userId int64) error {
 	}
 	defer tx.Rollback()
 
+	err = checkCanManage(tx, groupId, u)
+	if err != nil {
+		return err
+	}
+
 	err = removeMember(tx, groupId, userId)
 	if err != nil {
 		return err
@@ -184,6 +255,27 @@ func (s *service) RemoveMember(groupId string, userId int64) error {
 	return tx.Commit()
 }
 
+func (s *service) ListUserMemberships(userId int64) ([]Membership, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT ugm.group_id, ug.name AS group_name, ugm.created_at
+        FROM user_group_member ugm
+        INNER JOIN user_group ug ON ug.id = ugm.group_id
+        WHERE ugm.user_id = ? AND ug.is_deleted = FALSE
+        ORDER BY ugm.created_at ASC
+    `
+	args := []any{userId}
+
+	m := []Membership{}
+	err = tx.Select(&m, stmt, args...)
+	return m, err
+}
+
 func (s *service) UserCanAccess(groupId sql.NullString, userId int64) (bool, error) {
 	tx, err := s.db.Beginx()
 	if err != nil {
@@ -211,6 +303,59 @@ func (s *service) UserCanAccessError(groupId sql.NullString, userId int64) error
 	return nil
 }
 
+// Group managers can manage every group, group owners only the groups they created.
+func (s *service) UserCanManage(id string, u user.SessionUser) (bool, error) {
+	if u.Can(user.PermManageGroups) {
+		return true, nil
+	}
+	if !u.Can(user.PermManageOwnGroups) {
+		return false, nil
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	g, err := get(tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	return canManage(g, u), nil
+}
+
+func (s *service) UserCanManageError(id string, u user.SessionUser) error {
+	ok, err := s.UserCanManage(id, u)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCannotManage
+	}
+
+	return nil
+}
+
+func canManage(g Group, u user.SessionUser) bool {
+	if u.Can(user.PermManageGroups) {
+		return true
+	}
+	return u.Can(user.PermManageOwnGroups) && g.CreatorId == u.Id
+}
+
+func checkCanManage(tx *sqlx.Tx, id string, u user.SessionUser) error {
+	g, err := get(tx, id)
+	if err != nil {
+		return err
+	}
+	if !canManage(g, u) {
+		return ErrCannotManage
+	}
+	return nil
+}
+
 func (s *service) FilterEventsUserCanAccess(events []event.Event, userId int64) ([]event.Event, error) {
 	filtered := []event.Event{}
 	for _, e := range events {
@@ -226,7 +371,8 @@ func (s *service) FilterEventsUserCanAccess(events []event.Event, userId int64)
 	return filtered, nil
 }
 
-func (s *service) RefreshInviteId(groupId string) error {
+// Replaces the group's invite link, if u can manage it.
+func (s *service) RefreshInviteId(groupId string, u user.SessionUser) error {
 	s.log.Printf("group RefreshInviteId groupId %s", groupId)
 	tx, err := s.db.Beginx()
 	if err != nil {
@@ -234,6 +380,11 @@ func (s *service) RefreshInviteId(groupId string) error {
 	}
 	defer tx.Rollback()
 
+	err = checkCanManage(tx, groupId, u)
+	if err != nil {
+		return err
+	}
+
 	err = refreshInviteId(tx, groupId)
 	if err != nil {
 		return err
@@ -245,9 +396,9 @@ func (s *service) RefreshInviteId(groupId string) error {
 func get(tx *sqlx.Tx, id string) (Group, error) {
 	stmt := `
         SELECT ug.id, ug.name, ug.invite_id, ug.creator_id
-            , u.full_name AS creator_full_name
+            , ` + user.DisplayNameSQL("u") + ` AS creator_full_name
         FROM user_group ug
-        INNER JOIN users u ON ug.creator_id = u.id
+        LEFT JOIN users u ON ug.creator_id = u.id
         WHERE ug.id = ? AND is_deleted = FALSE
     `
 	args := []any{id}
@@ -259,8 +410,12 @@ func get(tx *sqlx.Tx, id string) (Group, error) {
 
 func listMembers(tx *sqlx.Tx, id string) ([]GroupMember, error) {
 	stmt := `
-        SELECT ugm.group_id, ugm.user_id, u.full_name AS user_full_name FROM user_group_member ugm
-        INNER JOIN users u ON u.id = ugm.user_id
+        SELECT
+            ugm.group_id, ugm.user_id
+            , ` + user.DisplayNameSQL("u") + ` AS user_full_name
+            , ` + user.PictureSQL("u") + ` AS user_picture
+        FROM user_group_member ugm
+        LEFT JOIN users u ON u.id = ugm.user_id
         WHERE group_id = ?
         ORDER BY ugm.created_at ASC
     `
@@ -274,7 +429,7 @@ func listMembers(tx *sqlx.Tx, id string) ([]GroupMember, error) {
 func list(tx *sqlx.Tx) ([]Group, error) {
 	stmt := `
         SELECT
-            ug.id, ug.name
+            ug.id, ug.name, ug.creator_id
             , COALESCE(ugc.total_member_count, 0) AS total_member_count
         FROM user_group AS ug
         LEFT JOIN (
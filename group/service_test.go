@@ -55,3 +55,151 @@ func TestFilterEventsUserCanAccess(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(u2Events))
 }
+
+func TestUserCanManage(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+
+	groupService := group.NewService(db)
+	userService := user.NewService(db)
+
+	owner, err := userService.Create(user.CreateParams{Email: "owner@example.com", Roles: []user.Role{user.RoleGroupOwner}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := userService.Create(user.CreateParams{Email: "other@example.com", Roles: []user.Role{user.RoleGroupOwner}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := userService.Create(user.CreateParams{Email: "admin2@example.com", IsAdmin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groupId, err := groupService.CreateAndAddMember(group.CreateParams{
+		CreatorId: owner.Id,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := groupService.UserCanManage(groupId, owner.ToSessionUser())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = groupService.UserCanManage(groupId, admin.ToSessionUser())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// owning a group does not give access to other groups
+	err = groupService.UserCanManageError(groupId, other.ToSessionUser())
+	assert.ErrorIs(t, err, group.ErrCannotManage)
+}
+
+func TestManageChecks(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+
+	groupService := group.NewService(db)
+	userService := user.NewService(db)
+
+	owner, err := userService.Create(user.CreateParams{Email: "owner@example.com", Roles: []user.Role{user.RoleGroupOwner}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := userService.Create(user.CreateParams{Email: "other@example.com", Roles: []user.Role{user.RoleGroupOwner}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groupId, err := groupService.CreateAndAddMember(group.CreateParams{CreatorId: owner.Id, Name: "Owned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// owning a group does not let you change other groups
+	ou := other.ToSessionUser()
+	assert.ErrorIs(t, groupService.Update(group.UpdateParams{Id: groupId, Name: "Taken", User: ou}), group.ErrCannotManage)
+	assert.ErrorIs(t, groupService.RemoveMember(groupId, owner.Id, ou), group.ErrCannotManage)
+	assert.ErrorIs(t, groupService.RefreshInviteId(groupId, ou), group.ErrCannotManage)
+	assert.ErrorIs(t, groupService.Delete(groupId, ou), group.ErrCannotManage)
+
+	g, err := groupService.GetDetailed(groupId)
+	assert.NoError(t, err)
+	assert.Equal(t, "Owned", g.Name)
+	assert.Len(t, g.Members, 1)
+
+	err = groupService.Update(group.UpdateParams{Id: groupId, Name: "Renamed", User: owner.ToSessionUser()})
+	assert.NoError(t, err)
+	err = groupService.Delete(groupId, owner.ToSessionUser())
+	assert.NoError(t, err)
+}
+
+func TestListManaged(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+
+	groupService := group.NewService(db)
+	userService := user.NewService(db)
+
+	owner, err := userService.Create(user.CreateParams{Email: "owner@example.com", Roles: []user.Role{user.RoleGroupOwner}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := userService.Create(user.CreateParams{Email: "other@example.com", Roles: []user.Role{user.RoleGroupOwner}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groupId, err := groupService.CreateAndAddMember(group.CreateParams{CreatorId: owner.Id, Name: "Owned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := groupService.CreateAndAddMember(group.CreateParams{CreatorId: other.Id, Name: "Other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := groupService.ListManaged(owner.ToSessionUser())
+	assert.NoError(t, err)
+	if assert.Len(t, g, 1) {
+		assert.Equal(t, groupId, g[0].Id)
+	}
+
+	g, err = groupService.ListManaged(user.SessionUser{IsAdmin: true})
+	assert.NoError(t, err)
+	assert.Len(t, g, 2)
+
+	// members without a group role manage nothing, not even groups they happen to have created
+	g, err = groupService.ListManaged(user.SessionUser{Id: owner.Id})
+	assert.NoError(t, err)
+	assert.Empty(t, g)
+}
+
+func TestAddMember(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+
+	groupService := group.NewService(db)
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groupId, err := groupService.CreateAndAddMember(group.CreateParams{
+		CreatorId: 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, groupService.AddMember(groupId, u.Id))
+	// adding someone twice is not an error
+	assert.NoError(t, groupService.AddMember(groupId, u.Id))
+
+	memberships, err := groupService.ListUserMemberships(u.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(memberships))
+	assert.Equal(t, groupId, memberships[0].GroupId)
+}
@@ -0,0 +1,148 @@
+// Package ical writes RFC 5545 calendars, just enough to publish events to calendar apps.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const prodId = "-//Clay Play//Events//EN"
+
+// How often subscribed calendar apps are asked to refresh the feed.
+const refreshInterval = "PT1H"
+
+type Status string
+
+const (
+	StatusConfirmed Status = "CONFIRMED"
+	StatusTentative Status = "TENTATIVE"
+	StatusCancelled Status = "CANCELLED"
+)
+
+type Event struct {
+	UID          string
+	Summary      string
+	Description  string
+	URL          string
+	Start        time.Time
+	Duration     time.Duration
+	Status       Status
+	Sequence     int // bumped on every change, so that calendar apps pick up the latest version
+	Created      time.Time
+	LastModified time.Time
+}
+
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Writes the calendar in the iCalendar format, stamping the events with now.
+func (c Calendar) Encode(w io.Writer, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	write := func(name string, value string) {
+		writeLine(bw, name+":"+value)
+	}
+
+	write("BEGIN", "VCALENDAR")
+	write("VERSION", "2.0")
+	write("PRODID", prodId)
+	write("CALSCALE", "GREGORIAN")
+	write("METHOD", "PUBLISH")
+	if c.Name != "" {
+		write("X-WR-CALNAME", escape(c.Name))
+	}
+	write("REFRESH-INTERVAL;VALUE=DURATION", refreshInterval)
+	write("X-PUBLISHED-TTL", refreshInterval)
+
+	for _, e := range c.Events {
+		write("BEGIN", "VEVENT")
+		write("UID", escape(e.UID))
+		write("DTSTAMP", formatTime(now))
+		write("DTSTART", formatTime(e.Start))
+		if e.Duration > 0 {
+			write("DURATION", formatDuration(e.Duration))
+		}
+		write("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			write("DESCRIPTION", escape(e.Description))
+		}
+		if e.URL != "" {
+			write("URL", e.URL)
+		}
+		if e.Status != "" {
+			write("STATUS", string(e.Status))
+		}
+		write("SEQUENCE", strconv.Itoa(e.Sequence))
+		if !e.Created.IsZero() {
+			write("CREATED", formatTime(e.Created))
+		}
+		if !e.LastModified.IsZero() {
+			write("LAST-MODIFIED", formatTime(e.LastModified))
+		}
+		write("END", "VEVENT")
+	}
+
+	write("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Formats positive durations such as "PT1H30M".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	s := "PT"
+	if h := d / time.Hour; h > 0 {
+		s += strconv.Itoa(int(h)) + "H"
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		s += strconv.Itoa(int(m)) + "M"
+		d -= m * time.Minute
+	}
+	if sec := d / time.Second; sec > 0 || s == "PT" {
+		s += strconv.Itoa(int(sec)) + "S"
+	}
+	return s
+}
+
+var escaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`,
+)
+
+// Escapes a TEXT value.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// Lines longer than 75 octets are folded onto continuation lines starting with a space, without splitting UTF-8 characters.
+func writeLine(w *bufio.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74 // the leading space counts
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
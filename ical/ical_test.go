@@ -0,0 +1,77 @@
+package ical_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/ical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	amsterdam := time.FixedZone("CET", 60*60)
+
+	c := ical.Calendar{
+		Name: "Clay Play",
+		Events: []ical.Event{{
+			UID:          "abc123",
+			Summary:      "Wheel throwing; beginners, all welcome",
+			Description:  "Bring a towel\nand an apron",
+			URL:          "https://example.com/event/abc123",
+			Start:        time.Date(2030, 3, 4, 19, 0, 0, 0, amsterdam),
+			Duration:     90 * time.Minute,
+			Status:       ical.StatusTentative,
+			Sequence:     2,
+			LastModified: now.Add(-time.Hour),
+		}},
+	}
+
+	var b strings.Builder
+	err := c.Encode(&b, now)
+	assert.NoError(t, err)
+
+	out := b.String()
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.True(t, strings.HasSuffix(out, "END:VEVENT\r\nEND:VCALENDAR\r\n"))
+	for _, line := range []string{
+		"UID:abc123",
+		"DTSTAMP:20300102T030405Z",
+		"DTSTART:20300304T180000Z",
+		"DURATION:PT1H30M",
+		`SUMMARY:Wheel throwing\; beginners\, all welcome`,
+		`DESCRIPTION:Bring a towel\nand an apron`,
+		"STATUS:TENTATIVE",
+		"SEQUENCE:2",
+		"LAST-MODIFIED:20300102T020405Z",
+	} {
+		assert.Contains(t, out, "\r\n"+line+"\r\n")
+	}
+	assert.NotContains(t, out, "CREATED:", "zero times are left out")
+}
+
+func TestEncodeFoldsLongLines(t *testing.T) {
+	c := ical.Calendar{
+		Events: []ical.Event{{
+			UID:     "x",
+			Summary: strings.Repeat("é", 100),
+			Start:   time.Unix(0, 0),
+		}},
+	}
+
+	var b strings.Builder
+	err := c.Encode(&b, time.Unix(0, 0))
+	assert.NoError(t, err)
+
+	unfolded := ""
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+		if strings.HasPrefix(line, " ") {
+			unfolded += line[1:]
+		} else {
+			unfolded += "\n" + line
+		}
+	}
+	assert.Contains(t, unfolded, "\nSUMMARY:"+strings.Repeat("é", 100)+"\n")
+}
@@ -0,0 +1,33 @@
+package mail
+
+import (
+	"github.com/Chaldron/clay-play/config"
+)
+
+type Sender interface {
+	Send(Message) error
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// NewSender returns an SMTP sender when a host is configured, otherwise messages are written to the outbox directory.
+func NewSender(conf *config.Config) Sender {
+	from := conf.MailFrom
+	if from == "" {
+		from = "Clay Play <no-reply@localhost>"
+	}
+
+	if conf.SMTPHost != "" {
+		return NewSMTPSender(conf.SMTPHost, conf.SMTPPort, conf.SMTPUsername, conf.SMTPPassword, from)
+	}
+
+	dir := conf.MailOutboxDir
+	if dir == "" {
+		dir = "./outbox"
+	}
+	return NewOutboxSender(dir, from)
+}
@@ -0,0 +1,24 @@
+package mail_test
+
+import (
+	"testing"
+
+	"github.com/Chaldron/clay-play/mail"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxSender(t *testing.T) {
+	outbox := mail.NewOutboxSender(t.TempDir(), "studio@example.com")
+
+	err := outbox.Send(mail.Message{To: "a@example.com", Subject: "first", Body: "hello"})
+	assert.NoError(t, err)
+	err = outbox.Send(mail.Message{To: "b@example.com", Subject: "second\r\nBcc: evil@example.com", Body: "hi"})
+	assert.NoError(t, err)
+
+	messages, err := outbox.Messages()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Contains(t, messages[0], "To: a@example.com\n")
+	assert.Contains(t, messages[0], "\n\nhello")
+	assert.NotContains(t, messages[1], "\nBcc:")
+}
@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutboxSender writes every message to a file instead of sending it, for development and tests.
+type OutboxSender struct {
+	dir  string
+	from string
+	mu   sync.Mutex
+	n    int
+}
+
+func NewOutboxSender(dir string, from string) *OutboxSender {
+	return &OutboxSender{
+		dir:  dir,
+		from: from,
+	}
+}
+
+func (s *OutboxSender) Send(m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+
+	s.n++
+	name := fmt.Sprintf("%d-%04d.eml", time.Now().UnixNano(), s.n)
+	return os.WriteFile(filepath.Join(s.dir, name), format(s.from, m), 0o644)
+}
+
+// Messages returns the raw contents of every message in the outbox, oldest first.
+func (s *OutboxSender) Messages() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	messages := []string{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, strings.ReplaceAll(string(b), "\r\n", "\n"))
+	}
+
+	return messages, nil
+}
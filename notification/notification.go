@@ -0,0 +1,45 @@
+package notification
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Service interface {
+	Create(CreateParams) (Notification, error)
+	List(userId int64, limit int) ([]Notification, error)
+	CountUnread(userId int64) (int, error)
+	MarkRead(userId int64, id string) error
+	MarkAllRead(userId int64) error
+	DeleteUserNotifications(userId int64) error
+}
+
+type Kind string
+
+const (
+	KindWaitlistPromoted Kind = "waitlist_promoted"
+	KindWaitlistDemoted  Kind = "waitlist_demoted"
+	KindWaitlistOffer    Kind = "waitlist_offer"
+	KindOfferExpired     Kind = "offer_expired"
+)
+
+// A message in the in-app inbox of a user.
+type Notification struct {
+	Id        string       `db:"id"`
+	UserId    int64        `db:"user_id"`
+	Kind      Kind         `db:"kind"`
+	Title     string       `db:"title"`
+	Body      string       `db:"body"`
+	Link      string       `db:"link"` // path within the app the notification is about
+	CreatedAt time.Time    `db:"created_at"`
+	ReadAt    sql.NullTime `db:"read_at"`
+}
+
+func (n Notification) IsRead() bool {
+	return n.ReadAt.Valid
+}
+
+var (
+	ErrNoNotification = errors.New("notification not found")
+)
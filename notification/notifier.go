@@ -0,0 +1,144 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/logger"
+	"github.com/Chaldron/clay-play/mail"
+	"github.com/Chaldron/clay-play/user"
+)
+
+// Emails are plain text and can't format times for the reader, so they say UTC.
+const timeFormat = "Mon Jan 02 at 15:04 UTC"
+
+// How many emails can wait to be sent. Once full, further emails are dropped, the notifications themselves are kept.
+const emailQueueSize = 256
+
+type userGetter interface {
+	Get(int64) (user.User, error)
+}
+
+// Tells users about changes they didn't make themselves, in their inbox and by email.
+type Notifier struct {
+	notifications Service
+	users         userGetter
+	mailer        mail.Sender
+	baseURL       string
+	log           logger.Logger
+	emails        chan queuedEmail
+}
+
+type queuedEmail struct {
+	userId int64
+	msg    mail.Message
+}
+
+// baseURL is used for links in emails, they are left out if it is empty.
+func NewNotifier(notifications Service, users userGetter, mailer mail.Sender, baseURL string) *Notifier {
+	return &Notifier{
+		notifications: notifications,
+		users:         users,
+		mailer:        mailer,
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		log:           logger.NewNoopLogger(),
+		emails:        make(chan queuedEmail, emailQueueSize),
+	}
+}
+
+func (n *Notifier) SetLogger(l logger.Logger) {
+	n.log = l
+}
+
+// Sends the queued emails until the context is done, then sends whatever is still queued.
+// Emails are only sent while this runs, so that subscribers don't wait on the mail server.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case e := <-n.emails:
+			n.send(e)
+		case <-ctx.Done():
+			for {
+				select {
+				case e := <-n.emails:
+					n.send(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (n *Notifier) send(e queuedEmail) {
+	err := n.mailer.Send(e.msg)
+	if err != nil {
+		n.log.Errorf("notification send to user %d: %s", e.userId, err.Error())
+	}
+}
+
+// Subscriber for event.Service. The notification is created right away and the email is queued, see Run.
+// Failures are logged, the change itself is already committed.
+func (n *Notifier) WaitlistChanged(c event.WaitlistChanged) {
+	p := CreateParams{
+		UserId: c.UserId,
+		Link:   "/event/" + c.EventId,
+	}
+	when := c.EventStart.Format(timeFormat)
+	switch {
+	case c.SeriesId != "":
+		p.Kind = KindWaitlistPromoted
+		p.Title = "You're enrolled in " + c.EventName
+		p.Body = fmt.Sprintf("A spot opened up in every upcoming %s event, so you were moved off the series waitlist. The next one is on %s.", c.EventName, when)
+	case c.OfferExpired:
+		p.Kind = KindOfferExpired
+		p.Title = "Your spot in " + c.EventName + " was passed on"
+		p.Body = fmt.Sprintf("You didn't accept the spot you were offered in %s on %s in time, so it went to the next person on the waitlist. Respond again to rejoin the waitlist.", c.EventName, when)
+	case c.IsOffer():
+		p.Kind = KindWaitlistOffer
+		p.Title = "A spot opened up in " + c.EventName
+		p.Body = fmt.Sprintf("A spot opened up in %s on %s and it's yours if you want it. Accept it by %s, after that it goes to the next person on the waitlist.", c.EventName, when, c.OfferExpiresAt.Format(timeFormat))
+	case c.OnWaitlist:
+		p.Kind = KindWaitlistDemoted
+		p.Title = "You were moved to the waitlist for " + c.EventName
+		p.Body = fmt.Sprintf("%s on %s has fewer spots now, so you are on the waitlist. You'll be told if a spot opens up.", c.EventName, when)
+	default:
+		p.Kind = KindWaitlistPromoted
+		p.Title = "You got a spot in " + c.EventName
+		p.Body = fmt.Sprintf("A spot opened up in %s on %s, so you were moved off the waitlist. Let the studio know if you can't make it.", c.EventName, when)
+	}
+
+	_, err := n.notifications.Create(p)
+	if err != nil {
+		n.log.Errorf("notification WaitlistChanged create: %s", err.Error())
+	}
+
+	u, err := n.users.Get(c.UserId)
+	if err != nil {
+		n.log.Errorf("notification WaitlistChanged get user %d: %s", c.UserId, err.Error())
+		return
+	}
+	if u.IsDeleted() || u.Email == "" {
+		return
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\n%s\n", u.FullName, p.Body)
+	if n.baseURL != "" {
+		body += fmt.Sprintf("\n%s\n", n.baseURL+p.Link)
+	}
+	e := queuedEmail{
+		userId: c.UserId,
+		msg: mail.Message{
+			To:      u.Email,
+			Subject: p.Title,
+			Body:    body,
+		},
+	}
+	select {
+	case n.emails <- e:
+	default:
+		n.log.Errorf("notification WaitlistChanged email queue is full, dropped the email to user %d", c.UserId)
+	}
+}
@@ -0,0 +1,78 @@
+package notification_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/event"
+	"github.com/Chaldron/clay-play/mail"
+	"github.com/Chaldron/clay-play/notification"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifierWaitlistChanged(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	notificationService := notification.NewService(db)
+	userService := user.NewService(db)
+	outbox := mail.NewOutboxSender(t.TempDir(), "studio@example.com")
+	notifier := notification.NewNotifier(notificationService, userService, outbox, "https://example.com/")
+
+	u, err := userService.Create(user.CreateParams{FullName: "Potter", Email: "potter@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := userService.Create(user.CreateParams{FullName: "Gone", Email: "gone@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = userService.Delete(gone.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		notifier.Run(ctx)
+		close(done)
+	}()
+
+	start := time.Date(2030, 1, 7, 18, 0, 0, 0, time.UTC)
+	notifier.WaitlistChanged(event.WaitlistChanged{EventId: "abc", EventName: "Wheel", EventStart: start, UserId: u.Id})
+	notifier.WaitlistChanged(event.WaitlistChanged{EventId: "abc", EventName: "Wheel", EventStart: start, UserId: u.Id, OnWaitlist: true})
+	notifier.WaitlistChanged(event.WaitlistChanged{EventId: "abc", EventName: "Wheel", EventStart: start, UserId: gone.Id})
+	notifier.WaitlistChanged(event.WaitlistChanged{EventId: "abc", EventName: "Wheel", EventStart: start, UserId: u.Id, OnWaitlist: true, OfferExpiresAt: start.Add(-time.Hour)})
+	notifier.WaitlistChanged(event.WaitlistChanged{EventId: "abc", EventName: "Wheel", EventStart: start, UserId: u.Id, OnWaitlist: true, OfferExpiresAt: start.Add(-time.Hour), OfferExpired: true})
+
+	l, err := notificationService.List(u.Id, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, l, 4) {
+		kinds := []notification.Kind{}
+		for _, n := range l {
+			kinds = append(kinds, n.Kind)
+		}
+		assert.ElementsMatch(t, []notification.Kind{
+			notification.KindWaitlistPromoted, notification.KindWaitlistDemoted, notification.KindWaitlistOffer, notification.KindOfferExpired,
+		}, kinds)
+		assert.Equal(t, "/event/abc", l[0].Link)
+	}
+
+	// stopping sends whatever is still queued
+	cancel()
+	<-done
+	messages, err := outbox.Messages()
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 4, "deleted users get no email") {
+		assert.Contains(t, messages[0], "To: potter@example.com\n")
+		assert.Contains(t, messages[0], "Subject: You got a spot in Wheel\n")
+		assert.Contains(t, messages[0], "https://example.com/event/abc")
+		assert.Contains(t, messages[1], "Subject: You were moved to the waitlist for Wheel\n")
+		assert.Contains(t, messages[2], "Subject: A spot opened up in Wheel\n")
+		assert.Contains(t, messages[2], "Accept it by Mon Jan 07 at 17:00 UTC")
+		assert.Contains(t, messages[3], "Subject: Your spot in Wheel was passed on\n")
+	}
+}
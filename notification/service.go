@@ -0,0 +1,170 @@
+package notification
+
+import (
+	"database/sql"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/logger"
+	"github.com/jmoiron/sqlx"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+type service struct {
+	db  *db.DB
+	log logger.Logger
+}
+
+func NewService(db *db.DB) *service {
+	return &service{
+		db:  db,
+		log: logger.NewNoopLogger(),
+	}
+}
+
+func (s *service) SetLogger(l logger.Logger) {
+	s.log = l
+}
+
+type CreateParams struct {
+	UserId int64
+	Kind   Kind
+	Title  string
+	Body   string
+	Link   string
+}
+
+func (s *service) Create(p CreateParams) (Notification, error) {
+	s.log.Printf("notification Create params %+v", p)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Notification{}, err
+	}
+	defer tx.Rollback()
+
+	n, err := create(tx, p)
+	if err != nil {
+		return Notification{}, err
+	}
+
+	return n, tx.Commit()
+}
+
+// Lists the newest notifications of the user, unread or not.
+func (s *service) List(userId int64, limit int) ([]Notification, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT id, user_id, kind, title, body, link, created_at, read_at
+        FROM notification
+        WHERE user_id = ?
+        ORDER BY created_at DESC
+        LIMIT ?
+    `
+	args := []any{userId, limit}
+
+	notifications := []Notification{}
+	err = tx.Select(&notifications, stmt, args...)
+	return notifications, err
+}
+
+func (s *service) CountUnread(userId int64) (int, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var n int
+	err = tx.Get(&n, `SELECT COUNT(*) FROM notification WHERE user_id = ? AND read_at IS NULL`, userId)
+	return n, err
+}
+
+func (s *service) MarkRead(userId int64, id string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        UPDATE notification SET read_at = COALESCE(read_at, ?)
+        WHERE id = ? AND user_id = ?
+    `
+	args := []any{db.Now(), id, userId}
+
+	res, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoNotification
+	}
+
+	return tx.Commit()
+}
+
+func (s *service) MarkAllRead(userId int64) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE notification SET read_at = ? WHERE user_id = ? AND read_at IS NULL`, db.Now(), userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *service) DeleteUserNotifications(userId int64) error {
+	s.log.Printf("notification DeleteUserNotifications userId %d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM notification WHERE user_id = ?`, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func create(tx *sqlx.Tx, p CreateParams) (Notification, error) {
+	id, err := gonanoid.New()
+	if err != nil {
+		return Notification{}, err
+	}
+
+	n := Notification{
+		Id:        id,
+		UserId:    p.UserId,
+		Kind:      p.Kind,
+		Title:     p.Title,
+		Body:      p.Body,
+		Link:      p.Link,
+		CreatedAt: db.Now(),
+		ReadAt:    sql.NullTime{},
+	}
+
+	stmt := `
+        INSERT INTO notification (id, user_id, kind, title, body, link, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `
+	args := []any{n.Id, n.UserId, n.Kind, n.Title, n.Body, n.Link, n.CreatedAt}
+
+	_, err = tx.Exec(stmt, args...)
+	return n, err
+}
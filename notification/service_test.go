@@ -0,0 +1,57 @@
+package notification_test
+
+import (
+	"testing"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/notification"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInbox(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	notificationService := notification.NewService(db)
+
+	first, err := notificationService.Create(notification.CreateParams{UserId: 1, Kind: notification.KindWaitlistPromoted, Title: "first", Link: "/event/a"})
+	assert.NoError(t, err)
+	_, err = notificationService.Create(notification.CreateParams{UserId: 1, Kind: notification.KindWaitlistDemoted, Title: "second", Link: "/event/b"})
+	assert.NoError(t, err)
+	_, err = notificationService.Create(notification.CreateParams{UserId: 2, Kind: notification.KindWaitlistPromoted, Title: "other", Link: "/event/a"})
+	assert.NoError(t, err)
+
+	n, err := notificationService.CountUnread(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	l, err := notificationService.List(1, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, l, 2) {
+		assert.Equal(t, "second", l[0].Title, "newest first")
+		assert.False(t, l[0].IsRead())
+	}
+
+	err = notificationService.MarkRead(2, first.Id)
+	assert.ErrorIs(t, err, notification.ErrNoNotification, "only the owner can mark it read")
+	err = notificationService.MarkRead(1, first.Id)
+	assert.NoError(t, err)
+	n, err = notificationService.CountUnread(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	err = notificationService.MarkAllRead(1)
+	assert.NoError(t, err)
+	n, err = notificationService.CountUnread(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = notificationService.CountUnread(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	err = notificationService.DeleteUserNotifications(1)
+	assert.NoError(t, err)
+	l, err = notificationService.List(1, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, l)
+}
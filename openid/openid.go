@@ -0,0 +1,107 @@
+package openid
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Chaldron/clay-play/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrNoIdToken       = errors.New("no id_token in token response")
+	ErrNonceMismatch   = errors.New("id token nonce does not match")
+	ErrEmailUnverified = errors.New("email address has not been verified by the provider")
+)
+
+// Provider runs the authorization code flow with PKCE against a single OpenID Connect issuer.
+type Provider struct {
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Claims are the ID token claims used to link and update a user.
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+}
+
+// New discovers the issuer's endpoints. Returns nil if OIDC is not configured.
+func New(ctx context.Context, conf *config.Config) (*Provider, error) {
+	if conf.OIDCIssuer == "" {
+		return nil, nil
+	}
+
+	p, err := oidc.NewProvider(ctx, conf.OIDCIssuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := conf.OIDCScopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+
+	return &Provider{
+		oauth: oauth2.Config{
+			ClientID:     conf.OIDCClientId,
+			ClientSecret: conf.OIDCClientSecret,
+			RedirectURL:  conf.OIDCRedirectURL,
+			Endpoint:     p.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+		},
+		verifier: p.Verifier(&oidc.Config{ClientID: conf.OIDCClientId}),
+	}, nil
+}
+
+// AuthCodeURL returns the URL to send the user to.
+// The state, nonce and verifier must be kept until the callback.
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) string {
+	return p.oauth.AuthCodeURL(
+		state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	)
+}
+
+// Exchange trades the authorization code for tokens, verifies the ID token and returns its claims.
+func (p *Provider) Exchange(ctx context.Context, code string, nonce string, verifier string) (Claims, error) {
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Claims{}, err
+	}
+
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Claims{}, ErrNoIdToken
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if idToken.Nonce != nonce {
+		return Claims{}, ErrNonceMismatch
+	}
+
+	var c Claims
+	if err := idToken.Claims(&c); err != nil {
+		return Claims{}, err
+	}
+
+	if !c.EmailVerified {
+		return Claims{}, ErrEmailUnverified
+	}
+
+	return c, nil
+}
+
+// GenerateVerifier returns a new PKCE code verifier.
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
@@ -0,0 +1,219 @@
+package openid_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/config"
+	"github.com/Chaldron/clay-play/openid"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// issuer is an in-process stand-in for an OpenID Connect provider.
+// It issues one code per authorize request and checks the PKCE verifier when it is exchanged.
+type issuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+
+	challenge string
+	nonce     string
+}
+
+func newIssuer(t testing.TB) *issuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := &issuer{
+		key: key,
+		claims: map[string]any{
+			"sub":            "subject",
+			"email":          "a@example.com",
+			"email_verified": true,
+			"picture":        "https://example.com/a.png",
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                i.URL,
+			"authorization_endpoint":                i.URL + "/authorize",
+			"token_endpoint":                        i.URL + "/token",
+			"jwks_uri":                              i.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]any{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		i.challenge = q.Get("code_challenge")
+		i.nonce = q.Get("nonce")
+
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		rq := redirect.Query()
+		rq.Set("code", "code")
+		rq.Set("state", q.Get("state"))
+		redirect.RawQuery = rq.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != i.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   i.URL,
+			"aud":   "client",
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": i.nonce,
+		}
+		for k, v := range i.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	i.Server = httptest.NewServer(mux)
+
+	return i
+}
+
+// Follows the authorize redirect without a browser and returns the code handed back to the app.
+func authorize(t testing.TB, authURL string) (code string, state string) {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func newProvider(t testing.TB, i *issuer) *openid.Provider {
+	t.Helper()
+	p, err := openid.New(context.Background(), &config.Config{
+		OIDCIssuer:       i.URL,
+		OIDCClientId:     "client",
+		OIDCClientSecret: "secret",
+		OIDCRedirectURL:  "http://app.test/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNew(t *testing.T) {
+	t.Run("NotConfigured", func(t *testing.T) {
+		p, err := openid.New(context.Background(), &config.Config{})
+		assert.NoError(t, err)
+		assert.Nil(t, p)
+	})
+}
+
+func TestExchange(t *testing.T) {
+	t.Run("Ok", func(t *testing.T) {
+		i := newIssuer(t)
+		defer i.Close()
+		p := newProvider(t, i)
+
+		verifier := openid.GenerateVerifier()
+		code, state := authorize(t, p.AuthCodeURL("state", "nonce", verifier))
+		assert.Equal(t, "state", state)
+
+		c, err := p.Exchange(context.Background(), code, "nonce", verifier)
+		assert.NoError(t, err)
+		assert.Equal(t, "a@example.com", c.Email)
+		assert.Equal(t, "https://example.com/a.png", c.Picture)
+	})
+
+	t.Run("WrongVerifier", func(t *testing.T) {
+		i := newIssuer(t)
+		defer i.Close()
+		p := newProvider(t, i)
+
+		code, _ := authorize(t, p.AuthCodeURL("state", "nonce", openid.GenerateVerifier()))
+
+		_, err := p.Exchange(context.Background(), code, "nonce", openid.GenerateVerifier())
+		assert.Error(t, err)
+	})
+
+	t.Run("WrongNonce", func(t *testing.T) {
+		i := newIssuer(t)
+		defer i.Close()
+		p := newProvider(t, i)
+
+		verifier := openid.GenerateVerifier()
+		code, _ := authorize(t, p.AuthCodeURL("state", "nonce", verifier))
+
+		_, err := p.Exchange(context.Background(), code, "other", verifier)
+		assert.ErrorIs(t, err, openid.ErrNonceMismatch)
+	})
+
+	t.Run("EmailUnverified", func(t *testing.T) {
+		i := newIssuer(t)
+		defer i.Close()
+		i.claims["email_verified"] = false
+		p := newProvider(t, i)
+
+		verifier := openid.GenerateVerifier()
+		code, _ := authorize(t, p.AuthCodeURL("state", "nonce", verifier))
+
+		_, err := p.Exchange(context.Background(), code, "nonce", verifier)
+		assert.ErrorIs(t, err, openid.ErrEmailUnverified)
+	})
+}
@@ -0,0 +1,72 @@
+package password
+
+import (
+	"crypto/subtle"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultCost is used whenever a cost of 0 is configured.
+	DefaultCost = bcrypt.DefaultCost
+	// MinCost keeps hashing fast in tests.
+	MinCost = bcrypt.MinCost
+)
+
+// Hash returns the bcrypt hash of plain using the given cost.
+// An empty password is stored as an empty string, meaning the user cannot log in with a password.
+func Hash(plain string, cost int) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+
+	if cost == 0 {
+		cost = DefaultCost
+	}
+
+	h, err := bcrypt.GenerateFromPassword([]byte(plain), cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(h), nil
+}
+
+// IsHashed reports whether stored looks like a bcrypt hash rather than a legacy plaintext password.
+func IsHashed(stored string) bool {
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
+
+// Verify compares plain against the stored password, which may be a bcrypt hash or legacy plaintext.
+//
+// needsRehash is true when the password matched but the stored value is plaintext or was hashed with a different cost.
+func Verify(stored string, plain string, cost int) (ok bool, needsRehash bool) {
+	if stored == "" || plain == "" {
+		return false, false
+	}
+
+	if cost == 0 {
+		cost = DefaultCost
+	}
+
+	storedCost, err := bcrypt.Cost([]byte(stored))
+	if err != nil { // legacy plaintext row
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(plain)) == 1
+		return ok, ok
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(plain)); err != nil {
+		return false, false
+	}
+
+	return true, storedCost != cost
+}
+
+// dummyHash is compared against when no user exists so that lookups for unknown emails take as long as real ones.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), DefaultCost)
+
+// VerifyDummy burns roughly the same amount of time as Verify on a real hash.
+func VerifyDummy(plain string) {
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(plain))
+}
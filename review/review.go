@@ -0,0 +1,28 @@
+package review
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Service interface {
+	Get(int64) (UserReview, error)
+	List() ([]UserReview, error)
+	Request(RequestParams) error
+	Approve(int64) (UserReview, error)
+	Reject(int64) (UserReview, error)
+}
+
+type UserReview struct {
+	UserId       int64          `db:"user_id"`
+	UserFullName string         `db:"user_full_name"`
+	UserEmail    string         `db:"user_email"`
+	Comment      sql.NullString `db:"comment"`
+	CreatedAt    time.Time      `db:"created_at"`
+	UpdatedAt    time.Time      `db:"updated_at"`
+}
+
+var (
+	ErrNoReview = errors.New("no pending review found")
+)
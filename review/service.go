@@ -0,0 +1,194 @@
+package review
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+type service struct {
+	db  *db.DB
+	log logger.Logger
+}
+
+func NewService(db *db.DB) *service {
+	return &service{
+		db:  db,
+		log: logger.NewNoopLogger(),
+	}
+}
+
+func (s *service) SetLogger(l logger.Logger) {
+	s.log = l
+}
+
+func (s *service) Get(userId int64) (UserReview, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return UserReview{}, err
+	}
+	defer tx.Rollback()
+
+	r, err := get(tx, userId)
+	return r, err
+}
+
+func (s *service) List() ([]UserReview, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return []UserReview{}, err
+	}
+	defer tx.Rollback()
+
+	r, err := list(tx)
+	return r, err
+}
+
+type RequestParams struct {
+	UserId  int64
+	Comment string
+}
+
+// Creates or updates the review request of a pending user.
+func (s *service) Request(p RequestParams) error {
+	s.log.Printf("review Request params %+v", p)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = upsert(tx, p)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Marks the user as no longer pending and removes their review.
+func (s *service) Approve(userId int64) (UserReview, error) {
+	s.log.Printf("review Approve userId %d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return UserReview{}, err
+	}
+	defer tx.Rollback()
+
+	r, err := get(tx, userId)
+	if err != nil {
+		return UserReview{}, err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET is_pending = FALSE WHERE id = ?`, userId)
+	if err != nil {
+		return UserReview{}, err
+	}
+
+	err = delete(tx, userId)
+	if err != nil {
+		return UserReview{}, err
+	}
+
+	return r, tx.Commit()
+}
+
+// Removes the pending user along with their review.
+func (s *service) Reject(userId int64) (UserReview, error) {
+	s.log.Printf("review Reject userId %d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return UserReview{}, err
+	}
+	defer tx.Rollback()
+
+	r, err := get(tx, userId)
+	if err != nil {
+		return UserReview{}, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM users WHERE id = ? AND is_pending = TRUE`, userId)
+	if err != nil {
+		return UserReview{}, err
+	}
+
+	err = delete(tx, userId)
+	if err != nil {
+		return UserReview{}, err
+	}
+
+	return r, tx.Commit()
+}
+
+func get(tx *sqlx.Tx, userId int64) (UserReview, error) {
+	stmt := `
+        SELECT ur.user_id, ur.comment, ur.created_at, ur.updated_at
+            , u.full_name AS user_full_name, u.email AS user_email
+        FROM user_review ur
+        INNER JOIN users u ON ur.user_id = u.id
+        WHERE ur.user_id = ? AND u.is_pending = TRUE AND u.deleted_at IS NULL
+    `
+	args := []any{userId}
+
+	var r UserReview
+	err := tx.Get(&r, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserReview{}, ErrNoReview
+	} else if err != nil {
+		return UserReview{}, err
+	}
+
+	return r, nil
+}
+
+func list(tx *sqlx.Tx) ([]UserReview, error) {
+	stmt := `
+        SELECT ur.user_id, ur.comment, ur.created_at, ur.updated_at
+            , u.full_name AS user_full_name, u.email AS user_email
+        FROM user_review ur
+        INNER JOIN users u ON ur.user_id = u.id
+        WHERE u.is_pending = TRUE AND u.deleted_at IS NULL
+        ORDER BY ur.created_at ASC
+    `
+
+	var r []UserReview
+	err := tx.Select(&r, stmt)
+	return r, err
+}
+
+func upsert(tx *sqlx.Tx, p RequestParams) error {
+	stmt := `
+        INSERT INTO user_review (user_id, comment, created_at, updated_at)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (user_id) DO UPDATE SET
+            comment = excluded.comment,
+            updated_at = excluded.updated_at
+    `
+	now := db.Now()
+	args := []any{
+		p.UserId,
+		sql.NullString{
+			String: p.Comment,
+			Valid:  p.Comment != "",
+		},
+		now,
+		now,
+	}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
+
+func delete(tx *sqlx.Tx, userId int64) error {
+	stmt := `
+        DELETE FROM user_review
+        WHERE user_id = ?
+    `
+	args := []any{userId}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
@@ -0,0 +1,87 @@
+package review_test
+
+import (
+	"testing"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/review"
+	"github.com/Chaldron/clay-play/user"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequest(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	reviewService := review.NewService(db)
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{FullName: "pending", IsPending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = reviewService.Request(review.RequestParams{UserId: u.Id, Comment: "first"})
+	assert.NoError(t, err)
+	err = reviewService.Request(review.RequestParams{UserId: u.Id, Comment: "second"})
+	assert.NoError(t, err)
+
+	rl, err := reviewService.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rl))
+	assert.Equal(t, "pending", rl[0].UserFullName)
+	assert.Equal(t, "second", rl[0].Comment.String)
+}
+
+func TestApprove(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	reviewService := review.NewService(db)
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{FullName: "pending", IsPending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = reviewService.Request(review.RequestParams{UserId: u.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ur, err := reviewService.Approve(u.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", ur.UserFullName)
+
+	u, err = userService.Get(u.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, false, u.IsPending)
+
+	rl, err := reviewService.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(rl))
+
+	_, err = reviewService.Approve(u.Id)
+	assert.ErrorIs(t, err, review.ErrNoReview)
+}
+
+func TestReject(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	reviewService := review.NewService(db)
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{FullName: "pending", IsPending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = reviewService.Request(review.RequestParams{UserId: u.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = reviewService.Reject(u.Id)
+	assert.NoError(t, err)
+
+	_, err = userService.Get(u.Id)
+	assert.ErrorIs(t, err, user.ErrNoUser)
+}
@@ -0,0 +1,502 @@
+// Package rrule implements the subset of RFC 5545 recurrence rules needed to schedule repeating events.
+//
+// Supported parts are FREQ (DAILY, WEEKLY, MONTHLY and YEARLY), INTERVAL, COUNT, UNTIL, BYDAY and BYMONTHDAY.
+// Anything else is rejected rather than silently ignored.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalid     = errors.New("invalid recurrence rule")
+	ErrUnsupported = errors.New("unsupported recurrence rule")
+	ErrNoEnd       = errors.New("the recurrence rule needs an end, set COUNT or UNTIL")
+	ErrTooMany     = errors.New("the recurrence rule has too many occurrences")
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// A weekday in BYDAY, optionally limited to the nth one of the month, counting from the end if negative.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+
+	// UNTIL was given without a UTC offset and is a wall clock time in the timezone of the start
+	untilFloating bool
+	untilDate     bool
+}
+
+// Parse reads a rule such as "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10". A leading "RRULE:" is allowed.
+func Parse(s string) (Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return Rule{}, ErrInvalid
+	}
+
+	r := Rule{Interval: 1}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("%w: %q", ErrInvalid, part)
+		}
+		if seen[name] {
+			return Rule{}, fmt.Errorf("%w: %s given twice", ErrInvalid, name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq = Frequency(value)
+			switch r.Freq {
+			case Daily, Weekly, Monthly, Yearly:
+			default:
+				return Rule{}, fmt.Errorf("%w: FREQ=%s", ErrUnsupported, value)
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+			if err != nil || r.Interval < 1 {
+				return Rule{}, fmt.Errorf("%w: INTERVAL=%s", ErrInvalid, value)
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+			if err != nil || r.Count < 1 {
+				return Rule{}, fmt.Errorf("%w: COUNT=%s", ErrInvalid, value)
+			}
+		case "UNTIL":
+			err = r.parseUntil(value)
+			if err != nil {
+				return Rule{}, err
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(d)
+				if err != nil {
+					return Rule{}, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return Rule{}, fmt.Errorf("%w: BYMONTHDAY=%s", ErrInvalid, d)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "WKST":
+			// weeks start on Monday, which is also the default
+			if value != "MO" {
+				return Rule{}, fmt.Errorf("%w: WKST=%s", ErrUnsupported, value)
+			}
+		default:
+			return Rule{}, fmt.Errorf("%w: %s", ErrUnsupported, name)
+		}
+	}
+
+	if r.Freq == "" {
+		return Rule{}, fmt.Errorf("%w: FREQ is required", ErrInvalid)
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return Rule{}, fmt.Errorf("%w: COUNT and UNTIL can't be used together", ErrInvalid)
+	}
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != Monthly {
+			return Rule{}, fmt.Errorf("%w: numbered BYDAY is only supported with FREQ=MONTHLY", ErrUnsupported)
+		}
+	}
+	if r.Freq == Yearly && (len(r.ByDay) > 0 || len(r.ByMonthDay) > 0) {
+		return Rule{}, fmt.Errorf("%w: BYDAY and BYMONTHDAY with FREQ=YEARLY", ErrUnsupported)
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return Rule{}, fmt.Errorf("%w: BYMONTHDAY with FREQ=WEEKLY", ErrInvalid)
+	}
+
+	return r, nil
+}
+
+func (r *Rule) parseUntil(value string) error {
+	layouts := []struct {
+		layout   string
+		floating bool
+		date     bool
+	}{
+		{"20060102T150405Z", false, false},
+		{"20060102T150405", true, false},
+		{"20060102", true, true},
+	}
+
+	for _, l := range layouts {
+		t, err := time.Parse(l.layout, value)
+		if err != nil {
+			continue
+		}
+		r.Until = t
+		r.untilFloating = l.floating
+		r.untilDate = l.date
+		return nil
+	}
+
+	return fmt.Errorf("%w: UNTIL=%s", ErrInvalid, value)
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("%w: BYDAY=%s", ErrInvalid, s)
+	}
+
+	day, ok := weekdays[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("%w: BYDAY=%s", ErrInvalid, s)
+	}
+
+	n := 0
+	if prefix := s[:len(s)-2]; prefix != "" {
+		var err error
+		n, err = strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, fmt.Errorf("%w: BYDAY=%s", ErrInvalid, s)
+		}
+	}
+
+	return WeekdayNum{N: n, Day: day}, nil
+}
+
+// String returns the rule in its RFC 5545 form, without the "RRULE:" prefix.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		switch {
+		case r.untilDate:
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+		case r.untilFloating:
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405"))
+		default:
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if len(r.ByDay) > 0 {
+		days := []string{}
+		for _, d := range r.ByDay {
+			code := weekdayCodes[d.Day]
+			if d.N != 0 {
+				code = strconv.Itoa(d.N) + code
+			}
+			days = append(days, code)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := []string{}
+		for _, d := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(d))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Describe returns a short English description of the rule, e.g. "Every 2 weeks on Monday, 10 times".
+func (r Rule) Describe() string {
+	units := map[Frequency]string{Daily: "day", Weekly: "week", Monthly: "month", Yearly: "year"}
+
+	s := "Every " + units[r.Freq]
+	if r.Interval > 1 {
+		s = fmt.Sprintf("Every %d %ss", r.Interval, units[r.Freq])
+	}
+
+	if len(r.ByDay) > 0 {
+		days := []string{}
+		for _, d := range r.ByDay {
+			name := d.Day.String()
+			switch {
+			case d.N == -1:
+				name = "last " + name
+			case d.N < 0:
+				name = ordinal(-d.N) + " to last " + name
+			case d.N > 0:
+				name = ordinal(d.N) + " " + name
+			}
+			days = append(days, name)
+		}
+		s += " on " + strings.Join(days, ", ")
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := []string{}
+		for _, d := range r.ByMonthDay {
+			switch {
+			case d == -1:
+				days = append(days, "the last day")
+			case d < 0:
+				days = append(days, "the "+ordinal(-d)+" to last day")
+			default:
+				days = append(days, "the "+ordinal(d))
+			}
+		}
+		s += " on " + strings.Join(days, ", ")
+	}
+
+	switch {
+	case r.Count == 1:
+		s += ", once"
+	case r.Count > 1:
+		s += fmt.Sprintf(", %d times", r.Count)
+	case !r.Until.IsZero():
+		s += ", until " + r.Until.Format("Jan 2, 2006")
+	}
+	return s
+}
+
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+// How many periods without a single occurrence are skipped before giving up, e.g. for BYMONTHDAY=31 with FREQ=MONTHLY;INTERVAL=2 starting in February.
+const maxEmptyPeriods = 1000
+
+// All returns the start of every occurrence, beginning with dtstart which always counts as the first one.
+// Occurrences keep the wall clock time of dtstart in its location, so they don't move when daylight saving time starts or ends.
+// Rules without COUNT or UNTIL return ErrNoEnd, and rules with more than limit occurrences return ErrTooMany.
+func (r Rule) All(dtstart time.Time, limit int) ([]time.Time, error) {
+	if r.Count == 0 && r.Until.IsZero() {
+		return nil, ErrNoEnd
+	}
+
+	loc := dtstart.Location()
+	until := r.Until
+	if !until.IsZero() && r.untilFloating {
+		until = time.Date(until.Year(), until.Month(), until.Day(), until.Hour(), until.Minute(), until.Second(), 0, loc)
+		if r.untilDate {
+			until = until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+
+	done := func(t time.Time, n int) bool {
+		return (r.Count > 0 && n >= r.Count) || (!until.IsZero() && t.After(until))
+	}
+
+	occurrences := []time.Time{dtstart}
+	if done(dtstart, 0) {
+		return nil, nil
+	}
+
+	empty := 0
+	for period := 0; ; period++ {
+		candidates := r.candidates(dtstart, period)
+		if len(candidates) == 0 {
+			empty++
+			if empty > maxEmptyPeriods {
+				return occurrences, nil
+			}
+			continue
+		}
+		empty = 0
+
+		for _, t := range candidates {
+			if !t.After(dtstart) {
+				continue
+			}
+			if done(t, len(occurrences)) {
+				return occurrences, nil
+			}
+			if len(occurrences) == limit {
+				return nil, ErrTooMany
+			}
+			occurrences = append(occurrences, t)
+		}
+	}
+}
+
+// Returns the sorted occurrences within the nth period after the one dtstart falls in.
+func (r Rule) candidates(dtstart time.Time, period int) []time.Time {
+	loc := dtstart.Location()
+	h, m, s := dtstart.Clock()
+	at := func(y int, mo time.Month, d int) time.Time {
+		return time.Date(y, mo, d, h, m, s, 0, loc)
+	}
+
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		d := dtstart.AddDate(0, 0, period*r.Interval)
+		days = []time.Time{at(d.Year(), d.Month(), d.Day())}
+
+	case Weekly:
+		// weeks start on Monday
+		offset := (int(dtstart.Weekday()) + 6) % 7
+		monday := dtstart.AddDate(0, 0, period*r.Interval*7-offset)
+		byDay := r.ByDay
+		if len(byDay) == 0 {
+			byDay = []WeekdayNum{{Day: dtstart.Weekday()}}
+		}
+		for _, wd := range byDay {
+			d := monday.AddDate(0, 0, (int(wd.Day)+6)%7)
+			days = append(days, at(d.Year(), d.Month(), d.Day()))
+		}
+
+	case Monthly:
+		first := time.Date(dtstart.Year(), dtstart.Month()+time.Month(period*r.Interval), 1, 0, 0, 0, 0, loc)
+		days = r.monthDays(first, dtstart.Day(), at)
+
+	case Yearly:
+		y := dtstart.Year() + period*r.Interval
+		// e.g. February 29th only happens in leap years
+		if t := at(y, dtstart.Month(), dtstart.Day()); t.Day() == dtstart.Day() {
+			days = []time.Time{t}
+		}
+	}
+
+	// BYDAY and BYMONTHDAY limit daily occurrences
+	if r.Freq == Daily {
+		days = filter(days, func(t time.Time) bool {
+			return (len(r.ByDay) == 0 || hasWeekday(r.ByDay, t.Weekday())) &&
+				(len(r.ByMonthDay) == 0 || hasMonthDay(r.ByMonthDay, t))
+		})
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return dedupe(days)
+}
+
+func (r Rule) monthDays(first time.Time, defaultDay int, at func(int, time.Month, int) time.Time) []time.Time {
+	y, mo := first.Year(), first.Month()
+	daysInMonth := time.Date(y, mo+1, 0, 0, 0, 0, 0, first.Location()).Day()
+
+	var days []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = daysInMonth + d + 1
+			}
+			if d >= 1 && d <= daysInMonth {
+				days = append(days, at(y, mo, d))
+			}
+		}
+		// BYDAY limits the days of the month
+		if len(r.ByDay) > 0 {
+			days = filter(days, func(t time.Time) bool { return hasWeekday(r.ByDay, t.Weekday()) })
+		}
+
+	case len(r.ByDay) > 0:
+		for _, wd := range r.ByDay {
+			matches := []int{}
+			for d := 1; d <= daysInMonth; d++ {
+				if time.Date(y, mo, d, 0, 0, 0, 0, first.Location()).Weekday() == wd.Day {
+					matches = append(matches, d)
+				}
+			}
+			switch {
+			case wd.N == 0:
+				for _, d := range matches {
+					days = append(days, at(y, mo, d))
+				}
+			case wd.N > 0 && wd.N <= len(matches):
+				days = append(days, at(y, mo, matches[wd.N-1]))
+			case wd.N < 0 && -wd.N <= len(matches):
+				days = append(days, at(y, mo, matches[len(matches)+wd.N]))
+			}
+		}
+
+	default:
+		// months that are too short are skipped, as the RFC asks
+		if defaultDay <= daysInMonth {
+			days = append(days, at(y, mo, defaultDay))
+		}
+	}
+
+	return days
+}
+
+func hasWeekday(byDay []WeekdayNum, wd time.Weekday) bool {
+	for _, d := range byDay {
+		if d.Day == wd {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMonthDay(byMonthDay []int, t time.Time) bool {
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	for _, d := range byMonthDay {
+		if d == t.Day() || daysInMonth+d+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func filter(times []time.Time, keep func(time.Time) bool) []time.Time {
+	kept := []time.Time{}
+	for _, t := range times {
+		if keep(t) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func dedupe(sorted []time.Time) []time.Time {
+	out := []time.Time{}
+	for i, t := range sorted {
+		if i == 0 || !t.Equal(sorted[i-1]) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
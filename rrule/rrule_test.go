@@ -0,0 +1,115 @@
+package rrule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/rrule"
+	"github.com/stretchr/testify/assert"
+)
+
+func dates(times []time.Time) []string {
+	s := []string{}
+	for _, t := range times {
+		s = append(s, t.Format("2006-01-02 15:04"))
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	r, err := rrule.Parse("RRULE:FREQ=weekly;INTERVAL=2;BYDAY=MO,WE;COUNT=10")
+	assert.NoError(t, err)
+	assert.Equal(t, rrule.Weekly, r.Freq)
+	assert.Equal(t, 2, r.Interval)
+	assert.Equal(t, 10, r.Count)
+	assert.Equal(t, []rrule.WeekdayNum{{Day: time.Monday}, {Day: time.Wednesday}}, r.ByDay)
+	assert.Equal(t, "FREQ=WEEKLY;INTERVAL=2;COUNT=10;BYDAY=MO,WE", r.String())
+	assert.Equal(t, "Every 2 weeks on Monday, Wednesday, 10 times", r.Describe())
+
+	r, err = rrule.Parse("FREQ=MONTHLY;BYDAY=-1FR;UNTIL=20240630")
+	assert.NoError(t, err)
+	assert.Equal(t, "FREQ=MONTHLY;UNTIL=20240630;BYDAY=-1FR", r.String())
+	assert.Equal(t, "Every month on last Friday, until Jun 30, 2024", r.Describe())
+
+	invalid := []string{
+		"",
+		"COUNT=3",
+		"FREQ=WEEKLY;COUNT=0",
+		"FREQ=WEEKLY;COUNT=2;UNTIL=20240101",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;COUNT=2;COUNT=3",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+	}
+	for _, s := range invalid {
+		_, err := rrule.Parse(s)
+		assert.ErrorIs(t, err, rrule.ErrInvalid, s)
+	}
+
+	unsupported := []string{
+		"FREQ=HOURLY;COUNT=2",
+		"FREQ=WEEKLY;BYHOUR=3;COUNT=2",
+		"FREQ=WEEKLY;BYDAY=1MO;COUNT=2",
+		"FREQ=YEARLY;BYDAY=MO;COUNT=2",
+	}
+	for _, s := range unsupported {
+		_, err := rrule.Parse(s)
+		assert.ErrorIs(t, err, rrule.ErrUnsupported, s)
+	}
+}
+
+func TestAll(t *testing.T) {
+	start := time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC) // a Monday
+
+	cases := []struct {
+		rule     string
+		expected []string
+	}{
+		{"FREQ=DAILY;COUNT=3", []string{"2024-01-01 18:00", "2024-01-02 18:00", "2024-01-03 18:00"}},
+		{"FREQ=DAILY;INTERVAL=2;UNTIL=20240105T180000Z", []string{"2024-01-01 18:00", "2024-01-03 18:00", "2024-01-05 18:00"}},
+		{"FREQ=DAILY;BYDAY=SA,SU;COUNT=3", []string{"2024-01-01 18:00", "2024-01-06 18:00", "2024-01-07 18:00"}},
+		{"FREQ=WEEKLY;COUNT=3", []string{"2024-01-01 18:00", "2024-01-08 18:00", "2024-01-15 18:00"}},
+		{"FREQ=WEEKLY;BYDAY=WE,MO;COUNT=4", []string{"2024-01-01 18:00", "2024-01-03 18:00", "2024-01-08 18:00", "2024-01-10 18:00"}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=FR;UNTIL=20240131", []string{"2024-01-01 18:00", "2024-01-05 18:00", "2024-01-19 18:00"}},
+		{"FREQ=MONTHLY;BYDAY=1TU;COUNT=3", []string{"2024-01-01 18:00", "2024-01-02 18:00", "2024-02-06 18:00"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=3", []string{"2024-01-01 18:00", "2024-01-26 18:00", "2024-02-23 18:00"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3", []string{"2024-01-01 18:00", "2024-01-31 18:00", "2024-02-29 18:00"}},
+		{"FREQ=YEARLY;COUNT=2", []string{"2024-01-01 18:00", "2025-01-01 18:00"}},
+	}
+
+	for _, c := range cases {
+		r, err := rrule.Parse(c.rule)
+		assert.NoError(t, err, c.rule)
+
+		all, err := r.All(start, 100)
+		assert.NoError(t, err, c.rule)
+		assert.Equal(t, c.expected, dates(all), c.rule)
+	}
+
+	// months without a 31st are skipped
+	r, _ := rrule.Parse("FREQ=MONTHLY;COUNT=3")
+	all, err := r.All(time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2024-01-31 10:00", "2024-03-31 10:00", "2024-05-31 10:00"}, dates(all))
+
+	r, _ = rrule.Parse("FREQ=WEEKLY")
+	_, err = r.All(start, 100)
+	assert.ErrorIs(t, err, rrule.ErrNoEnd)
+
+	r, _ = rrule.Parse("FREQ=DAILY;COUNT=101")
+	_, err = r.All(start, 100)
+	assert.ErrorIs(t, err, rrule.ErrTooMany)
+}
+
+func TestAllKeepsWallClockTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone database not available")
+	}
+
+	// daylight saving time starts on March 10th 2024
+	r, _ := rrule.Parse("FREQ=WEEKLY;COUNT=2")
+	all, err := r.All(time.Date(2024, 3, 4, 18, 0, 0, 0, loc), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 18, all[1].Hour())
+	assert.Equal(t, 7*24*time.Hour-time.Hour, all[1].Sub(all[0]))
+}
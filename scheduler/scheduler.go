@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Chaldron/clay-play/clock"
+	"github.com/Chaldron/clay-play/logger"
+)
+
+// Runs background jobs at fixed intervals for as long as the app is up.
+type Scheduler struct {
+	clock clock.Clock
+	log   logger.Logger
+	jobs  []job
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func() error
+}
+
+func New(c clock.Clock) *Scheduler {
+	return &Scheduler{
+		clock: c,
+		log:   logger.NewNoopLogger(),
+	}
+}
+
+func (s *Scheduler) SetLogger(l logger.Logger) {
+	s.log = l
+}
+
+// Adds a job that runs every interval, the first time one interval after Run is called.
+// Errors are logged and the job keeps running.
+func (s *Scheduler) Every(name string, interval time.Duration, run func() error) {
+	s.jobs = append(s.jobs, job{
+		name:     name,
+		interval: interval,
+		run:      run,
+	})
+}
+
+// Runs every job in its own goroutine until the context is done, then waits for running jobs to finish.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func(j job) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-s.clock.After(j.interval):
+				}
+
+				err := j.run()
+				if err != nil {
+					s.log.Errorf("scheduler %s: %s", j.name, err.Error())
+				}
+			}
+		}(j)
+	}
+	wg.Wait()
+}
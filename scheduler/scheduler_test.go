@@ -0,0 +1,49 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/clock"
+	"github.com/Chaldron/clay-play/scheduler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	c := clock.NewFake(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	s := scheduler.New(c)
+
+	minutely, hourly := make(chan time.Time, 10), make(chan time.Time, 10)
+	s.Every("minutely", time.Minute, func() error {
+		minutely <- c.Now()
+		return errors.New("keeps running after errors")
+	})
+	s.Every("hourly", time.Hour, func() error {
+		hourly <- c.Now()
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	c.BlockUntil(2)
+	assert.Len(t, minutely, 0, "nothing runs right away")
+
+	for i := 1; i <= 60; i++ {
+		c.Advance(time.Minute)
+		assert.Equal(t, time.Date(2030, 1, 1, 12, i, 0, 0, time.UTC), <-minutely)
+		if i < 60 {
+			c.BlockUntil(2)
+		}
+	}
+	assert.Equal(t, time.Date(2030, 1, 1, 13, 0, 0, 0, time.UTC), <-hourly)
+
+	cancel()
+	<-done
+}
@@ -46,6 +46,7 @@ func Generate() (TemplateMap, error) {
 		t, err = t.ParseFiles(
 			filepath.Join(rootPath, "base.html"),
 			filepath.Join(rootPath, "header.html"),
+			filepath.Join(rootPath, "avatar.html"),
 			pagePath,
 		)
 		if err != nil {
@@ -0,0 +1,70 @@
+package totp_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+// base32 of the ASCII secret "12345678901234567890" from RFC 6238 appendix B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// the RFC lists 8 digit codes, these are their last 6 digits
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, expected := range cases {
+		code, err := totp.Code(rfcSecret, totp.Step(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, "time %d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	step, ok := totp.Validate(rfcSecret, "005924", now)
+	assert.True(t, ok)
+	assert.Equal(t, totp.Step(now), step)
+
+	// the previous code is still accepted to allow for clock drift
+	prev, err := totp.Code(rfcSecret, totp.Step(now)-1)
+	assert.NoError(t, err)
+	step, ok = totp.Validate(rfcSecret, prev, now)
+	assert.True(t, ok)
+	assert.Equal(t, totp.Step(now)-1, step)
+
+	old, err := totp.Code(rfcSecret, totp.Step(now)-5)
+	assert.NoError(t, err)
+	_, ok = totp.Validate(rfcSecret, old, now)
+	assert.False(t, ok)
+
+	_, ok = totp.Validate(rfcSecret, "005 924", now)
+	assert.True(t, ok)
+	_, ok = totp.Validate(rfcSecret, "5924", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	uri := totp.URI("Clay Play", "a@example.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Clay%20Play:a@example.com?"))
+	assert.Contains(t, uri, "secret="+secret)
+
+	qr, err := totp.QRCode(uri)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(qr, "data:image/png;base64,"))
+}
@@ -4,12 +4,25 @@ document.addEventListener("htmx:beforeSwap", (e) => {
         || e.detail.xhr.status === 400
         || e.detail.xhr.status === 401
         || e.detail.xhr.status === 403
+        || e.detail.xhr.status === 409
+        || e.detail.xhr.status === 429
     ) {
         e.detail.shouldSwap = true
         e.detail.isError = false
     }
 })
 
+// attach the CSRF token to every htmx request, see app/middleware.go
+document.addEventListener("htmx:configRequest", (e) => {
+    const token = document.cookie
+        .split("; ")
+        .find((c) => c.startsWith("csrf_token="))
+        ?.split("=")[1]
+    if (token) {
+        e.detail.headers["X-CSRF-Token"] = token
+    }
+})
+
 function formatTime(t) {
     return dayjs(t).format("ddd, MMM DD h:mm A")
 }
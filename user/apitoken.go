@@ -0,0 +1,261 @@
+package user
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrInvalidAPIToken = errors.New("invalid or revoked API token")
+	ErrNoAPIToken      = errors.New("API token not found")
+	ErrUnknownScope    = errors.New("unknown scope")
+	ErrNoScopes        = errors.New("choose at least one scope")
+)
+
+// Every API token starts with this, so that leaked tokens are easy to recognize.
+const apiTokenPrefix = "cp_"
+
+// How often the last used time of a token is written back.
+const apiTokenTouchInterval = time.Minute
+
+// A Scope limits what an API token can be used for. Scopes never grant more than the user's own permissions.
+type Scope string
+
+const (
+	ScopeEventsRead  Scope = "events:read"
+	ScopeEventsWrite Scope = "events:write"
+	ScopeGroupsRead  Scope = "groups:read"
+	ScopeGroupsWrite Scope = "groups:write"
+	ScopeUsersRead   Scope = "users:read"
+	ScopeUsersWrite  Scope = "users:write"
+)
+
+// Scopes that can be chosen when creating a token, in display order.
+var Scopes = []Scope{
+	ScopeEventsRead,
+	ScopeEventsWrite,
+	ScopeGroupsRead,
+	ScopeGroupsWrite,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+}
+
+// Stored as a comma separated list.
+type ScopeList []Scope
+
+func (l ScopeList) Value() (driver.Value, error) {
+	s := make([]string, len(l))
+	for i, scope := range l {
+		s[i] = string(scope)
+	}
+	return strings.Join(s, ","), nil
+}
+
+func (l *ScopeList) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into ScopeList", src)
+	}
+
+	*l = nil
+	for _, scope := range strings.Split(s, ",") {
+		if scope != "" {
+			*l = append(*l, Scope(scope))
+		}
+	}
+	return nil
+}
+
+type APIToken struct {
+	Id         string       `db:"id"`
+	UserId     int64        `db:"user_id"`
+	Name       string       `db:"name"`
+	Scopes     ScopeList    `db:"scopes"`
+	CreatedAt  time.Time    `db:"created_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+}
+
+func (t APIToken) HasScope(s Scope) bool {
+	return slices.Contains(t.Scopes, s)
+}
+
+type CreateAPITokenParams struct {
+	UserId int64
+	Name   string
+	Scopes []Scope
+}
+
+// Creates a token for the user. Only its hash is stored, the returned plaintext token is shown to the user once.
+func (s *service) CreateAPIToken(p CreateAPITokenParams) (APIToken, string, error) {
+	s.log.Printf("user CreateAPIToken userId:%d name:%s scopes:%v", p.UserId, p.Name, p.Scopes)
+	if len(p.Scopes) == 0 {
+		return APIToken{}, "", ErrNoScopes
+	}
+	for _, scope := range p.Scopes {
+		if !slices.Contains(Scopes, scope) {
+			return APIToken{}, "", ErrUnknownScope
+		}
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	defer tx.Rollback()
+
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return APIToken{}, "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return APIToken{}, "", err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	t := APIToken{
+		Id:        base64.RawURLEncoding.EncodeToString(idBytes),
+		UserId:    p.UserId,
+		Name:      strings.TrimSpace(p.Name),
+		Scopes:    p.Scopes,
+		CreatedAt: db.Now(),
+	}
+
+	stmt := `
+        INSERT INTO api_token (id, user_id, name, token_hash, scopes, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `
+	args := []any{t.Id, t.UserId, t.Name, hashToken(token), t.Scopes, t.CreatedAt}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+
+	return t, token, tx.Commit()
+}
+
+// Lists the tokens of the user that have not been revoked.
+func (s *service) ListAPITokens(userId int64) ([]APIToken, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT id, user_id, name, scopes, created_at, last_used_at, revoked_at FROM api_token
+        WHERE user_id = ? AND revoked_at IS NULL
+        ORDER BY created_at DESC
+    `
+	args := []any{userId}
+
+	tokens := []APIToken{}
+	err = tx.Select(&tokens, stmt, args...)
+	return tokens, err
+}
+
+// Revokes one of the user's tokens, returning it so that it can be named in the audit log.
+func (s *service) RevokeAPIToken(userId int64, id string) (APIToken, error) {
+	s.log.Printf("user RevokeAPIToken userId:%d id:%s", userId, id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return APIToken{}, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT id, user_id, name, scopes, created_at, last_used_at, revoked_at FROM api_token
+        WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+    `
+	args := []any{id, userId}
+
+	var t APIToken
+	err = tx.Get(&t, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, ErrNoAPIToken
+	} else if err != nil {
+		return APIToken{}, err
+	}
+
+	_, err = tx.Exec(`UPDATE api_token SET revoked_at = ? WHERE id = ?`, db.Now(), id)
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	return t, tx.Commit()
+}
+
+// Looks up the user a bearer token belongs to and records that the token was used.
+func (s *service) AuthenticateAPIToken(token string) (APIToken, User, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return APIToken{}, User{}, ErrInvalidAPIToken
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return APIToken{}, User{}, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT id, user_id, name, scopes, created_at, last_used_at, revoked_at FROM api_token
+        WHERE token_hash = ? AND revoked_at IS NULL
+    `
+	args := []any{hashToken(token)}
+
+	var t APIToken
+	err = tx.Get(&t, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, User{}, ErrInvalidAPIToken
+	} else if err != nil {
+		return APIToken{}, User{}, err
+	}
+
+	u, err := get(tx, t.UserId)
+	if errors.Is(err, ErrNoUser) || u.IsPending || u.DeletedAt.Valid {
+		return APIToken{}, User{}, ErrInvalidAPIToken
+	} else if err != nil {
+		return APIToken{}, User{}, err
+	}
+
+	now := db.Now()
+	if !t.LastUsedAt.Valid || now.Sub(t.LastUsedAt.Time) >= apiTokenTouchInterval {
+		_, err = tx.Exec(`UPDATE api_token SET last_used_at = ? WHERE id = ?`, now, t.Id)
+		if err != nil {
+			return APIToken{}, User{}, err
+		}
+		t.LastUsedAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	return t, u, tx.Commit()
+}
+
+func revokeUserAPITokens(tx *sqlx.Tx, userId int64) error {
+	stmt := `
+        UPDATE api_token
+        SET revoked_at = ?
+        WHERE user_id = ? AND revoked_at IS NULL
+    `
+	args := []any{db.Now(), userId}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
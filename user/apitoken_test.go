@@ -0,0 +1,130 @@
+package user_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPITokens(t *testing.T) {
+	t.Run("CreateAndAuthenticate", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		created, token, err := userService.CreateAPIToken(user.CreateAPITokenParams{
+			UserId: u.Id,
+			Name:   " Sync ",
+			Scopes: []user.Scope{user.ScopeEventsRead, user.ScopeGroupsWrite},
+		})
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(token, "cp_"))
+		assert.Equal(t, "Sync", created.Name)
+
+		// only the hash is stored
+		var n int
+		assert.NoError(t, db.Get(&n, `SELECT COUNT(*) FROM api_token WHERE token_hash = ?`, token))
+		assert.Equal(t, 0, n)
+
+		got, gotUser, err := userService.AuthenticateAPIToken(token)
+		assert.NoError(t, err)
+		assert.Equal(t, created.Id, got.Id)
+		assert.Equal(t, u.Id, gotUser.Id)
+		assert.True(t, got.HasScope(user.ScopeEventsRead))
+		assert.True(t, got.HasScope(user.ScopeGroupsWrite))
+		assert.False(t, got.HasScope(user.ScopeEventsWrite))
+		assert.True(t, got.LastUsedAt.Valid)
+
+		tokens, err := userService.ListAPITokens(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(tokens))
+		assert.Equal(t, user.ScopeList{user.ScopeEventsRead, user.ScopeGroupsWrite}, tokens[0].Scopes)
+
+		_, _, err = userService.AuthenticateAPIToken(token + "x")
+		assert.ErrorIs(t, err, user.ErrInvalidAPIToken)
+		_, _, err = userService.AuthenticateAPIToken("nope")
+		assert.ErrorIs(t, err, user.ErrInvalidAPIToken)
+	})
+
+	t.Run("InvalidScopes", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		_, _, err := userService.CreateAPIToken(user.CreateAPITokenParams{UserId: 0, Name: "a"})
+		assert.ErrorIs(t, err, user.ErrNoScopes)
+
+		_, _, err = userService.CreateAPIToken(user.CreateAPITokenParams{
+			UserId: 0,
+			Name:   "a",
+			Scopes: []user.Scope{"admin:everything"},
+		})
+		assert.ErrorIs(t, err, user.ErrUnknownScope)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		created, token, err := userService.CreateAPIToken(user.CreateAPITokenParams{
+			UserId: u.Id,
+			Name:   "a",
+			Scopes: []user.Scope{user.ScopeEventsRead},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// users can only revoke their own tokens
+		_, err = userService.RevokeAPIToken(0, created.Id)
+		assert.ErrorIs(t, err, user.ErrNoAPIToken)
+
+		revoked, err := userService.RevokeAPIToken(u.Id, created.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "a", revoked.Name)
+
+		_, _, err = userService.AuthenticateAPIToken(token)
+		assert.ErrorIs(t, err, user.ErrInvalidAPIToken)
+
+		tokens, err := userService.ListAPITokens(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(tokens))
+	})
+
+	t.Run("RevokedOnDelete", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, token, err := userService.CreateAPIToken(user.CreateAPITokenParams{
+			UserId: u.Id,
+			Name:   "a",
+			Scopes: []user.Scope{user.ScopeEventsRead},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NoError(t, userService.Delete(u.Id))
+
+		_, _, err = userService.AuthenticateAPIToken(token)
+		assert.ErrorIs(t, err, user.ErrInvalidAPIToken)
+	})
+}
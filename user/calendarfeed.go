@@ -0,0 +1,165 @@
+package user
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrInvalidCalendarFeed = errors.New("invalid or revoked calendar link")
+
+// How often the last used time of a feed is written back. Calendar apps poll, so this is coarser than for API tokens.
+const calendarFeedTouchInterval = time.Hour
+
+// The private link a user subscribes to in their calendar app. A user has at most one, creating a new one replaces it.
+type CalendarFeed struct {
+	UserId     int64        `db:"user_id"`
+	IncludeAll bool         `db:"include_all"`
+	CreatedAt  time.Time    `db:"created_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+}
+
+// Creates the calendar link of the user, revoking the previous one. Only its hash is stored, the plaintext token is shown once.
+func (s *service) CreateCalendarFeed(userId int64, includeAll bool) (CalendarFeed, string, error) {
+	s.log.Printf("user CreateCalendarFeed userId:%d includeAll:%t", userId, includeAll)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return CalendarFeed{}, "", err
+	}
+	defer tx.Rollback()
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return CalendarFeed{}, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	f := CalendarFeed{
+		UserId:     userId,
+		IncludeAll: includeAll,
+		CreatedAt:  db.Now(),
+	}
+
+	stmt := `
+        REPLACE INTO calendar_feed (user_id, token_hash, include_all, created_at, last_used_at)
+        VALUES (?, ?, ?, ?, NULL)
+    `
+	args := []any{f.UserId, hashToken(token), f.IncludeAll, f.CreatedAt}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return CalendarFeed{}, "", err
+	}
+
+	return f, token, tx.Commit()
+}
+
+// Returns nil if the user has no calendar link.
+func (s *service) GetCalendarFeed(userId int64) (*CalendarFeed, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT user_id, include_all, created_at, last_used_at FROM calendar_feed
+        WHERE user_id = ?
+    `
+	args := []any{userId}
+
+	var f CalendarFeed
+	err = tx.Get(&f, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Changes which events the feed lists, keeping the link.
+func (s *service) SetCalendarFeedIncludeAll(userId int64, includeAll bool) error {
+	s.log.Printf("user SetCalendarFeedIncludeAll userId:%d includeAll:%t", userId, includeAll)
+	res, err := s.db.Exec(`UPDATE calendar_feed SET include_all = ? WHERE user_id = ?`, includeAll, userId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidCalendarFeed
+	}
+	return nil
+}
+
+func (s *service) RevokeCalendarFeed(userId int64) error {
+	s.log.Printf("user RevokeCalendarFeed userId:%d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteCalendarFeed(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Looks up the user a calendar link belongs to and records that it was used.
+func (s *service) AuthenticateCalendarFeed(token string) (CalendarFeed, User, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return CalendarFeed{}, User{}, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT user_id, include_all, created_at, last_used_at FROM calendar_feed
+        WHERE token_hash = ?
+    `
+	args := []any{hashToken(token)}
+
+	var f CalendarFeed
+	err = tx.Get(&f, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CalendarFeed{}, User{}, ErrInvalidCalendarFeed
+	} else if err != nil {
+		return CalendarFeed{}, User{}, err
+	}
+
+	u, err := get(tx, f.UserId)
+	if errors.Is(err, ErrNoUser) || u.IsPending || u.DeletedAt.Valid {
+		return CalendarFeed{}, User{}, ErrInvalidCalendarFeed
+	} else if err != nil {
+		return CalendarFeed{}, User{}, err
+	}
+
+	now := db.Now()
+	if !f.LastUsedAt.Valid || now.Sub(f.LastUsedAt.Time) >= calendarFeedTouchInterval {
+		_, err = tx.Exec(`UPDATE calendar_feed SET last_used_at = ? WHERE user_id = ?`, now, f.UserId)
+		if err != nil {
+			return CalendarFeed{}, User{}, err
+		}
+		f.LastUsedAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	return f, u, tx.Commit()
+}
+
+func deleteCalendarFeed(tx *sqlx.Tx, userId int64) error {
+	_, err := tx.Exec(`DELETE FROM calendar_feed WHERE user_id = ?`, userId)
+	return err
+}
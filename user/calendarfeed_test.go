@@ -0,0 +1,91 @@
+package user_test
+
+import (
+	"testing"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalendarFeed(t *testing.T) {
+	t.Run("CreateAndAuthenticate", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := userService.GetCalendarFeed(u.Id)
+		assert.NoError(t, err)
+		assert.Nil(t, f)
+
+		_, token, err := userService.CreateCalendarFeed(u.Id, false)
+		assert.NoError(t, err)
+
+		got, gotUser, err := userService.AuthenticateCalendarFeed(token)
+		assert.NoError(t, err)
+		assert.Equal(t, u.Id, gotUser.Id)
+		assert.False(t, got.IncludeAll)
+		assert.True(t, got.LastUsedAt.Valid)
+
+		err = userService.SetCalendarFeedIncludeAll(u.Id, true)
+		assert.NoError(t, err)
+		got, _, err = userService.AuthenticateCalendarFeed(token)
+		assert.NoError(t, err)
+		assert.True(t, got.IncludeAll, "keeps the link")
+
+		// a new link replaces the old one
+		_, newToken, err := userService.CreateCalendarFeed(u.Id, false)
+		assert.NoError(t, err)
+		assert.NotEqual(t, token, newToken)
+		_, _, err = userService.AuthenticateCalendarFeed(token)
+		assert.ErrorIs(t, err, user.ErrInvalidCalendarFeed)
+		_, _, err = userService.AuthenticateCalendarFeed(newToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, token, err := userService.CreateCalendarFeed(u.Id, false)
+		assert.NoError(t, err)
+
+		err = userService.RevokeCalendarFeed(u.Id)
+		assert.NoError(t, err)
+		_, _, err = userService.AuthenticateCalendarFeed(token)
+		assert.ErrorIs(t, err, user.ErrInvalidCalendarFeed)
+
+		err = userService.SetCalendarFeedIncludeAll(u.Id, true)
+		assert.ErrorIs(t, err, user.ErrInvalidCalendarFeed)
+	})
+
+	t.Run("DeletedUser", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, token, err := userService.CreateCalendarFeed(u.Id, true)
+		assert.NoError(t, err)
+
+		err = userService.Delete(u.Id)
+		assert.NoError(t, err)
+		_, _, err = userService.AuthenticateCalendarFeed(token)
+		assert.ErrorIs(t, err, user.ErrInvalidCalendarFeed)
+	})
+}
@@ -0,0 +1,147 @@
+package user
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+)
+
+var ErrImportColumns = errors.New(`the first row must name the columns, with at least "name" and "email"`)
+
+// Columns of the CSV files used to import and export users. Groups are separated by semicolons.
+var ImportColumns = []string{"name", "email", "admin", "groups"}
+
+// Largest number of users that can be imported at once.
+const MaxImportRows = 500
+
+// A user to be created by an import, along with any problems found with it.
+type ImportRow struct {
+	Line     int
+	FullName string
+	Email    string
+	IsAdmin  bool
+	Groups   []string
+	Errors   []string
+}
+
+func (r ImportRow) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Reads users from a CSV file, validating each row on its own and against the other rows.
+// Emails that already have an account are not detected here, see Service.EmailsInUse.
+// Only an unreadable file or a missing header returns an error, problems with single rows are reported on the row.
+func ParseImportCSV(r io.Reader) ([]ImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, ErrImportColumns
+	} else if err != nil {
+		return nil, err
+	}
+
+	cols := map[string]int{}
+	for i, h := range header {
+		// spreadsheet apps like to start the file with a byte order mark
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	if _, ok := cols["name"]; !ok {
+		return nil, ErrImportColumns
+	}
+	if _, ok := cols["email"]; !ok {
+		return nil, ErrImportColumns
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	rows := []ImportRow{}
+	seen := map[string]int{}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		if len(rows) == MaxImportRows {
+			return nil, fmt.Errorf("at most %d users can be imported at once", MaxImportRows)
+		}
+
+		row := ImportRow{
+			Line:     line,
+			FullName: field(record, "name"),
+			Email:    strings.ToLower(field(record, "email")),
+		}
+
+		if row.FullName == "" {
+			row.Errors = append(row.Errors, "name is missing")
+		}
+
+		if row.Email == "" {
+			row.Errors = append(row.Errors, "email is missing")
+		} else if a, err := mail.ParseAddress(row.Email); err != nil || a.Address != row.Email {
+			row.Errors = append(row.Errors, "email is not valid")
+		} else if first, ok := seen[row.Email]; ok {
+			row.Errors = append(row.Errors, fmt.Sprintf("same email as line %d", first))
+		} else {
+			seen[row.Email] = line
+		}
+
+		switch strings.ToLower(field(record, "admin")) {
+		case "", "false", "no", "n", "0":
+		case "true", "yes", "y", "1":
+			row.IsAdmin = true
+		default:
+			row.Errors = append(row.Errors, `admin must be "yes" or "no"`)
+		}
+
+		for _, g := range strings.Split(field(record, "groups"), ";") {
+			if g = strings.TrimSpace(g); g != "" {
+				row.Groups = append(row.Groups, g)
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Reports which of the emails already belong to a user, including deactivated ones.
+func (s *service) EmailsInUse(emails []string) (map[string]bool, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inUse := map[string]bool{}
+	for _, email := range emails {
+		_, err := getByEmail(tx, email)
+		if errors.Is(err, ErrNoUser) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		inUse[strings.ToLower(email)] = true
+	}
+
+	return inUse, nil
+}
@@ -0,0 +1,70 @@
+package user_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	csv := "\ufeffEmail,Name,Groups,Admin\n" +
+		"a@example.com,A,Group 1; Group 2,yes\n" +
+		"\n" +
+		"A@Example.com,A again,,\n" +
+		"not an email,B,,no\n" +
+		",C,,maybe\n" +
+		"d@example.com,D\n"
+
+	rows, err := user.ParseImportCSV(strings.NewReader(csv))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(rows))
+
+	assert.True(t, rows[0].Valid())
+	assert.Equal(t, 2, rows[0].Line)
+	assert.Equal(t, "A", rows[0].FullName)
+	assert.Equal(t, "a@example.com", rows[0].Email)
+	assert.True(t, rows[0].IsAdmin)
+	assert.Equal(t, []string{"Group 1", "Group 2"}, rows[0].Groups)
+
+	// duplicates are found by lowercased email, blank lines still count towards line numbers
+	assert.Equal(t, 4, rows[1].Line)
+	assert.Equal(t, []string{"same email as line 2"}, rows[1].Errors)
+
+	assert.Equal(t, []string{"email is not valid"}, rows[2].Errors)
+	assert.Equal(t, []string{"email is missing", `admin must be "yes" or "no"`}, rows[3].Errors)
+
+	// missing trailing columns are treated as empty
+	assert.True(t, rows[4].Valid())
+	assert.False(t, rows[4].IsAdmin)
+	assert.Empty(t, rows[4].Groups)
+
+	_, err = user.ParseImportCSV(strings.NewReader("full name,mail\nA,a@example.com\n"))
+	assert.ErrorIs(t, err, user.ErrImportColumns)
+
+	_, err = user.ParseImportCSV(strings.NewReader(""))
+	assert.ErrorIs(t, err, user.ErrImportColumns)
+}
+
+func TestEmailsInUse(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{FullName: "A", Email: "a@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = userService.Create(user.CreateParams{FullName: "B", Email: "b@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, userService.Delete(u.Id))
+
+	// deactivated users still hold on to their email
+	inUse, err := userService.EmailsInUse([]string{"A@example.com", "b@example.com", "c@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a@example.com": true, "b@example.com": true}, inUse)
+}
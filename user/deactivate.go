@@ -0,0 +1,169 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrUserDeleted    = errors.New("user is deactivated")
+	ErrUserNotDeleted = errors.New("user must be deactivated first")
+	ErrAnonymized     = errors.New("anonymized users cannot be reactivated")
+)
+
+// Shown instead of the name of deactivated users wherever they are still referenced, e.g. past event responses.
+const FormerMemberName = "Former member"
+
+// Returns a SQL expression for the display name of the users table aliased as alias.
+// Deactivated users, and ids that no longer exist at all, show up as FormerMemberName. Use with a LEFT JOIN.
+func DisplayNameSQL(alias string) string {
+	return fmt.Sprintf(
+		"CASE WHEN %[1]s.id IS NULL OR %[1]s.deleted_at IS NOT NULL THEN '%[2]s' ELSE %[1]s.full_name END",
+		alias, FormerMemberName,
+	)
+}
+
+// Returns a SQL expression for the picture of the users table aliased as alias, hiding those of deactivated users.
+func PictureSQL(alias string) string {
+	return fmt.Sprintf("CASE WHEN %[1]s.deleted_at IS NULL THEN %[1]s.picture END", alias)
+}
+
+// Brings back a deactivated user. Their group memberships and event responses are not restored.
+func (s *service) Reactivate(id int64) (User, error) {
+	s.log.Printf("user Reactivate id:%d", id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	u, err := get(tx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if !u.DeletedAt.Valid {
+		return u, nil
+	}
+	if u.AnonymizedAt.Valid {
+		return User{}, ErrAnonymized
+	}
+
+	_, err = tx.Exec(`UPDATE users SET deleted_at = NULL WHERE id = ?`, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	u, err = get(tx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, tx.Commit()
+}
+
+// Removes the personal data of a deactivated user for good. The row itself stays, so that
+// everything that references it keeps working and shows them as FormerMemberName.
+func (s *service) Anonymize(id int64) (User, error) {
+	s.log.Printf("user Anonymize id:%d", id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	u, err := AnonymizeTx(tx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, tx.Commit()
+}
+
+// Does the work of Anonymize inside tx, see DeleteTx.
+func AnonymizeTx(tx *sqlx.Tx, id int64) (User, error) {
+	u, err := get(tx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if !u.DeletedAt.Valid {
+		return User{}, ErrUserNotDeleted
+	}
+	if u.AnonymizedAt.Valid {
+		return u, nil
+	}
+
+	stmt := `
+        UPDATE users
+        SET
+            full_name = ?,
+            email = ?,
+            password = '',
+            picture = NULL,
+            email_verified_at = NULL,
+            pending_email = NULL,
+            isadmin = FALSE,
+            anonymized_at = ?
+        WHERE id = ?
+    `
+	// emails are unique, and must not match any real address
+	args := []any{FormerMemberName, fmt.Sprintf("former-member-%d@invalid", id), db.Now(), id}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return User{}, err
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM user_role WHERE user_id = ?`,
+		`DELETE FROM user_token WHERE user_id = ?`,
+		`DELETE FROM user_totp WHERE user_id = ?`,
+		`DELETE FROM user_recovery_code WHERE user_id = ?`,
+		`DELETE FROM api_token WHERE user_id = ?`,
+		`DELETE FROM user_avatar WHERE user_id = ?`,
+		`DELETE FROM user_review WHERE user_id = ?`,
+	} {
+		_, err = tx.Exec(stmt, id)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	err = clearThrottle(tx, emailThrottleKey(u.Email))
+	if err != nil {
+		return User{}, err
+	}
+
+	u, err = get(tx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+func softDelete(tx *sqlx.Tx, id int64) error {
+	stmt := `
+        UPDATE users
+        SET deleted_at = ?
+        WHERE id = ? AND deleted_at IS NULL
+    `
+	args := []any{db.Now(), id}
+
+	res, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := get(tx, id)
+		return err
+	}
+	return nil
+}
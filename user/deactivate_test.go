@@ -0,0 +1,86 @@
+package user_test
+
+import (
+	"testing"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelete(t *testing.T) {
+	t.Run("Deactivates", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{FullName: "A", Email: "a@example.com", Password: "password"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NoError(t, userService.Delete(u.Id))
+
+		// the user is kept, but can no longer log in
+		u, err = userService.Get(u.Id)
+		assert.NoError(t, err)
+		assert.True(t, u.IsDeleted())
+		assert.Equal(t, "A", u.FullName)
+
+		_, err = userService.HandleFromCreds("a@example.com", "password", "")
+		assert.ErrorIs(t, err, user.ErrInvalidCredentials)
+		_, err = userService.HandleFromOIDC("a@example.com", "")
+		assert.ErrorIs(t, err, user.ErrNoUser)
+		_, err = userService.GetByEmail("a@example.com")
+		assert.ErrorIs(t, err, user.ErrNoUser)
+
+		// the email stays taken until the user is anonymized
+		_, err = userService.Create(user.CreateParams{Email: "a@example.com"})
+		assert.ErrorIs(t, err, user.ErrEmailTaken)
+
+		_, err = userService.Reactivate(u.Id)
+		assert.NoError(t, err)
+		_, err = userService.HandleFromCreds("a@example.com", "password", "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Anonymize", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{
+			FullName: "A",
+			Email:    "a@example.com",
+			Roles:    []user.Role{user.RoleEventManager},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = userService.SetAvatar(u.Id, mustPNG(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.Anonymize(u.Id)
+		assert.ErrorIs(t, err, user.ErrUserNotDeleted)
+
+		assert.NoError(t, userService.Delete(u.Id))
+		u, err = userService.Anonymize(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, user.FormerMemberName, u.FullName)
+		assert.NotContains(t, u.Email, "a@example.com")
+		assert.False(t, u.Picture.Valid)
+		assert.Empty(t, u.Roles)
+		assert.True(t, u.AnonymizedAt.Valid)
+
+		_, err = userService.GetAvatar(u.Id)
+		assert.ErrorIs(t, err, user.ErrNoAvatar)
+
+		_, err = userService.Reactivate(u.Id)
+		assert.ErrorIs(t, err, user.ErrAnonymized)
+
+		_, err = userService.Create(user.CreateParams{Email: "a@example.com"})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/Chaldron/clay-play/db"
+)
+
+var (
+	ErrErasureRequested = errors.New("erasure was already requested")
+	ErrNoErasureRequest = errors.New("no pending erasure request")
+)
+
+// Records that the user wants their personal data erased. An admin carries it out with Anonymize.
+func (s *service) RequestErasure(id int64) error {
+	s.log.Printf("user RequestErasure id:%d", id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u, err := get(tx, id)
+	if err != nil {
+		return err
+	}
+	if u.ErasureRequestedAt.Valid && !u.AnonymizedAt.Valid {
+		return ErrErasureRequested
+	}
+
+	_, err = tx.Exec(`UPDATE users SET erasure_requested_at = ? WHERE id = ?`, db.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Withdraws a pending erasure request.
+func (s *service) CancelErasure(id int64) error {
+	s.log.Printf("user CancelErasure id:%d", id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        UPDATE users
+        SET erasure_requested_at = NULL
+        WHERE id = ? AND erasure_requested_at IS NOT NULL AND anonymized_at IS NULL
+    `
+	args := []any{id}
+
+	res, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoErasureRequest
+	}
+
+	return tx.Commit()
+}
+
+// Lists the users waiting for their erasure request to be carried out, oldest request first.
+func (s *service) ListErasureRequests() ([]User, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT id, full_name, email, picture, created_at, isadmin, is_pending, email_verified_at, pending_email, deleted_at, anonymized_at, erasure_requested_at
+        FROM users
+        WHERE erasure_requested_at IS NOT NULL AND anonymized_at IS NULL
+        ORDER BY erasure_requested_at ASC
+    `
+
+	users := []User{}
+	err = tx.Select(&users, stmt)
+	return users, err
+}
@@ -0,0 +1,46 @@
+package user_test
+
+import (
+	"testing"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErasureRequest(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{FullName: "A", Email: "a@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = userService.CancelErasure(u.Id)
+	assert.ErrorIs(t, err, user.ErrNoErasureRequest)
+
+	assert.NoError(t, userService.RequestErasure(u.Id))
+	assert.ErrorIs(t, userService.RequestErasure(u.Id), user.ErrErasureRequested)
+
+	pending, err := userService.ListErasureRequests()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, u.Id, pending[0].Id)
+
+	assert.NoError(t, userService.CancelErasure(u.Id))
+	pending, err = userService.ListErasureRequests()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(pending))
+
+	// anonymizing fulfils the request
+	assert.NoError(t, userService.RequestErasure(u.Id))
+	assert.NoError(t, userService.Delete(u.Id))
+	_, err = userService.Anonymize(u.Id)
+	assert.NoError(t, err)
+
+	pending, err = userService.ListErasureRequests()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(pending))
+}
@@ -0,0 +1,392 @@
+package user
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/password"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrNoInvitation      = errors.New("invitation not found")
+	ErrInvitationPending = errors.New("this email already has a pending invitation, resend it instead")
+	ErrInvitationClosed  = errors.New("this invitation was already accepted or revoked")
+	ErrInvalidEmail      = errors.New("email is not valid")
+)
+
+// How long an invitation link can be used for. Resending an invitation starts over.
+const InvitationTTL = 7 * 24 * time.Hour
+
+// An invitation to create an account. The user is only created once the invitee accepts and picks a password.
+type Invitation struct {
+	Id         string        `db:"id"`
+	Email      string        `db:"email"`
+	FullName   string        `db:"full_name"`
+	IsAdmin    bool          `db:"isadmin"`
+	InvitedBy  int64         `db:"invited_by"`
+	CreatedAt  time.Time     `db:"created_at"`
+	SentAt     time.Time     `db:"sent_at"`
+	ExpiresAt  time.Time     `db:"expires_at"`
+	AcceptedAt sql.NullTime  `db:"accepted_at"`
+	UserId     sql.NullInt64 `db:"user_id"`
+	RevokedAt  sql.NullTime  `db:"revoked_at"`
+
+	Roles    []Role   `db:"-"`
+	GroupIds []string `db:"-"` // groups the user is added to on accepting
+}
+
+func (i Invitation) IsExpired() bool {
+	return db.Now().After(i.ExpiresAt)
+}
+
+type CreateInvitationParams struct {
+	Email     string
+	FullName  string
+	IsAdmin   bool
+	Roles     []Role
+	GroupIds  []string
+	InvitedBy int64
+}
+
+// Creates an invitation and returns the plaintext token for the invitation link. Only its hash is stored.
+func (s *service) CreateInvitation(p CreateInvitationParams) (Invitation, string, error) {
+	s.log.Printf("user CreateInvitation email:%s invitedBy:%d", p.Email, p.InvitedBy)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Invitation{}, "", err
+	}
+	defer tx.Rollback()
+
+	email := strings.ToLower(strings.TrimSpace(p.Email))
+	if a, err := mail.ParseAddress(email); err != nil || a.Address != email {
+		return Invitation{}, "", ErrInvalidEmail
+	}
+
+	_, err = getByEmail(tx, email)
+	if err == nil {
+		return Invitation{}, "", ErrEmailTaken
+	} else if !errors.Is(err, ErrNoUser) {
+		return Invitation{}, "", err
+	}
+
+	stmt := `
+        SELECT COUNT(*) FROM user_invitation
+        WHERE email = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?
+    `
+	var pending int
+	err = tx.Get(&pending, stmt, email, db.Now())
+	if err != nil {
+		return Invitation{}, "", err
+	}
+	if pending > 0 {
+		return Invitation{}, "", ErrInvitationPending
+	}
+
+	for _, r := range p.Roles {
+		if !validRole(r) {
+			return Invitation{}, "", ErrUnknownRole
+		}
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return Invitation{}, "", err
+	}
+	token, err := newInvitationToken()
+	if err != nil {
+		return Invitation{}, "", err
+	}
+
+	now := db.Now()
+	inv := Invitation{
+		Id:        base64.RawURLEncoding.EncodeToString(b),
+		Email:     email,
+		FullName:  strings.TrimSpace(p.FullName),
+		IsAdmin:   p.IsAdmin,
+		InvitedBy: p.InvitedBy,
+		CreatedAt: now,
+		SentAt:    now,
+		ExpiresAt: now.Add(InvitationTTL),
+		Roles:     p.Roles,
+		GroupIds:  p.GroupIds,
+	}
+
+	stmt = `
+        INSERT INTO user_invitation (id, email, full_name, isadmin, token_hash, invited_by, created_at, sent_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+	args := []any{inv.Id, inv.Email, inv.FullName, inv.IsAdmin, hashToken(token), inv.InvitedBy, inv.CreatedAt, inv.SentAt, inv.ExpiresAt}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return Invitation{}, "", err
+	}
+
+	for _, r := range inv.Roles {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO user_invitation_role (invitation_id, role) VALUES (?, ?)`, inv.Id, r)
+		if err != nil {
+			return Invitation{}, "", err
+		}
+	}
+	for _, g := range inv.GroupIds {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO user_invitation_group (invitation_id, group_id) VALUES (?, ?)`, inv.Id, g)
+		if err != nil {
+			return Invitation{}, "", err
+		}
+	}
+
+	return inv, token, tx.Commit()
+}
+
+func (s *service) GetInvitation(id string) (Invitation, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Invitation{}, err
+	}
+	defer tx.Rollback()
+
+	return getInvitation(tx, `id = ?`, id)
+}
+
+// Lists the invitations that were neither accepted nor revoked, including expired ones so that they can be resent.
+func (s *service) ListInvitations() ([]Invitation, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT id, email, full_name, isadmin, invited_by, created_at, sent_at, expires_at, accepted_at, user_id, revoked_at
+        FROM user_invitation
+        WHERE accepted_at IS NULL AND revoked_at IS NULL
+        ORDER BY sent_at DESC
+    `
+
+	invitations := []Invitation{}
+	err = tx.Select(&invitations, stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range invitations {
+		err = loadInvitationDetails(tx, &invitations[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return invitations, nil
+}
+
+// Replaces the token of a pending invitation and extends it, returning the new token to send.
+// The link sent before stops working.
+func (s *service) ResendInvitation(id string) (Invitation, string, error) {
+	s.log.Printf("user ResendInvitation id:%s", id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Invitation{}, "", err
+	}
+	defer tx.Rollback()
+
+	inv, err := getInvitation(tx, `id = ?`, id)
+	if err != nil {
+		return Invitation{}, "", err
+	}
+	if inv.AcceptedAt.Valid || inv.RevokedAt.Valid {
+		return Invitation{}, "", ErrInvitationClosed
+	}
+
+	token, err := newInvitationToken()
+	if err != nil {
+		return Invitation{}, "", err
+	}
+
+	now := db.Now()
+	inv.SentAt = now
+	inv.ExpiresAt = now.Add(InvitationTTL)
+
+	stmt := `
+        UPDATE user_invitation
+        SET token_hash = ?, sent_at = ?, expires_at = ?
+        WHERE id = ?
+    `
+	args := []any{hashToken(token), inv.SentAt, inv.ExpiresAt, inv.Id}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return Invitation{}, "", err
+	}
+
+	return inv, token, tx.Commit()
+}
+
+// Revokes a pending invitation, returning it so that it can be named in the audit log.
+func (s *service) RevokeInvitation(id string) (Invitation, error) {
+	s.log.Printf("user RevokeInvitation id:%s", id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Invitation{}, err
+	}
+	defer tx.Rollback()
+
+	inv, err := getInvitation(tx, `id = ?`, id)
+	if err != nil {
+		return Invitation{}, err
+	}
+	if inv.AcceptedAt.Valid || inv.RevokedAt.Valid {
+		return Invitation{}, ErrInvitationClosed
+	}
+
+	_, err = tx.Exec(`UPDATE user_invitation SET revoked_at = ? WHERE id = ?`, db.Now(), inv.Id)
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// Looks up the invitation a link was sent for. Returns ErrInvalidToken once it can no longer be accepted.
+func (s *service) GetInvitationByToken(token string) (Invitation, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Invitation{}, err
+	}
+	defer tx.Rollback()
+
+	return getOpenInvitation(tx, token)
+}
+
+type AcceptInvitationParams struct {
+	Token    string
+	FullName string
+	Password string
+}
+
+// Creates the invited user with the password they picked and adds them to the invitation's groups, skipping groups
+// deleted since. Their email counts as verified, since the link was sent there.
+func (s *service) AcceptInvitation(p AcceptInvitationParams) (Invitation, User, error) {
+	hash, err := password.Hash(p.Password, s.passwordCost)
+	if err != nil {
+		return Invitation{}, User{}, err
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Invitation{}, User{}, err
+	}
+	defer tx.Rollback()
+
+	inv, err := getOpenInvitation(tx, p.Token)
+	if err != nil {
+		return Invitation{}, User{}, err
+	}
+	s.log.Printf("user AcceptInvitation id:%s", inv.Id)
+
+	name := strings.TrimSpace(p.FullName)
+	if name == "" {
+		name = inv.FullName
+	}
+
+	u, err := create(tx, CreateParams{
+		FullName: name,
+		Email:    inv.Email,
+		Password: hash,
+		IsAdmin:  inv.IsAdmin,
+		Roles:    inv.Roles,
+	})
+	if err != nil {
+		return Invitation{}, User{}, err
+	}
+
+	now := db.Now()
+	_, err = tx.Exec(`UPDATE users SET email_verified_at = ? WHERE id = ?`, now, u.Id)
+	if err != nil {
+		return Invitation{}, User{}, err
+	}
+
+	_, err = tx.Exec(`UPDATE user_invitation SET accepted_at = ?, user_id = ? WHERE id = ?`, now, u.Id, inv.Id)
+	if err != nil {
+		return Invitation{}, User{}, err
+	}
+
+	stmt := `
+        INSERT INTO user_group_member (group_id, user_id, created_at)
+        SELECT id, ?, ? FROM user_group
+        WHERE is_deleted = FALSE AND id IN (SELECT group_id FROM user_invitation_group WHERE invitation_id = ?)
+        ON CONFLICT (group_id, user_id) DO NOTHING
+    `
+	args := []any{u.Id, now, inv.Id}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return Invitation{}, User{}, err
+	}
+	inv.AcceptedAt = sql.NullTime{Time: now, Valid: true}
+	inv.UserId = sql.NullInt64{Int64: u.Id, Valid: true}
+
+	u, err = get(tx, u.Id)
+	if err != nil {
+		return Invitation{}, User{}, err
+	}
+
+	return inv, u, tx.Commit()
+}
+
+func newInvitationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func getInvitation(tx *sqlx.Tx, where string, args ...any) (Invitation, error) {
+	stmt := `
+        SELECT id, email, full_name, isadmin, invited_by, created_at, sent_at, expires_at, accepted_at, user_id, revoked_at
+        FROM user_invitation
+        WHERE ` + where
+
+	var inv Invitation
+	err := tx.Get(&inv, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Invitation{}, ErrNoInvitation
+	} else if err != nil {
+		return Invitation{}, err
+	}
+
+	err = loadInvitationDetails(tx, &inv)
+	return inv, err
+}
+
+func getOpenInvitation(tx *sqlx.Tx, token string) (Invitation, error) {
+	inv, err := getInvitation(tx, `token_hash = ?`, hashToken(token))
+	if errors.Is(err, ErrNoInvitation) {
+		return Invitation{}, ErrInvalidToken
+	} else if err != nil {
+		return Invitation{}, err
+	}
+
+	if inv.AcceptedAt.Valid || inv.RevokedAt.Valid || inv.IsExpired() {
+		return Invitation{}, ErrInvalidToken
+	}
+	return inv, nil
+}
+
+func loadInvitationDetails(tx *sqlx.Tx, inv *Invitation) error {
+	inv.Roles = []Role{}
+	err := tx.Select(&inv.Roles, `SELECT role FROM user_invitation_role WHERE invitation_id = ? ORDER BY role`, inv.Id)
+	if err != nil {
+		return err
+	}
+
+	inv.GroupIds = []string{}
+	return tx.Select(&inv.GroupIds, `SELECT group_id FROM user_invitation_group WHERE invitation_id = ?`, inv.Id)
+}
@@ -0,0 +1,115 @@
+package user_test
+
+import (
+	"testing"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvitation(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	userService := user.NewService(db)
+
+	inv, token, err := userService.CreateInvitation(user.CreateInvitationParams{
+		Email:     "New@Example.com",
+		FullName:  "New",
+		Roles:     []user.Role{user.RoleGroupOwner},
+		GroupIds:  []string{"g1"},
+		InvitedBy: 0,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "new@example.com", inv.Email)
+
+	_, _, err = userService.CreateInvitation(user.CreateInvitationParams{Email: "new@example.com"})
+	assert.ErrorIs(t, err, user.ErrInvitationPending)
+	_, _, err = userService.CreateInvitation(user.CreateInvitationParams{Email: "admin@example.com"})
+	assert.ErrorIs(t, err, user.ErrEmailTaken)
+	_, _, err = userService.CreateInvitation(user.CreateInvitationParams{Email: "not an email"})
+	assert.ErrorIs(t, err, user.ErrInvalidEmail)
+
+	pending, err := userService.ListInvitations()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, []user.Role{user.RoleGroupOwner}, pending[0].Roles)
+	assert.Equal(t, []string{"g1"}, pending[0].GroupIds)
+
+	// resending replaces the link
+	_, newToken, err := userService.ResendInvitation(inv.Id)
+	assert.NoError(t, err)
+	_, err = userService.GetInvitationByToken(token)
+	assert.ErrorIs(t, err, user.ErrInvalidToken)
+
+	accepted, u, err := userService.AcceptInvitation(user.AcceptInvitationParams{
+		Token:    newToken,
+		FullName: "New Name",
+		Password: "password",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g1"}, accepted.GroupIds)
+	assert.Equal(t, "New Name", u.FullName)
+	assert.True(t, u.EmailVerifiedAt.Valid)
+	assert.True(t, u.HasRole(user.RoleGroupOwner))
+
+	_, err = userService.HandleFromCreds("new@example.com", "password", "")
+	assert.NoError(t, err)
+
+	// links only work once
+	_, _, err = userService.AcceptInvitation(user.AcceptInvitationParams{Token: newToken, Password: "password"})
+	assert.ErrorIs(t, err, user.ErrInvalidToken)
+	_, _, err = userService.ResendInvitation(inv.Id)
+	assert.ErrorIs(t, err, user.ErrInvitationClosed)
+
+	pending, err = userService.ListInvitations()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(pending))
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	userService := user.NewService(db)
+
+	inv, token, err := userService.CreateInvitation(user.CreateInvitationParams{Email: "new@example.com"})
+	assert.NoError(t, err)
+
+	_, err = userService.RevokeInvitation(inv.Id)
+	assert.NoError(t, err)
+	_, err = userService.RevokeInvitation(inv.Id)
+	assert.ErrorIs(t, err, user.ErrInvitationClosed)
+	_, err = userService.RevokeInvitation("missing")
+	assert.ErrorIs(t, err, user.ErrNoInvitation)
+
+	_, _, err = userService.AcceptInvitation(user.AcceptInvitationParams{Token: token, Password: "password"})
+	assert.ErrorIs(t, err, user.ErrInvalidToken)
+
+	// the email can be invited again
+	_, _, err = userService.CreateInvitation(user.CreateInvitationParams{Email: "new@example.com"})
+	assert.NoError(t, err)
+}
+
+func TestAcceptInvitationGroups(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	userService := user.NewService(db)
+
+	stmt := `
+        INSERT INTO user_group (id, created_at, creator_id, is_deleted, name, invite_id)
+        VALUES ('kept', datetime(), 0, FALSE, 'Kept', 'i1'), ('deleted', datetime(), 0, TRUE, 'Deleted', 'i2')
+    `
+	if _, err := db.Exec(stmt); err != nil {
+		t.Fatal(err)
+	}
+
+	_, token, err := userService.CreateInvitation(user.CreateInvitationParams{Email: "new@example.com", GroupIds: []string{"kept", "deleted"}})
+	assert.NoError(t, err)
+	_, u, err := userService.AcceptInvitation(user.AcceptInvitationParams{Token: token, FullName: "New", Password: "password"})
+	assert.NoError(t, err)
+
+	var groupIds []string
+	err = db.Select(&groupIds, `SELECT group_id FROM user_group_member WHERE user_id = ?`, u.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kept"}, groupIds, "deleted groups are skipped")
+}
@@ -0,0 +1,262 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/password"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrWrongPassword   = errors.New("current password is incorrect")
+	ErrNoAvatar        = errors.New("no avatar found")
+	ErrInvalidAvatar   = errors.New("avatar must be a PNG, JPEG, GIF or WebP image")
+	ErrAvatarTooLarge  = errors.New("avatar must be smaller than 1 MB")
+	ErrProfileRequired = errors.New("name and email are required")
+)
+
+// Largest avatar that can be uploaded, in bytes.
+const MaxAvatarSize = 1 << 20
+
+var avatarContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+type Avatar struct {
+	UserId      int64     `db:"user_id"`
+	ContentType string    `db:"content_type"`
+	Data        []byte    `db:"data"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+// Path the avatar is served from. The version makes browsers fetch it again after it changed.
+func avatarURL(userId int64, updatedAt time.Time) string {
+	return fmt.Sprintf("/user/%d/avatar?v=%d", userId, updatedAt.Unix())
+}
+
+type UpdateProfileParams struct {
+	Id       int64
+	FullName string
+	Email    string
+}
+
+// Lets users change their own name and email. A changed email is only kept as pending, it replaces the
+// current one once the user verifies it, see VerifyEmail. Until then logins and password resets keep using
+// the current address. Any verification links sent before stop working.
+func (s *service) UpdateProfile(p UpdateProfileParams) (User, error) {
+	s.log.Printf("user UpdateProfile id:%d", p.Id)
+	p.FullName = strings.TrimSpace(p.FullName)
+	p.Email = strings.ToLower(strings.TrimSpace(p.Email))
+	if p.FullName == "" || p.Email == "" {
+		return User{}, ErrProfileRequired
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	old, err := get(tx, p.Id)
+	if err != nil {
+		return User{}, err
+	}
+
+	// asking for the current email again cancels a pending change
+	pending := sql.NullString{String: p.Email, Valid: p.Email != old.Email}
+	if pending != old.PendingEmail {
+		if pending.Valid {
+			_, err := getByEmail(tx, p.Email)
+			if err == nil {
+				return User{}, ErrEmailTaken
+			} else if !errors.Is(err, ErrNoUser) {
+				return User{}, err
+			}
+		}
+
+		err = expireTokens(tx, p.Id, TokenEmailVerify)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	stmt := `
+        UPDATE users
+        SET full_name = ?, pending_email = ?
+        WHERE id = ?
+    `
+	args := []any{p.FullName, pending, p.Id}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return User{}, err
+	}
+
+	u, err := get(tx, p.Id)
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, tx.Commit()
+}
+
+type ChangePasswordParams struct {
+	Id        int64
+	Current   string
+	New       string
+	KeepToken string // session that stays logged in, every other session of the user is logged out
+}
+
+// Changes the password of the user after checking their current one.
+func (s *service) ChangePassword(p ChangePasswordParams) error {
+	s.log.Printf("user ChangePassword id:%d", p.Id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u, err := get(tx, p.Id)
+	if err != nil {
+		return err
+	}
+
+	var hash string
+	err = tx.Get(&hash, `SELECT password FROM users WHERE id = ?`, u.Id)
+	if err != nil {
+		return err
+	}
+
+	if ok, _ := password.Verify(hash, p.Current, s.passwordCost); !ok {
+		return ErrWrongPassword
+	}
+
+	hash, err = password.Hash(p.New, s.passwordCost)
+	if err != nil {
+		return err
+	}
+
+	err = setPassword(tx, u.Id, hash)
+	if err != nil {
+		return err
+	}
+
+	err = expireTokens(tx, u.Id, TokenPasswordReset)
+	if err != nil {
+		return err
+	}
+
+	err = deleteOtherSessions(tx, u.Id, p.KeepToken)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Stores an uploaded avatar for the user and points their picture at it, replacing any picture from OpenID Connect.
+func (s *service) SetAvatar(userId int64, data []byte) (User, error) {
+	s.log.Printf("user SetAvatar id:%d size:%d", userId, len(data))
+	if len(data) > MaxAvatarSize {
+		return User{}, ErrAvatarTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if !slices.Contains(avatarContentTypes, contentType) {
+		return User{}, ErrInvalidAvatar
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	now := db.Now()
+	stmt := `
+        REPLACE INTO user_avatar (user_id, content_type, data, updated_at)
+        VALUES (?, ?, ?, ?)
+    `
+	args := []any{userId, contentType, data, now}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = setPicture(tx, userId, avatarURL(userId, now))
+	if err != nil {
+		return User{}, err
+	}
+
+	u, err := get(tx, userId)
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, tx.Commit()
+}
+
+func (s *service) GetAvatar(userId int64) (Avatar, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Avatar{}, err
+	}
+	defer tx.Rollback()
+
+	a, err := getAvatar(tx, userId)
+	return a, err
+}
+
+// Removes the uploaded avatar. The next OpenID Connect login sets the picture from the provider again.
+func (s *service) DeleteAvatar(userId int64) error {
+	s.log.Printf("user DeleteAvatar id:%d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteAvatar(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET picture = NULL WHERE id = ?`, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func getAvatar(tx *sqlx.Tx, userId int64) (Avatar, error) {
+	stmt := `
+        SELECT user_id, content_type, data, updated_at FROM user_avatar
+        WHERE user_id = ?
+    `
+	args := []any{userId}
+
+	var a Avatar
+	err := tx.Get(&a, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Avatar{}, ErrNoAvatar
+	}
+	return a, err
+}
+
+func hasAvatar(tx *sqlx.Tx, userId int64) (bool, error) {
+	var n int
+	err := tx.Get(&n, `SELECT COUNT(*) FROM user_avatar WHERE user_id = ?`, userId)
+	return n > 0, err
+}
+
+func deleteAvatar(tx *sqlx.Tx, userId int64) error {
+	_, err := tx.Exec(`DELETE FROM user_avatar WHERE user_id = ?`, userId)
+	return err
+}
@@ -0,0 +1,224 @@
+package user_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestUpdateProfile(t *testing.T) {
+	t.Run("EmailChangeNeedsVerification", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{FullName: "A", Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := userService.IssueToken(u.Id, user.TokenEmailVerify, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err = userService.VerifyEmail(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// renaming keeps the email verified
+		u, err = userService.UpdateProfile(user.UpdateProfileParams{Id: u.Id, FullName: " B ", Email: "a@example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, "B", u.FullName)
+		assert.True(t, u.EmailVerifiedAt.Valid)
+
+		pending, err := userService.IssueToken(u.Id, user.TokenEmailVerify, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, err = userService.UpdateProfile(user.UpdateProfileParams{Id: u.Id, FullName: "B", Email: "New@Example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, "a@example.com", u.Email, "the email only changes once the new one is verified")
+		assert.Equal(t, "new@example.com", u.PendingEmail.String)
+		assert.True(t, u.EmailVerifiedAt.Valid)
+
+		// links sent to the old address no longer verify the new one
+		_, err = userService.VerifyEmail(pending)
+		assert.ErrorIs(t, err, user.ErrInvalidToken)
+
+		// until then the current address is the one to log in and reset the password with
+		_, err = userService.GetByEmail("new@example.com")
+		assert.ErrorIs(t, err, user.ErrNoUser)
+		_, err = userService.HandleFromOIDC("a@example.com", "")
+		assert.NoError(t, err)
+
+		token, err = userService.IssueToken(u.Id, user.TokenEmailVerify, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err = userService.VerifyEmail(token)
+		assert.NoError(t, err)
+		assert.Equal(t, "new@example.com", u.Email)
+		assert.False(t, u.PendingEmail.Valid)
+		assert.True(t, u.EmailVerifiedAt.Valid)
+	})
+
+	t.Run("CancelEmailChange", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{FullName: "A", Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, err = userService.UpdateProfile(user.UpdateProfileParams{Id: u.Id, FullName: "A", Email: "new@example.com"})
+		assert.NoError(t, err)
+		pending, err := userService.IssueToken(u.Id, user.TokenEmailVerify, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, err = userService.UpdateProfile(user.UpdateProfileParams{Id: u.Id, FullName: "A", Email: "a@example.com"})
+		assert.NoError(t, err)
+		assert.False(t, u.PendingEmail.Valid)
+		_, err = userService.VerifyEmail(pending)
+		assert.ErrorIs(t, err, user.ErrInvalidToken)
+	})
+
+	t.Run("EmailTakenBeforeVerified", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{FullName: "A", Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = userService.UpdateProfile(user.UpdateProfileParams{Id: u.Id, FullName: "A", Email: "new@example.com"})
+		assert.NoError(t, err)
+		token, err := userService.IssueToken(u.Id, user.TokenEmailVerify, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.Create(user.CreateParams{FullName: "B", Email: "new@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.VerifyEmail(token)
+		assert.ErrorIs(t, err, user.ErrEmailTaken)
+	})
+
+	t.Run("EmailTaken", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{FullName: "A", Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.UpdateProfile(user.UpdateProfileParams{Id: u.Id, FullName: "A", Email: "admin@example.com"})
+		assert.ErrorIs(t, err, user.ErrEmailTaken)
+
+		_, err = userService.UpdateProfile(user.UpdateProfileParams{Id: u.Id, FullName: " ", Email: "a@example.com"})
+		assert.ErrorIs(t, err, user.ErrProfileRequired)
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{Email: "a@example.com", Password: "oldpassword"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustStartSession(t, db, userService, u.Id, "current")
+	mustStartSession(t, db, userService, u.Id, "other")
+
+	err = userService.ChangePassword(user.ChangePasswordParams{Id: u.Id, Current: "wrong", New: "newpassword", KeepToken: "current"})
+	assert.ErrorIs(t, err, user.ErrWrongPassword)
+
+	err = userService.ChangePassword(user.ChangePasswordParams{Id: u.Id, Current: "oldpassword", New: "newpassword", KeepToken: "current"})
+	assert.NoError(t, err)
+
+	_, err = userService.HandleFromCreds("a@example.com", "oldpassword", "")
+	assert.ErrorIs(t, err, user.ErrInvalidCredentials)
+	_, err = userService.HandleFromCreds("a@example.com", "newpassword", "")
+	assert.NoError(t, err)
+
+	// only the session that changed the password stays logged in
+	assert.NoError(t, userService.TouchSession(user.TouchSessionParams{Token: "current"}))
+	assert.ErrorIs(t, userService.TouchSession(user.TouchSessionParams{Token: "other"}), user.ErrNoSession)
+}
+
+func TestAvatar(t *testing.T) {
+	t.Run("SetAndDelete", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustVerifyEmail(t, userService, u.Id)
+
+		data := mustPNG(t)
+		u, err = userService.SetAvatar(u.Id, data)
+		assert.NoError(t, err)
+		assert.Contains(t, u.Picture.String, "/user/1/avatar")
+
+		a, err := userService.GetAvatar(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "image/png", a.ContentType)
+		assert.Equal(t, data, a.Data)
+
+		// an uploaded avatar wins over the OpenID Connect picture
+		u, err = userService.HandleFromOIDC("a@example.com", "https://example.com/a.png")
+		assert.NoError(t, err)
+		assert.Contains(t, u.Picture.String, "/user/1/avatar")
+
+		assert.NoError(t, userService.DeleteAvatar(u.Id))
+		_, err = userService.GetAvatar(u.Id)
+		assert.ErrorIs(t, err, user.ErrNoAvatar)
+
+		u, err = userService.Get(u.Id)
+		assert.NoError(t, err)
+		assert.False(t, u.Picture.Valid)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		_, err := userService.SetAvatar(0, []byte("<svg onload=alert(1)></svg>"))
+		assert.ErrorIs(t, err, user.ErrInvalidAvatar)
+
+		_, err = userService.SetAvatar(0, make([]byte, user.MaxAvatarSize+1))
+		assert.ErrorIs(t, err, user.ErrAvatarTooLarge)
+	})
+}
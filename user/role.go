@@ -0,0 +1,118 @@
+package user
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// A Role grants a fixed set of permissions on top of what every member can do.
+// Admins are tracked by the isadmin column and implicitly hold every permission.
+type Role string
+
+const (
+	RoleAdmin         Role = "admin"
+	RoleEventManager  Role = "event_manager"
+	RoleStudioMonitor Role = "studio_monitor"
+	RoleGroupOwner    Role = "group_owner"
+)
+
+// Roles that can be assigned from the user admin pages, in display order.
+var AssignableRoles = []Role{RoleEventManager, RoleStudioMonitor, RoleGroupOwner}
+
+func (r Role) Label() string {
+	switch r {
+	case RoleAdmin:
+		return "Admin"
+	case RoleEventManager:
+		return "Event Manager"
+	case RoleStudioMonitor:
+		return "Studio Monitor"
+	case RoleGroupOwner:
+		return "Group Owner"
+	}
+	return string(r)
+}
+
+type Permission string
+
+const (
+	PermManageUsers  Permission = "user:manage"
+	PermViewAuditlog Permission = "auditlog:view"
+
+	PermCreateEvents          Permission = "event:create"
+	PermManageEvents          Permission = "event:manage"           // edit and delete any event
+	PermManageMonitoredEvents Permission = "event:manage_monitored" // edit events the user is the studio monitor of
+
+	PermCreateGroups    Permission = "group:create"
+	PermManageGroups    Permission = "group:manage"     // edit and delete any group
+	PermManageOwnGroups Permission = "group:manage_own" // edit groups the user created
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleEventManager:  {PermCreateEvents, PermManageEvents},
+	RoleStudioMonitor: {PermManageMonitoredEvents},
+	RoleGroupOwner:    {PermCreateGroups, PermManageOwnGroups},
+}
+
+var ErrUnknownRole = errors.New("unknown role")
+
+func validRole(r Role) bool {
+	return slices.Contains(AssignableRoles, r)
+}
+
+// Reports whether any of the roles grants the permission.
+func hasPermission(roles []Role, p Permission) bool {
+	for _, r := range roles {
+		if slices.Contains(rolePermissions[r], p) {
+			return true
+		}
+	}
+	return false
+}
+
+func listRoles(tx *sqlx.Tx, userId int64) ([]Role, error) {
+	stmt := `
+        SELECT role FROM user_role
+        WHERE user_id = ?
+        ORDER BY role
+    `
+	args := []any{userId}
+
+	roles := []Role{}
+	err := tx.Select(&roles, stmt, args...)
+	return roles, err
+}
+
+func setRoles(tx *sqlx.Tx, userId int64, roles []Role) error {
+	for _, r := range roles {
+		if !validRole(r) {
+			return ErrUnknownRole
+		}
+	}
+
+	stmt := `
+        DELETE FROM user_role
+        WHERE user_id = ?
+    `
+	_, err := tx.Exec(stmt, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range roles {
+		stmt := `
+            INSERT OR IGNORE INTO user_role (user_id, role)
+            VALUES (?, ?)
+        `
+		args := []any{userId, r}
+
+		_, err := tx.Exec(stmt, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
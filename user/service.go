@@ -8,18 +8,23 @@ import (
 
 	"github.com/Chaldron/clay-play/db"
 	"github.com/Chaldron/clay-play/logger"
+	"github.com/Chaldron/clay-play/password"
 	"github.com/jmoiron/sqlx"
 )
 
 type service struct {
-	db  *db.DB
-	log logger.Logger
+	db           *db.DB
+	log          logger.Logger
+	passwordCost int
+	throttle     ThrottlePolicy
 }
 
 func NewService(db *db.DB) *service {
 	return &service{
-		db:  db,
-		log: logger.NewNoopLogger(),
+		db:           db,
+		log:          logger.NewNoopLogger(),
+		passwordCost: password.DefaultCost,
+		throttle:     DefaultThrottlePolicy,
 	}
 }
 
@@ -27,6 +32,10 @@ func (s *service) SetLogger(l logger.Logger) {
 	s.log = l
 }
 
+func (s *service) SetPasswordCost(cost int) {
+	s.passwordCost = cost
+}
+
 func (s *service) Get(id int64) (User, error) {
 	tx, err := s.db.Beginx()
 	if err != nil {
@@ -49,18 +58,95 @@ func (s *service) GetAll() ([]User, error) {
 	return u, err
 }
 
-func (s *service) HandleFromCreds(email string, password string) (User, error) {
+func (s *service) GetByEmail(email string) (User, error) {
 	tx, err := s.db.Beginx()
 	if err != nil {
 		return User{}, err
 	}
 	defer tx.Rollback()
 
-	user, err := getByExternal(tx, email, password)
+	u, err := getByEmail(tx, email)
+	if err == nil && u.DeletedAt.Valid {
+		return User{}, ErrNoUser
+	}
+	u.Password = ""
+	return u, err
+}
+
+// Looks up the user by email and verifies the password against the stored hash.
+// Passwords still stored as plaintext, or hashed with an outdated cost, are rehashed on success.
+//
+// Failed attempts are counted per email and per IP, see ThrottlePolicy.
+func (s *service) HandleFromCreds(email string, plain string, ip string) (User, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	emailKey := emailThrottleKey(email)
+	ipKey := ""
+	if ip != "" {
+		ipKey = ipThrottleKey(ip)
+	}
+
+	user, err := getByEmail(tx, email)
+	userId := user.Id
+	if errors.Is(err, ErrNoUser) || user.DeletedAt.Valid {
+		userId = -1
+	} else if err != nil {
+		return User{}, err
+	}
+
+	err = checkThrottle(tx, userId, emailKey, ipKey)
 	if err != nil {
 		return User{}, err
 	}
 
+	ok := false
+	needsRehash := false
+	if userId == -1 {
+		password.VerifyDummy(plain)
+	} else {
+		ok, needsRehash = password.Verify(user.Password, plain, s.passwordCost)
+	}
+
+	if !ok {
+		lockout := recordFailure(tx, s.throttle, userId, emailKey, ipKey)
+		var lockoutErr *LockoutError
+		if lockout != nil && !errors.As(lockout, &lockoutErr) {
+			return User{}, lockout
+		}
+
+		if err := tx.Commit(); err != nil {
+			return User{}, err
+		}
+
+		if lockoutErr != nil {
+			s.log.Printf("login locked out %+v", lockoutErr)
+			return User{}, lockoutErr
+		}
+		return User{}, ErrInvalidCredentials
+	}
+
+	err = clearThrottle(tx, emailKey)
+	if err != nil {
+		return User{}, err
+	}
+
+	if needsRehash {
+		hash, err := password.Hash(plain, s.passwordCost)
+		if err != nil {
+			return User{}, err
+		}
+
+		err = setPassword(tx, user.Id, hash)
+		if err != nil {
+			return User{}, err
+		}
+		s.log.Printf("rehashed password for user %d", user.Id)
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return User{}, err
@@ -69,15 +155,59 @@ func (s *service) HandleFromCreds(email string, password string) (User, error) {
 	return user, nil
 }
 
+// Links a user that signed in with OpenID Connect by their verified email, updating their picture from the provider
+// unless they uploaded their own avatar. The email of the local account has to be verified as well, otherwise anyone
+// who set it on their account would be handed the provider's user.
+func (s *service) HandleFromOIDC(email string, picture string) (User, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := getByEmail(tx, email)
+	if err != nil {
+		return User{}, err
+	}
+	if user.DeletedAt.Valid {
+		return User{}, ErrNoUser
+	}
+	if !user.EmailVerifiedAt.Valid {
+		return User{}, ErrEmailNotVerified
+	}
+
+	uploaded, err := hasAvatar(tx, user.Id)
+	if err != nil {
+		return User{}, err
+	}
+
+	if picture != "" && picture != user.Picture.String && !uploaded {
+		err = setPicture(tx, user.Id, picture)
+		if err != nil {
+			return User{}, err
+		}
+		user.Picture = sql.NullString{String: picture, Valid: true}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// Deactivates the user, logs them out everywhere and revokes their API tokens and calendar link.
+// The user is kept so that their history still resolves, see Anonymize for removing their personal data.
 func (s *service) Delete(id int64) error {
-	s.log.Printf("user Delete id %s", id)
+	s.log.Printf("user Delete id %d", id)
 	tx, err := s.db.Beginx()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	err = delete(tx, id)
+	err = DeleteTx(tx, id)
 	if err != nil {
 		return err
 	}
@@ -85,14 +215,54 @@ func (s *service) Delete(id int64) error {
 	return tx.Commit()
 }
 
+// Does the work of Delete inside tx, for services that remove more of the user's data in the same transaction.
+func DeleteTx(tx *sqlx.Tx, id int64) error {
+	err := softDelete(tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = deleteUserSessions(tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = revokeUserAPITokens(tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = deleteCalendarFeed(tx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, purpose := range []TokenPurpose{TokenPasswordReset, TokenEmailVerify} {
+		err = expireTokens(tx, id, purpose)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type CreateParams struct {
-	FullName string
-	Email    string
-	Password string
-	IsAdmin  bool
+	FullName  string
+	Email     string
+	Password  string
+	IsAdmin   bool
+	IsPending bool
+	Roles     []Role
 }
 
 func (s *service) Create(p CreateParams) (User, error) {
+	hash, err := password.Hash(p.Password, s.passwordCost)
+	if err != nil {
+		return User{}, err
+	}
+	p.Password = hash
+
 	tx, err := s.db.Beginx()
 	if err != nil {
 		return User{}, err
@@ -118,20 +288,58 @@ type UpdateParams struct {
 	Email    string
 	Password string
 	IsAdmin  bool
+	Roles    []Role
 }
 
+// Updates the user. Taking away the admin flag or a role logs them out everywhere,
+// since their sessions still carry the old permissions.
 func (s *service) Update(p UpdateParams) (User, error) {
+	hash, err := password.Hash(p.Password, s.passwordCost)
+	if err != nil {
+		return User{}, err
+	}
+	p.Password = hash
+	p.Email = strings.ToLower(strings.TrimSpace(p.Email))
+
 	tx, err := s.db.Beginx()
 	if err != nil {
 		return User{}, err
 	}
 	defer tx.Rollback()
 
+	old, err := get(tx, p.Id)
+	if err != nil {
+		return User{}, err
+	}
+
+	// a new address has to be verified again before SSO logins link to it, and pending changes are dropped
+	if p.Email != old.Email {
+		_, err := getByEmail(tx, p.Email)
+		if err == nil {
+			return User{}, ErrEmailTaken
+		} else if !errors.Is(err, ErrNoUser) {
+			return User{}, err
+		}
+
+		err = expireTokens(tx, p.Id, TokenEmailVerify)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
 	u, err := update(tx, p)
 	if err != nil {
 		return User{}, err
 	}
 
+	if isDemotion(old, u) {
+		s.log.Printf("user %d demoted, revoking sessions", u.Id)
+		err = deleteUserSessions(tx, u.Id)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return User{}, err
@@ -142,7 +350,7 @@ func (s *service) Update(p UpdateParams) (User, error) {
 
 func get(tx *sqlx.Tx, id int64) (User, error) {
 	stmt := `
-        SELECT id, full_name, email, created_at, isadmin FROM users
+        SELECT id, full_name, email, picture, created_at, isadmin, is_pending, email_verified_at, pending_email, deleted_at, anonymized_at, erasure_requested_at FROM users
         WHERE id = ?
     `
 	args := []any{id}
@@ -155,12 +363,17 @@ func get(tx *sqlx.Tx, id int64) (User, error) {
 		return User{}, err
 	}
 
+	user.Roles, err = listRoles(tx, user.Id)
+	if err != nil {
+		return User{}, err
+	}
+
 	return user, nil
 }
 
 func getAll(tx *sqlx.Tx) ([]User, error) {
 	stmt := `
-        SELECT id, full_name, email, created_at, isadmin FROM users
+        SELECT id, full_name, email, picture, created_at, isadmin, is_pending, email_verified_at, pending_email, deleted_at, anonymized_at, erasure_requested_at FROM users
     `
 
 	var users []User
@@ -171,17 +384,34 @@ func getAll(tx *sqlx.Tx) ([]User, error) {
 		return nil, err
 	}
 
+	var roles []struct {
+		UserId int64 `db:"user_id"`
+		Role   Role  `db:"role"`
+	}
+	err = tx.Select(&roles, `SELECT user_id, role FROM user_role ORDER BY role`)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := map[int64][]Role{}
+	for _, r := range roles {
+		byUser[r.UserId] = append(byUser[r.UserId], r.Role)
+	}
+	for i := range users {
+		users[i].Roles = byUser[users[i].Id]
+	}
+
 	return users, nil
 }
 
-func getByExternal(tx *sqlx.Tx, email string, password string) (User, error) {
+func getByEmail(tx *sqlx.Tx, email string) (User, error) {
 	stmt := `
         SELECT 
-            id, full_name, created_at, email, isadmin
+            id, full_name, created_at, email, password, picture, isadmin, is_pending, email_verified_at, pending_email, deleted_at, anonymized_at, erasure_requested_at
         FROM users
-        WHERE email = ? AND password = ?
+        WHERE email = ?
     `
-	args := []any{email, password}
+	args := []any{strings.ToLower(email)}
 
 	var user User
 	err := tx.Get(&user, stmt, args...)
@@ -191,13 +421,27 @@ func getByExternal(tx *sqlx.Tx, email string, password string) (User, error) {
 		return User{}, err
 	}
 
+	user.Roles, err = listRoles(tx, user.Id)
+	if err != nil {
+		return User{}, err
+	}
+
 	return user, nil
 }
 
 func create(tx *sqlx.Tx, p CreateParams) (User, error) {
+	if p.Email != "" {
+		_, err := getByEmail(tx, p.Email)
+		if err == nil {
+			return User{}, ErrEmailTaken
+		} else if !errors.Is(err, ErrNoUser) {
+			return User{}, err
+		}
+	}
+
 	stmt := `
-        INSERT INTO users (full_name, email, password, created_at, isadmin)
-        VALUES (?, ?, ?, ?, ?)
+        INSERT INTO users (full_name, email, password, created_at, isadmin, is_pending)
+        VALUES (?, ?, ?, ?, ?, ?)
     `
 	args := []any{
 		p.FullName,
@@ -205,6 +449,7 @@ func create(tx *sqlx.Tx, p CreateParams) (User, error) {
 		p.Password,
 		time.Now().UTC(),
 		p.IsAdmin,
+		p.IsPending,
 	}
 
 	u, err := tx.Exec(stmt, args...)
@@ -213,6 +458,11 @@ func create(tx *sqlx.Tx, p CreateParams) (User, error) {
 	}
 
 	newId, _ := u.LastInsertId()
+	err = setRoles(tx, newId, p.Roles)
+	if err != nil {
+		return User{}, err
+	}
+
 	newUser, err := get(tx, newId)
 	if err != nil {
 		return User{}, err
@@ -226,6 +476,8 @@ func update(tx *sqlx.Tx, p UpdateParams) (User, error) {
 		UPDATE users
 		SET 
 			full_name = ?, 
+			email_verified_at = CASE WHEN email = ? THEN email_verified_at ELSE NULL END,
+			pending_email = CASE WHEN email = ? THEN pending_email ELSE NULL END,
 			email = ?, 
 			password = CASE WHEN ? = '' THEN password ELSE ? END, 
 			isadmin = ?
@@ -234,6 +486,8 @@ func update(tx *sqlx.Tx, p UpdateParams) (User, error) {
 	args := []any{
 		p.FullName,
 		p.Email,
+		p.Email,
+		p.Email,
 		p.Password,
 		p.Password,
 		p.IsAdmin,
@@ -245,6 +499,11 @@ func update(tx *sqlx.Tx, p UpdateParams) (User, error) {
 		return User{}, err
 	}
 
+	err = setRoles(tx, p.Id, p.Roles)
+	if err != nil {
+		return User{}, err
+	}
+
 	newUser, err := get(tx, p.Id)
 	if err != nil {
 		return User{}, err
@@ -253,12 +512,25 @@ func update(tx *sqlx.Tx, p UpdateParams) (User, error) {
 	return newUser, nil
 }
 
-func delete(tx *sqlx.Tx, id int64) error {
+func setPassword(tx *sqlx.Tx, id int64, hash string) error {
 	stmt := `
-        DELETE FROM users
+        UPDATE users
+        SET password = ?
         WHERE id = ?
     `
-	args := []any{id}
+	args := []any{hash, id}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
+
+func setPicture(tx *sqlx.Tx, id int64, picture string) error {
+	stmt := `
+        UPDATE users
+        SET picture = ?
+        WHERE id = ?
+    `
+	args := []any{picture, id}
 
 	_, err := tx.Exec(stmt, args...)
 	return err
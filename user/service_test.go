@@ -0,0 +1,303 @@
+package user_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/password"
+	"github.com/Chaldron/clay-play/user"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+// Verifies the user's email the way clicking the emailed link does.
+func mustVerifyEmail(t *testing.T, userService user.Service, userId int64) {
+	t.Helper()
+
+	token, err := userService.IssueToken(userId, user.TokenEmailVerify, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = userService.VerifyEmail(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	t.Run("HashesPassword", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+		userService.SetPasswordCost(password.MinCost)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stored string
+		err = db.Get(&stored, "SELECT password FROM users WHERE id = ?", u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NotEqual(t, "secret", stored)
+		assert.True(t, password.IsHashed(stored))
+	})
+
+	t.Run("EmailTaken", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		_, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.Create(user.CreateParams{Email: "A@example.com"})
+		assert.ErrorIs(t, err, user.ErrEmailTaken)
+	})
+}
+
+func TestHandleFromCreds(t *testing.T) {
+	t.Run("Ok", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+		userService.SetPasswordCost(password.MinCost)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := userService.HandleFromCreds("A@example.com", "secret", "")
+		assert.NoError(t, err)
+		assert.Equal(t, u.Id, found.Id)
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+		userService.SetPasswordCost(password.MinCost)
+
+		_, err := userService.Create(user.CreateParams{Email: "a@example.com", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.HandleFromCreds("a@example.com", "wrong", "")
+		assert.ErrorIs(t, err, user.ErrInvalidCredentials)
+
+		_, err = userService.HandleFromCreds("nobody@example.com", "secret", "")
+		assert.ErrorIs(t, err, user.ErrInvalidCredentials)
+	})
+
+	t.Run("EmptyPasswordCannotLogin", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		_, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.HandleFromCreds("a@example.com", "", "")
+		assert.ErrorIs(t, err, user.ErrInvalidCredentials)
+	})
+
+	t.Run("RehashesPlaintext", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+		userService.SetPasswordCost(password.MinCost)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// simulate a row written before passwords were hashed
+		_, err = db.Exec("UPDATE users SET password = 'legacy' WHERE id = ?", u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.HandleFromCreds("a@example.com", "legacy", "")
+		assert.NoError(t, err)
+
+		var stored string
+		err = db.Get(&stored, "SELECT password FROM users WHERE id = ?", u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, password.IsHashed(stored))
+
+		_, err = userService.HandleFromCreds("a@example.com", "legacy", "")
+		assert.NoError(t, err)
+	})
+}
+
+func TestDefaultAdminPasswordHashed(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+
+	var stored string
+	err := db.Get(&stored, "SELECT password FROM users WHERE id = 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, password.IsHashed(stored))
+
+	_, err = user.NewService(db).HandleFromCreds("admin@example.com", "admin", "")
+	assert.NoError(t, err)
+}
+
+func TestHandleFromOIDC(t *testing.T) {
+	t.Run("LinksByEmail", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustVerifyEmail(t, userService, u.Id)
+
+		found, err := userService.HandleFromOIDC("A@example.com", "https://example.com/a.png")
+		assert.NoError(t, err)
+		assert.Equal(t, u.Id, found.Id)
+
+		u, err = userService.Get(u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "https://example.com/a.png", u.Picture.String)
+	})
+
+	t.Run("NoUser", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+
+		_, err := user.NewService(db).HandleFromOIDC("nobody@example.com", "")
+		assert.ErrorIs(t, err, user.ErrNoUser)
+	})
+
+	t.Run("UnverifiedEmail", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		_, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.HandleFromOIDC("a@example.com", "")
+		assert.ErrorIs(t, err, user.ErrEmailNotVerified, "anyone could have put the email on their account")
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	t.Run("ChangedEmailNeedsVerification", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{FullName: "A", Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustVerifyEmail(t, userService, u.Id)
+
+		u, err = userService.Update(user.UpdateParams{Id: u.Id, FullName: "A", Email: "a@example.com"})
+		assert.NoError(t, err)
+		assert.True(t, u.EmailVerifiedAt.Valid, "keeps the verification of an unchanged email")
+
+		u, err = userService.Update(user.UpdateParams{Id: u.Id, FullName: "A", Email: " B@Example.com "})
+		assert.NoError(t, err)
+		assert.Equal(t, "b@example.com", u.Email)
+		assert.False(t, u.EmailVerifiedAt.Valid)
+
+		_, err = userService.HandleFromOIDC("b@example.com", "")
+		assert.ErrorIs(t, err, user.ErrEmailNotVerified)
+	})
+
+	t.Run("EmailTaken", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		if _, err := userService.Create(user.CreateParams{Email: "a@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		u, err := userService.Create(user.CreateParams{Email: "b@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.Update(user.UpdateParams{Id: u.Id, Email: "A@example.com"})
+		assert.ErrorIs(t, err, user.ErrEmailTaken)
+	})
+}
+
+func TestRoles(t *testing.T) {
+	t.Run("CreateAndUpdate", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com", Roles: []user.Role{user.RoleStudioMonitor}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []user.Role{user.RoleStudioMonitor}, u.Roles)
+		assert.True(t, u.ToSessionUser().Can(user.PermManageMonitoredEvents))
+		assert.False(t, u.ToSessionUser().Can(user.PermManageEvents))
+
+		u, err = userService.Update(user.UpdateParams{
+			Id:    u.Id,
+			Email: u.Email,
+			Roles: []user.Role{user.RoleEventManager, user.RoleGroupOwner},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.ElementsMatch(t, []user.Role{user.RoleEventManager, user.RoleGroupOwner}, u.Roles)
+
+		all, err := userService.GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range all {
+			if a.Id == u.Id {
+				assert.ElementsMatch(t, u.Roles, a.Roles)
+			}
+		}
+
+		found, err := userService.GetByEmail("a@example.com")
+		assert.NoError(t, err)
+		assert.True(t, found.ToSessionUser().Can(user.PermManageEvents))
+		assert.False(t, found.ToSessionUser().Can(user.PermManageUsers))
+	})
+
+	t.Run("UnknownRole", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		_, err := userService.Create(user.CreateParams{Email: "a@example.com", Roles: []user.Role{"superuser"}})
+		assert.ErrorIs(t, err, user.ErrUnknownRole)
+	})
+
+	t.Run("AdminCanDoEverything", func(t *testing.T) {
+		su := user.SessionUser{Id: 1, IsAdmin: true}
+		assert.True(t, su.Can(user.PermManageUsers))
+		assert.True(t, su.Can(user.PermManageGroups))
+	})
+}
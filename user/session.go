@@ -0,0 +1,373 @@
+package user
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrNoSession = errors.New("session not found")
+
+// How often the last seen time of a session is written back, so that not every request costs a write.
+const sessionTouchInterval = time.Minute
+
+// A login session of a user. The session data itself is kept by scs in the sessions table,
+// this only tracks who it belongs to and where it is used from.
+type Session struct {
+	Id         string    `db:"id"`
+	UserId     int64     `db:"user_id"`
+	Token      string    `db:"token"`
+	UserAgent  string    `db:"user_agent"`
+	IP         string    `db:"ip"`
+	CreatedAt  time.Time `db:"created_at"`
+	LastSeenAt time.Time `db:"last_seen_at"`
+}
+
+// A short human readable description of the browser and OS, e.g. "Firefox on Linux".
+func (s Session) Device() string {
+	ua := s.UserAgent
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+
+	os := ""
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		os = "iOS"
+	case strings.Contains(ua, "Android"):
+		os = "Android"
+	case strings.Contains(ua, "Windows"):
+		os = "Windows"
+	case strings.Contains(ua, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(ua, "Linux"):
+		os = "Linux"
+	}
+
+	if os == "" {
+		return browser
+	}
+	return browser + " on " + os
+}
+
+type StartSessionParams struct {
+	UserId    int64
+	Token     string
+	PrevToken string // token the session had before it was renewed, if any
+	UserAgent string
+	IP        string
+}
+
+// Records a freshly authenticated session token.
+func (s *service) StartSession(p StartSessionParams) error {
+	s.log.Printf("user StartSession userId:%d ip:%s", p.UserId, p.IP)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteStaleSessions(tx)
+	if err != nil {
+		return err
+	}
+
+	if p.PrevToken != "" {
+		err = deleteSession(tx, p.PrevToken)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = createSession(tx, p)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type TouchSessionParams struct {
+	UserId    int64
+	Token     string
+	UserAgent string
+	IP        string
+}
+
+// Updates the last seen time and IP of the session. Sessions from before they were tracked are recorded the first time
+// they are used, so that nobody is logged out by the upgrade.
+// Returns ErrNoSession if the session was revoked or has expired, or the user was deactivated.
+func (s *service) TouchSession(p TouchSessionParams) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sess, err := getSessionByToken(tx, p.Token)
+	if errors.Is(err, ErrNoSession) {
+		err = adoptSession(tx, p)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	} else if err != nil {
+		return err
+	}
+
+	now := db.Now()
+	if now.Sub(sess.LastSeenAt) < sessionTouchInterval && sess.IP == p.IP {
+		return nil
+	}
+
+	stmt := `
+        UPDATE user_session
+        SET last_seen_at = ?, ip = ?
+        WHERE token = ?
+    `
+	args := []any{now, p.IP, p.Token}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Forgets the session, e.g. after the user logged out.
+func (s *service) EndSession(token string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteSession(tx, token)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Lists the active sessions of the user, most recently seen first.
+func (s *service) ListSessions(userId int64) ([]Session, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	sessions, err := listSessions(tx, userId)
+	return sessions, err
+}
+
+// Logs out a single session of the user.
+func (s *service) RevokeSession(userId int64, id string) error {
+	s.log.Printf("user RevokeSession userId:%d id:%s", userId, id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+        SELECT token FROM user_session
+        WHERE id = ? AND user_id = ?
+    `
+	args := []any{id, userId}
+
+	var token string
+	err = tx.Get(&token, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoSession
+	} else if err != nil {
+		return err
+	}
+
+	err = deleteSession(tx, token)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Logs the user out everywhere.
+func (s *service) RevokeSessions(userId int64) error {
+	s.log.Printf("user RevokeSessions userId:%d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteUserSessions(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Reports whether going from the old to the new user takes away any of their permissions.
+func isDemotion(old User, new User) bool {
+	if old.IsAdmin && !new.IsAdmin {
+		return true
+	}
+	for _, r := range old.Roles {
+		if !slices.Contains(new.Roles, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func createSession(tx *sqlx.Tx, p StartSessionParams) error {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	stmt := `
+        INSERT INTO user_session (id, user_id, token, user_agent, ip, created_at, last_seen_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `
+	now := db.Now()
+	args := []any{
+		base64.RawURLEncoding.EncodeToString(b),
+		p.UserId,
+		p.Token,
+		p.UserAgent,
+		p.IP,
+		now,
+		now,
+	}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
+
+// Records a session that scs still holds but that was never tracked. Revoking a session removes it from scs as well,
+// so this can't bring back a session that was logged out.
+func adoptSession(tx *sqlx.Tx, p TouchSessionParams) error {
+	stmt := `
+        SELECT COUNT(*) FROM sessions
+        WHERE token = ? AND julianday('now') < expiry
+            AND EXISTS (SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)
+    `
+	args := []any{p.Token, p.UserId}
+
+	var n int
+	err := tx.Get(&n, stmt, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoSession
+	}
+
+	return createSession(tx, StartSessionParams{
+		UserId:    p.UserId,
+		Token:     p.Token,
+		UserAgent: p.UserAgent,
+		IP:        p.IP,
+	})
+}
+
+func getSessionByToken(tx *sqlx.Tx, token string) (Session, error) {
+	stmt := `
+        SELECT id, user_id, token, user_agent, ip, created_at, last_seen_at FROM user_session
+        WHERE token = ?
+    `
+	args := []any{token}
+
+	var sess Session
+	err := tx.Get(&sess, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrNoSession
+	}
+	return sess, err
+}
+
+func listSessions(tx *sqlx.Tx, userId int64) ([]Session, error) {
+	stmt := `
+        SELECT us.id, us.user_id, us.token, us.user_agent, us.ip, us.created_at, us.last_seen_at
+        FROM user_session us
+        INNER JOIN sessions s ON s.token = us.token
+        WHERE us.user_id = ? AND julianday('now') < s.expiry
+        ORDER BY us.last_seen_at DESC
+    `
+	args := []any{userId}
+
+	sessions := []Session{}
+	err := tx.Select(&sessions, stmt, args...)
+	return sessions, err
+}
+
+// Removes the session from both our table and the scs store, which logs it out.
+func deleteSession(tx *sqlx.Tx, token string) error {
+	_, err := tx.Exec(`DELETE FROM sessions WHERE token = ?`, token)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_session WHERE token = ?`, token)
+	return err
+}
+
+func deleteUserSessions(tx *sqlx.Tx, userId int64) error {
+	return deleteOtherSessions(tx, userId, "")
+}
+
+// Removes every session of the user except the one with the given token.
+func deleteOtherSessions(tx *sqlx.Tx, userId int64, keepToken string) error {
+	stmt := `
+        DELETE FROM sessions
+        WHERE token IN (SELECT token FROM user_session WHERE user_id = ? AND token != ?)
+    `
+	args := []any{userId, keepToken}
+
+	_, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_session WHERE user_id = ? AND token != ?`, args...)
+	return err
+}
+
+// Sessions that expired or were removed by scs are no longer worth keeping.
+// Sessions created in the last minute are kept, since scs only stores them at the end of the request.
+func deleteStaleSessions(tx *sqlx.Tx) error {
+	stmt := `
+        DELETE FROM user_session
+        WHERE created_at < ?
+            AND token NOT IN (SELECT token FROM sessions WHERE julianday('now') < expiry)
+    `
+	args := []any{db.Now().Add(-time.Minute)}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
@@ -0,0 +1,197 @@
+package user_test
+
+import (
+	"testing"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+// Stands in for scs committing the session at the end of the request.
+func mustStartSession(t *testing.T, d *db.DB, userService user.Service, userId int64, token string) {
+	t.Helper()
+
+	err := userService.StartSession(user.StartSessionParams{
+		UserId:    userId,
+		Token:     token,
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+		IP:        "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.Exec(`INSERT INTO sessions (token, data, expiry) VALUES (?, x'00', julianday('now') + 1)`, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countScsSessions(t *testing.T, d *db.DB) int {
+	t.Helper()
+
+	var n int
+	if err := d.Get(&n, `SELECT COUNT(*) FROM sessions`); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSessions(t *testing.T) {
+	t.Run("ListAndRevoke", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := userService.Create(user.CreateParams{Email: "b@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustStartSession(t, db, userService, u.Id, "t1")
+		mustStartSession(t, db, userService, u.Id, "t2")
+		mustStartSession(t, db, userService, other.Id, "t3")
+
+		sessions, err := userService.ListSessions(u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 2, len(sessions))
+		assert.Equal(t, "Firefox on Linux", sessions[0].Device())
+
+		// users can only revoke their own sessions
+		err = userService.RevokeSession(other.Id, sessions[0].Id)
+		assert.ErrorIs(t, err, user.ErrNoSession)
+
+		err = userService.RevokeSession(u.Id, sessions[0].Id)
+		assert.NoError(t, err)
+
+		sessions, err = userService.ListSessions(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(sessions))
+		assert.Equal(t, 2, countScsSessions(t, db))
+	})
+
+	t.Run("Touch", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustStartSession(t, db, userService, u.Id, "t1")
+
+		err = userService.TouchSession(user.TouchSessionParams{Token: "t1", IP: "10.0.0.1"})
+		assert.NoError(t, err)
+
+		sessions, err := userService.ListSessions(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", sessions[0].IP)
+
+		err = userService.EndSession("t1")
+		assert.NoError(t, err)
+
+		err = userService.TouchSession(user.TouchSessionParams{Token: "t1", IP: "10.0.0.1"})
+		assert.ErrorIs(t, err, user.ErrNoSession)
+	})
+
+	t.Run("AdoptsUntrackedSession", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		gone, err := userService.Create(user.CreateParams{Email: "b@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, userService.Delete(gone.Id))
+
+		// sessions from before they were tracked only exist in the scs store
+		for _, token := range []string{"t1", "t2"} {
+			_, err = db.Exec(`INSERT INTO sessions (token, data, expiry) VALUES (?, x'00', julianday('now') + 1)`, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = userService.TouchSession(user.TouchSessionParams{UserId: u.Id, Token: "t1", IP: "10.0.0.1"})
+		assert.NoError(t, err)
+		sessions, err := userService.ListSessions(u.Id)
+		assert.NoError(t, err)
+		if assert.Len(t, sessions, 1) {
+			assert.Equal(t, "10.0.0.1", sessions[0].IP)
+		}
+
+		err = userService.TouchSession(user.TouchSessionParams{UserId: gone.Id, Token: "t2"})
+		assert.ErrorIs(t, err, user.ErrNoSession, "deactivated users stay logged out")
+		err = userService.TouchSession(user.TouchSessionParams{UserId: u.Id, Token: "unknown"})
+		assert.ErrorIs(t, err, user.ErrNoSession)
+	})
+
+	t.Run("RenewReplacesPrevToken", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustStartSession(t, db, userService, u.Id, "t1")
+
+		err = userService.StartSession(user.StartSessionParams{UserId: u.Id, Token: "t2", PrevToken: "t1"})
+		assert.NoError(t, err)
+
+		err = userService.TouchSession(user.TouchSessionParams{Token: "t1"})
+		assert.ErrorIs(t, err, user.ErrNoSession)
+		assert.NoError(t, userService.TouchSession(user.TouchSessionParams{Token: "t2"}))
+	})
+
+	t.Run("RevokedOnDemotion", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com", IsAdmin: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustStartSession(t, db, userService, u.Id, "t1")
+
+		// renaming keeps the user logged in
+		_, err = userService.Update(user.UpdateParams{Id: u.Id, FullName: "A", Email: u.Email, IsAdmin: true})
+		assert.NoError(t, err)
+		assert.NoError(t, userService.TouchSession(user.TouchSessionParams{Token: "t1", IP: "127.0.0.1"}))
+
+		_, err = userService.Update(user.UpdateParams{Id: u.Id, FullName: "A", Email: u.Email, IsAdmin: false})
+		assert.NoError(t, err)
+		assert.ErrorIs(t, userService.TouchSession(user.TouchSessionParams{Token: "t1", IP: "127.0.0.1"}), user.ErrNoSession)
+		assert.Equal(t, 0, countScsSessions(t, db))
+	})
+
+	t.Run("RevokedOnDelete", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustStartSession(t, db, userService, u.Id, "t1")
+
+		err = userService.Delete(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, countScsSessions(t, db))
+	})
+}
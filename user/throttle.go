@@ -0,0 +1,228 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// ThrottlePolicy controls how failed logins are slowed down.
+//
+// Once a key (an email or an IP) reaches Threshold failures, every further failure locks it for
+// Base, doubling each time up to Max. Failures are forgotten once the key has been quiet for Max.
+type ThrottlePolicy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+}
+
+var DefaultThrottlePolicy = ThrottlePolicy{
+	Threshold: 5,
+	Base:      30 * time.Second,
+	Max:       time.Hour,
+}
+
+// IPs are shared by many users so they get more attempts before being throttled.
+const ipThresholdMultiplier = 4
+
+// LockoutError is returned by HandleFromCreds while an email or IP is locked out.
+type LockoutError struct {
+	UserId  int64 // -1 if the email does not belong to a user
+	ByIP    bool
+	Until   time.Time
+	Started bool // true if this attempt is the one that caused the lockout
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again after %s", e.Until.Format(time.RFC3339))
+}
+
+func (e *LockoutError) Is(target error) bool {
+	return target == ErrLockedOut
+}
+
+type Lockout struct {
+	Failures    int          `db:"failures"`
+	LockedUntil sql.NullTime `db:"locked_until"`
+}
+
+func (l Lockout) IsLocked() bool {
+	return l.LockedUntil.Valid && db.Now().Before(l.LockedUntil.Time)
+}
+
+func (s *service) SetThrottlePolicy(p ThrottlePolicy) {
+	s.throttle = p
+}
+
+// Returns the throttle state of the user's email.
+func (s *service) GetLockout(id int64) (Lockout, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Lockout{}, err
+	}
+	defer tx.Rollback()
+
+	u, err := get(tx, id)
+	if err != nil {
+		return Lockout{}, err
+	}
+
+	l, err := getThrottle(tx, emailThrottleKey(u.Email))
+	return l, err
+}
+
+// Clears the failed attempts and any lockout on the user's email.
+func (s *service) Unlock(id int64) (User, error) {
+	s.log.Printf("user Unlock id %d", id)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	u, err := get(tx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = clearThrottle(tx, emailThrottleKey(u.Email))
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, tx.Commit()
+}
+
+func emailThrottleKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Returns a LockoutError if any of the keys are currently locked.
+func checkThrottle(tx *sqlx.Tx, userId int64, emailKey string, ipKey string) error {
+	for _, key := range []string{emailKey, ipKey} {
+		if key == "" {
+			continue
+		}
+		l, err := getThrottle(tx, key)
+		if err != nil {
+			return err
+		}
+		if l.IsLocked() {
+			return &LockoutError{
+				UserId: userId,
+				ByIP:   key == ipKey,
+				Until:  l.LockedUntil.Time,
+			}
+		}
+	}
+
+	return nil
+}
+
+// Records a failure against every key, returning a LockoutError if one of them became locked.
+func recordFailure(tx *sqlx.Tx, p ThrottlePolicy, userId int64, emailKey string, ipKey string) error {
+	var lockout error
+	for _, key := range []string{emailKey, ipKey} {
+		if key == "" {
+			continue
+		}
+
+		threshold := p.Threshold
+		if key == ipKey {
+			threshold *= ipThresholdMultiplier
+		}
+
+		until, err := incrementThrottle(tx, p, key, threshold)
+		if err != nil {
+			return err
+		}
+		if until.Valid && lockout == nil {
+			lockout = &LockoutError{
+				UserId:  userId,
+				ByIP:    key == ipKey,
+				Until:   until.Time,
+				Started: true,
+			}
+		}
+	}
+
+	return lockout
+}
+
+func getThrottle(tx *sqlx.Tx, key string) (Lockout, error) {
+	stmt := `
+        SELECT failures, locked_until FROM login_throttle
+        WHERE key = ?
+    `
+	args := []any{key}
+
+	var l Lockout
+	err := tx.Get(&l, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Lockout{}, nil
+	}
+	return l, err
+}
+
+func incrementThrottle(tx *sqlx.Tx, p ThrottlePolicy, key string, threshold int) (sql.NullTime, error) {
+	stmt := `
+        SELECT failures, last_failure_at FROM login_throttle
+        WHERE key = ?
+    `
+	var row struct {
+		Failures      int       `db:"failures"`
+		LastFailureAt time.Time `db:"last_failure_at"`
+	}
+	err := tx.Get(&row, stmt, key)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return sql.NullTime{}, err
+	}
+
+	now := db.Now()
+	failures := row.Failures + 1
+	if now.Sub(row.LastFailureAt) > p.Max { // quiet long enough, start over
+		failures = 1
+	}
+
+	var lockedUntil sql.NullTime
+	if failures >= threshold {
+		d := p.Max
+		if shift := failures - threshold; shift < 32 && p.Base<<shift < p.Max {
+			d = p.Base << shift
+		}
+		lockedUntil = sql.NullTime{Time: now.Add(d), Valid: true}
+	}
+
+	stmt = `
+        INSERT INTO login_throttle (key, failures, last_failure_at, locked_until)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (key) DO UPDATE SET
+            failures = excluded.failures,
+            last_failure_at = excluded.last_failure_at,
+            locked_until = excluded.locked_until
+    `
+	args := []any{key, failures, now, lockedUntil}
+
+	_, err = tx.Exec(stmt, args...)
+	return lockedUntil, err
+}
+
+func clearThrottle(tx *sqlx.Tx, key string) error {
+	stmt := `
+        DELETE FROM login_throttle
+        WHERE key = ?
+    `
+	args := []any{key}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
@@ -0,0 +1,144 @@
+package user_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/password"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+var testThrottle = user.ThrottlePolicy{
+	Threshold: 3,
+	Base:      time.Minute,
+	Max:       time.Hour,
+}
+
+func TestThrottle(t *testing.T) {
+	t.Run("LocksEmail", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+		userService.SetPasswordCost(password.MinCost)
+		userService.SetThrottlePolicy(testThrottle)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			_, err = userService.HandleFromCreds("a@example.com", "wrong", "1.1.1.1")
+			assert.ErrorIs(t, err, user.ErrInvalidCredentials)
+		}
+
+		_, err = userService.HandleFromCreds("a@example.com", "wrong", "1.1.1.1")
+		var lockout *user.LockoutError
+		assert.True(t, errors.As(err, &lockout))
+		assert.True(t, lockout.Started)
+		assert.False(t, lockout.ByIP)
+		assert.Equal(t, u.Id, lockout.UserId)
+
+		// even the right password is refused while locked
+		_, err = userService.HandleFromCreds("a@example.com", "secret", "2.2.2.2")
+		assert.ErrorIs(t, err, user.ErrLockedOut)
+		assert.True(t, errors.As(err, &lockout))
+		assert.False(t, lockout.Started)
+
+		l, err := userService.GetLockout(u.Id)
+		assert.NoError(t, err)
+		assert.True(t, l.IsLocked())
+		assert.Equal(t, 3, l.Failures)
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+		userService.SetThrottlePolicy(testThrottle)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lockedFor := func() time.Duration {
+			l, err := userService.GetLockout(u.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return time.Until(l.LockedUntil.Time).Round(time.Minute)
+		}
+		fail := func() {
+			userService.HandleFromCreds("a@example.com", "wrong", "")
+			// pretend the lockout already passed so the next attempt is counted
+			_, err := db.Exec("UPDATE login_throttle SET locked_until = NULL")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		fail()
+		fail()
+		userService.HandleFromCreds("a@example.com", "wrong", "")
+		assert.Equal(t, time.Minute, lockedFor())
+		fail()
+		userService.HandleFromCreds("a@example.com", "wrong", "")
+		assert.Equal(t, 2*time.Minute, lockedFor())
+		for i := 0; i < 10; i++ {
+			fail()
+		}
+		userService.HandleFromCreds("a@example.com", "wrong", "")
+		assert.Equal(t, time.Hour, lockedFor())
+	})
+
+	t.Run("LocksIP", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+		userService.SetThrottlePolicy(testThrottle)
+
+		// spread over many emails so that only the IP reaches its threshold
+		var err error
+		for i := 0; i < testThrottle.Threshold*4; i++ {
+			_, err = userService.HandleFromCreds(string(rune('a'+i))+"@example.com", "wrong", "1.1.1.1")
+		}
+		var lockout *user.LockoutError
+		assert.True(t, errors.As(err, &lockout))
+		assert.True(t, lockout.ByIP)
+
+		_, err = userService.HandleFromCreds("admin@example.com", "admin", "1.1.1.1")
+		assert.ErrorIs(t, err, user.ErrLockedOut)
+
+		_, err = userService.HandleFromCreds("admin@example.com", "admin", "2.2.2.2")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Unlock", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+		userService.SetPasswordCost(password.MinCost)
+		userService.SetThrottlePolicy(testThrottle)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < testThrottle.Threshold; i++ {
+			userService.HandleFromCreds("a@example.com", "wrong", "")
+		}
+		_, err = userService.HandleFromCreds("a@example.com", "secret", "")
+		assert.ErrorIs(t, err, user.ErrLockedOut)
+
+		_, err = userService.Unlock(u.Id)
+		assert.NoError(t, err)
+
+		_, err = userService.HandleFromCreds("a@example.com", "secret", "")
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,255 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/password"
+	"github.com/jmoiron/sqlx"
+)
+
+// Issues a new single-use token for the user, expiring any outstanding tokens with the same purpose.
+// Only the hash of the token is stored, the returned plaintext token must be sent to the user.
+func (s *service) IssueToken(userId int64, purpose TokenPurpose, ttl time.Duration) (string, error) {
+	s.log.Printf("user IssueToken userId:%d purpose:%s", userId, purpose)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	err = deleteStaleTokens(tx)
+	if err != nil {
+		return "", err
+	}
+
+	err = expireTokens(tx, userId, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := issueToken(tx, userId, purpose, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// Marks the token as used and returns the user it was issued to.
+func (s *service) ConsumeToken(token string, purpose TokenPurpose) (User, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	u, err := consumeToken(tx, token, purpose)
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, tx.Commit()
+}
+
+// Expires every outstanding token of the user with the given purpose.
+func (s *service) ExpireTokens(userId int64, purpose TokenPurpose) error {
+	s.log.Printf("user ExpireTokens userId:%d purpose:%s", userId, purpose)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = expireTokens(tx, userId, purpose)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Sets a new password using a password reset token. The token was mailed to the user, so their email counts as verified.
+func (s *service) ResetPassword(token string, plain string) (User, error) {
+	hash, err := password.Hash(plain, s.passwordCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	u, err := consumeToken(tx, token, TokenPasswordReset)
+	if err != nil {
+		return User{}, err
+	}
+	s.log.Printf("user ResetPassword userId:%d", u.Id)
+
+	err = setPassword(tx, u.Id, hash)
+	if err != nil {
+		return User{}, err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?) WHERE id = ?`, db.Now(), u.Id)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = expireTokens(tx, u.Id, TokenPasswordReset)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = clearThrottle(tx, emailThrottleKey(u.Email))
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, tx.Commit()
+}
+
+// Marks the user's email as verified using an email verification token.
+// If the user asked for a new email, the token was sent there, and it replaces their current one.
+func (s *service) VerifyEmail(token string) (User, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	u, err := consumeToken(tx, token, TokenEmailVerify)
+	if err != nil {
+		return User{}, err
+	}
+	s.log.Printf("user VerifyEmail userId:%d", u.Id)
+
+	if u.PendingEmail.Valid {
+		// someone may have taken the address since it was asked for
+		_, err := getByEmail(tx, u.PendingEmail.String)
+		if err == nil {
+			return User{}, ErrEmailTaken
+		} else if !errors.Is(err, ErrNoUser) {
+			return User{}, err
+		}
+	}
+
+	stmt := `
+        UPDATE users
+        SET
+            email = COALESCE(pending_email, email),
+            pending_email = NULL,
+            email_verified_at = ?
+        WHERE id = ?
+    `
+	args := []any{db.Now(), u.Id}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return User{}, err
+	}
+
+	u, err = get(tx, u.Id)
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, tx.Commit()
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func issueToken(tx *sqlx.Tx, userId int64, purpose TokenPurpose, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	stmt := `
+        INSERT INTO user_token (token_hash, user_id, purpose, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?)
+    `
+	now := db.Now()
+	args := []any{
+		hashToken(token),
+		userId,
+		purpose,
+		now,
+		now.Add(ttl),
+	}
+
+	_, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func consumeToken(tx *sqlx.Tx, token string, purpose TokenPurpose) (User, error) {
+	stmt := `
+        SELECT user_id, expires_at, used_at FROM user_token
+        WHERE token_hash = ? AND purpose = ?
+    `
+	args := []any{hashToken(token), purpose}
+
+	var t struct {
+		UserId    int64        `db:"user_id"`
+		ExpiresAt time.Time    `db:"expires_at"`
+		UsedAt    sql.NullTime `db:"used_at"`
+	}
+	err := tx.Get(&t, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrInvalidToken
+	} else if err != nil {
+		return User{}, err
+	}
+
+	if t.UsedAt.Valid || db.Now().After(t.ExpiresAt) {
+		return User{}, ErrInvalidToken
+	}
+
+	_, err = tx.Exec(`UPDATE user_token SET used_at = ? WHERE token_hash = ?`, db.Now(), hashToken(token))
+	if err != nil {
+		return User{}, err
+	}
+
+	return get(tx, t.UserId)
+}
+
+func expireTokens(tx *sqlx.Tx, userId int64, purpose TokenPurpose) error {
+	stmt := `
+        UPDATE user_token
+        SET expires_at = ?
+        WHERE user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+    `
+	now := db.Now()
+	args := []any{now, userId, purpose, now}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
+
+// Tokens are only kept around for a day after they stop being usable.
+func deleteStaleTokens(tx *sqlx.Tx) error {
+	stmt := `
+        DELETE FROM user_token
+        WHERE expires_at < ?
+    `
+	args := []any{db.Now().Add(-24 * time.Hour)}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
@@ -0,0 +1,147 @@
+package user_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/password"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumeToken(t *testing.T) {
+	t.Run("SingleUse", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := userService.IssueToken(u.Id, user.TokenEmailVerify, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := userService.ConsumeToken(token, user.TokenEmailVerify)
+		assert.NoError(t, err)
+		assert.Equal(t, u.Id, found.Id)
+
+		_, err = userService.ConsumeToken(token, user.TokenEmailVerify)
+		assert.ErrorIs(t, err, user.ErrInvalidToken)
+	})
+
+	t.Run("WrongPurpose", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := userService.IssueToken(u.Id, user.TokenEmailVerify, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.ConsumeToken(token, user.TokenPasswordReset)
+		assert.ErrorIs(t, err, user.ErrInvalidToken)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := userService.IssueToken(u.Id, user.TokenEmailVerify, -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.ConsumeToken(token, user.TokenEmailVerify)
+		assert.ErrorIs(t, err, user.ErrInvalidToken)
+	})
+
+	t.Run("NewTokenExpiresOld", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		old, err := userService.IssueToken(u.Id, user.TokenPasswordReset, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = userService.IssueToken(u.Id, user.TokenPasswordReset, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = userService.ConsumeToken(old, user.TokenPasswordReset)
+		assert.ErrorIs(t, err, user.ErrInvalidToken)
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	userService := user.NewService(db)
+	userService.SetPasswordCost(password.MinCost)
+
+	u, err := userService.Create(user.CreateParams{Email: "a@example.com", Password: "old password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := userService.IssueToken(u.Id, user.TokenPasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = userService.ResetPassword(token, "new password")
+	assert.NoError(t, err)
+
+	_, err = userService.HandleFromCreds("a@example.com", "old password", "")
+	assert.ErrorIs(t, err, user.ErrInvalidCredentials)
+	u, err = userService.HandleFromCreds("a@example.com", "new password", "")
+	assert.NoError(t, err)
+	assert.True(t, u.EmailVerifiedAt.Valid, "the reset link proved the user owns the email")
+
+	_, err = userService.ResetPassword(token, "another password")
+	assert.ErrorIs(t, err, user.ErrInvalidToken)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	db := db.TestingConnect(t)
+	defer db.Close()
+	userService := user.NewService(db)
+
+	u, err := userService.Create(user.CreateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, u.EmailVerifiedAt.Valid)
+
+	token, err := userService.IssueToken(u.Id, user.TokenEmailVerify, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err = userService.VerifyEmail(token)
+	assert.NoError(t, err)
+	assert.True(t, u.EmailVerifiedAt.Valid)
+}
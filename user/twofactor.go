@@ -0,0 +1,341 @@
+package user
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/totp"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrInvalidTOTP    = errors.New("invalid authentication code")
+	ErrTOTPNotStarted = errors.New("two-factor setup has not been started")
+	ErrTOTPEnabled    = errors.New("two-factor authentication is already enabled")
+)
+
+// Number of recovery codes handed out when two-factor authentication is enabled.
+const recoveryCodeCount = 10
+
+type TOTPStatus struct {
+	Enabled           bool
+	RecoveryCodesLeft int
+}
+
+type userTOTP struct {
+	UserId    int64        `db:"user_id"`
+	Secret    string       `db:"secret"`
+	CreatedAt time.Time    `db:"created_at"`
+	EnabledAt sql.NullTime `db:"enabled_at"`
+	LastStep  int64        `db:"last_step"`
+}
+
+// Returns the secret for the user to add to their authenticator app, generating one unless setup was already started.
+// It only takes effect once confirmed with a code through EnableTOTP.
+func (s *service) BeginTOTP(userId int64) (string, error) {
+	s.log.Printf("user BeginTOTP userId:%d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	t, err := getTOTP(tx, userId)
+	if err != nil && !errors.Is(err, ErrTOTPNotStarted) {
+		return "", err
+	}
+	if t.EnabledAt.Valid {
+		return "", ErrTOTPEnabled
+	}
+	if t.Secret != "" {
+		return t.Secret, nil
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `
+        REPLACE INTO user_totp (user_id, secret, created_at, enabled_at, last_step)
+        VALUES (?, ?, ?, NULL, 0)
+    `
+	args := []any{userId, secret, db.Now()}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return "", err
+	}
+
+	return secret, tx.Commit()
+}
+
+// Enables two-factor authentication once the user proves their app generates the right codes.
+// Returns the plaintext recovery codes, which are only stored hashed.
+func (s *service) EnableTOTP(userId int64, code string) ([]string, error) {
+	s.log.Printf("user EnableTOTP userId:%d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := getTOTP(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if t.EnabledAt.Valid {
+		return nil, ErrTOTPEnabled
+	}
+
+	step, ok := totp.Validate(t.Secret, code, db.Now())
+	if !ok {
+		return nil, ErrInvalidTOTP
+	}
+
+	stmt := `
+        UPDATE user_totp
+        SET enabled_at = ?, last_step = ?
+        WHERE user_id = ?
+    `
+	args := []any{db.Now(), step, userId}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := createRecoveryCodes(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// Turns off two-factor authentication and removes the recovery codes.
+func (s *service) DisableTOTP(userId int64) error {
+	s.log.Printf("user DisableTOTP userId:%d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = ?`, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_recovery_code WHERE user_id = ?`, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *service) GetTOTPStatus(userId int64) (TOTPStatus, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return TOTPStatus{}, err
+	}
+	defer tx.Rollback()
+
+	t, err := getTOTP(tx, userId)
+	if errors.Is(err, ErrTOTPNotStarted) {
+		return TOTPStatus{}, nil
+	} else if err != nil {
+		return TOTPStatus{}, err
+	}
+
+	stmt := `
+        SELECT COUNT(*) FROM user_recovery_code
+        WHERE user_id = ? AND used_at IS NULL
+    `
+	var left int
+	err = tx.Get(&left, stmt, userId)
+	if err != nil {
+		return TOTPStatus{}, err
+	}
+
+	return TOTPStatus{
+		Enabled:           t.EnabledAt.Valid,
+		RecoveryCodesLeft: left,
+	}, nil
+}
+
+// Checks the second login step, accepting either a code from the authenticator app or an unused recovery code.
+// Failures count towards the same lockout as wrong passwords, see ThrottlePolicy.
+func (s *service) VerifyTOTP(userId int64, code string, ip string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u, err := get(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	emailKey := emailThrottleKey(u.Email)
+	ipKey := ""
+	if ip != "" {
+		ipKey = ipThrottleKey(ip)
+	}
+
+	err = checkThrottle(tx, userId, emailKey, ipKey)
+	if err != nil {
+		return err
+	}
+
+	t, err := getTOTP(tx, userId)
+	if err != nil {
+		return err
+	}
+	if !t.EnabledAt.Valid {
+		return ErrTOTPNotStarted
+	}
+
+	ok := false
+	step, valid := totp.Validate(t.Secret, code, db.Now())
+	if valid && step > t.LastStep {
+		ok = true
+		_, err = tx.Exec(`UPDATE user_totp SET last_step = ? WHERE user_id = ?`, step, userId)
+		if err != nil {
+			return err
+		}
+	} else if !valid {
+		ok, err = useRecoveryCode(tx, userId, code)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !ok {
+		lockout := recordFailure(tx, s.throttle, userId, emailKey, ipKey)
+		var lockoutErr *LockoutError
+		if lockout != nil && !errors.As(lockout, &lockoutErr) {
+			return lockout
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		if lockoutErr != nil {
+			s.log.Printf("two-factor locked out %+v", lockoutErr)
+			return lockoutErr
+		}
+		return ErrInvalidTOTP
+	}
+
+	err = clearThrottle(tx, emailKey)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Replaces the recovery codes of the user with a fresh set.
+func (s *service) RegenerateRecoveryCodes(userId int64) ([]string, error) {
+	s.log.Printf("user RegenerateRecoveryCodes userId:%d", userId)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := getTOTP(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !t.EnabledAt.Valid {
+		return nil, ErrTOTPNotStarted
+	}
+
+	codes, err := createRecoveryCodes(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+func getTOTP(tx *sqlx.Tx, userId int64) (userTOTP, error) {
+	stmt := `
+        SELECT user_id, secret, created_at, enabled_at, last_step FROM user_totp
+        WHERE user_id = ?
+    `
+	args := []any{userId}
+
+	var t userTOTP
+	err := tx.Get(&t, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return userTOTP{}, ErrTOTPNotStarted
+	}
+	return t, err
+}
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Recovery codes are compared ignoring case, spaces and dashes.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+func createRecoveryCodes(tx *sqlx.Tx, userId int64) ([]string, error) {
+	_, err := tx.Exec(`DELETE FROM user_recovery_code WHERE user_id = ?`, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := recoveryEncoding.EncodeToString(b)
+		code := c[:4] + "-" + c[4:]
+
+		stmt := `
+            INSERT INTO user_recovery_code (code_hash, user_id, created_at)
+            VALUES (?, ?, ?)
+        `
+		args := []any{hashToken(normalizeRecoveryCode(code)), userId, db.Now()}
+
+		_, err = tx.Exec(stmt, args...)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+func useRecoveryCode(tx *sqlx.Tx, userId int64, code string) (bool, error) {
+	stmt := `
+        UPDATE user_recovery_code
+        SET used_at = ?
+        WHERE code_hash = ? AND user_id = ? AND used_at IS NULL
+    `
+	args := []any{db.Now(), hashToken(normalizeRecoveryCode(code)), userId}
+
+	res, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
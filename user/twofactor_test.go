@@ -0,0 +1,158 @@
+package user_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Chaldron/clay-play/db"
+	"github.com/Chaldron/clay-play/totp"
+	"github.com/Chaldron/clay-play/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTP(t *testing.T) {
+	t.Run("EnableAndVerify", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := userService.BeginTOTP(u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// starting again keeps the secret that was already shown
+		again, err := userService.BeginTOTP(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, secret, again)
+
+		status, err := userService.GetTOTPStatus(u.Id)
+		assert.NoError(t, err)
+		assert.False(t, status.Enabled)
+
+		_, err = userService.EnableTOTP(u.Id, "000000")
+		assert.ErrorIs(t, err, user.ErrInvalidTOTP)
+
+		// the code used to enable can't be used to log in
+		codes, err := userService.EnableTOTP(u.Id, mustCode(t, secret, time.Now()))
+		assert.NoError(t, err)
+		assert.Len(t, codes, 10)
+
+		status, err = userService.GetTOTPStatus(u.Id)
+		assert.NoError(t, err)
+		assert.True(t, status.Enabled)
+		assert.Equal(t, 10, status.RecoveryCodesLeft)
+
+		err = userService.VerifyTOTP(u.Id, mustCode(t, secret, time.Now()), "")
+		assert.ErrorIs(t, err, user.ErrInvalidTOTP)
+
+		err = userService.VerifyTOTP(u.Id, mustCode(t, secret, time.Now().Add(totp.Period*time.Second)), "")
+		assert.NoError(t, err)
+
+		_, err = userService.BeginTOTP(u.Id)
+		assert.ErrorIs(t, err, user.ErrTOTPEnabled)
+	})
+
+	t.Run("RecoveryCodes", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret, err := userService.BeginTOTP(u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes, err := userService.EnableTOTP(u.Id, mustCode(t, secret, time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = userService.VerifyTOTP(u.Id, codes[0], "")
+		assert.NoError(t, err)
+
+		err = userService.VerifyTOTP(u.Id, codes[0], "")
+		assert.ErrorIs(t, err, user.ErrInvalidTOTP)
+
+		status, err := userService.GetTOTPStatus(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 9, status.RecoveryCodesLeft)
+
+		newCodes, err := userService.RegenerateRecoveryCodes(u.Id)
+		assert.NoError(t, err)
+		err = userService.VerifyTOTP(u.Id, codes[1], "")
+		assert.ErrorIs(t, err, user.ErrInvalidTOTP)
+		err = userService.VerifyTOTP(u.Id, newCodes[1], "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("LockedOut", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+		userService.SetThrottlePolicy(user.ThrottlePolicy{Threshold: 2, Base: time.Minute, Max: time.Hour})
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret, err := userService.BeginTOTP(u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = userService.EnableTOTP(u.Id, mustCode(t, secret, time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.ErrorIs(t, userService.VerifyTOTP(u.Id, "000000", ""), user.ErrInvalidTOTP)
+		assert.ErrorIs(t, userService.VerifyTOTP(u.Id, "000000", ""), user.ErrLockedOut)
+
+		// even the right code is refused while locked out
+		err = userService.VerifyTOTP(u.Id, mustCode(t, secret, time.Now().Add(totp.Period*time.Second)), "")
+		assert.ErrorIs(t, err, user.ErrLockedOut)
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		db := db.TestingConnect(t)
+		defer db.Close()
+		userService := user.NewService(db)
+
+		u, err := userService.Create(user.CreateParams{Email: "a@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret, err := userService.BeginTOTP(u.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = userService.EnableTOTP(u.Id, mustCode(t, secret, time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = userService.DisableTOTP(u.Id)
+		assert.NoError(t, err)
+
+		status, err := userService.GetTOTPStatus(u.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, user.TOTPStatus{}, status)
+	})
+}
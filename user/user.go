@@ -1,7 +1,9 @@
 package user
 
 import (
+	"database/sql"
 	"errors"
+	"slices"
 	"strings"
 	"time"
 )
@@ -9,37 +11,148 @@ import (
 type Service interface {
 	Get(int64) (User, error)
 	GetAll() ([]User, error)
-	HandleFromCreds(email string, password string) (User, error)
+	HandleFromCreds(email string, password string, ip string) (User, error)
+	HandleFromOIDC(email string, picture string) (User, error)
 	Create(CreateParams) (User, error)
 	Update(UpdateParams) (User, error)
 	Delete(int64) error
+	Reactivate(int64) (User, error)
+	Anonymize(int64) (User, error)
+	RequestErasure(int64) error
+	CancelErasure(int64) error
+	ListErasureRequests() ([]User, error)
+	GetByEmail(string) (User, error)
+	EmailsInUse(emails []string) (map[string]bool, error)
+	IssueToken(userId int64, purpose TokenPurpose, ttl time.Duration) (string, error)
+	ConsumeToken(token string, purpose TokenPurpose) (User, error)
+	ExpireTokens(userId int64, purpose TokenPurpose) error
+	ResetPassword(token string, password string) (User, error)
+	VerifyEmail(token string) (User, error)
+	GetLockout(int64) (Lockout, error)
+	Unlock(int64) (User, error)
+	StartSession(StartSessionParams) error
+	TouchSession(TouchSessionParams) error
+	EndSession(token string) error
+	ListSessions(userId int64) ([]Session, error)
+	RevokeSession(userId int64, id string) error
+	RevokeSessions(userId int64) error
+	BeginTOTP(userId int64) (string, error)
+	EnableTOTP(userId int64, code string) ([]string, error)
+	DisableTOTP(userId int64) error
+	GetTOTPStatus(userId int64) (TOTPStatus, error)
+	VerifyTOTP(userId int64, code string, ip string) error
+	RegenerateRecoveryCodes(userId int64) ([]string, error)
+	CreateAPIToken(CreateAPITokenParams) (APIToken, string, error)
+	ListAPITokens(userId int64) ([]APIToken, error)
+	RevokeAPIToken(userId int64, id string) (APIToken, error)
+	AuthenticateAPIToken(token string) (APIToken, User, error)
+	CreateCalendarFeed(userId int64, includeAll bool) (CalendarFeed, string, error)
+	GetCalendarFeed(userId int64) (*CalendarFeed, error)
+	SetCalendarFeedIncludeAll(userId int64, includeAll bool) error
+	RevokeCalendarFeed(userId int64) error
+	AuthenticateCalendarFeed(token string) (CalendarFeed, User, error)
+	UpdateProfile(UpdateProfileParams) (User, error)
+	ChangePassword(ChangePasswordParams) error
+	SetAvatar(userId int64, data []byte) (User, error)
+	GetAvatar(userId int64) (Avatar, error)
+	DeleteAvatar(userId int64) error
+	CreateInvitation(CreateInvitationParams) (Invitation, string, error)
+	GetInvitation(id string) (Invitation, error)
+	ListInvitations() ([]Invitation, error)
+	ResendInvitation(id string) (Invitation, string, error)
+	RevokeInvitation(id string) (Invitation, error)
+	GetInvitationByToken(token string) (Invitation, error)
+	AcceptInvitation(AcceptInvitationParams) (Invitation, User, error)
 }
 
+type TokenPurpose string
+
+const (
+	TokenPasswordReset TokenPurpose = "password_reset"
+	TokenEmailVerify   TokenPurpose = "email_verify"
+)
+
 var (
-	ErrNoUser = errors.New("no user found")
+	ErrNoUser             = errors.New("no user found")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrEmailTaken         = errors.New("an account with this email already exists")
+	ErrInvalidToken       = errors.New("this link is invalid or has expired")
+	ErrLockedOut          = errors.New("too many failed login attempts")
+	ErrEmailNotVerified   = errors.New("email has not been verified")
 )
 
 type User struct {
-	Id        int64     `db:"id"`
-	FullName  string    `db:"full_name"`
-	Email     string    `db:"email"`
-	Password  string    `db:"password"`
-	CreatedAt time.Time `db:"created_at"`
-	IsAdmin   bool      `db:"isadmin"`
+	Id        int64          `db:"id"`
+	FullName  string         `db:"full_name"`
+	Email     string         `db:"email"`
+	Password  string         `db:"password"`
+	Picture   sql.NullString `db:"picture"`
+	CreatedAt time.Time      `db:"created_at"`
+	IsAdmin   bool           `db:"isadmin"`
+	IsPending bool           `db:"is_pending"`
+
+	EmailVerifiedAt sql.NullTime   `db:"email_verified_at"`
+	PendingEmail    sql.NullString `db:"pending_email"` // new email the user asked for, it replaces Email once verified
+	DeletedAt       sql.NullTime   `db:"deleted_at"`
+	AnonymizedAt    sql.NullTime   `db:"anonymized_at"`
+
+	ErasureRequestedAt sql.NullTime `db:"erasure_requested_at"`
+
+	Roles []Role `db:"-"`
+}
+
+// Reports whether the user holds the role. RoleAdmin is derived from IsAdmin.
+func (u User) HasRole(r Role) bool {
+	if r == RoleAdmin {
+		return u.IsAdmin
+	}
+	return slices.Contains(u.Roles, r)
+}
+
+// Reports whether the user was deactivated, see Service.Delete.
+func (u User) IsDeleted() bool {
+	return u.DeletedAt.Valid
 }
 
 func (u *User) ToSessionUser() SessionUser {
 	return SessionUser{
-		Id:       u.Id,
-		FullName: u.FullName,
-		IsAdmin:  u.IsAdmin,
+		Id:        u.Id,
+		FullName:  u.FullName,
+		IsAdmin:   u.IsAdmin,
+		IsPending: u.IsPending,
+		Roles:     u.Roles,
 	}
 }
 
 type SessionUser struct {
-	Id       int64
-	FullName string
-	IsAdmin  bool
+	Id        int64
+	FullName  string
+	IsAdmin   bool
+	IsPending bool // registered but not yet approved by an admin
+	Roles     []Role
+	TwoFactor bool // logged in with a second factor
+
+	Impersonator *Impersonator // set while an admin is viewing the app as this user
+}
+
+// The admin behind an impersonated session.
+type Impersonator struct {
+	Id          int64
+	FullName    string
+	AllowWrites bool // whether requests that change data go through, they are blocked by default
+	StartedAt   time.Time
+}
+
+func (u SessionUser) IsImpersonated() bool {
+	return u.Impersonator != nil
+}
+
+// Reports whether the user is granted the permission, either by being an admin or through one of their roles.
+func (u SessionUser) Can(p Permission) bool {
+	if u.IsAdmin {
+		return true
+	}
+	return hasPermission(u.Roles, p)
 }
 
 func (u SessionUser) IsAuthenticated() bool {